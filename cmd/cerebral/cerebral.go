@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"net/http"
 	"os"
 	"runtime"
 	"time"
@@ -14,16 +15,30 @@ import (
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog"
+	metricsclient "k8s.io/metrics/pkg/client/clientset/versioned"
 
 	"github.com/containership/cerebral/pkg/buildinfo"
 	cerebral "github.com/containership/cerebral/pkg/client/clientset/versioned"
 	cerebralscheme "github.com/containership/cerebral/pkg/client/clientset/versioned/scheme"
 	cinformers "github.com/containership/cerebral/pkg/client/informers/externalversions"
 	"github.com/containership/cerebral/pkg/controller"
+	"github.com/containership/cerebral/pkg/instrumentation"
 
 	"github.com/containership/cluster-manager/pkg/log"
 )
 
+const (
+	// metricsAddressEnvVar is the environment variable used to override the
+	// address that Cerebral serves its own Prometheus metrics on
+	metricsAddressEnvVar = "CEREBRAL_METRICS_ADDRESS"
+
+	defaultMetricsAddress = ":9091"
+
+	// metricsAPITimeout bounds requests to the resource metrics API so that
+	// an unresponsive metrics-server can't block a policy poll indefinitely
+	metricsAPITimeout = 30 * time.Second
+)
+
 func main() {
 	log.Info("Starting Cerebral...")
 	log.Infof("Version: %s", buildinfo.String())
@@ -46,6 +61,13 @@ func main() {
 		log.Fatalf("Failed to create Kubernetes clientset: %+v", err)
 	}
 
+	metricsConfig := rest.CopyConfig(config)
+	metricsConfig.Timeout = metricsAPITimeout
+	metricsclientset, err := metricsclient.NewForConfig(metricsConfig)
+	if err != nil {
+		log.Fatalf("Failed to create metrics clientset: %+v", err)
+	}
+
 	cerebralclientset, err := cerebral.NewForConfig(config)
 	if err != nil {
 		log.Fatalf("Failed to create Cerebral clientset: %+v", err)
@@ -70,11 +92,14 @@ func main() {
 		scaleMgr.ScaleRequestChan())
 
 	metricsBackendController := controller.NewMetricsBackend(
-		kubeclientset, kubeInformerFactory, cerebralclientset, cerebralInformerFactory)
+		kubeclientset, metricsclientset, kubeInformerFactory, cerebralclientset, cerebralInformerFactory)
 
 	autoscalingEngineController := controller.NewAutoscalingEngine(
 		kubeclientset, kubeInformerFactory, cerebralclientset, cerebralInformerFactory)
 
+	scheduledScalingController := controller.NewScheduledScalingController(
+		kubeInformerFactory, cerebralInformerFactory, scaleMgr.ScaleRequestChan())
+
 	kubeInformerFactory.Start(stopCh)
 	cerebralInformerFactory.Start(stopCh)
 
@@ -108,10 +133,36 @@ func main() {
 		}
 	}()
 
+	go func() {
+		if err := scheduledScalingController.Run(stopCh); err != nil {
+			log.Fatalf("Error running ScheduledScalingController: %s", err.Error())
+		}
+	}()
+
+	go func() {
+		if err := serveMetrics(); err != nil {
+			log.Fatalf("Error serving metrics: %s", err.Error())
+		}
+	}()
+
 	<-stopCh
 	log.Fatal("There was an error while running the scale manager and controllers")
 }
 
+// serveMetrics serves Cerebral's own Prometheus metrics until an error occurs
+func serveMetrics() error {
+	address := os.Getenv(metricsAddressEnvVar)
+	if address == "" {
+		address = defaultMetricsAddress
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", instrumentation.Handler())
+
+	log.Infof("Serving metrics on %s", address)
+	return http.ListenAndServe(address, mux)
+}
+
 // determineConfig determines if we are running in a cluster or outside
 // and gets the appropriate configuration to talk with Kubernetes.
 func determineConfig() (*rest.Config, error) {
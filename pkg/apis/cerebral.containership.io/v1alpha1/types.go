@@ -21,6 +21,9 @@ type MetricsBackend struct {
 type MetricsBackendSpec struct {
 	Type          string            `json:"type"`
 	Configuration map[string]string `json:"configuration"`
+	// CacheTTL is the number of seconds values returned by the backend are
+	// cached for. Identical queries are deduplicated even if it's zero.
+	CacheTTL int `json:"cacheTTL,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -56,12 +59,28 @@ type AutoscalingGroupSpec struct {
 	MinNodes        int               `json:"minNodes"`
 	MaxNodes        int               `json:"maxNodes"`
 	ScalingStrategy *ScalingStrategy  `json:"scalingStrategy,omitempty"`
+	Arbitration     *AlertArbitration `json:"arbitration,omitempty"`
+	Schedules       []ScalingSchedule `json:"schedules,omitempty"`
 }
 
 // AutoscalingGroupStatus is the status for a autoscaling group
 type AutoscalingGroupStatus struct {
 	// LastUpdatedAt is a Unix time, time.Time is not a valid type for code gen
 	LastUpdatedAt metav1.Time `json:"lastUpdatedAt"`
+	// PendingAlerts are alerts waiting for the arbitration evaluation window
+	// to elapse
+	PendingAlerts []PendingAlert `json:"pendingAlerts,omitempty"`
+}
+
+// PendingAlert is an alert fired by an AutoscalingPolicy that is waiting to
+// be arbitrated
+type PendingAlert struct {
+	AutoscalingPolicy string  `json:"autoscalingPolicy"`
+	Direction         string  `json:"direction"`
+	AdjustmentType    string  `json:"adjustmentType"`
+	AdjustmentValue   float64 `json:"adjustmentValue"`
+	// AlertedAt is when the alert fired
+	AlertedAt metav1.Time `json:"alertedAt"`
 }
 
 // ScalingStrategy defines the strategy that should be used when scaling up and down
@@ -70,6 +89,30 @@ type ScalingStrategy struct {
 	ScaleDown string `json:"scaleDown"`
 }
 
+// AlertArbitration defines how alerts fired by multiple policies for the same
+// autoscaling group are consolidated into a single scale request
+type AlertArbitration struct {
+	Mode string `json:"mode"`
+	// EvaluationWindow is the number of seconds to collect alerts before
+	// arbitrating between them
+	EvaluationWindow int `json:"evaluationWindow"`
+}
+
+// A ScalingSchedule overrides the node count bounds of an autoscaling group
+// for a window of time starting at each occurrence of a cron schedule
+type ScalingSchedule struct {
+	Name     string `json:"name"`
+	Schedule string `json:"schedule"`
+	// TimeZone is an IANA time zone name; UTC is used if empty
+	TimeZone string `json:"timeZone,omitempty"`
+	// Duration is the number of seconds the window lasts
+	Duration int `json:"duration"`
+
+	MinNodes     *int `json:"minNodes,omitempty"`
+	MaxNodes     *int `json:"maxNodes,omitempty"`
+	DesiredNodes *int `json:"desiredNodes,omitempty"`
+}
+
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 // AutoscalingGroupList is a list of autoscaling groups.
@@ -81,7 +124,6 @@ type AutoscalingGroupList struct {
 }
 
 // +genclient
-// +genclient:noStatus
 // +genclient:nonNamespaced
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
@@ -90,7 +132,8 @@ type AutoscalingPolicy struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
-	Spec AutoscalingPolicySpec `json:"spec"`
+	Spec   AutoscalingPolicySpec   `json:"spec"`
+	Status AutoscalingPolicyStatus `json:"status,omitempty"`
 }
 
 // AutoscalingPolicySpec is the spec for a autoscaling group
@@ -101,6 +144,103 @@ type AutoscalingPolicySpec struct {
 	ScalingPolicy       ScalingPolicy     `json:"scalingPolicy"`
 	PollInterval        int               `json:"pollInterval"`
 	SamplePeriod        int               `json:"samplePeriod"`
+	// Predictive optionally enables scaling up ahead of forecasted demand
+	Predictive *PredictiveScaling `json:"predictive,omitempty"`
+	// FailureTolerance optionally configures how failures to get the metric
+	// are retried and tolerated
+	FailureTolerance *FailureTolerance `json:"failureTolerance,omitempty"`
+	// SampleWindow optionally fires alerts based on the number of recent
+	// samples that breach a threshold instead of the SamplePeriod
+	SampleWindow *SampleWindow `json:"sampleWindow,omitempty"`
+	// Hysteresis is how far beyond the scale up threshold the metric must be
+	// for the scale down threshold to be breached
+	Hysteresis float64 `json:"hysteresis,omitempty"`
+}
+
+// SampleWindow configures alerts to fire once at least RequiredBreaches of
+// the last Samples samples breach a threshold
+type SampleWindow struct {
+	// Samples is the number of most recent samples considered
+	Samples int `json:"samples"`
+	// RequiredBreaches is the number of the samples that must breach the
+	// threshold. If unset, all of the samples must breach it.
+	RequiredBreaches int `json:"requiredBreaches,omitempty"`
+}
+
+// FailureTolerance configures retries of failed polls of a policy's metric.
+// Durations are in seconds.
+type FailureTolerance struct {
+	// MaxConsecutiveFailures is how many polls in a row may fail before the
+	// policy's alert timers are reset
+	MaxConsecutiveFailures int `json:"maxConsecutiveFailures,omitempty"`
+	// InitialBackoff is the delay before retrying the first failed poll. The
+	// delay doubles with each consecutive failure.
+	InitialBackoff int `json:"initialBackoff,omitempty"`
+	// MaxBackoff is the maximum delay between retries
+	MaxBackoff int `json:"maxBackoff,omitempty"`
+}
+
+// PredictiveScaling configures forecasting of a policy's metric from its
+// history. All fields are in seconds.
+type PredictiveScaling struct {
+	// LookbackPeriod is how much history to fit the forecast to
+	LookbackPeriod int `json:"lookbackPeriod"`
+	// Step is the resolution of the history and forecast
+	Step int `json:"step"`
+	// SeasonalPeriod is the length of a repeating cycle in the metric, e.g.
+	// 86400 for daily traffic patterns. If unset, only the trend is used.
+	SeasonalPeriod int `json:"seasonalPeriod,omitempty"`
+	// ForecastHorizon is how far ahead to forecast
+	ForecastHorizon int `json:"forecastHorizon"`
+}
+
+// AutoscalingPolicyStatus is the status for an autoscaling policy
+type AutoscalingPolicyStatus struct {
+	// Polls describes polling of the policy's metric, the state of its
+	// alerts, and its forecast for each AutoscalingGroup using it
+	Polls []PolicyPollStatus `json:"polls,omitempty"`
+}
+
+// PolicyPollStatus is the status of polling a policy's metric for a single
+// AutoscalingGroup
+type PolicyPollStatus struct {
+	AutoscalingGroup string `json:"autoscalingGroup"`
+	// ConsecutiveFailures is the number of polls that have failed since the
+	// last successful poll
+	ConsecutiveFailures int `json:"consecutiveFailures"`
+	// Forecast is the most recent forecast of the metric, if the policy
+	// uses predictive scaling
+	Forecast *PolicyForecast `json:"forecast,omitempty"`
+	// LastError is the error of the last poll if it failed
+	LastError string `json:"lastError,omitempty"`
+	// LastFailureTime is when a poll last failed
+	LastFailureTime *metav1.Time `json:"lastFailureTime,omitempty"`
+	// LastSuccessTime is when a poll last succeeded
+	LastSuccessTime *metav1.Time `json:"lastSuccessTime,omitempty"`
+	// LastValue is the metric value of the last poll that changed the state
+	// of an alert
+	LastValue *float64 `json:"lastValue,omitempty"`
+	// ObservedGeneration is the generation of the policy that the alert
+	// state below was recorded for
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// ScaleUpAlertStartTime is when the metric started breaching the scale up
+	// threshold, if it is pending
+	ScaleUpAlertStartTime *metav1.Time `json:"scaleUpAlertStartTime,omitempty"`
+	// ScaleDownAlertStartTime is when the metric started breaching the scale
+	// down threshold, if it is pending
+	ScaleDownAlertStartTime *metav1.Time `json:"scaleDownAlertStartTime,omitempty"`
+}
+
+// PolicyForecast is the most recent forecast of a policy's metric
+type PolicyForecast struct {
+	GeneratedAt metav1.Time `json:"generatedAt"`
+	// Value is the forecasted value at the end of the horizon
+	Value float64 `json:"value"`
+	// PredictedBreachAt is the first time within the horizon at which the
+	// forecast breaches the scale up threshold, if any
+	PredictedBreachAt *metav1.Time `json:"predictedBreachAt,omitempty"`
+	// PredictedBreachValue is the forecasted value at PredictedBreachAt
+	PredictedBreachValue *float64 `json:"predictedBreachValue,omitempty"`
 }
 
 // ScalingPolicy holds the policy configurations for scaling up and down
@@ -115,6 +255,17 @@ type ScalingPolicyConfiguration struct {
 	ComparisonOperator string  `json:"comparisonOperator"`
 	AdjustmentType     string  `json:"adjustmentType"`
 	AdjustmentValue    float64 `json:"adjustmentValue"`
+	// Steps optionally override the adjustment above when the metric value
+	// breaches further thresholds at the time the alert fires
+	Steps []ScalingPolicyStep `json:"steps,omitempty"`
+}
+
+// A ScalingPolicyStep defines a graduated adjustment for a metric interval
+// beyond the threshold of its parent ScalingPolicyConfiguration
+type ScalingPolicyStep struct {
+	Threshold       float64 `json:"threshold"`
+	AdjustmentType  string  `json:"adjustmentType"`
+	AdjustmentValue float64 `json:"adjustmentValue"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
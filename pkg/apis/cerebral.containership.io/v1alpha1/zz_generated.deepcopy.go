@@ -24,6 +24,22 @@ import (
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AlertArbitration) DeepCopyInto(out *AlertArbitration) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AlertArbitration.
+func (in *AlertArbitration) DeepCopy() *AlertArbitration {
+	if in == nil {
+		return nil
+	}
+	out := new(AlertArbitration)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AutoscalingEngine) DeepCopyInto(out *AutoscalingEngine) {
 	*out = *in
@@ -188,6 +204,18 @@ func (in *AutoscalingGroupSpec) DeepCopyInto(out *AutoscalingGroupSpec) {
 		*out = new(ScalingStrategy)
 		**out = **in
 	}
+	if in.Arbitration != nil {
+		in, out := &in.Arbitration, &out.Arbitration
+		*out = new(AlertArbitration)
+		**out = **in
+	}
+	if in.Schedules != nil {
+		in, out := &in.Schedules, &out.Schedules
+		*out = make([]ScalingSchedule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -205,6 +233,13 @@ func (in *AutoscalingGroupSpec) DeepCopy() *AutoscalingGroupSpec {
 func (in *AutoscalingGroupStatus) DeepCopyInto(out *AutoscalingGroupStatus) {
 	*out = *in
 	in.LastUpdatedAt.DeepCopyInto(&out.LastUpdatedAt)
+	if in.PendingAlerts != nil {
+		in, out := &in.PendingAlerts, &out.PendingAlerts
+		*out = make([]PendingAlert, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -224,6 +259,7 @@ func (in *AutoscalingPolicy) DeepCopyInto(out *AutoscalingPolicy) {
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
 	return
 }
 
@@ -289,6 +325,21 @@ func (in *AutoscalingPolicySpec) DeepCopyInto(out *AutoscalingPolicySpec) {
 		}
 	}
 	in.ScalingPolicy.DeepCopyInto(&out.ScalingPolicy)
+	if in.Predictive != nil {
+		in, out := &in.Predictive, &out.Predictive
+		*out = new(PredictiveScaling)
+		**out = **in
+	}
+	if in.FailureTolerance != nil {
+		in, out := &in.FailureTolerance, &out.FailureTolerance
+		*out = new(FailureTolerance)
+		**out = **in
+	}
+	if in.SampleWindow != nil {
+		in, out := &in.SampleWindow, &out.SampleWindow
+		*out = new(SampleWindow)
+		**out = **in
+	}
 	return
 }
 
@@ -302,6 +353,45 @@ func (in *AutoscalingPolicySpec) DeepCopy() *AutoscalingPolicySpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AutoscalingPolicyStatus) DeepCopyInto(out *AutoscalingPolicyStatus) {
+	*out = *in
+	if in.Polls != nil {
+		in, out := &in.Polls, &out.Polls
+		*out = make([]PolicyPollStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AutoscalingPolicyStatus.
+func (in *AutoscalingPolicyStatus) DeepCopy() *AutoscalingPolicyStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(AutoscalingPolicyStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FailureTolerance) DeepCopyInto(out *FailureTolerance) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FailureTolerance.
+func (in *FailureTolerance) DeepCopy() *FailureTolerance {
+	if in == nil {
+		return nil
+	}
+	out := new(FailureTolerance)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *MetricsBackend) DeepCopyInto(out *MetricsBackend) {
 	*out = *in
@@ -385,18 +475,135 @@ func (in *MetricsBackendSpec) DeepCopy() *MetricsBackendSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PendingAlert) DeepCopyInto(out *PendingAlert) {
+	*out = *in
+	in.AlertedAt.DeepCopyInto(&out.AlertedAt)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PendingAlert.
+func (in *PendingAlert) DeepCopy() *PendingAlert {
+	if in == nil {
+		return nil
+	}
+	out := new(PendingAlert)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PolicyForecast) DeepCopyInto(out *PolicyForecast) {
+	*out = *in
+	in.GeneratedAt.DeepCopyInto(&out.GeneratedAt)
+	if in.PredictedBreachAt != nil {
+		in, out := &in.PredictedBreachAt, &out.PredictedBreachAt
+		*out = (*in).DeepCopy()
+	}
+	if in.PredictedBreachValue != nil {
+		in, out := &in.PredictedBreachValue, &out.PredictedBreachValue
+		*out = new(float64)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PolicyForecast.
+func (in *PolicyForecast) DeepCopy() *PolicyForecast {
+	if in == nil {
+		return nil
+	}
+	out := new(PolicyForecast)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PolicyPollStatus) DeepCopyInto(out *PolicyPollStatus) {
+	*out = *in
+	if in.Forecast != nil {
+		in, out := &in.Forecast, &out.Forecast
+		*out = new(PolicyForecast)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.LastFailureTime != nil {
+		in, out := &in.LastFailureTime, &out.LastFailureTime
+		*out = (*in).DeepCopy()
+	}
+	if in.LastSuccessTime != nil {
+		in, out := &in.LastSuccessTime, &out.LastSuccessTime
+		*out = (*in).DeepCopy()
+	}
+	if in.LastValue != nil {
+		in, out := &in.LastValue, &out.LastValue
+		*out = new(float64)
+		**out = **in
+	}
+	if in.ScaleUpAlertStartTime != nil {
+		in, out := &in.ScaleUpAlertStartTime, &out.ScaleUpAlertStartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.ScaleDownAlertStartTime != nil {
+		in, out := &in.ScaleDownAlertStartTime, &out.ScaleDownAlertStartTime
+		*out = (*in).DeepCopy()
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PolicyPollStatus.
+func (in *PolicyPollStatus) DeepCopy() *PolicyPollStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(PolicyPollStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PredictiveScaling) DeepCopyInto(out *PredictiveScaling) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PredictiveScaling.
+func (in *PredictiveScaling) DeepCopy() *PredictiveScaling {
+	if in == nil {
+		return nil
+	}
+	out := new(PredictiveScaling)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SampleWindow) DeepCopyInto(out *SampleWindow) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SampleWindow.
+func (in *SampleWindow) DeepCopy() *SampleWindow {
+	if in == nil {
+		return nil
+	}
+	out := new(SampleWindow)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ScalingPolicy) DeepCopyInto(out *ScalingPolicy) {
 	*out = *in
 	if in.ScaleUp != nil {
 		in, out := &in.ScaleUp, &out.ScaleUp
 		*out = new(ScalingPolicyConfiguration)
-		**out = **in
+		(*in).DeepCopyInto(*out)
 	}
 	if in.ScaleDown != nil {
 		in, out := &in.ScaleDown, &out.ScaleDown
 		*out = new(ScalingPolicyConfiguration)
-		**out = **in
+		(*in).DeepCopyInto(*out)
 	}
 	return
 }
@@ -414,6 +621,11 @@ func (in *ScalingPolicy) DeepCopy() *ScalingPolicy {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ScalingPolicyConfiguration) DeepCopyInto(out *ScalingPolicyConfiguration) {
 	*out = *in
+	if in.Steps != nil {
+		in, out := &in.Steps, &out.Steps
+		*out = make([]ScalingPolicyStep, len(*in))
+		copy(*out, *in)
+	}
 	return
 }
 
@@ -427,6 +639,53 @@ func (in *ScalingPolicyConfiguration) DeepCopy() *ScalingPolicyConfiguration {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScalingPolicyStep) DeepCopyInto(out *ScalingPolicyStep) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScalingPolicyStep.
+func (in *ScalingPolicyStep) DeepCopy() *ScalingPolicyStep {
+	if in == nil {
+		return nil
+	}
+	out := new(ScalingPolicyStep)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScalingSchedule) DeepCopyInto(out *ScalingSchedule) {
+	*out = *in
+	if in.MinNodes != nil {
+		in, out := &in.MinNodes, &out.MinNodes
+		*out = new(int)
+		**out = **in
+	}
+	if in.MaxNodes != nil {
+		in, out := &in.MaxNodes, &out.MaxNodes
+		*out = new(int)
+		**out = **in
+	}
+	if in.DesiredNodes != nil {
+		in, out := &in.DesiredNodes, &out.DesiredNodes
+		*out = new(int)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScalingSchedule.
+func (in *ScalingSchedule) DeepCopy() *ScalingSchedule {
+	if in == nil {
+		return nil
+	}
+	out := new(ScalingSchedule)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ScalingStrategy) DeepCopyInto(out *ScalingStrategy) {
 	*out = *in
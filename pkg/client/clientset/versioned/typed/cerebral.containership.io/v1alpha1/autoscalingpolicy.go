@@ -39,6 +39,7 @@ type AutoscalingPoliciesGetter interface {
 type AutoscalingPolicyInterface interface {
 	Create(*v1alpha1.AutoscalingPolicy) (*v1alpha1.AutoscalingPolicy, error)
 	Update(*v1alpha1.AutoscalingPolicy) (*v1alpha1.AutoscalingPolicy, error)
+	UpdateStatus(*v1alpha1.AutoscalingPolicy) (*v1alpha1.AutoscalingPolicy, error)
 	Delete(name string, options *v1.DeleteOptions) error
 	DeleteCollection(options *v1.DeleteOptions, listOptions v1.ListOptions) error
 	Get(name string, options v1.GetOptions) (*v1alpha1.AutoscalingPolicy, error)
@@ -125,6 +126,21 @@ func (c *autoscalingPolicies) Update(autoscalingPolicy *v1alpha1.AutoscalingPoli
 	return
 }
 
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+
+func (c *autoscalingPolicies) UpdateStatus(autoscalingPolicy *v1alpha1.AutoscalingPolicy) (result *v1alpha1.AutoscalingPolicy, err error) {
+	result = &v1alpha1.AutoscalingPolicy{}
+	err = c.client.Put().
+		Resource("autoscalingpolicies").
+		Name(autoscalingPolicy.Name).
+		SubResource("status").
+		Body(autoscalingPolicy).
+		Do().
+		Into(result)
+	return
+}
+
 // Delete takes name of the autoscalingPolicy and deletes it. Returns an error if one occurs.
 func (c *autoscalingPolicies) Delete(name string, options *v1.DeleteOptions) error {
 	return c.client.Delete().
@@ -94,6 +94,17 @@ func (c *FakeAutoscalingPolicies) Update(autoscalingPolicy *v1alpha1.Autoscaling
 	return obj.(*v1alpha1.AutoscalingPolicy), err
 }
 
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *FakeAutoscalingPolicies) UpdateStatus(autoscalingPolicy *v1alpha1.AutoscalingPolicy) (*v1alpha1.AutoscalingPolicy, error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewRootUpdateSubresourceAction(autoscalingpoliciesResource, "status", autoscalingPolicy), &v1alpha1.AutoscalingPolicy{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.AutoscalingPolicy), err
+}
+
 // Delete takes name of the autoscalingPolicy and deletes it. Returns an error if one occurs.
 func (c *FakeAutoscalingPolicies) Delete(name string, options *v1.DeleteOptions) error {
 	_, err := c.Fake.
@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/containership/cerebral/pkg/apis/cerebral.containership.io/v1alpha1"
+)
+
+type arbitrationMode int
+
+const (
+	arbitrationModeScaleUpWins arbitrationMode = iota
+	arbitrationModeLargestTargetWins
+	arbitrationModeRequireAllScaleDown
+)
+
+// The window used to collect alerts if arbitration is enabled but no
+// evaluation window is specified
+const defaultArbitrationEvaluationWindow = 30 * time.Second
+
+func (a arbitrationMode) String() string {
+	switch a {
+	case arbitrationModeScaleUpWins:
+		return "scale-up-wins"
+	case arbitrationModeLargestTargetWins:
+		return "largest-target-wins"
+	case arbitrationModeRequireAllScaleDown:
+		return "require-all-scale-down"
+	}
+
+	return "unknown"
+}
+
+func arbitrationModeFromString(s string) (arbitrationMode, error) {
+	switch s {
+	case "scale-up-wins":
+		return arbitrationModeScaleUpWins, nil
+	case "largest-target-wins":
+		return arbitrationModeLargestTargetWins, nil
+	case "require-all-scale-down":
+		return arbitrationModeRequireAllScaleDown, nil
+	}
+
+	return 0, errors.Errorf("invalid arbitration mode %q", s)
+}
+
+// arbitrationEvaluationWindow returns the window over which alerts should be
+// collected before arbitrating
+func arbitrationEvaluationWindow(arbitration *v1alpha1.AlertArbitration) time.Duration {
+	if arbitration.EvaluationWindow <= 0 {
+		return defaultArbitrationEvaluationWindow
+	}
+
+	return time.Duration(arbitration.EvaluationWindow) * time.Second
+}
+
+// An arbitrationInput holds everything needed to choose between alerts
+// collected over an evaluation window
+type arbitrationInput struct {
+	mode        arbitrationMode
+	alerts      []alert
+	numPolicies int
+
+	currNodeCount int
+	minNodes      int
+	maxNodes      int
+}
+
+// arbitrateAlerts chooses a single alert to act on from the given input. It
+// returns the chosen alert, a human-readable reason for the decision, and
+// false if no scale request should be issued.
+func arbitrateAlerts(in arbitrationInput) (alert, string, bool) {
+	// Only the latest alert from each policy counts
+	latest := make(map[string]alert)
+	var order []string
+	for _, a := range in.alerts {
+		if _, ok := latest[a.aspName]; !ok {
+			order = append(order, a.aspName)
+		}
+		latest[a.aspName] = a
+	}
+
+	var ups, downs []alert
+	for _, name := range order {
+		a := latest[name]
+		if a.direction == scaleDirectionUp {
+			ups = append(ups, a)
+		} else {
+			downs = append(downs, a)
+		}
+	}
+
+	if len(ups) == 0 && len(downs) == 0 {
+		return alert{}, "no alerts to arbitrate", false
+	}
+
+	target := func(a alert) int {
+		return calculateTargetNodeCount(in.currNodeCount, in.minNodes, in.maxNodes,
+			a.direction, a.adjustmentType, a.adjustmentValue)
+	}
+
+	switch in.mode {
+	case arbitrationModeLargestTargetWins:
+		chosen := largestTargetAlert(append(ups, downs...), target)
+		return chosen, fmt.Sprintf("policy %q has the largest target of %d nodes (mode %s)",
+			chosen.aspName, target(chosen), in.mode), true
+
+	case arbitrationModeRequireAllScaleDown:
+		if len(ups) == 0 && len(downs) < in.numPolicies {
+			return alert{}, fmt.Sprintf("only %d of %d policies alerted to scale down (mode %s)",
+				len(downs), in.numPolicies, in.mode), false
+		}
+	}
+
+	// Scale up always wins over scale down for the remaining modes
+	if len(ups) > 0 {
+		chosen := largestTargetAlert(ups, target)
+		return chosen, fmt.Sprintf("policy %q scale up to %d nodes wins over %d scale down alert(s) (mode %s)",
+			chosen.aspName, target(chosen), len(downs), in.mode), true
+	}
+
+	// Be conservative when scaling down and remove the fewest nodes
+	chosen := largestTargetAlert(downs, target)
+	return chosen, fmt.Sprintf("policy %q scale down to %d nodes is the most conservative (mode %s)",
+		chosen.aspName, target(chosen), in.mode), true
+}
+
+// largestTargetAlert returns the alert that results in the largest target
+// node count. alerts must not be empty.
+func largestTargetAlert(alerts []alert, target func(alert) int) alert {
+	chosen := alerts[0]
+	for _, a := range alerts[1:] {
+		if target(a) > target(chosen) {
+			chosen = a
+		}
+	}
+
+	return chosen
+}
@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/containership/cerebral/pkg/apis/cerebral.containership.io/v1alpha1"
+)
+
+var (
+	cpuUpAlert = alert{
+		aspName:         "cpu",
+		direction:       scaleDirectionUp,
+		adjustmentType:  adjustmentTypeAbsolute,
+		adjustmentValue: 1,
+	}
+	memoryUpAlert = alert{
+		aspName:         "memory",
+		direction:       scaleDirectionUp,
+		adjustmentType:  adjustmentTypePercent,
+		adjustmentValue: 50,
+	}
+	cpuDownAlert = alert{
+		aspName:         "cpu",
+		direction:       scaleDirectionDown,
+		adjustmentType:  adjustmentTypeAbsolute,
+		adjustmentValue: 2,
+	}
+	memoryDownAlert = alert{
+		aspName:         "memory",
+		direction:       scaleDirectionDown,
+		adjustmentType:  adjustmentTypeAbsolute,
+		adjustmentValue: 1,
+	}
+)
+
+func TestArbitrationModeFromString(t *testing.T) {
+	for _, mode := range []arbitrationMode{
+		arbitrationModeScaleUpWins,
+		arbitrationModeLargestTargetWins,
+		arbitrationModeRequireAllScaleDown,
+	} {
+		result, err := arbitrationModeFromString(mode.String())
+		assert.NoError(t, err)
+		assert.Equal(t, mode, result)
+	}
+
+	_, err := arbitrationModeFromString("invalid")
+	assert.Error(t, err, "invalid mode errors")
+}
+
+func TestArbitrationEvaluationWindow(t *testing.T) {
+	window := arbitrationEvaluationWindow(&v1alpha1.AlertArbitration{})
+	assert.Equal(t, defaultArbitrationEvaluationWindow, window, "unspecified window is defaulted")
+
+	window = arbitrationEvaluationWindow(&v1alpha1.AlertArbitration{EvaluationWindow: 10})
+	assert.Equal(t, 10*time.Second, window, "specified window is used")
+}
+
+func TestArbitrateAlerts(t *testing.T) {
+	in := arbitrationInput{
+		mode:          arbitrationModeScaleUpWins,
+		numPolicies:   2,
+		currNodeCount: 4,
+		minNodes:      1,
+		maxNodes:      10,
+	}
+
+	_, _, ok := arbitrateAlerts(in)
+	assert.False(t, ok, "no alerts is a noop")
+
+	in.alerts = []alert{cpuDownAlert, memoryUpAlert}
+	chosen, _, ok := arbitrateAlerts(in)
+	assert.True(t, ok)
+	assert.Equal(t, memoryUpAlert, chosen, "scale up wins over scale down")
+
+	in.alerts = []alert{cpuUpAlert, memoryUpAlert}
+	chosen, _, ok = arbitrateAlerts(in)
+	assert.True(t, ok)
+	assert.Equal(t, memoryUpAlert, chosen, "largest scale up wins")
+
+	in.alerts = []alert{cpuDownAlert, memoryDownAlert}
+	chosen, _, ok = arbitrateAlerts(in)
+	assert.True(t, ok)
+	assert.Equal(t, memoryDownAlert, chosen, "most conservative scale down wins")
+
+	in.alerts = []alert{cpuUpAlert, cpuDownAlert}
+	chosen, _, ok = arbitrateAlerts(in)
+	assert.True(t, ok)
+	assert.Equal(t, cpuDownAlert, chosen, "only the latest alert per policy counts")
+
+	in.mode = arbitrationModeLargestTargetWins
+	in.alerts = []alert{cpuDownAlert, memoryDownAlert}
+	chosen, _, ok = arbitrateAlerts(in)
+	assert.True(t, ok)
+	assert.Equal(t, memoryDownAlert, chosen, "largest target wins")
+
+	in.mode = arbitrationModeRequireAllScaleDown
+	in.alerts = []alert{cpuDownAlert}
+	_, _, ok = arbitrateAlerts(in)
+	assert.False(t, ok, "not all policies want to scale down")
+
+	in.alerts = []alert{cpuDownAlert, memoryDownAlert}
+	chosen, _, ok = arbitrateAlerts(in)
+	assert.True(t, ok, "all policies want to scale down")
+	assert.Equal(t, memoryDownAlert, chosen)
+
+	in.alerts = []alert{cpuUpAlert}
+	chosen, _, ok = arbitrateAlerts(in)
+	assert.True(t, ok, "scale up does not require all policies")
+	assert.Equal(t, cpuUpAlert, chosen)
+}
@@ -268,7 +268,10 @@ func (agc *AutoscalingGroupController) syncHandler(key string) error {
 	numNodes := len(nodes)
 	log.Infof("Current number of nodes in autoscaling group '%s' : %d", autoscalingGroup.Name, numNodes)
 
-	delta, dir := determineScaleDeltaAndDirection(numNodes, autoscalingGroup.Spec.MinNodes, autoscalingGroup.Spec.MaxNodes)
+	// Bounds may be overridden by any active schedules
+	minNodes, maxNodes := effectiveBounds(autoscalingGroup, nowFunc())
+
+	delta, dir := determineScaleDeltaAndDirection(numNodes, minNodes, maxNodes)
 	if delta == 0 {
 		log.Debugf("%s: AutoscalingGroup %s is within bounds - ignoring", controllerName, autoscalingGroup.Name)
 		return nil
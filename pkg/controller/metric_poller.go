@@ -1,26 +1,120 @@
 package controller
 
 import (
-	"sync"
+	"fmt"
+	"math"
 	"time"
 
 	"github.com/pkg/errors"
 
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
 	"github.com/containership/cluster-manager/pkg/log"
 
 	"github.com/containership/cerebral/pkg/apis/cerebral.containership.io/v1alpha1"
+	"github.com/containership/cerebral/pkg/events"
+	"github.com/containership/cerebral/pkg/instrumentation"
 	"github.com/containership/cerebral/pkg/metrics"
 	"github.com/containership/cerebral/pkg/operator"
 )
 
+// A pollStatusRecorder persists the status of polling an AutoscalingPolicy's
+// metric for an AutoscalingGroup
+type pollStatusRecorder func(aspName string, status v1alpha1.PolicyPollStatus) error
+
 type metricPoller struct {
 	asp          *v1alpha1.AutoscalingPolicy
+	asgName      string
 	nodeSelector map[string]string
+
+	recorder         record.EventRecorder
+	recordForecast   forecastRecorder
+	recordPollStatus pollStatusRecorder
+
+	// Alert state is shared with a replacement poller if the policy is
+	// changed in a way that doesn't affect alerting
+	upAlert    *alertState
+	downAlert  *alertState
+	predictive *predictiveState
+
+	state *pollState
+}
+
+const (
+	defaultMaxConsecutiveFailures = 3
+	defaultInitialBackoff         = 5 * time.Second
+	defaultMaxBackoff             = 5 * time.Minute
+)
+
+// failureTolerance is a v1alpha1.FailureTolerance with defaults applied
+type failureTolerance struct {
+	maxConsecutiveFailures int
+	initialBackoff         time.Duration
+	maxBackoff             time.Duration
+}
+
+func newFailureTolerance(ft *v1alpha1.FailureTolerance) failureTolerance {
+	result := failureTolerance{
+		maxConsecutiveFailures: defaultMaxConsecutiveFailures,
+		initialBackoff:         defaultInitialBackoff,
+		maxBackoff:             defaultMaxBackoff,
+	}
+
+	if ft == nil {
+		return result
+	}
+
+	if ft.MaxConsecutiveFailures > 0 {
+		result.maxConsecutiveFailures = ft.MaxConsecutiveFailures
+	}
+
+	if ft.InitialBackoff > 0 {
+		result.initialBackoff = time.Duration(ft.InitialBackoff) * time.Second
+	}
+
+	if ft.MaxBackoff > 0 {
+		result.maxBackoff = time.Duration(ft.MaxBackoff) * time.Second
+	}
+
+	if result.maxBackoff < result.initialBackoff {
+		result.maxBackoff = result.initialBackoff
+	}
+
+	return result
+}
+
+// backoff returns the delay before retrying after the given number of
+// consecutive failures
+func (t failureTolerance) backoff(failures int) time.Duration {
+	backoff := t.initialBackoff
+	for i := 1; i < failures; i++ {
+		backoff *= 2
+		if backoff >= t.maxBackoff {
+			return t.maxBackoff
+		}
+	}
+
+	return backoff
+}
+
+// pollState tracks consecutive failures of a single poller
+type pollState struct {
+	failures    int
+	lastFailure time.Time
+	lastSuccess time.Time
+	// lastValue is the value of the last poll that changed alert state
+	lastValue *float64
 }
 
 type alertState struct {
 	active    bool
 	startTime time.Time
+
+	// samples holds whether each of the most recent samples breached the
+	// threshold, oldest first. It's only used with a sample window.
+	samples []bool
 }
 
 var nowFunc = time.Now
@@ -34,66 +128,210 @@ func (a *alertState) shouldFire(samplePeriod time.Duration) bool {
 	return a.active && nowFunc().Sub(a.startTime) >= samplePeriod
 }
 
-func newMetricPoller(asp *v1alpha1.AutoscalingPolicy, nodeSelector map[string]string) metricPoller {
+// observe updates the alert state with a sample and returns true if the alert
+// should fire, i.e. every sample has breached the threshold for the sample
+// period. A sample that doesn't breach the threshold resets the alert.
+func (a *alertState) observe(breached bool, samplePeriod time.Duration) bool {
+	if !breached {
+		*a = alertState{}
+		return false
+	}
+
+	if !a.active {
+		// We just started alerting, so update the alert state accordingly
+		a.start()
+		return false
+	}
+
+	if a.shouldFire(samplePeriod) {
+		// We've been in an active alert state for the entire sample period,
+		// so fire an alert
+		*a = alertState{}
+		return true
+	}
+
+	return false
+}
+
+// observeWindow updates the alert state with a sample and returns true if the
+// alert should fire, i.e. enough of the samples in the window have breached
+// the threshold. The alert is active while any sample in the window breaches.
+func (a *alertState) observeWindow(breached bool, window v1alpha1.SampleWindow) bool {
+	a.samples = append(a.samples, breached)
+	if len(a.samples) > window.Samples {
+		a.samples = a.samples[len(a.samples)-window.Samples:]
+	}
+
+	breaches := 0
+	for _, b := range a.samples {
+		if b {
+			breaches++
+		}
+	}
+
+	if breaches == 0 {
+		*a = alertState{}
+		return false
+	}
+
+	if !a.active {
+		a.start()
+	}
+
+	if breaches >= requiredBreaches(window) {
+		*a = alertState{}
+		return true
+	}
+
+	return false
+}
+
+// changed returns true if the alert state differs from the given previous
+// state in a way that must be recorded. Samples aren't recorded since they
+// change with every poll.
+func (a alertState) changed(prev alertState) bool {
+	return a.active != prev.active || !a.startTime.Equal(prev.startTime)
+}
+
+// requiredBreaches returns the number of samples in the window that must
+// breach the threshold for an alert to fire
+func requiredBreaches(window v1alpha1.SampleWindow) int {
+	if window.RequiredBreaches <= 0 || window.RequiredBreaches > window.Samples {
+		return window.Samples
+	}
+
+	return window.RequiredBreaches
+}
+
+func newMetricPoller(asp *v1alpha1.AutoscalingPolicy, asgName string, nodeSelector map[string]string,
+	recorder record.EventRecorder, recordForecast forecastRecorder, recordPollStatus pollStatusRecorder) metricPoller {
 	return metricPoller{
-		asp:          asp,
-		nodeSelector: nodeSelector,
+		asp:              asp,
+		asgName:          asgName,
+		nodeSelector:     nodeSelector,
+		recorder:         recorder,
+		recordForecast:   recordForecast,
+		recordPollStatus: recordPollStatus,
+		upAlert:          &alertState{},
+		downAlert:        &alertState{},
+		predictive:       &predictiveState{},
+		state:            &pollState{},
+	}
+}
+
+// restoreState restores the poll and alert state recorded in the policy's
+// status for the poller's AutoscalingGroup, e.g. before Cerebral restarted.
+// Alert state is only restored if it was recorded for the current generation
+// of the policy.
+func (p *metricPoller) restoreState() {
+	for _, status := range p.asp.Status.Polls {
+		if status.AutoscalingGroup != p.asgName {
+			continue
+		}
+
+		if status.LastFailureTime != nil {
+			p.state.lastFailure = status.LastFailureTime.Time
+		}
+
+		if status.LastSuccessTime != nil {
+			p.state.lastSuccess = status.LastSuccessTime.Time
+		}
+
+		p.state.lastValue = status.LastValue
+
+		if status.ObservedGeneration != p.asp.ObjectMeta.Generation {
+			log.Infof("Poller for ASP %q and ASG %q not restoring alert state recorded for a previous generation of the policy",
+				p.asp.ObjectMeta.Name, p.asgName)
+			return
+		}
+
+		if status.ScaleUpAlertStartTime != nil {
+			p.upAlert.active = true
+			p.upAlert.startTime = status.ScaleUpAlertStartTime.Time
+		}
+
+		if status.ScaleDownAlertStartTime != nil {
+			p.downAlert.active = true
+			p.downAlert.startTime = status.ScaleDownAlertStartTime.Time
+		}
+
+		return
 	}
 }
 
-func sendAlert(alertCh chan<- alert, msg alert) {
+// sendAlert sends the alert to the poll manager, blocking until it's received
+// or the poller is stopped. Alerts are never dropped, so a poller waits for
+// the poll manager to finish handling earlier alerts.
+func sendAlert(alertCh chan<- alert, stopCh <-chan struct{}, msg alert) {
 	select {
 	case alertCh <- msg:
-	default:
-		log.Debugf("Alert channel is full. Discarding alert %v", msg)
+	case <-stopCh:
+		log.Debugf("Poller stopped. Discarding alert %v", msg)
 	}
 }
 
-func (p metricPoller) run(wg *sync.WaitGroup, alertCh chan<- alert, stopCh <-chan struct{}) {
-	defer wg.Done()
-
+func (p metricPoller) run(alertCh chan<- alert, stopCh <-chan struct{}) {
 	pollInterval := time.Duration(p.asp.Spec.PollInterval) * time.Second
 	samplePeriod := time.Duration(p.asp.Spec.SamplePeriod) * time.Second
 	policyName := p.asp.ObjectMeta.Name
-	backendName := p.asp.Spec.MetricsBackend
-	metric := p.asp.Spec.Metric
-	metricConfig := p.asp.Spec.MetricConfiguration
+	metricConfig := metrics.WithAutoscalingGroup(p.asp.Spec.MetricConfiguration, p.asgName)
+	tolerance := newFailureTolerance(p.asp.Spec.FailureTolerance)
+
+	predictive := p.predictive
+	state := p.state
 
-	upAlert := &alertState{}
-	downAlert := &alertState{}
+	// A replacement poller inherits the failures of the poller it replaces
+	instrumentation.SetPolicyPollFailures(p.asgName, policyName, state.failures)
+	defer instrumentation.DeletePolicyPollFailures(p.asgName, policyName)
+	defer instrumentation.DeletePolicyForecast(p.asgName, policyName)
+	if p.asp.Spec.Predictive != nil && predictive.forecast != nil {
+		instrumentation.SetPolicyForecast(p.asgName, policyName, *predictive.forecast)
+	}
 
-	ticker := time.NewTicker(pollInterval)
-	defer ticker.Stop()
+	timer := time.NewTimer(pollInterval)
+	defer timer.Stop()
 
 	for {
 		select {
-		case <-ticker.C:
-			backend, err := metrics.Registry().Get(backendName)
+		case <-timer.C:
+			backend, val, err := p.poll(metricConfig)
 			if err != nil {
-				err = errors.Wrapf(err, "metrics backend %q specified by policy %q is unavailable", backendName, policyName)
-				sendAlert(alertCh, alert{err: err})
-				return
+				p.handlePollFailure(state, tolerance, err)
+				timer.Reset(tolerance.backoff(state.failures))
+				continue
 			}
 
-			val, err := backend.GetValue(metric, metricConfig, p.nodeSelector)
-			if err != nil {
-				err = errors.Wrapf(err, "getting metric %q for policy %q", metric, policyName)
-				sendAlert(alertCh, alert{err: err})
-				return
-			}
+			recovered := p.handlePollSuccess(state, tolerance)
+			timer.Reset(pollInterval)
 
 			log.Debugf("Poller for ASP %q got value %f", policyName, val)
 
+			upBefore := *p.upAlert
+			downBefore := *p.downAlert
+
 			// Scale up alerts
 			upConfig := p.asp.Spec.ScalingPolicy.ScaleUp
-			if policyConfigurationShouldFireAlert(upConfig, upAlert, samplePeriod, val) {
-				p.fireAlert(alertCh, upConfig, scaleDirectionUp)
+			if step, fire := policyConfigurationShouldFireAlert(upConfig, p.upAlert, samplePeriod, p.asp.Spec.SampleWindow, val); fire {
+				p.fireAlert(alertCh, stopCh, step, scaleDirectionUp)
 			}
 
 			// Scale down alerts
-			downConfig := p.asp.Spec.ScalingPolicy.ScaleDown
-			if policyConfigurationShouldFireAlert(downConfig, downAlert, samplePeriod, val) {
-				p.fireAlert(alertCh, downConfig, scaleDirectionDown)
+			downConfig := scaleDownWithHysteresis(p.asp.Spec.ScalingPolicy, p.asp.Spec.Hysteresis)
+			if step, fire := policyConfigurationShouldFireAlert(downConfig, p.downAlert, samplePeriod, p.asp.Spec.SampleWindow, val); fire {
+				p.fireAlert(alertCh, stopCh, step, scaleDirectionDown)
+			}
+
+			// Record the alert state whenever it changes so that it can be
+			// restored if Cerebral restarts
+			if recovered || p.upAlert.changed(upBefore) || p.downAlert.changed(downBefore) {
+				v := val
+				state.lastValue = &v
+				p.updatePollStatus(state, nil)
+			}
+
+			// Predictive scale up alerts
+			if p.asp.Spec.Predictive != nil && predictive.shouldForecast(p.asp.Spec.Predictive, nowFunc()) {
+				p.predict(alertCh, stopCh, backend, metricConfig, predictive, samplePeriod)
 			}
 
 		case <-stopCh:
@@ -103,43 +341,255 @@ func (p metricPoller) run(wg *sync.WaitGroup, alertCh chan<- alert, stopCh <-cha
 	}
 }
 
-func (p *metricPoller) fireAlert(alertCh chan<- alert, policy *v1alpha1.ScalingPolicyConfiguration, dir scaleDirection) {
+// poll gets the policy's metric from its backend
+func (p *metricPoller) poll(metricConfig map[string]string) (metrics.Backend, float64, error) {
+	backendName := p.asp.Spec.MetricsBackend
+	metric := p.asp.Spec.Metric
+
+	backend, err := metrics.Registry().Get(backendName)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "metrics backend %q is unavailable", backendName)
+	}
+
+	val, err := backend.GetValue(metric, metricConfig, p.nodeSelector)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "getting metric %q", metric)
+	}
+
+	return backend, val, nil
+}
+
+// handlePollFailure records a failed poll, resetting the alert state if the
+// number of consecutive failures has just exceeded the tolerance
+func (p *metricPoller) handlePollFailure(state *pollState, tolerance failureTolerance, err error) {
+	policyName := p.asp.ObjectMeta.Name
+
+	state.failures++
+	state.lastFailure = nowFunc()
+
+	log.Warnf("Poller for ASP %q and ASG %q failed %d time(s) in a row: %s", policyName, p.asgName, state.failures, err)
+
+	exceeded := state.failures == tolerance.maxConsecutiveFailures+1
+	if exceeded {
+		// The alert timers can't be trusted after this many failures, since
+		// the metric may have recovered in the meantime
+		*p.upAlert = alertState{}
+		*p.downAlert = alertState{}
+	}
+
+	instrumentation.SetPolicyPollFailures(p.asgName, policyName, state.failures)
+	p.updatePollStatus(state, err)
+
+	if exceeded && p.recorder != nil {
+		p.recorder.Event(p.asp, corev1.EventTypeWarning, events.MetricPollFailing,
+			fmt.Sprintf("Polling metric for AutoscalingGroup %s failed %d times in a row: %s", p.asgName, state.failures, err))
+	}
+}
+
+// handlePollSuccess records a successful poll, recording recovery if previous
+// polls failed. It returns true if the poll status must be updated to reflect
+// the recovery.
+func (p *metricPoller) handlePollSuccess(state *pollState, tolerance failureTolerance) bool {
+	failures := state.failures
+
+	state.failures = 0
+	state.lastSuccess = nowFunc()
+
+	if failures == 0 {
+		return false
+	}
+
+	policyName := p.asp.ObjectMeta.Name
+	log.Infof("Poller for ASP %q and ASG %q recovered after %d failure(s)", policyName, p.asgName, failures)
+
+	instrumentation.SetPolicyPollFailures(p.asgName, policyName, 0)
+
+	if failures > tolerance.maxConsecutiveFailures && p.recorder != nil {
+		p.recorder.Event(p.asp, corev1.EventTypeNormal, events.MetricPollRecovered,
+			fmt.Sprintf("Polling metric for AutoscalingGroup %s recovered after %d failures", p.asgName, failures))
+	}
+
+	return true
+}
+
+// updatePollStatus persists the poll and alert state with the error of the
+// last poll, if any. Failing to persist it is not fatal.
+func (p *metricPoller) updatePollStatus(state *pollState, err error) {
+	if p.recordPollStatus == nil {
+		return
+	}
+
+	status := v1alpha1.PolicyPollStatus{
+		AutoscalingGroup:    p.asgName,
+		ConsecutiveFailures: state.failures,
+		LastValue:           state.lastValue,
+		ObservedGeneration:  p.asp.ObjectMeta.Generation,
+	}
+
+	if p.upAlert.active {
+		t := metav1.NewTime(p.upAlert.startTime)
+		status.ScaleUpAlertStartTime = &t
+	}
+
+	if p.downAlert.active {
+		t := metav1.NewTime(p.downAlert.startTime)
+		status.ScaleDownAlertStartTime = &t
+	}
+
+	if err != nil {
+		status.LastError = err.Error()
+	}
+
+	if !state.lastFailure.IsZero() {
+		t := metav1.NewTime(state.lastFailure)
+		status.LastFailureTime = &t
+	}
+
+	if !state.lastSuccess.IsZero() {
+		t := metav1.NewTime(state.lastSuccess)
+		status.LastSuccessTime = &t
+	}
+
+	if err := p.recordPollStatus(p.asp.ObjectMeta.Name, status); err != nil {
+		log.Warnf("Poller for ASP %q failed to record poll status: %s", p.asp.ObjectMeta.Name, err)
+	}
+}
+
+// predict forecasts the policy's metric and fires a scale up alert if the
+// forecast breaches the scale up threshold within the horizon. Failing to
+// forecast is not fatal since reactive alerts can still fire.
+func (p *metricPoller) predict(alertCh chan<- alert, stopCh <-chan struct{}, backend metrics.Backend,
+	metricConfig map[string]string, state *predictiveState, samplePeriod time.Duration) {
+	policyName := p.asp.ObjectMeta.Name
+
+	now := nowFunc()
+	state.lastForecast = now
+
+	f, err := buildForecast(backend, p.asp, metricConfig, p.nodeSelector, now)
+	if err != nil {
+		log.Warnf("Poller for ASP %q failed to forecast: %s", policyName, err)
+		return
+	}
+
+	log.Debugf("Poller for ASP %q forecasted value %f", policyName, f.Value)
+
+	state.forecast = f
+
+	instrumentation.SetPolicyForecast(p.asgName, policyName, *f)
+	if p.recordForecast != nil {
+		if err := p.recordForecast(p.asgName, policyName, f); err != nil {
+			log.Warnf("Poller for ASP %q failed to record forecast: %s", policyName, err)
+		}
+	}
+
+	upConfig := p.asp.Spec.ScalingPolicy.ScaleUp
+	if step, fire := predictiveShouldFireAlert(upConfig, state, samplePeriod, f); fire {
+		log.Infof("Poller for ASP %q forecasts a breach at %s", policyName, f.PredictedBreachAt)
+		p.fireAlert(alertCh, stopCh, step, scaleDirectionUp)
+	}
+}
+
+func (p *metricPoller) fireAlert(alertCh chan<- alert, stopCh <-chan struct{},
+	step v1alpha1.ScalingPolicyStep, dir scaleDirection) {
 	// Thanks to CRD validation, we can assume that this is valid
-	adjustmentType, _ := adjustmentTypeFromString(policy.AdjustmentType)
-	sendAlert(alertCh, alert{
+	adjustmentType, _ := adjustmentTypeFromString(step.AdjustmentType)
+	sendAlert(alertCh, stopCh, alert{
 		aspName:         p.asp.ObjectMeta.Name,
 		direction:       dir,
 		adjustmentType:  adjustmentType,
-		adjustmentValue: policy.AdjustmentValue,
+		adjustmentValue: step.AdjustmentValue,
+		alertedAt:       nowFunc(),
 	})
 }
 
+// policyConfigurationShouldFireAlert updates the alert state for the given
+// policy configuration and value and returns the step that should be fired
+// along with true if an alert should fire. If a sample window is given, it's
+// used instead of the sample period.
 func policyConfigurationShouldFireAlert(policy *v1alpha1.ScalingPolicyConfiguration,
-	alert *alertState, samplePeriod time.Duration, val float64) bool {
+	alert *alertState, samplePeriod time.Duration, window *v1alpha1.SampleWindow, val float64) (v1alpha1.ScalingPolicyStep, bool) {
 	if policy == nil {
 		// Nothing to do
-		return false
+		return v1alpha1.ScalingPolicyStep{}, false
 	}
 
 	// Assume the operator is correct thanks to OpenAPI validation on the CR
 	op, _ := operator.FromString(policy.ComparisonOperator)
-	if !op.Evaluate(val, policy.Threshold) {
-		// We're not alerting, so nothing to do
-		return false
+	breached := op.Evaluate(val, policy.Threshold)
+
+	var fire bool
+	if window != nil {
+		fire = alert.observeWindow(breached, *window)
+	} else {
+		fire = alert.observe(breached, samplePeriod)
 	}
 
-	if !alert.active {
-		// We just started alerting, so update the alert state accordingly
-		alert.start()
-		return false
+	if !fire {
+		return v1alpha1.ScalingPolicyStep{}, false
 	}
 
-	if alert.shouldFire(samplePeriod) {
-		// We've been in an active alert state for the entire sample period,
-		// so fire an alert
-		alert.active = false
-		return true
+	// An alert only fires on a sample that breaches, so the step can be
+	// chosen from its value
+	return highestMatchingStep(policy, op, val), true
+}
+
+// scaleDownWithHysteresis returns the scale down configuration of the policy,
+// moving its threshold if needed so that the metric must be at least the
+// hysteresis margin beyond the scale up threshold to breach it
+func scaleDownWithHysteresis(policy v1alpha1.ScalingPolicy, hysteresis float64) *v1alpha1.ScalingPolicyConfiguration {
+	down := policy.ScaleDown
+	if down == nil || policy.ScaleUp == nil || hysteresis <= 0 {
+		return down
 	}
 
-	return false
+	result := *down
+
+	// Assume the operator is correct thanks to OpenAPI validation on the CR
+	op, _ := operator.FromString(down.ComparisonOperator)
+	switch op {
+	case operator.LessThan, operator.LessThanEqual:
+		result.Threshold = math.Min(down.Threshold, policy.ScaleUp.Threshold-hysteresis)
+	case operator.GreaterThan, operator.GreaterThanEqual:
+		result.Threshold = math.Max(down.Threshold, policy.ScaleUp.Threshold+hysteresis)
+	default:
+		// There's no notion of a margin for the remaining operators
+		return down
+	}
+
+	return &result
+}
+
+// highestMatchingStep returns the step furthest beyond the policy threshold
+// that the given value breaches. The policy's own threshold and adjustment act
+// as the first step, so it is returned if no additional steps match.
+func highestMatchingStep(policy *v1alpha1.ScalingPolicyConfiguration,
+	op operator.ComparisonOperator, val float64) v1alpha1.ScalingPolicyStep {
+	result := v1alpha1.ScalingPolicyStep{
+		Threshold:       policy.Threshold,
+		AdjustmentType:  policy.AdjustmentType,
+		AdjustmentValue: policy.AdjustmentValue,
+	}
+
+	for _, step := range policy.Steps {
+		if !op.Evaluate(val, step.Threshold) {
+			continue
+		}
+
+		switch op {
+		case operator.GreaterThan, operator.GreaterThanEqual:
+			if step.Threshold > result.Threshold {
+				result = step
+			}
+		case operator.LessThan, operator.LessThanEqual:
+			if step.Threshold < result.Threshold {
+				result = step
+			}
+		default:
+			// There's no notion of "higher" for the remaining operators, so
+			// the last matching step in the list wins
+			result = step
+		}
+	}
+
+	return result
 }
@@ -4,9 +4,14 @@ import (
 	"testing"
 	"time"
 
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
 	"github.com/containership/cerebral/pkg/apis/cerebral.containership.io/v1alpha1"
+	"github.com/containership/cerebral/pkg/operator"
 )
 
 func setTime(seconds int64) {
@@ -20,12 +25,44 @@ func resetTime() {
 }
 
 func TestNewMetricPoller(t *testing.T) {
-	p := newMetricPoller(&v1alpha1.AutoscalingPolicy{}, map[string]string{})
+	p := newMetricPoller(&v1alpha1.AutoscalingPolicy{}, "asg", map[string]string{}, nil, nil, nil)
 	assert.NotNil(t, p, "never nil")
 }
 
+func TestSendAlert(t *testing.T) {
+	alertCh := make(chan alert)
+	stopCh := make(chan struct{})
+
+	doneCh := make(chan struct{})
+	go func() {
+		sendAlert(alertCh, stopCh, alert{aspName: "cpu"})
+		close(doneCh)
+	}()
+
+	select {
+	case a := <-alertCh:
+		assert.Equal(t, "cpu", a.aspName, "alert is not dropped when the channel is full")
+	case <-time.After(time.Second):
+		assert.Fail(t, "alert is sent")
+	}
+	<-doneCh
+
+	close(stopCh)
+	doneCh = make(chan struct{})
+	go func() {
+		sendAlert(alertCh, stopCh, alert{aspName: "cpu"})
+		close(doneCh)
+	}()
+
+	select {
+	case <-doneCh:
+	case <-time.After(time.Second):
+		assert.Fail(t, "stopped poller does not block sending an alert")
+	}
+}
+
 func TestPolicyConfigurationShouldFireAlert(t *testing.T) {
-	fired := policyConfigurationShouldFireAlert(nil, &alertState{}, time.Second, 0)
+	_, fired := policyConfigurationShouldFireAlert(nil, &alertState{}, time.Second, nil, 0)
 	assert.False(t, fired, "nil config is a noop")
 
 	alert := &alertState{active: false}
@@ -34,32 +71,226 @@ func TestPolicyConfigurationShouldFireAlert(t *testing.T) {
 		ComparisonOperator: ">=",
 	}
 
-	fired = policyConfigurationShouldFireAlert(gteConfig, alert, 5*time.Second, 10)
+	_, fired = policyConfigurationShouldFireAlert(gteConfig, alert, 5*time.Second, nil, 10)
 	assert.False(t, fired, "have not breached threshold")
 
 	alert = &alertState{active: true, startTime: time.Unix(0, 0)}
 	setTime(2)
-	fired = policyConfigurationShouldFireAlert(gteConfig, alert, 5*time.Second, 80)
+	_, fired = policyConfigurationShouldFireAlert(gteConfig, alert, 5*time.Second, nil, 80)
 	assert.False(t, fired, "breached threshold but not long enough")
 
 	alert = &alertState{active: false}
 	setTime(2)
-	fired = policyConfigurationShouldFireAlert(gteConfig, alert, 5*time.Second, 80)
+	_, fired = policyConfigurationShouldFireAlert(gteConfig, alert, 5*time.Second, nil, 80)
 	assert.False(t, fired, "breached threshold but not active")
 
 	alert = &alertState{active: true, startTime: time.Unix(0, 0)}
 	setTime(10)
-	fired = policyConfigurationShouldFireAlert(gteConfig, alert, 5*time.Second, 80)
+	_, fired = policyConfigurationShouldFireAlert(gteConfig, alert, 5*time.Second, nil, 80)
 	assert.True(t, fired, "breached threshold for long enough")
 
 	alert = &alertState{active: false, startTime: time.Unix(0, 0)}
 	setTime(2)
-	fired = policyConfigurationShouldFireAlert(gteConfig, alert, 5*time.Second, 10)
+	_, fired = policyConfigurationShouldFireAlert(gteConfig, alert, 5*time.Second, nil, 10)
 	assert.False(t, fired, "breached threshold but not long enough")
 
 	resetTime()
 }
 
+func TestPolicyConfigurationShouldFireAlertResetsOnRecovery(t *testing.T) {
+	defer resetTime()
+
+	config := &v1alpha1.ScalingPolicyConfiguration{
+		Threshold:          75,
+		ComparisonOperator: ">",
+	}
+	alert := &alertState{}
+
+	setTime(0)
+	_, fired := policyConfigurationShouldFireAlert(config, alert, 5*time.Second, nil, 80)
+	assert.False(t, fired, "first breach starts alert")
+	assert.True(t, alert.active)
+
+	setTime(3)
+	_, fired = policyConfigurationShouldFireAlert(config, alert, 5*time.Second, nil, 10)
+	assert.False(t, fired, "recovered")
+	assert.False(t, alert.active, "recovery resets alert")
+
+	setTime(5)
+	_, fired = policyConfigurationShouldFireAlert(config, alert, 5*time.Second, nil, 80)
+	assert.False(t, fired, "breach at end of sample period doesn't fire after recovery")
+	assert.Equal(t, time.Unix(5, 0), alert.startTime, "sample period restarts")
+
+	setTime(10)
+	_, fired = policyConfigurationShouldFireAlert(config, alert, 5*time.Second, nil, 80)
+	assert.True(t, fired, "every sample breached for the sample period")
+	assert.False(t, alert.active, "alert reset after firing")
+}
+
+func TestPolicyConfigurationShouldFireAlertWindow(t *testing.T) {
+	config := &v1alpha1.ScalingPolicyConfiguration{
+		Threshold:          75,
+		ComparisonOperator: ">",
+		AdjustmentType:     "absolute",
+		AdjustmentValue:    1,
+	}
+	window := &v1alpha1.SampleWindow{Samples: 4, RequiredBreaches: 3}
+	alert := &alertState{}
+
+	// The sample period is ignored with a window
+	for i, val := range []float64{80, 10, 80} {
+		_, fired := policyConfigurationShouldFireAlert(config, alert, 0, window, val)
+		assert.Falsef(t, fired, "sample %d: not enough breaches", i)
+	}
+	assert.True(t, alert.active, "active while a sample in the window breaches")
+
+	step, fired := policyConfigurationShouldFireAlert(config, alert, 0, window, 90)
+	assert.True(t, fired, "3 of last 4 samples breached")
+	assert.Equal(t, float64(1), step.AdjustmentValue)
+	assert.Equal(t, alertState{}, *alert, "window reset after firing")
+
+	// Breaches that slide out of the window no longer count
+	for i, val := range []float64{80, 80, 10, 10, 10, 80} {
+		_, fired := policyConfigurationShouldFireAlert(config, alert, 0, window, val)
+		assert.Falsef(t, fired, "sample %d: not enough breaches in window", i)
+	}
+	assert.Len(t, alert.samples, 4, "only the window is kept")
+
+	for i := 0; i < 3; i++ {
+		policyConfigurationShouldFireAlert(config, alert, 0, window, 10)
+	}
+	assert.True(t, alert.active, "active while the breach remains in the window")
+
+	_, fired = policyConfigurationShouldFireAlert(config, alert, 0, window, 10)
+	assert.False(t, fired)
+	assert.False(t, alert.active, "inactive once no sample in the window breaches")
+
+	// All samples must breach if required breaches is unset
+	window = &v1alpha1.SampleWindow{Samples: 2}
+	alert = &alertState{}
+	_, fired = policyConfigurationShouldFireAlert(config, alert, 0, window, 80)
+	assert.False(t, fired)
+	_, fired = policyConfigurationShouldFireAlert(config, alert, 0, window, 80)
+	assert.True(t, fired, "all samples breached")
+}
+
+func TestRequiredBreaches(t *testing.T) {
+	assert.Equal(t, 5, requiredBreaches(v1alpha1.SampleWindow{Samples: 5}), "defaults to all samples")
+	assert.Equal(t, 3, requiredBreaches(v1alpha1.SampleWindow{Samples: 5, RequiredBreaches: 3}))
+	assert.Equal(t, 5, requiredBreaches(v1alpha1.SampleWindow{Samples: 5, RequiredBreaches: 10}), "at most all samples")
+}
+
+func TestAlertStateChanged(t *testing.T) {
+	alert := alertState{active: true, startTime: time.Unix(10, 0), samples: []bool{true}}
+
+	assert.False(t, alert.changed(alertState{active: true, startTime: time.Unix(10, 0)}), "samples are ignored")
+	assert.True(t, alert.changed(alertState{}), "activated")
+	assert.True(t, alert.changed(alertState{active: true, startTime: time.Unix(5, 0)}), "restarted")
+}
+
+func TestScaleDownWithHysteresis(t *testing.T) {
+	policy := v1alpha1.ScalingPolicy{
+		ScaleUp: &v1alpha1.ScalingPolicyConfiguration{
+			Threshold:          80,
+			ComparisonOperator: ">",
+		},
+		ScaleDown: &v1alpha1.ScalingPolicyConfiguration{
+			Threshold:          75,
+			ComparisonOperator: "<",
+		},
+	}
+
+	assert.True(t, policy.ScaleDown == scaleDownWithHysteresis(policy, 0), "no hysteresis")
+	assert.Equal(t, float64(70), scaleDownWithHysteresis(policy, 10).Threshold, "margin below scale up threshold")
+	assert.Equal(t, float64(75), scaleDownWithHysteresis(policy, 2).Threshold, "scale down threshold is already beyond margin")
+	assert.Equal(t, float64(75), policy.ScaleDown.Threshold, "policy is not modified")
+
+	inverted := v1alpha1.ScalingPolicy{
+		ScaleUp: &v1alpha1.ScalingPolicyConfiguration{
+			Threshold:          20,
+			ComparisonOperator: "<=",
+		},
+		ScaleDown: &v1alpha1.ScalingPolicyConfiguration{
+			Threshold:          25,
+			ComparisonOperator: ">=",
+		},
+	}
+	assert.Equal(t, float64(30), scaleDownWithHysteresis(inverted, 10).Threshold, "margin above inverted scale up threshold")
+
+	assert.Nil(t, scaleDownWithHysteresis(v1alpha1.ScalingPolicy{ScaleUp: policy.ScaleUp}, 10), "no scale down")
+
+	downOnly := v1alpha1.ScalingPolicy{ScaleDown: policy.ScaleDown}
+	assert.True(t, policy.ScaleDown == scaleDownWithHysteresis(downOnly, 10), "no scale up")
+
+	equal := v1alpha1.ScalingPolicy{
+		ScaleUp: policy.ScaleUp,
+		ScaleDown: &v1alpha1.ScalingPolicyConfiguration{
+			Threshold:          0,
+			ComparisonOperator: "==",
+		},
+	}
+	assert.True(t, equal.ScaleDown == scaleDownWithHysteresis(equal, 10), "no margin for equality")
+}
+
+func TestPolicyConfigurationShouldFireAlertSteps(t *testing.T) {
+	config := &v1alpha1.ScalingPolicyConfiguration{
+		Threshold:          70,
+		ComparisonOperator: ">",
+		AdjustmentType:     "absolute",
+		AdjustmentValue:    1,
+		Steps: []v1alpha1.ScalingPolicyStep{
+			{Threshold: 95, AdjustmentType: "percent", AdjustmentValue: 25},
+			{Threshold: 85, AdjustmentType: "absolute", AdjustmentValue: 3},
+		},
+	}
+
+	alert := &alertState{active: true, startTime: time.Unix(0, 0)}
+	setTime(10)
+	step, fired := policyConfigurationShouldFireAlert(config, alert, 5*time.Second, nil, 90)
+	assert.True(t, fired, "breached threshold for long enough")
+	assert.Equal(t, float64(3), step.AdjustmentValue, "highest matching step is fired")
+
+	resetTime()
+}
+
+func TestHighestMatchingStep(t *testing.T) {
+	gtConfig := &v1alpha1.ScalingPolicyConfiguration{
+		Threshold:       70,
+		AdjustmentType:  "absolute",
+		AdjustmentValue: 1,
+		Steps: []v1alpha1.ScalingPolicyStep{
+			{Threshold: 95, AdjustmentType: "percent", AdjustmentValue: 25},
+			{Threshold: 85, AdjustmentType: "absolute", AdjustmentValue: 3},
+		},
+	}
+
+	step := highestMatchingStep(gtConfig, operator.GreaterThan, 75)
+	assert.Equal(t, float64(1), step.AdjustmentValue, "no steps match so base adjustment is used")
+
+	step = highestMatchingStep(gtConfig, operator.GreaterThan, 90)
+	assert.Equal(t, float64(3), step.AdjustmentValue, "single step matches")
+
+	step = highestMatchingStep(gtConfig, operator.GreaterThan, 99)
+	assert.Equal(t, "percent", step.AdjustmentType, "highest of multiple matching steps")
+	assert.Equal(t, float64(25), step.AdjustmentValue, "highest of multiple matching steps")
+
+	ltConfig := &v1alpha1.ScalingPolicyConfiguration{
+		Threshold:       30,
+		AdjustmentType:  "absolute",
+		AdjustmentValue: 1,
+		Steps: []v1alpha1.ScalingPolicyStep{
+			{Threshold: 20, AdjustmentType: "absolute", AdjustmentValue: 2},
+			{Threshold: 10, AdjustmentType: "absolute", AdjustmentValue: 4},
+		},
+	}
+
+	step = highestMatchingStep(ltConfig, operator.LessThanEqual, 5)
+	assert.Equal(t, float64(4), step.AdjustmentValue, "lowest threshold wins for less than operators")
+
+	step = highestMatchingStep(&v1alpha1.ScalingPolicyConfiguration{}, operator.Equal, 0)
+	assert.Equal(t, v1alpha1.ScalingPolicyStep{}, step, "no steps defined")
+}
+
 func TestAlertShouldFire(t *testing.T) {
 	inactive := &alertState{active: false}
 	fired := inactive.shouldFire(0)
@@ -82,3 +313,140 @@ func TestAlertShouldFire(t *testing.T) {
 
 	resetTime()
 }
+
+func TestNewFailureTolerance(t *testing.T) {
+	assert.Equal(t, failureTolerance{
+		maxConsecutiveFailures: defaultMaxConsecutiveFailures,
+		initialBackoff:         defaultInitialBackoff,
+		maxBackoff:             defaultMaxBackoff,
+	}, newFailureTolerance(nil), "defaults")
+
+	assert.Equal(t, failureTolerance{
+		maxConsecutiveFailures: 10,
+		initialBackoff:         time.Second,
+		maxBackoff:             time.Minute,
+	}, newFailureTolerance(&v1alpha1.FailureTolerance{
+		MaxConsecutiveFailures: 10,
+		InitialBackoff:         1,
+		MaxBackoff:             60,
+	}), "configured")
+
+	assert.Equal(t, failureTolerance{
+		maxConsecutiveFailures: defaultMaxConsecutiveFailures,
+		initialBackoff:         10 * time.Minute,
+		maxBackoff:             10 * time.Minute,
+	}, newFailureTolerance(&v1alpha1.FailureTolerance{
+		InitialBackoff: 600,
+	}), "max backoff is at least the initial backoff")
+}
+
+func TestFailureToleranceBackoff(t *testing.T) {
+	tolerance := failureTolerance{
+		initialBackoff: time.Second,
+		maxBackoff:     10 * time.Second,
+	}
+
+	assert.Equal(t, time.Second, tolerance.backoff(1), "first retry uses initial backoff")
+	assert.Equal(t, 2*time.Second, tolerance.backoff(2), "backoff doubles")
+	assert.Equal(t, 8*time.Second, tolerance.backoff(4))
+	assert.Equal(t, 10*time.Second, tolerance.backoff(5), "backoff is capped")
+	assert.Equal(t, 10*time.Second, tolerance.backoff(1000), "backoff doesn't overflow")
+}
+
+func TestHandlePollFailureAndSuccess(t *testing.T) {
+	defer resetTime()
+
+	var statuses []v1alpha1.PolicyPollStatus
+	recorder := record.NewFakeRecorder(10)
+	p := newMetricPoller(&v1alpha1.AutoscalingPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "asp", Generation: 2},
+	}, "asg", nil, recorder, nil, func(aspName string, status v1alpha1.PolicyPollStatus) error {
+		assert.Equal(t, "asp", aspName)
+		statuses = append(statuses, status)
+		return errors.New("status updates may fail")
+	})
+
+	tolerance := failureTolerance{maxConsecutiveFailures: 2}
+	state := p.state
+
+	setTime(10)
+	assert.False(t, p.handlePollSuccess(state, tolerance), "no status update while healthy")
+
+	p.upAlert.start()
+
+	setTime(20)
+	p.handlePollFailure(state, tolerance, errors.New("unavailable"))
+	assert.Equal(t, 1, state.failures)
+	if assert.Len(t, statuses, 1, "failures are recorded") {
+		assert.Equal(t, "asg", statuses[0].AutoscalingGroup)
+		assert.Equal(t, 1, statuses[0].ConsecutiveFailures)
+		assert.Equal(t, "unavailable", statuses[0].LastError)
+		assert.Equal(t, time.Unix(20, 0), statuses[0].LastFailureTime.Time)
+		assert.Equal(t, time.Unix(10, 0), statuses[0].LastSuccessTime.Time)
+		assert.Equal(t, int64(2), statuses[0].ObservedGeneration)
+		assert.Equal(t, time.Unix(10, 0), statuses[0].ScaleUpAlertStartTime.Time, "alert state is recorded")
+		assert.Nil(t, statuses[0].ScaleDownAlertStartTime)
+	}
+
+	p.handlePollFailure(state, tolerance, errors.New("unavailable"))
+	assert.True(t, p.upAlert.active, "alert state is kept while failures are tolerated")
+	assert.Empty(t, recorder.Events, "no events while failures are tolerated")
+
+	p.handlePollFailure(state, tolerance, errors.New("unavailable"))
+	assert.False(t, p.upAlert.active, "alert state is reset once tolerance is exceeded")
+	assert.Nil(t, statuses[2].ScaleUpAlertStartTime, "reset alert state is recorded")
+	assert.Len(t, recorder.Events, 1, "failing event recorded")
+
+	p.handlePollFailure(state, tolerance, errors.New("unavailable"))
+	assert.Len(t, recorder.Events, 1, "failing event only recorded once")
+
+	setTime(30)
+	assert.True(t, p.handlePollSuccess(state, tolerance), "recovery requires status update")
+	assert.Equal(t, 0, state.failures, "failures reset")
+	assert.Equal(t, time.Unix(30, 0), state.lastSuccess)
+	assert.Len(t, recorder.Events, 2, "recovered event recorded")
+
+	p.handlePollFailure(state, tolerance, errors.New("unavailable"))
+	assert.True(t, p.handlePollSuccess(state, tolerance))
+	assert.Len(t, recorder.Events, 2, "no events when recovering from tolerated failures")
+}
+
+func TestRestoreState(t *testing.T) {
+	value := 0.5
+	asp := &v1alpha1.AutoscalingPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "asp", Generation: 2},
+		Status: v1alpha1.AutoscalingPolicyStatus{
+			Polls: []v1alpha1.PolicyPollStatus{
+				{
+					AutoscalingGroup:        "other",
+					ObservedGeneration:      2,
+					ScaleDownAlertStartTime: &metav1.Time{Time: time.Unix(5, 0)},
+				},
+				{
+					AutoscalingGroup:      "asg",
+					LastSuccessTime:       &metav1.Time{Time: time.Unix(30, 0)},
+					LastValue:             &value,
+					ObservedGeneration:    2,
+					ScaleUpAlertStartTime: &metav1.Time{Time: time.Unix(10, 0)},
+				},
+			},
+		},
+	}
+
+	p := newMetricPoller(asp, "asg", nil, nil, nil, nil)
+	p.restoreState()
+	assert.Equal(t, alertState{active: true, startTime: time.Unix(10, 0)}, *p.upAlert, "alert state restored")
+	assert.Equal(t, alertState{}, *p.downAlert, "only state of this ASG restored")
+	assert.Equal(t, time.Unix(30, 0), p.state.lastSuccess)
+	assert.Equal(t, &value, p.state.lastValue)
+
+	asp.ObjectMeta.Generation = 3
+	p = newMetricPoller(asp, "asg", nil, nil, nil, nil)
+	p.restoreState()
+	assert.Equal(t, alertState{}, *p.upAlert, "alert state of previous generation not restored")
+	assert.Equal(t, time.Unix(30, 0), p.state.lastSuccess, "poll state restored regardless of generation")
+
+	p = newMetricPoller(asp, "new", nil, nil, nil, nil)
+	p.restoreState()
+	assert.Equal(t, pollState{}, *p.state, "nothing to restore")
+}
@@ -2,6 +2,8 @@ package controller
 
 import (
 	"fmt"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -9,6 +11,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 
 	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -17,6 +20,7 @@ import (
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelistersv1 "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
@@ -55,13 +59,24 @@ type MetricsController struct {
 	aseLister clisters.AutoscalingEngineLister
 	aseSynced cache.InformerSynced
 
+	// Nodes and pods are needed to arbitrate between alerts based on target
+	// node counts
+	nodeLister corelistersv1.NodeLister
+	nodeSynced cache.InformerSynced
+
+	podLister corelistersv1.PodLister
+	podSynced cache.InformerSynced
+
 	workqueue workqueue.RateLimitingInterface
 
 	recorder record.EventRecorder
 
 	// Key is ASG name
-	pollManagers   map[string]pollManager
+	pollManagers   map[string]*pollManager
 	scaleRequestCh chan<- ScaleRequest
+
+	// statusLock serializes AutoscalingPolicy status updates from pollers
+	statusLock sync.Mutex
 }
 
 // NewMetrics constructs a new Metrics controller
@@ -76,7 +91,7 @@ func NewMetrics(kubeclientset kubernetes.Interface,
 		kubeclientset:     kubeclientset,
 		cerebralclientset: cerebralclientset,
 		workqueue:         workqueue.NewNamedRateLimitingQueue(rateLimiter, metricsControllerName),
-		pollManagers:      make(map[string]pollManager),
+		pollManagers:      make(map[string]*pollManager),
 		scaleRequestCh:    scaleRequestCh,
 	}
 
@@ -92,6 +107,8 @@ func NewMetrics(kubeclientset kubernetes.Interface,
 	asgInformer := cInformerFactory.Cerebral().V1alpha1().AutoscalingGroups()
 	aspInformer := cInformerFactory.Cerebral().V1alpha1().AutoscalingPolicies()
 	aseInformer := cInformerFactory.Cerebral().V1alpha1().AutoscalingEngines()
+	nodeInformer := kubeInformerFactory.Core().V1().Nodes()
+	podInformer := kubeInformerFactory.Core().V1().Pods()
 
 	log.Infof("%s: setting up event handlers", metricsControllerName)
 
@@ -116,7 +133,10 @@ func NewMetrics(kubeclientset kubernetes.Interface,
 		UpdateFunc: func(old, new interface{}) {
 			newASP := new.(*cerebralv1alpha1.AutoscalingPolicy)
 			oldASP := old.(*cerebralv1alpha1.AutoscalingPolicy)
-			if newASP.ResourceVersion == oldASP.ResourceVersion {
+			// Like ASGs, ASPs are only enqueued on spec changes so that
+			// forecasts written to the status don't restart the pollers
+			if newASP.ResourceVersion == oldASP.ResourceVersion ||
+				newASP.Generation == oldASP.Generation {
 				return
 			}
 			c.enqueueASGsForAutoscalingPolicy(new)
@@ -143,6 +163,12 @@ func NewMetrics(kubeclientset kubernetes.Interface,
 	c.aseLister = aseInformer.Lister()
 	c.aseSynced = aseInformer.Informer().HasSynced
 
+	c.nodeLister = nodeInformer.Lister()
+	c.nodeSynced = nodeInformer.Informer().HasSynced
+
+	c.podLister = podInformer.Lister()
+	c.podSynced = podInformer.Informer().HasSynced
+
 	return c
 }
 
@@ -157,7 +183,7 @@ func (c *MetricsController) Run(numWorkers int, stopCh <-chan struct{}) error {
 	// Start the informer factories to begin populating the informer caches
 	log.Infof("Starting %s", metricsControllerName)
 
-	if ok := cache.WaitForCacheSync(stopCh, c.asgSynced, c.aspSynced, c.aseSynced); !ok {
+	if ok := cache.WaitForCacheSync(stopCh, c.asgSynced, c.aspSynced, c.aseSynced, c.nodeSynced, c.podSynced); !ok {
 		return errors.Errorf("%s: failed to wait for caches to sync", metricsControllerName)
 	}
 
@@ -262,10 +288,8 @@ func (c *MetricsController) enqueueASGsForAutoscalingPolicy(obj interface{}) {
 	}
 
 	for _, asg := range asgs {
-		for _, p := range asg.Spec.Policies {
-			if p == asp.ObjectMeta.Name {
-				c.enqueueAutoscalingGroup(asg)
-			}
+		if asgUsesPolicy(asg, asp.ObjectMeta.Name) {
+			c.enqueueAutoscalingGroup(asg)
 		}
 	}
 }
@@ -313,15 +337,9 @@ func (c *MetricsController) syncHandler(key string) error {
 		return err
 	}
 
-	// TODO obviously this can be easily optimized by updating the underlying types
-	// instead of just shutting down, deleting, and recreating, but this works for now
-	if _, ok := c.pollManagers[asgName]; ok {
-		log.Debugf("%s: poll manager for %q already exists - it will be replaced", metricsControllerName, asgName)
-		c.cleanupPollManagerForASG(asgName)
-	}
-
 	if asg.Spec.Suspended {
 		log.Infof("%s: AutoscalingGroup %q is suspended - skipping", metricsControllerName, asgName)
+		c.cleanupPollManagerForASG(asgName)
 		return nil
 	}
 
@@ -343,6 +361,7 @@ func (c *MetricsController) syncHandler(key string) error {
 
 	if len(asps) == 0 {
 		log.Warnf("%s: No valid policies found for AutoscalingGroup %q - skipping", metricsControllerName, asgName)
+		c.cleanupPollManagerForASG(asgName)
 		return nil
 	}
 
@@ -350,19 +369,35 @@ func (c *MetricsController) syncHandler(key string) error {
 	if err != nil {
 		if kubeerrors.IsNotFound(err) {
 			log.Warnf("%s: No valid engines found for AutoscalingGroup %q - skipping", metricsControllerName, asgName)
+			c.cleanupPollManagerForASG(asgName)
 			return nil
 		}
 
 		return err
 	}
 
+	// Reconcile a running poll manager in place so that pollers for unchanged
+	// policies keep their alert state
+	if mgr, ok := c.pollManagers[asgName]; ok {
+		if mgr.update(asg.DeepCopy(), asps) {
+			log.Debugf("%s: updated poll manager for %q", metricsControllerName, asgName)
+			return nil
+		}
+
+		log.Debugf("%s: poll manager for %q has shut down - it will be replaced", metricsControllerName, asgName)
+		c.cleanupPollManagerForASG(asgName)
+	}
+
 	stopCh := make(chan struct{})
-	c.pollManagers[asgName] = newPollManager(asgName, asps, asg.Spec.NodeSelector, c.recorder, c.scaleRequestCh, stopCh)
+	mgr := newPollManager(asg.DeepCopy(), asps, c.nodeLister, c.podLister, c.recorder,
+		c.updateAutoscalingPolicyForecast, c.updateAutoscalingPolicyPollStatus, c.updateAutoscalingGroupPendingAlerts,
+		c.scaleRequestCh, stopCh)
+	c.pollManagers[asgName] = mgr
 
 	go func() {
 		log.Infof("Starting poll manager for AutoscalingGroup %q", asgName)
 
-		if err := c.pollManagers[asgName].run(); err != nil {
+		if err := mgr.run(); err != nil {
 			// Handle unexpected failures in the poller simply by requeueing the ASG so it tries again
 			// The ASG may be out of date at this point, but it doesn't matter
 			log.Errorf("Poll manager for AutoscalingGroup %q died: %s", asgName, err)
@@ -373,10 +408,115 @@ func (c *MetricsController) syncHandler(key string) error {
 	return nil
 }
 
+// updateAutoscalingPolicyForecast records the given forecast for the
+// AutoscalingGroup in the status of the AutoscalingPolicy. It is safe to call
+// from any poller.
+func (c *MetricsController) updateAutoscalingPolicyForecast(asgName, aspName string,
+	forecast *cerebralv1alpha1.PolicyForecast) error {
+	return c.updateAutoscalingPolicyPolls(aspName, asgName, func(poll *cerebralv1alpha1.PolicyPollStatus) {
+		poll.Forecast = forecast
+	})
+}
+
+// updateAutoscalingPolicyPollStatus records the given poll status in the
+// status of the AutoscalingPolicy, replacing any status for the same
+// AutoscalingGroup other than its forecast. It is safe to call from any
+// poller.
+func (c *MetricsController) updateAutoscalingPolicyPollStatus(aspName string, status cerebralv1alpha1.PolicyPollStatus) error {
+	return c.updateAutoscalingPolicyPolls(aspName, status.AutoscalingGroup, func(poll *cerebralv1alpha1.PolicyPollStatus) {
+		forecast := poll.Forecast
+		*poll = status
+		poll.Forecast = forecast
+	})
+}
+
+// updateAutoscalingPolicyPolls applies the given mutation to the latest poll
+// status of the AutoscalingGroup in the status of the AutoscalingPolicy,
+// retrying on conflicts. Poll statuses of AutoscalingGroups that no longer use
+// the policy are dropped.
+func (c *MetricsController) updateAutoscalingPolicyPolls(aspName, asgName string,
+	mutate func(*cerebralv1alpha1.PolicyPollStatus)) error {
+	// Pollers of every AutoscalingGroup using the policy update the same
+	// status, so serialize updates to avoid needless conflicts
+	c.statusLock.Lock()
+	defer c.statusLock.Unlock()
+
+	var err error
+	for i := 0; i < statusUpdateAttempts; i++ {
+		var asp *cerebralv1alpha1.AutoscalingPolicy
+		asp, err = c.cerebralclientset.CerebralV1alpha1().AutoscalingPolicies().Get(aspName, metav1.GetOptions{})
+		if err != nil {
+			return errors.Wrapf(err, "getting AutoscalingPolicy %q", aspName)
+		}
+
+		aspCopy := asp.DeepCopy()
+
+		poll := cerebralv1alpha1.PolicyPollStatus{AutoscalingGroup: asgName}
+		polls := make([]cerebralv1alpha1.PolicyPollStatus, 0, len(aspCopy.Status.Polls)+1)
+		for _, p := range aspCopy.Status.Polls {
+			if p.AutoscalingGroup == asgName {
+				poll = p
+				continue
+			}
+
+			asg, err := c.asgLister.Get(p.AutoscalingGroup)
+			if err != nil || !asgUsesPolicy(asg, aspName) {
+				continue
+			}
+
+			polls = append(polls, p)
+		}
+
+		mutate(&poll)
+		polls = append(polls, poll)
+
+		sort.Slice(polls, func(i, j int) bool {
+			return polls[i].AutoscalingGroup < polls[j].AutoscalingGroup
+		})
+
+		aspCopy.Status.Polls = polls
+
+		_, err = c.cerebralclientset.CerebralV1alpha1().AutoscalingPolicies().UpdateStatus(aspCopy)
+		if !kubeerrors.IsConflict(err) {
+			break
+		}
+	}
+
+	if err != nil {
+		return errors.Wrapf(err, "updating status of AutoscalingPolicy %q", aspName)
+	}
+
+	return nil
+}
+
+// updateAutoscalingGroupPendingAlerts records the alerts waiting to be
+// arbitrated in the status of the AutoscalingGroup
+func (c *MetricsController) updateAutoscalingGroupPendingAlerts(asgName string, alerts []cerebralv1alpha1.PendingAlert) error {
+	err := updateAutoscalingGroupStatus(c.cerebralclientset, asgName, func(status *cerebralv1alpha1.AutoscalingGroupStatus) {
+		status.PendingAlerts = alerts
+	})
+	if err != nil {
+		return errors.Wrapf(err, "updating status of AutoscalingGroup %q", asgName)
+	}
+
+	return nil
+}
+
+// asgUsesPolicy returns true if the AutoscalingGroup references the policy
+func asgUsesPolicy(asg *cerebralv1alpha1.AutoscalingGroup, aspName string) bool {
+	for _, p := range asg.Spec.Policies {
+		if p == aspName {
+			return true
+		}
+	}
+
+	return false
+}
+
 // Close any metric pollers associated with this ASG and its ASPs and
 // delete the poll manager from the map.
 func (c *MetricsController) cleanupPollManagerForASG(asgName string) {
-	var mgr pollManager
+	var mgr *pollManager
 	var ok bool
 	if mgr, ok = c.pollManagers[asgName]; !ok {
 		// Nothing to do
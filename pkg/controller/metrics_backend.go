@@ -2,9 +2,11 @@ package controller
 
 import (
 	"fmt"
+	"net/http"
 	"time"
 
 	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 
@@ -14,6 +16,8 @@ import (
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
 
+	metricsclient "k8s.io/metrics/pkg/client/clientset/versioned"
+
 	"github.com/containership/cluster-manager/pkg/log"
 
 	cerebralv1alpha1 "github.com/containership/cerebral/pkg/apis/cerebral.containership.io/v1alpha1"
@@ -22,9 +26,17 @@ import (
 	clisters "github.com/containership/cerebral/pkg/client/listers/cerebral.containership.io/v1alpha1"
 
 	"github.com/containership/cerebral/pkg/metrics"
+	"github.com/containership/cerebral/pkg/metrics/backends/cloudwatch"
+	"github.com/containership/cerebral/pkg/metrics/backends/custommetrics"
+	"github.com/containership/cerebral/pkg/metrics/backends/datadog"
+	"github.com/containership/cerebral/pkg/metrics/backends/expression"
+	"github.com/containership/cerebral/pkg/metrics/backends/httpconfig"
 	"github.com/containership/cerebral/pkg/metrics/backends/influxdb"
 	k8smb "github.com/containership/cerebral/pkg/metrics/backends/kubernetes"
+	"github.com/containership/cerebral/pkg/metrics/backends/metricsserver"
 	"github.com/containership/cerebral/pkg/metrics/backends/prometheus"
+	"github.com/containership/cerebral/pkg/metrics/backends/queue"
+	"github.com/containership/cerebral/pkg/nodeutil"
 
 	"github.com/pkg/errors"
 )
@@ -43,6 +55,7 @@ const (
 // instantiated backend clients.
 type MetricsBackendController struct {
 	kubeclientset     kubernetes.Interface
+	metricsclientset  metricsclient.Interface
 	cerebralclientset cerebral.Interface
 
 	metricsBackendLister clisters.MetricsBackendLister
@@ -57,11 +70,16 @@ type MetricsBackendController struct {
 	podLister corelistersv1.PodLister
 	podSynced cache.InformerSynced
 
+	// The Kubernetes backend looks up pods by node using the pod informer's
+	// indexer rather than listing every pod in the cluster
+	podIndexer cache.Indexer
+
 	workqueue workqueue.RateLimitingInterface
 }
 
 // NewMetricsBackend constructs a new MetricsBackend
 func NewMetricsBackend(kubeclientset kubernetes.Interface,
+	metricsclientset metricsclient.Interface,
 	kubeInformerFactory kubeinformers.SharedInformerFactory,
 	cerebralclientset cerebral.Interface,
 	cInformerFactory cinformers.SharedInformerFactory) *MetricsBackendController {
@@ -69,6 +87,7 @@ func NewMetricsBackend(kubeclientset kubernetes.Interface,
 
 	c := &MetricsBackendController{
 		kubeclientset:     kubeclientset,
+		metricsclientset:  metricsclientset,
 		cerebralclientset: cerebralclientset,
 		workqueue:         workqueue.NewNamedRateLimitingQueue(rateLimiter, metricsBackendControllerName),
 	}
@@ -78,6 +97,12 @@ func NewMetricsBackend(kubeclientset kubernetes.Interface,
 	nodeInformer := kubeInformerFactory.Core().V1().Nodes()
 	podInformer := kubeInformerFactory.Core().V1().Pods()
 
+	if err := podInformer.Informer().AddIndexers(nodeutil.PodNodeNameIndexers()); err != nil {
+		// Only fails if the informer has already started or the index already
+		// exists, either of which is a programming error
+		log.Errorf("%s: adding pod indexers: %s", metricsBackendControllerName, err)
+	}
+
 	log.Infof("%s: setting up event handlers", metricsBackendControllerName)
 
 	metricsBackendInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
@@ -102,6 +127,7 @@ func NewMetricsBackend(kubeclientset kubernetes.Interface,
 	c.nodeSynced = nodeInformer.Informer().HasSynced
 
 	c.podLister = podInformer.Lister()
+	c.podIndexer = podInformer.Informer().GetIndexer()
 	c.podSynced = podInformer.Informer().HasSynced
 
 	return c
@@ -243,7 +269,11 @@ func (c *MetricsBackendController) syncHandler(key string) error {
 	if err != nil {
 		return errors.Wrapf(err, "instantiating backend client for MetricsBackend %q", name)
 	}
-	metrics.Registry().Put(name, client)
+
+	// Wrap the backend so that pollers share a cache, which is discarded
+	// along with the backend when it's replaced
+	ttl := time.Duration(backend.Spec.CacheTTL) * time.Second
+	metrics.Registry().Put(name, metrics.NewCachedBackend(client, ttl))
 	log.Infof("Backend %q instantiated successfully", name)
 
 	return nil
@@ -254,7 +284,15 @@ func (c *MetricsBackendController) syncHandler(key string) error {
 func (c *MetricsBackendController) instantiateBackend(backend *cerebralv1alpha1.MetricsBackend) (metrics.Backend, error) {
 	switch backend.Spec.Type {
 	case "kubernetes":
-		return k8smb.NewClient(c.nodeLister, c.podLister)
+		return k8smb.NewClient(c.nodeLister, c.podIndexer)
+
+	case "metrics-server":
+		return metricsserver.NewClient(c.metricsclientset, c.nodeLister, c.podLister)
+
+	case "custom-metrics":
+		client := custommetrics.NewRESTClient(c.kubeclientset.Discovery().RESTClient())
+		return custommetrics.NewClient(client)
+
 	case "prometheus":
 		var address string
 		var ok bool
@@ -263,7 +301,12 @@ func (c *MetricsBackendController) instantiateBackend(backend *cerebralv1alpha1.
 			return nil, errors.New("Prometheus backend requires address in configuration")
 		}
 
-		return prometheus.NewClient(address, c.nodeLister)
+		httpConfig, err := httpconfig.FromConfiguration(backend.Spec.Configuration, c.getSecretData)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing Prometheus HTTP configuration")
+		}
+
+		return prometheus.NewClient(address, httpConfig, c.nodeLister, time.Duration(backend.Spec.CacheTTL)*time.Second)
 
 	case "influxdb":
 		var address string
@@ -273,9 +316,67 @@ func (c *MetricsBackendController) instantiateBackend(backend *cerebralv1alpha1.
 			return nil, errors.New("InfluxDB backend requires address in configuration")
 		}
 
-		return influxdb.NewClient(address, c.nodeLister)
+		httpConfig, err := httpconfig.FromConfiguration(backend.Spec.Configuration, c.getSecretData)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing InfluxDB HTTP configuration")
+		}
+
+		switch version := backend.Spec.Configuration["version"]; version {
+		case "", "1":
+			return influxdb.NewClient(address, httpConfig, c.nodeLister)
+
+		case "2":
+			token, err := httpconfig.Resolve(backend.Spec.Configuration, "token", c.getSecretData)
+			if err != nil {
+				return nil, errors.Wrap(err, "reading InfluxDB token")
+			}
+
+			return influxdb.NewV2Client(address, backend.Spec.Configuration["org"], token, httpConfig, c.nodeLister)
+
+		default:
+			return nil, errors.Errorf("unsupported InfluxDB version %q", version)
+		}
+
+	case "cloudwatch":
+		return cloudwatch.NewClient(backend.Spec.Configuration["region"], c.nodeLister)
+
+	case "expression":
+		return expression.NewClient(backend.Name, backend.Spec.Configuration, metrics.Registry())
+
+	case "queue":
+		return queue.NewClient(backend.Spec.Configuration, c.getSecretData, c.nodeLister)
+
+	case "datadog":
+		address := backend.Spec.Configuration["address"]
+		if address == "" {
+			address = datadog.DefaultAddress
+		}
+
+		apiKey, err := httpconfig.Resolve(backend.Spec.Configuration, "apiKey", c.getSecretData)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading Datadog API key")
+		}
+
+		appKey, err := httpconfig.Resolve(backend.Spec.Configuration, "appKey", c.getSecretData)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading Datadog application key")
+		}
+
+		return datadog.NewClient(address, apiKey, appKey, &http.Client{}, c.nodeLister)
 
 	default:
 		return nil, errors.Errorf("unknown backend type %q", backend.Spec.Type)
 	}
 }
+
+// getSecretData returns the data of the given Secret. Secrets are only read
+// when instantiating backends, so they're fetched directly rather than
+// watching every Secret in the cluster.
+func (c *MetricsBackendController) getSecretData(namespace, name string) (map[string][]byte, error) {
+	secret, err := c.kubeclientset.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return secret.Data, nil
+}
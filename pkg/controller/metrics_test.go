@@ -0,0 +1,73 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/containership/cerebral/pkg/apis/cerebral.containership.io/v1alpha1"
+	"github.com/containership/cerebral/pkg/client/clientset/versioned/fake"
+	informers "github.com/containership/cerebral/pkg/client/informers/externalversions"
+)
+
+func TestUpdateAutoscalingPolicyPolls(t *testing.T) {
+	asp := &v1alpha1.AutoscalingPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "cpu"},
+		Status: v1alpha1.AutoscalingPolicyStatus{
+			Polls: []v1alpha1.PolicyPollStatus{
+				{AutoscalingGroup: "removed", ConsecutiveFailures: 1},
+				{AutoscalingGroup: "web", ConsecutiveFailures: 2},
+			},
+		},
+	}
+
+	client := fake.NewSimpleClientset(asp)
+	i := informers.NewSharedInformerFactory(client, noResyncPeriodFunc())
+	for _, name := range []string{"db", "web"} {
+		i.Cerebral().V1alpha1().AutoscalingGroups().Informer().GetIndexer().Add(&v1alpha1.AutoscalingGroup{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       v1alpha1.AutoscalingGroupSpec{Policies: []string{"cpu"}},
+		})
+	}
+
+	c := &MetricsController{
+		cerebralclientset: client,
+		asgLister:         i.Cerebral().V1alpha1().AutoscalingGroups().Lister(),
+	}
+
+	getPolls := func() []v1alpha1.PolicyPollStatus {
+		asp, err := client.CerebralV1alpha1().AutoscalingPolicies().Get("cpu", metav1.GetOptions{})
+		assert.NoError(t, err)
+		return asp.Status.Polls
+	}
+
+	webForecast := &v1alpha1.PolicyForecast{Value: 42}
+	err := c.updateAutoscalingPolicyForecast("web", "cpu", webForecast)
+	assert.NoError(t, err)
+	assert.Equal(t, []v1alpha1.PolicyPollStatus{
+		{AutoscalingGroup: "web", ConsecutiveFailures: 2, Forecast: webForecast},
+	}, getPolls(), "forecast is recorded for the AutoscalingGroup and unused statuses are dropped")
+
+	dbForecast := &v1alpha1.PolicyForecast{Value: 7}
+	err = c.updateAutoscalingPolicyForecast("db", "cpu", dbForecast)
+	assert.NoError(t, err)
+	assert.Equal(t, []v1alpha1.PolicyPollStatus{
+		{AutoscalingGroup: "db", Forecast: dbForecast},
+		{AutoscalingGroup: "web", ConsecutiveFailures: 2, Forecast: webForecast},
+	}, getPolls(), "forecasts are recorded per AutoscalingGroup")
+
+	err = c.updateAutoscalingPolicyPollStatus("cpu", v1alpha1.PolicyPollStatus{
+		AutoscalingGroup: "web",
+		LastError:        "error",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []v1alpha1.PolicyPollStatus{
+		{AutoscalingGroup: "db", Forecast: dbForecast},
+		{AutoscalingGroup: "web", LastError: "error", Forecast: webForecast},
+	}, getPolls(), "poll status is replaced except for the forecast")
+
+	err = c.updateAutoscalingPolicyForecast("web", "asp-dne", webForecast)
+	assert.Error(t, err, "missing ASP")
+}
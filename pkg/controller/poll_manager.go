@@ -2,31 +2,82 @@ package controller
 
 import (
 	"fmt"
-	"sync"
+	"reflect"
+	"time"
 
 	"github.com/pkg/errors"
 
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
+	corelistersv1 "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/record"
 
 	"github.com/containership/cluster-manager/pkg/log"
 
 	"github.com/containership/cerebral/pkg/apis/cerebral.containership.io/v1alpha1"
 	"github.com/containership/cerebral/pkg/events"
+	"github.com/containership/cerebral/pkg/nodeutil"
 )
 
+// A pendingAlertsRecorder persists the alerts of an AutoscalingGroup that are
+// waiting to be arbitrated
+type pendingAlertsRecorder func(asgName string, alerts []v1alpha1.PendingAlert) error
+
 type pollManager struct {
 	asgName string
+	asg     *v1alpha1.AutoscalingGroup
 
 	// Keys are ASP name
 	asps    map[string]*v1alpha1.AutoscalingPolicy
-	pollers map[string]metricPoller
+	pollers map[string]*runningPoller
+
+	nodeLister corelistersv1.NodeLister
+	podLister  corelistersv1.PodLister
+
+	recorder         record.EventRecorder
+	recordForecast   forecastRecorder
+	recordPollStatus pollStatusRecorder
 
-	recorder record.EventRecorder
+	recordPendingAlerts pendingAlertsRecorder
+
+	// scaleTolerance governs retries of failed scale requests
+	scaleTolerance failureTolerance
 
 	scaleRequestCh chan<- ScaleRequest
+	updateCh       chan pollManagerUpdate
 	stopCh         chan struct{}
+	// doneCh is closed once run returns
+	doneCh chan struct{}
+}
+
+// pollManagerUpdate is the desired state of a running poll manager
+type pollManagerUpdate struct {
+	asg  *v1alpha1.AutoscalingGroup
+	asps map[string]*v1alpha1.AutoscalingPolicy
+}
+
+// runningPoller is a poller started by a poll manager
+type runningPoller struct {
+	poller metricPoller
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// scaleRetry is a failed scale request waiting to be retried
+type scaleRetry struct {
+	alert    alert
+	failures int
+	timerCh  <-chan time.Time
+}
+
+// ch returns the channel on which the retry is due, or nil if there's no retry
+func (r *scaleRetry) ch() <-chan time.Time {
+	if r == nil {
+		return nil
+	}
+
+	return r.timerCh
 }
 
 type alert struct {
@@ -34,97 +85,468 @@ type alert struct {
 	direction       scaleDirection
 	adjustmentType  adjustmentType
 	adjustmentValue float64
-
-	err error
+	alertedAt       time.Time
 }
 
-func newPollManager(asgName string, asps map[string]*v1alpha1.AutoscalingPolicy, nodeSelector map[string]string,
-	recorder record.EventRecorder,
-	scaleRequestCh chan<- ScaleRequest, stopCh chan struct{}) pollManager {
-	mgr := pollManager{
-		asgName:        asgName,
-		asps:           asps,
-		pollers:        make(map[string]metricPoller),
-		recorder:       recorder,
-		scaleRequestCh: scaleRequestCh,
-		stopCh:         stopCh,
+func newPollManager(asg *v1alpha1.AutoscalingGroup, asps map[string]*v1alpha1.AutoscalingPolicy,
+	nodeLister corelistersv1.NodeLister, podLister corelistersv1.PodLister,
+	recorder record.EventRecorder, recordForecast forecastRecorder,
+	recordPollStatus pollStatusRecorder, recordPendingAlerts pendingAlertsRecorder,
+	scaleRequestCh chan<- ScaleRequest, stopCh chan struct{}) *pollManager {
+	return &pollManager{
+		asgName:             asg.ObjectMeta.Name,
+		asg:                 asg,
+		asps:                asps,
+		pollers:             make(map[string]*runningPoller),
+		nodeLister:          nodeLister,
+		podLister:           podLister,
+		recorder:            recorder,
+		recordForecast:      recordForecast,
+		recordPollStatus:    recordPollStatus,
+		recordPendingAlerts: recordPendingAlerts,
+		scaleTolerance:      newFailureTolerance(nil),
+		scaleRequestCh:      scaleRequestCh,
+		updateCh:            make(chan pollManagerUpdate),
+		stopCh:              stopCh,
+		doneCh:              make(chan struct{}),
 	}
+}
 
-	for _, asp := range asps {
-		p := newMetricPoller(asp, nodeSelector)
-		mgr.pollers[asp.ObjectMeta.Name] = p
+// update reconciles the running poll manager with the given AutoscalingGroup
+// and policies. It returns false if the poll manager has already shut down.
+func (m *pollManager) update(asg *v1alpha1.AutoscalingGroup, asps map[string]*v1alpha1.AutoscalingPolicy) bool {
+	select {
+	case m.updateCh <- pollManagerUpdate{asg: asg, asps: asps}:
+		return true
+	case <-m.doneCh:
+		return false
 	}
-
-	return mgr
 }
 
-func (m pollManager) run() error {
+func (m *pollManager) run() error {
+	defer close(m.doneCh)
+
 	// This alert channel has N writers - the pollers that are created here.
-	// Since its lifetime is matched to this function, we'll just let it be
-	// garbage collected if this function exits.
-	alertCh := make(chan alert, len(m.pollers))
+	// Pollers block until their alerts are received, so the buffer only
+	// absorbs bursts and needn't track the number of policies. Since its
+	// lifetime is matched to this function, we'll just let it be garbage
+	// collected if this function exits.
+	alertCh := make(chan alert, len(m.asps))
 
 	// This error channel is used essentially as a return value for any requests
 	// to the scale manager. Similar to the alert channel, we'll just let it
 	// be garbage collected.
 	errCh := make(chan error)
 
-	// This stop channel tells the pollers to stop if this poll manager is
-	// shutting down for any reason. It must be closed if this function exits.
-	pollerStopCh := make(chan struct{})
-
-	var wg sync.WaitGroup
-	for _, p := range m.pollers {
-		wg.Add(1)
-		go p.run(&wg, alertCh, pollerStopCh)
-	}
+	m.reconcile(m.asg, m.asps, alertCh)
 
 	// Make sure that when this poll manager dies, all of its pollers are properly
 	// cleaned up.
 	defer func() {
-		close(pollerStopCh)
-
-		// Wait for all pollers to shut down
-		wg.Wait()
+		m.stopPollers()
 
 		log.Infof("Poll manager for AutoscalingGroup %s shut down success", m.asgName)
 	}()
 
+	// If arbitration is enabled, alerts are collected here until the
+	// evaluation window elapses. The window channel is nil (and thus never
+	// selected) while no window is open.
+	pendingAlerts, windowCh := m.restorePendingAlerts()
+
+	// Failed scale requests are retried rather than shutting down the poll
+	// manager, which would discard the state of its pollers
+	var retry *scaleRetry
+
 	for {
 		select {
 		case alert := <-alertCh:
-			if alert.err != nil {
-				return errors.Wrapf(alert.err, "polling metrics for ASP")
+			if _, ok := m.asps[alert.aspName]; !ok {
+				log.Debugf("Poll manager for AutoscalingGroup %s discarding alert for removed AutoscalingPolicy %s",
+					m.asgName, alert.aspName)
+				continue
 			}
 
-			asp := m.asps[alert.aspName]
+			m.recordAlertEvent(alert)
 
-			if alert.direction == scaleDirectionUp {
-				m.recorder.Event(asp, corev1.EventTypeNormal, events.ScaleUpAlerted,
-					fmt.Sprintf("Alert triggered to scale up by %.2f (%s)",
-						alert.adjustmentValue, alert.adjustmentType.String()))
-			} else {
-				m.recorder.Event(asp, corev1.EventTypeNormal, events.ScaleDownAlerted,
-					fmt.Sprintf("Alert triggered to scale down by %.2f (%s)",
-						alert.adjustmentValue, alert.adjustmentType.String()))
+			if m.asg.Spec.Arbitration == nil {
+				retry = m.scale(alert, retry, errCh)
+				continue
 			}
 
-			m.scaleRequestCh <- ScaleRequest{
-				asgName:         m.asgName,
-				direction:       alert.direction,
-				adjustmentType:  alert.adjustmentType,
-				adjustmentValue: alert.adjustmentValue,
-				errCh:           errCh,
+			pendingAlerts = append(pendingAlerts, alert)
+			if windowCh == nil {
+				windowCh = time.After(arbitrationEvaluationWindow(m.asg.Spec.Arbitration))
 			}
 
-			err := <-errCh
-			if err != nil {
-				return errors.Wrap(err, "requesting scale manager to scale")
+			m.checkpointPendingAlerts(pendingAlerts)
+
+		case <-windowCh:
+			alerts := pendingAlerts
+			pendingAlerts = nil
+			windowCh = nil
+
+			// Clear the checkpoint before arbitrating so that the alerts
+			// can't be arbitrated again if Cerebral restarts
+			m.checkpointPendingAlerts(nil)
+
+			if chosen, ok := m.arbitrate(alerts); ok {
+				retry = m.scale(chosen, retry, errCh)
 			}
 
+		case <-retry.ch():
+			if _, ok := m.asps[retry.alert.aspName]; !ok {
+				log.Debugf("Poll manager for AutoscalingGroup %s discarding retry for removed AutoscalingPolicy %s",
+					m.asgName, retry.alert.aspName)
+				retry = nil
+				continue
+			}
+
+			log.Infof("Poll manager for AutoscalingGroup %s retrying scale request", m.asgName)
+			retry = m.scale(retry.alert, retry, errCh)
+
+		case u := <-m.updateCh:
+			if windowCh != nil && u.asg.Spec.Arbitration == nil {
+				// Arbitration is being disabled, so close the open window
+				// early using the current settings
+				alerts := pendingAlerts
+				pendingAlerts = nil
+				windowCh = nil
+
+				m.checkpointPendingAlerts(nil)
+
+				if chosen, ok := m.arbitrate(alerts); ok {
+					retry = m.scale(chosen, retry, errCh)
+				}
+			}
+
+			m.reconcile(u.asg, u.asps, alertCh)
+
 		case <-m.stopCh:
 			log.Infof("Poll manager for AutoscalingGroup %s shutting down", m.asgName)
 			return nil
 		}
 	}
 }
+
+// reconcile updates the poll manager to the given AutoscalingGroup and
+// policies, starting and stopping pollers as needed. Pollers for policies
+// that haven't changed keep running undisturbed. Pollers for changed
+// policies are replaced, keeping their alert and forecast state if the change
+// doesn't affect alerting or forecasting.
+func (m *pollManager) reconcile(asg *v1alpha1.AutoscalingGroup, asps map[string]*v1alpha1.AutoscalingPolicy,
+	alertCh chan<- alert) {
+	// The node selector is passed to the metrics backend, so changing it
+	// changes the value of every metric
+	nodeSelectorChanged := !reflect.DeepEqual(m.asg.Spec.NodeSelector, asg.Spec.NodeSelector)
+
+	m.asg = asg
+	m.asps = asps
+
+	for aspName, running := range m.pollers {
+		asp, ok := asps[aspName]
+		if !ok {
+			log.Infof("Poll manager for AutoscalingGroup %s stopping poller for removed AutoscalingPolicy %s",
+				m.asgName, aspName)
+			running.stop()
+			delete(m.pollers, aspName)
+			continue
+		}
+
+		old := running.poller.asp
+		if !nodeSelectorChanged && reflect.DeepEqual(old.Spec, asp.Spec) {
+			continue
+		}
+
+		log.Infof("Poll manager for AutoscalingGroup %s replacing poller for AutoscalingPolicy %s",
+			m.asgName, aspName)
+		running.stop()
+
+		// The poll state is always kept so that the recorded poll status
+		// remains accurate
+		p := m.newPoller(asp)
+		p.state = running.poller.state
+		if !nodeSelectorChanged && alertingUnchanged(old, asp) {
+			p.upAlert = running.poller.upAlert
+			p.downAlert = running.poller.downAlert
+
+			if reflect.DeepEqual(old.Spec.Predictive, asp.Spec.Predictive) {
+				p.predictive = running.poller.predictive
+			}
+		}
+
+		m.pollers[aspName] = startPoller(p, alertCh)
+	}
+
+	for aspName, asp := range asps {
+		if _, ok := m.pollers[aspName]; ok {
+			continue
+		}
+
+		log.Infof("Poll manager for AutoscalingGroup %s starting poller for AutoscalingPolicy %s",
+			m.asgName, aspName)
+		p := m.newPoller(asp)
+		p.restoreState()
+		m.pollers[aspName] = startPoller(p, alertCh)
+	}
+}
+
+// restorePendingAlerts returns the alerts recorded in the AutoscalingGroup's
+// status as waiting to be arbitrated, e.g. before Cerebral restarted, along
+// with a channel for the remainder of their evaluation window
+func (m *pollManager) restorePendingAlerts() ([]alert, <-chan time.Time) {
+	if len(m.asg.Status.PendingAlerts) == 0 {
+		return nil, nil
+	}
+
+	if m.asg.Spec.Arbitration == nil {
+		log.Infof("Poll manager for AutoscalingGroup %s discarding %d pending alert(s) since arbitration is disabled",
+			m.asgName, len(m.asg.Status.PendingAlerts))
+		m.checkpointPendingAlerts(nil)
+		return nil, nil
+	}
+
+	var alerts []alert
+	for _, pending := range m.asg.Status.PendingAlerts {
+		if _, ok := m.asps[pending.AutoscalingPolicy]; !ok {
+			continue
+		}
+
+		a, err := alertFromPendingAlert(pending)
+		if err != nil {
+			log.Warnf("Poll manager for AutoscalingGroup %s discarding invalid pending alert: %s", m.asgName, err)
+			continue
+		}
+
+		alerts = append(alerts, a)
+	}
+
+	if len(alerts) == 0 {
+		m.checkpointPendingAlerts(nil)
+		return nil, nil
+	}
+
+	// The window opened when the first alert fired
+	remaining := alerts[0].alertedAt.Add(arbitrationEvaluationWindow(m.asg.Spec.Arbitration)).Sub(nowFunc())
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	log.Infof("Poll manager for AutoscalingGroup %s restored %d pending alert(s) to arbitrate in %s",
+		m.asgName, len(alerts), remaining)
+
+	return alerts, time.After(remaining)
+}
+
+// checkpointPendingAlerts records the alerts waiting to be arbitrated so that
+// they can be restored if Cerebral restarts. Failing to record them is not
+// fatal.
+func (m *pollManager) checkpointPendingAlerts(alerts []alert) {
+	if m.recordPendingAlerts == nil {
+		return
+	}
+
+	var pending []v1alpha1.PendingAlert
+	for _, a := range alerts {
+		pending = append(pending, v1alpha1.PendingAlert{
+			AutoscalingPolicy: a.aspName,
+			Direction:         a.direction.String(),
+			AdjustmentType:    a.adjustmentType.String(),
+			AdjustmentValue:   a.adjustmentValue,
+			AlertedAt:         metav1.NewTime(a.alertedAt),
+		})
+	}
+
+	if err := m.recordPendingAlerts(m.asgName, pending); err != nil {
+		log.Warnf("Poll manager for AutoscalingGroup %s failed to record pending alerts: %s", m.asgName, err)
+	}
+}
+
+func alertFromPendingAlert(pending v1alpha1.PendingAlert) (alert, error) {
+	direction, err := scaleDirectionFromString(pending.Direction)
+	if err != nil {
+		return alert{}, err
+	}
+
+	adjustmentType, err := adjustmentTypeFromString(pending.AdjustmentType)
+	if err != nil {
+		return alert{}, err
+	}
+
+	return alert{
+		aspName:         pending.AutoscalingPolicy,
+		direction:       direction,
+		adjustmentType:  adjustmentType,
+		adjustmentValue: pending.AdjustmentValue,
+		alertedAt:       pending.AlertedAt.Time,
+	}, nil
+}
+
+func (m *pollManager) newPoller(asp *v1alpha1.AutoscalingPolicy) metricPoller {
+	return newMetricPoller(asp, m.asgName, m.asg.Spec.NodeSelector, m.recorder, m.recordForecast, m.recordPollStatus)
+}
+
+// stopPollers stops all pollers and waits for them to shut down
+func (m *pollManager) stopPollers() {
+	for _, running := range m.pollers {
+		close(running.stopCh)
+	}
+
+	for aspName, running := range m.pollers {
+		<-running.doneCh
+		delete(m.pollers, aspName)
+	}
+}
+
+func startPoller(p metricPoller, alertCh chan<- alert) *runningPoller {
+	running := &runningPoller{
+		poller: p,
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}
+
+	go func() {
+		defer close(running.doneCh)
+		p.run(alertCh, running.stopCh)
+	}()
+
+	return running
+}
+
+// stop stops the poller and waits for it to shut down, so that its state
+// may be safely handed off to a replacement
+func (r *runningPoller) stop() {
+	close(r.stopCh)
+	<-r.doneCh
+}
+
+// alertingUnchanged returns true if the alert state of a poller for the old
+// policy is still valid for the new policy, i.e. the same metric is compared
+// against the same scaling policy in the same way
+func alertingUnchanged(old, new *v1alpha1.AutoscalingPolicy) bool {
+	return old.Spec.MetricsBackend == new.Spec.MetricsBackend &&
+		old.Spec.Metric == new.Spec.Metric &&
+		reflect.DeepEqual(old.Spec.MetricConfiguration, new.Spec.MetricConfiguration) &&
+		reflect.DeepEqual(old.Spec.ScalingPolicy, new.Spec.ScalingPolicy) &&
+		reflect.DeepEqual(old.Spec.SampleWindow, new.Spec.SampleWindow) &&
+		old.Spec.Hysteresis == new.Spec.Hysteresis
+}
+
+func (m *pollManager) recordAlertEvent(alert alert) {
+	asp := m.asps[alert.aspName]
+
+	if alert.direction == scaleDirectionUp {
+		m.recorder.Event(asp, corev1.EventTypeNormal, events.ScaleUpAlerted,
+			fmt.Sprintf("Alert triggered to scale up by %.2f (%s)",
+				alert.adjustmentValue, alert.adjustmentType.String()))
+	} else {
+		m.recorder.Event(asp, corev1.EventTypeNormal, events.ScaleDownAlerted,
+			fmt.Sprintf("Alert triggered to scale down by %.2f (%s)",
+				alert.adjustmentValue, alert.adjustmentType.String()))
+	}
+}
+
+// arbitrate consolidates the alerts collected during an evaluation window
+// into at most one alert to scale for and records the decision. It returns
+// false if no scale should be requested.
+func (m *pollManager) arbitrate(alerts []alert) (alert, bool) {
+	// Thanks to CRD validation, we can assume that this is valid
+	mode, _ := arbitrationModeFromString(m.asg.Spec.Arbitration.Mode)
+
+	ns := nodeutil.GetNodesLabelSelector(m.asg.Spec.NodeSelector)
+	nodes, err := m.nodeLister.List(ns)
+	if err != nil {
+		log.Errorf("Poll manager for AutoscalingGroup %s failed to list nodes to arbitrate %d alert(s): %s",
+			m.asgName, len(alerts), err)
+		return alert{}, false
+	}
+
+	// Pending pod adjustments only cap the number of nodes added, so resolve
+	// them to the number of nodes needed before comparing targets
+	resolved := make([]alert, 0, len(alerts))
+	for _, a := range alerts {
+		if a.adjustmentType == adjustmentTypePendingPods {
+			needed, err := nodesForPendingPods(m.podLister, m.asg, nodes, a.adjustmentValue)
+			if err != nil {
+				log.Errorf("Poll manager for AutoscalingGroup %s failed to calculate nodes for pending pods of AutoscalingPolicy %s: %s",
+					m.asgName, a.aspName, err)
+				continue
+			}
+
+			a.adjustmentType, a.adjustmentValue = adjustmentTypeAbsolute, float64(needed)
+		}
+
+		resolved = append(resolved, a)
+	}
+
+	// Bounds may be overridden by any active schedules
+	minNodes, maxNodes := effectiveBounds(m.asg, nowFunc())
+
+	chosen, reason, ok := arbitrateAlerts(arbitrationInput{
+		mode:          mode,
+		alerts:        resolved,
+		numPolicies:   len(m.asps),
+		currNodeCount: len(nodes),
+		minNodes:      minNodes,
+		maxNodes:      maxNodes,
+	})
+
+	if !ok {
+		m.recorder.Event(m.asg, corev1.EventTypeNormal, events.ScaleArbitrated,
+			fmt.Sprintf("Arbitrated %d alert(s) with no scale request: %s", len(alerts), reason))
+		return alert{}, false
+	}
+
+	m.recorder.Event(m.asg, corev1.EventTypeNormal, events.ScaleArbitrated,
+		fmt.Sprintf("Arbitrated %d alert(s) to scale %s by %.2f (%s): %s", len(alerts),
+			chosen.direction.String(), chosen.adjustmentValue, chosen.adjustmentType.String(), reason))
+
+	return chosen, true
+}
+
+// scale requests a scale for the alert. If the request fails, it returns a
+// retry of the request after a backoff, unless the scale manager has failed
+// too many times in a row. A newer request supersedes any previous retry, but
+// the previous failures still count towards the backoff.
+func (m *pollManager) scale(a alert, prev *scaleRetry, errCh chan error) *scaleRetry {
+	err := m.requestScale(a, errCh)
+	if err == nil {
+		return nil
+	}
+
+	failures := 1
+	if prev != nil {
+		failures = prev.failures + 1
+	}
+
+	if failures > m.scaleTolerance.maxConsecutiveFailures {
+		log.Errorf("Poll manager for AutoscalingGroup %s giving up on scale request after %d failures: %s",
+			m.asgName, failures, err)
+		return nil
+	}
+
+	backoff := m.scaleTolerance.backoff(failures)
+	log.Warnf("Poll manager for AutoscalingGroup %s failed to scale, retrying in %s: %s", m.asgName, backoff, err)
+
+	return &scaleRetry{
+		alert:    a,
+		failures: failures,
+		timerCh:  time.After(backoff),
+	}
+}
+
+func (m *pollManager) requestScale(alert alert, errCh chan error) error {
+	m.scaleRequestCh <- ScaleRequest{
+		asgName:         m.asgName,
+		direction:       alert.direction,
+		adjustmentType:  alert.adjustmentType,
+		adjustmentValue: alert.adjustmentValue,
+		errCh:           errCh,
+	}
+
+	err := <-errCh
+	if err != nil {
+		return errors.Wrap(err, "requesting scale manager to scale")
+	}
+
+	return nil
+}
@@ -0,0 +1,431 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kubeinformers "k8s.io/client-go/informers"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/containership/cerebral/pkg/apis/cerebral.containership.io/v1alpha1"
+)
+
+func buildPollManagerTestASG(nodeSelector map[string]string, maxNodes int) *v1alpha1.AutoscalingGroup {
+	return &v1alpha1.AutoscalingGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "asg"},
+		Spec: v1alpha1.AutoscalingGroupSpec{
+			NodeSelector: nodeSelector,
+			MaxNodes:     maxNodes,
+		},
+	}
+}
+
+func buildPollManagerTestASP(name string, threshold float64, samplePeriod int) *v1alpha1.AutoscalingPolicy {
+	return &v1alpha1.AutoscalingPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: v1alpha1.AutoscalingPolicySpec{
+			MetricsBackend: "prometheus",
+			Metric:         "cpu_percentage",
+			ScalingPolicy: v1alpha1.ScalingPolicy{
+				ScaleUp: &v1alpha1.ScalingPolicyConfiguration{
+					Threshold:          threshold,
+					ComparisonOperator: ">",
+					AdjustmentType:     "absolute",
+					AdjustmentValue:    1,
+				},
+			},
+			// Long enough that the pollers never poll during the test
+			PollInterval: 3600,
+			SamplePeriod: samplePeriod,
+		},
+	}
+}
+
+func TestPollManagerReconcile(t *testing.T) {
+	selector := map[string]string{"pool": "a"}
+	asps := map[string]*v1alpha1.AutoscalingPolicy{
+		"cpu": buildPollManagerTestASP("cpu", 0.8, 60),
+		"mem": buildPollManagerTestASP("mem", 0.8, 60),
+	}
+
+	alertCh := make(chan alert, 2)
+	m := newPollManager(buildPollManagerTestASG(selector, 10), asps, nil, nil, nil, nil, nil, nil, nil, make(chan struct{}))
+	defer m.stopPollers()
+
+	m.reconcile(m.asg, asps, alertCh)
+	assert.Len(t, m.pollers, 2, "a poller is started for each policy")
+
+	cpu := m.pollers["cpu"]
+	mem := m.pollers["mem"]
+	cpu.poller.upAlert.active = true
+	cpu.poller.upAlert.startTime = time.Unix(10, 0)
+	mem.poller.upAlert.active = true
+
+	// Changing the ASG without changing its node selector leaves pollers alone
+	m.reconcile(buildPollManagerTestASG(selector, 20), asps, alertCh)
+	assert.Equal(t, 20, m.asg.Spec.MaxNodes, "ASG is updated")
+	assert.True(t, cpu == m.pollers["cpu"], "poller is not replaced for ASG change")
+	assert.True(t, mem == m.pollers["mem"], "poller is not replaced for ASG change")
+
+	// Changing the sample period replaces the poller but keeps its alert state,
+	// while changing the threshold resets it
+	asps = map[string]*v1alpha1.AutoscalingPolicy{
+		"cpu": buildPollManagerTestASP("cpu", 0.8, 120),
+		"mem": buildPollManagerTestASP("mem", 0.9, 60),
+	}
+	m.reconcile(m.asg, asps, alertCh)
+	assert.False(t, cpu == m.pollers["cpu"], "poller is replaced for policy change")
+	assert.True(t, cpu.poller.upAlert == m.pollers["cpu"].poller.upAlert, "alert state is kept")
+	assert.Equal(t, time.Unix(10, 0), m.pollers["cpu"].poller.upAlert.startTime)
+	assert.True(t, cpu.poller.predictive == m.pollers["cpu"].poller.predictive, "predictive state is kept")
+	assert.False(t, mem == m.pollers["mem"], "poller is replaced for policy change")
+	assert.False(t, m.pollers["mem"].poller.upAlert.active, "alert state is reset for threshold change")
+	assert.False(t, mem.poller.predictive == m.pollers["mem"].poller.predictive,
+		"predictive state is reset for threshold change")
+
+	// Changing predictive scaling keeps the alert state but resets the
+	// predictive state
+	cpu = m.pollers["cpu"]
+	asps["cpu"] = buildPollManagerTestASP("cpu", 0.8, 120)
+	asps["cpu"].Spec.Predictive = &v1alpha1.PredictiveScaling{
+		LookbackPeriod:  3600,
+		Step:            60,
+		ForecastHorizon: 600,
+	}
+	m.reconcile(m.asg, asps, alertCh)
+	assert.True(t, cpu.poller.upAlert == m.pollers["cpu"].poller.upAlert, "alert state is kept")
+	assert.False(t, cpu.poller.predictive == m.pollers["cpu"].poller.predictive,
+		"predictive state is reset for predictive scaling change")
+
+	// Changing the node selector changes the metric, so alert state is reset
+	cpu = m.pollers["cpu"]
+	m.reconcile(buildPollManagerTestASG(map[string]string{"pool": "b"}, 20), asps, alertCh)
+	assert.False(t, cpu == m.pollers["cpu"], "poller is replaced for node selector change")
+	assert.False(t, m.pollers["cpu"].poller.upAlert.active, "alert state is reset for node selector change")
+	assert.Equal(t, map[string]string{"pool": "b"}, m.pollers["cpu"].poller.nodeSelector)
+
+	// Removing a policy stops only its poller
+	cpu = m.pollers["cpu"]
+	mem = m.pollers["mem"]
+	delete(asps, "mem")
+	m.reconcile(m.asg, asps, alertCh)
+	assert.Len(t, m.pollers, 1)
+	assert.True(t, cpu == m.pollers["cpu"], "remaining poller is not replaced")
+	select {
+	case <-mem.doneCh:
+	default:
+		assert.Fail(t, "poller for removed policy is stopped")
+	}
+
+	// Adding a policy starts only its poller
+	asps["mem"] = buildPollManagerTestASP("mem", 0.9, 60)
+	m.reconcile(m.asg, asps, alertCh)
+	assert.Len(t, m.pollers, 2)
+	assert.True(t, cpu == m.pollers["cpu"], "existing poller is not replaced")
+}
+
+func TestPollManagerUpdate(t *testing.T) {
+	asps := map[string]*v1alpha1.AutoscalingPolicy{
+		"cpu": buildPollManagerTestASP("cpu", 0.8, 60),
+	}
+
+	stopCh := make(chan struct{})
+	m := newPollManager(buildPollManagerTestASG(nil, 10), asps, nil, nil, nil, nil, nil, nil, nil, stopCh)
+
+	errCh := make(chan error)
+	go func() {
+		errCh <- m.run()
+	}()
+
+	assert.True(t, m.update(buildPollManagerTestASG(nil, 20), asps), "running poll manager is updated")
+
+	close(stopCh)
+	assert.NoError(t, <-errCh)
+	assert.Empty(t, m.pollers, "pollers are stopped on shutdown")
+
+	assert.False(t, m.update(buildPollManagerTestASG(nil, 30), asps), "stopped poll manager is not updated")
+}
+
+func TestPollManagerScale(t *testing.T) {
+	scaleRequestCh := make(chan ScaleRequest)
+	m := newPollManager(buildPollManagerTestASG(nil, 10), nil, nil, nil, nil, nil, nil, nil, scaleRequestCh, make(chan struct{}))
+	m.scaleTolerance = failureTolerance{
+		maxConsecutiveFailures: 2,
+		initialBackoff:         time.Millisecond,
+		maxBackoff:             time.Millisecond,
+	}
+
+	// respond handles a single scale request with the given error
+	respond := func(err error) {
+		go func() {
+			req := <-scaleRequestCh
+			req.errCh <- err
+		}()
+	}
+
+	errCh := make(chan error)
+	a := alert{aspName: "cpu", direction: scaleDirectionUp, adjustmentType: adjustmentTypeAbsolute, adjustmentValue: 1}
+
+	respond(nil)
+	assert.Nil(t, m.scale(a, nil, errCh), "no retry on success")
+
+	respond(errors.New("scale failed"))
+	retry := m.scale(a, nil, errCh)
+	if assert.NotNil(t, retry, "failed request is retried") {
+		assert.Equal(t, a, retry.alert)
+		assert.Equal(t, 1, retry.failures)
+		select {
+		case <-retry.ch():
+		case <-time.After(time.Second):
+			assert.Fail(t, "retry is due after backoff")
+		}
+	}
+
+	b := a
+	b.direction = scaleDirectionDown
+	respond(errors.New("scale failed"))
+	retry = m.scale(b, retry, errCh)
+	if assert.NotNil(t, retry, "failed request is retried") {
+		assert.Equal(t, b, retry.alert, "newer request supersedes retry")
+		assert.Equal(t, 2, retry.failures, "failures are consecutive")
+	}
+
+	respond(errors.New("scale failed"))
+	assert.Nil(t, m.scale(b, retry, errCh), "retries are given up after too many failures")
+
+	var none *scaleRetry
+	assert.Nil(t, none.ch(), "no retry is never due")
+}
+
+func TestPollManagerArbitratePendingPods(t *testing.T) {
+	k8sI := kubeinformers.NewSharedInformerFactory(k8sfake.NewSimpleClientset(), noResyncPeriodFunc())
+
+	n := newNode("node0", nil)
+	n.Status.Allocatable = corev1.ResourceList{
+		corev1.ResourceCPU:  resource.MustParse("2"),
+		corev1.ResourcePods: resource.MustParse("10"),
+	}
+	k8sI.Core().V1().Nodes().Informer().GetIndexer().Add(n)
+	for _, name := range []string{"pod0", "pod1", "pod2"} {
+		k8sI.Core().V1().Pods().Informer().GetIndexer().Add(newPendingPod(name, "1500m"))
+	}
+
+	asg := buildPollManagerTestASG(nil, 10)
+	asg.Spec.Arbitration = &v1alpha1.AlertArbitration{Mode: "largest-target-wins"}
+	asps := map[string]*v1alpha1.AutoscalingPolicy{
+		"cpu":  buildPollManagerTestASP("cpu", 0.8, 60),
+		"pods": buildPollManagerTestASP("pods", 0.8, 60),
+	}
+
+	m := newPollManager(asg, asps, k8sI.Core().V1().Nodes().Lister(), k8sI.Core().V1().Pods().Lister(),
+		&record.FakeRecorder{}, nil, nil, nil, nil, make(chan struct{}))
+
+	chosen, ok := m.arbitrate([]alert{
+		{aspName: "cpu", direction: scaleDirectionUp, adjustmentType: adjustmentTypeAbsolute, adjustmentValue: 2},
+		{aspName: "pods", direction: scaleDirectionUp, adjustmentType: adjustmentTypePendingPods},
+	})
+	assert.True(t, ok)
+	assert.Equal(t, alert{
+		aspName:         "pods",
+		direction:       scaleDirectionUp,
+		adjustmentType:  adjustmentTypeAbsolute,
+		adjustmentValue: 3,
+	}, chosen, "unlimited pending pods adjustment is resolved before arbitration")
+
+	chosen, ok = m.arbitrate([]alert{
+		{aspName: "cpu", direction: scaleDirectionUp, adjustmentType: adjustmentTypeAbsolute, adjustmentValue: 2},
+		{aspName: "pods", direction: scaleDirectionUp, adjustmentType: adjustmentTypePendingPods, adjustmentValue: 1},
+	})
+	assert.True(t, ok)
+	assert.Equal(t, "cpu", chosen.aspName, "capped pending pods adjustment loses to a larger adjustment")
+}
+
+func TestPollManagerArbitrateSchedule(t *testing.T) {
+	k8sI := kubeinformers.NewSharedInformerFactory(k8sfake.NewSimpleClientset(), noResyncPeriodFunc())
+	k8sI.Core().V1().Nodes().Informer().GetIndexer().Add(newNode("node0", nil))
+
+	asg := buildPollManagerTestASG(nil, 10)
+	asg.Spec.Arbitration = &v1alpha1.AlertArbitration{Mode: "largest-target-wins"}
+	schedule := morningSchedule
+	schedule.MinNodes = nil
+	schedule.MaxNodes = intPtr(3)
+	asg.Spec.Schedules = []v1alpha1.ScalingSchedule{schedule}
+	asps := map[string]*v1alpha1.AutoscalingPolicy{
+		"cpu":    buildPollManagerTestASP("cpu", 0.8, 60),
+		"memory": buildPollManagerTestASP("memory", 0.8, 60),
+	}
+
+	m := newPollManager(asg, asps, k8sI.Core().V1().Nodes().Lister(), k8sI.Core().V1().Pods().Lister(),
+		&record.FakeRecorder{}, nil, nil, nil, nil, make(chan struct{}))
+
+	alerts := []alert{
+		{aspName: "cpu", direction: scaleDirectionUp, adjustmentType: adjustmentTypeAbsolute, adjustmentValue: 2},
+		{aspName: "memory", direction: scaleDirectionUp, adjustmentType: adjustmentTypeAbsolute, adjustmentValue: 5},
+	}
+
+	nowFunc = func() time.Time {
+		return time.Date(2019, time.March, 6, 12, 0, 0, 0, time.UTC)
+	}
+	defer resetTime()
+
+	chosen, ok := m.arbitrate(alerts)
+	assert.True(t, ok)
+	assert.Equal(t, "memory", chosen.aspName, "largest target wins without an active schedule")
+
+	nowFunc = func() time.Time {
+		return time.Date(2019, time.March, 6, 9, 30, 0, 0, time.UTC)
+	}
+
+	chosen, ok = m.arbitrate(alerts)
+	assert.True(t, ok)
+	assert.Equal(t, "cpu", chosen.aspName, "targets are clamped to the scheduled max")
+}
+
+func TestAlertingUnchanged(t *testing.T) {
+	asp := buildPollManagerTestASP("cpu", 0.8, 60)
+
+	other := asp.DeepCopy()
+	other.Spec.PollInterval = 10
+	other.Spec.SamplePeriod = 10
+	assert.True(t, alertingUnchanged(asp, other), "poll timing doesn't affect alerting")
+
+	other = asp.DeepCopy()
+	other.Spec.Metric = "memory_percentage"
+	assert.False(t, alertingUnchanged(asp, other), "metric change")
+
+	other = asp.DeepCopy()
+	other.Spec.MetricsBackend = "influxdb"
+	assert.False(t, alertingUnchanged(asp, other), "backend change")
+
+	other = asp.DeepCopy()
+	other.Spec.MetricConfiguration = map[string]string{"aggregation": "max"}
+	assert.False(t, alertingUnchanged(asp, other), "configuration change")
+
+	other = asp.DeepCopy()
+	other.Spec.ScalingPolicy.ScaleUp.ComparisonOperator = ">="
+	assert.False(t, alertingUnchanged(asp, other), "scaling policy change")
+
+	other = asp.DeepCopy()
+	other.Spec.SampleWindow = &v1alpha1.SampleWindow{Samples: 3}
+	assert.False(t, alertingUnchanged(asp, other), "sample window change")
+
+	other = asp.DeepCopy()
+	other.Spec.Hysteresis = 5
+	assert.False(t, alertingUnchanged(asp, other), "hysteresis change")
+}
+
+func TestPollManagerRestorePendingAlerts(t *testing.T) {
+	defer resetTime()
+	setTime(100)
+
+	var recorded [][]v1alpha1.PendingAlert
+	recordPendingAlerts := func(asgName string, alerts []v1alpha1.PendingAlert) error {
+		assert.Equal(t, "asg", asgName)
+		recorded = append(recorded, alerts)
+		return nil
+	}
+
+	asps := map[string]*v1alpha1.AutoscalingPolicy{
+		"cpu": buildPollManagerTestASP("cpu", 0.8, 60),
+	}
+
+	asg := buildPollManagerTestASG(nil, 10)
+	m := newPollManager(asg, asps, nil, nil, nil, nil, nil, recordPendingAlerts, nil, make(chan struct{}))
+	alerts, windowCh := m.restorePendingAlerts()
+	assert.Empty(t, alerts, "nothing to restore")
+	assert.Nil(t, windowCh, "no window is open")
+	assert.Empty(t, recorded, "nothing recorded")
+
+	asg.Spec.Arbitration = &v1alpha1.AlertArbitration{
+		Mode:             "scale-up-wins",
+		EvaluationWindow: 30,
+	}
+	asg.Status.PendingAlerts = []v1alpha1.PendingAlert{
+		{
+			AutoscalingPolicy: "cpu",
+			Direction:         "up",
+			AdjustmentType:    "absolute",
+			AdjustmentValue:   2,
+			AlertedAt:         metav1.NewTime(time.Unix(90, 0)),
+		},
+		{
+			AutoscalingPolicy: "removed",
+			Direction:         "down",
+			AdjustmentType:    "absolute",
+			AdjustmentValue:   1,
+			AlertedAt:         metav1.NewTime(time.Unix(95, 0)),
+		},
+	}
+
+	alerts, windowCh = m.restorePendingAlerts()
+	assert.Equal(t, []alert{
+		{
+			aspName:         "cpu",
+			direction:       scaleDirectionUp,
+			adjustmentType:  adjustmentTypeAbsolute,
+			adjustmentValue: 2,
+			alertedAt:       time.Unix(90, 0),
+		},
+	}, alerts, "alerts of removed policies are discarded")
+	assert.NotNil(t, windowCh, "window is open")
+
+	// The window should have already closed
+	setTime(200)
+	_, windowCh = m.restorePendingAlerts()
+	select {
+	case <-windowCh:
+	case <-time.After(time.Second):
+		assert.Fail(t, "expired window closes immediately")
+	}
+
+	asg.Spec.Arbitration = nil
+	alerts, windowCh = m.restorePendingAlerts()
+	assert.Empty(t, alerts, "alerts discarded if arbitration is disabled")
+	assert.Nil(t, windowCh)
+	if assert.Len(t, recorded, 1) {
+		assert.Empty(t, recorded[0], "discarded alerts are cleared")
+	}
+}
+
+func TestPollManagerCheckpointPendingAlerts(t *testing.T) {
+	var recorded []v1alpha1.PendingAlert
+	m := newPollManager(buildPollManagerTestASG(nil, 10), nil, nil, nil, nil, nil, nil,
+		func(asgName string, alerts []v1alpha1.PendingAlert) error {
+			recorded = alerts
+			return nil
+		}, nil, make(chan struct{}))
+
+	m.checkpointPendingAlerts([]alert{
+		{
+			aspName:         "cpu",
+			direction:       scaleDirectionDown,
+			adjustmentType:  adjustmentTypePercent,
+			adjustmentValue: 10,
+			alertedAt:       time.Unix(10, 0),
+		},
+	})
+	assert.Equal(t, []v1alpha1.PendingAlert{
+		{
+			AutoscalingPolicy: "cpu",
+			Direction:         "down",
+			AdjustmentType:    "percent",
+			AdjustmentValue:   10,
+			AlertedAt:         metav1.NewTime(time.Unix(10, 0)),
+		},
+	}, recorded)
+
+	m.checkpointPendingAlerts(nil)
+	assert.Nil(t, recorded, "pending alerts are cleared")
+
+	// A missing recorder is a noop
+	m.recordPendingAlerts = nil
+	m.checkpointPendingAlerts(nil)
+}
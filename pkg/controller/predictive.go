@@ -0,0 +1,124 @@
+package controller
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/containership/cerebral/pkg/apis/cerebral.containership.io/v1alpha1"
+	"github.com/containership/cerebral/pkg/forecast"
+	"github.com/containership/cerebral/pkg/metrics"
+	"github.com/containership/cerebral/pkg/operator"
+)
+
+// A forecastRecorder persists the most recent forecast of an
+// AutoscalingPolicy's metric for an AutoscalingGroup
+type forecastRecorder func(asgName, aspName string, forecast *v1alpha1.PolicyForecast) error
+
+// predictiveState tracks the forecast of a single poller. It's shared with a
+// replacement poller if the policy is changed in a way that doesn't affect
+// alerting or forecasting.
+type predictiveState struct {
+	// lastForecast is when the forecast was last generated. A new sample is
+	// only available once per step, so there's no need to forecast more often.
+	lastForecast time.Time
+
+	// lastFired is when a predictive alert last fired, or zero if the
+	// forecast is not currently breaching
+	lastFired time.Time
+
+	// forecast is the most recent forecast, so that a replacement poller can
+	// keep exposing it until it forecasts again
+	forecast *v1alpha1.PolicyForecast
+}
+
+// shouldForecast returns true if a new forecast should be generated at the
+// given time
+func (s *predictiveState) shouldForecast(predictive *v1alpha1.PredictiveScaling, now time.Time) bool {
+	step := time.Duration(predictive.Step) * time.Second
+	return s.lastForecast.IsZero() || now.Sub(s.lastForecast) >= step
+}
+
+// buildForecast fetches the history of the policy's metric with the given
+// configuration from the backend and forecasts it over the policy's horizon
+func buildForecast(backend metrics.Backend, asp *v1alpha1.AutoscalingPolicy, metricConfig map[string]string,
+	nodeSelector map[string]string, now time.Time) (*v1alpha1.PolicyForecast, error) {
+	rangeBackend, ok := backend.(metrics.RangeBackend)
+	if !ok {
+		return nil, errors.Errorf("metrics backend %q does not support range queries", asp.Spec.MetricsBackend)
+	}
+
+	predictive := asp.Spec.Predictive
+	lookback := time.Duration(predictive.LookbackPeriod) * time.Second
+	step := time.Duration(predictive.Step) * time.Second
+	seasonalPeriod := time.Duration(predictive.SeasonalPeriod) * time.Second
+
+	samples, err := rangeBackend.GetValueRange(asp.Spec.Metric, metricConfig, nodeSelector,
+		now.Add(-lookback), now, step)
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting history of metric %q", asp.Spec.Metric)
+	}
+
+	model, err := forecast.Fit(samples, seasonalPeriod, step)
+	if err != nil {
+		return nil, errors.Wrap(err, "fitting forecast")
+	}
+
+	horizon := time.Duration(predictive.ForecastHorizon) * time.Second
+	return forecastPolicy(model, asp.Spec.ScalingPolicy.ScaleUp, now, horizon, step), nil
+}
+
+// forecastPolicy evaluates the model at each step over the horizon and
+// returns the resulting forecast, including the first time at which the
+// forecast breaches the scale up threshold and the value at that time if it
+// does at all.
+func forecastPolicy(model *forecast.Model, upConfig *v1alpha1.ScalingPolicyConfiguration,
+	now time.Time, horizon time.Duration, step time.Duration) *v1alpha1.PolicyForecast {
+	result := &v1alpha1.PolicyForecast{
+		GeneratedAt: metav1.NewTime(now),
+		Value:       model.Predict(now.Add(horizon)),
+	}
+
+	if upConfig == nil {
+		return result
+	}
+
+	// Assume the operator is correct thanks to OpenAPI validation on the CR
+	op, _ := operator.FromString(upConfig.ComparisonOperator)
+	for t := now.Add(step); !t.After(now.Add(horizon)); t = t.Add(step) {
+		if value := model.Predict(t); op.Evaluate(value, upConfig.Threshold) {
+			breachAt := metav1.NewTime(t)
+			result.PredictedBreachAt = &breachAt
+			result.PredictedBreachValue = &value
+			break
+		}
+	}
+
+	return result
+}
+
+// predictiveShouldFireAlert updates the predictive state for the given scale
+// up configuration and forecast and returns the step that should be fired
+// along with true if an alert should fire. The step is chosen using the
+// forecasted value at the breach rather than at the end of the horizon, since
+// that's the value the policy will see when the breach happens. A sustained
+// breach refires at most once per sample period, matching the reactive alerts.
+func predictiveShouldFireAlert(policy *v1alpha1.ScalingPolicyConfiguration, state *predictiveState,
+	samplePeriod time.Duration, f *v1alpha1.PolicyForecast) (v1alpha1.ScalingPolicyStep, bool) {
+	if policy == nil || f.PredictedBreachAt == nil || f.PredictedBreachValue == nil {
+		state.lastFired = time.Time{}
+		return v1alpha1.ScalingPolicyStep{}, false
+	}
+
+	now := nowFunc()
+	if !state.lastFired.IsZero() && now.Sub(state.lastFired) < samplePeriod {
+		return v1alpha1.ScalingPolicyStep{}, false
+	}
+
+	state.lastFired = now
+
+	op, _ := operator.FromString(policy.ComparisonOperator)
+	return highestMatchingStep(policy, op, *f.PredictedBreachValue), true
+}
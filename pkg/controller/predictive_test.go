@@ -0,0 +1,200 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/containership/cerebral/pkg/apis/cerebral.containership.io/v1alpha1"
+	"github.com/containership/cerebral/pkg/forecast"
+	"github.com/containership/cerebral/pkg/metrics"
+)
+
+// linearBackend returns a history that increases by one every second
+type linearBackend struct {
+	err error
+}
+
+func (b linearBackend) GetValue(metric string, configuration map[string]string, nodeSelector map[string]string) (float64, error) {
+	return 0, b.err
+}
+
+func (b linearBackend) GetValueRange(metric string, configuration map[string]string, nodeSelector map[string]string,
+	start, end time.Time, step time.Duration) ([]metrics.Sample, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	var samples []metrics.Sample
+	for t := start; !t.After(end); t = t.Add(step) {
+		samples = append(samples, metrics.Sample{
+			Timestamp: t,
+			Value:     float64(t.Unix()),
+		})
+	}
+
+	return samples, nil
+}
+
+// valueOnlyBackend does not support range queries
+type valueOnlyBackend struct{}
+
+func (b valueOnlyBackend) GetValue(metric string, configuration map[string]string, nodeSelector map[string]string) (float64, error) {
+	return 0, nil
+}
+
+var predictiveASP = &v1alpha1.AutoscalingPolicy{
+	ObjectMeta: metav1.ObjectMeta{
+		Name: "predictive",
+	},
+	Spec: v1alpha1.AutoscalingPolicySpec{
+		MetricsBackend: "backend",
+		Metric:         "metric",
+		ScalingPolicy: v1alpha1.ScalingPolicy{
+			ScaleUp: &v1alpha1.ScalingPolicyConfiguration{
+				Threshold:          1100,
+				ComparisonOperator: ">",
+				AdjustmentType:     "absolute",
+				AdjustmentValue:    1,
+			},
+		},
+		Predictive: &v1alpha1.PredictiveScaling{
+			LookbackPeriod:  600,
+			Step:            60,
+			ForecastHorizon: 300,
+		},
+	},
+}
+
+func TestBuildForecast(t *testing.T) {
+	now := time.Unix(1000, 0)
+	metricConfig := metrics.WithAutoscalingGroup(predictiveASP.Spec.MetricConfiguration, "asg")
+
+	_, err := buildForecast(valueOnlyBackend{}, predictiveASP, metricConfig, nil, now)
+	assert.Error(t, err, "backend must support range queries")
+
+	_, err = buildForecast(linearBackend{err: errors.New("some error")}, predictiveASP, metricConfig, nil, now)
+	assert.Error(t, err, "backend error")
+
+	f, err := buildForecast(linearBackend{}, predictiveASP, metricConfig, nil, now)
+	assert.NoError(t, err)
+	assert.InDelta(t, 1300, f.Value, 1e-6, "value at end of horizon")
+	if assert.NotNil(t, f.PredictedBreachAt) {
+		assert.Equal(t, time.Unix(1120, 0), f.PredictedBreachAt.Time, "first step past threshold")
+	}
+}
+
+func TestForecastPolicy(t *testing.T) {
+	origin := time.Unix(0, 0)
+	model, err := forecast.Fit([]metrics.Sample{
+		{Timestamp: origin, Value: 0},
+		{Timestamp: origin.Add(time.Minute), Value: 10},
+	}, 0, time.Minute)
+	assert.NoError(t, err)
+
+	f := forecastPolicy(model, nil, origin, 5*time.Minute, time.Minute)
+	assert.InDelta(t, 50, f.Value, 1e-6, "value at end of horizon")
+	assert.Nil(t, f.PredictedBreachAt, "no breach without scale up config")
+
+	upConfig := &v1alpha1.ScalingPolicyConfiguration{
+		Threshold:          25,
+		ComparisonOperator: ">=",
+	}
+	f = forecastPolicy(model, upConfig, origin, 5*time.Minute, time.Minute)
+	if assert.NotNil(t, f.PredictedBreachAt) {
+		assert.Equal(t, origin.Add(3*time.Minute), f.PredictedBreachAt.Time)
+	}
+	if assert.NotNil(t, f.PredictedBreachValue) {
+		assert.InDelta(t, 30, *f.PredictedBreachValue, 1e-6, "value at breach")
+	}
+
+	upConfig.Threshold = 100
+	f = forecastPolicy(model, upConfig, origin, 5*time.Minute, time.Minute)
+	assert.Nil(t, f.PredictedBreachAt, "no breach within horizon")
+	assert.Nil(t, f.PredictedBreachValue, "no breach within horizon")
+}
+
+func TestPredictiveShouldFireAlert(t *testing.T) {
+	defer resetTime()
+
+	upConfig := &v1alpha1.ScalingPolicyConfiguration{
+		Threshold:          25,
+		ComparisonOperator: ">=",
+		AdjustmentType:     "absolute",
+		AdjustmentValue:    1,
+		Steps: []v1alpha1.ScalingPolicyStep{
+			{
+				Threshold:       50,
+				AdjustmentType:  "absolute",
+				AdjustmentValue: 3,
+			},
+		},
+	}
+
+	breachAt := metav1.NewTime(time.Unix(100, 0))
+	breachValue := float64(60)
+	breaching := &v1alpha1.PolicyForecast{
+		Value:                60,
+		PredictedBreachAt:    &breachAt,
+		PredictedBreachValue: &breachValue,
+	}
+	barelyBreachValue := float64(30)
+	barelyBreaching := &v1alpha1.PolicyForecast{
+		Value:                90,
+		PredictedBreachAt:    &breachAt,
+		PredictedBreachValue: &barelyBreachValue,
+	}
+	notBreaching := &v1alpha1.PolicyForecast{
+		Value: 10,
+	}
+
+	state := &predictiveState{}
+
+	setTime(0)
+	_, fired := predictiveShouldFireAlert(nil, state, 10*time.Second, breaching)
+	assert.False(t, fired, "nil config is a noop")
+
+	_, fired = predictiveShouldFireAlert(upConfig, state, 10*time.Second, notBreaching)
+	assert.False(t, fired, "forecast does not breach")
+
+	step, fired := predictiveShouldFireAlert(upConfig, state, 10*time.Second, breaching)
+	assert.True(t, fired, "fires immediately on breach")
+	assert.Equal(t, float64(3), step.AdjustmentValue, "step is chosen from value at breach")
+
+	setTime(5)
+	_, fired = predictiveShouldFireAlert(upConfig, state, 10*time.Second, breaching)
+	assert.False(t, fired, "sustained breach does not refire within sample period")
+
+	setTime(10)
+	_, fired = predictiveShouldFireAlert(upConfig, state, 10*time.Second, breaching)
+	assert.True(t, fired, "sustained breach refires after sample period")
+
+	setTime(11)
+	_, fired = predictiveShouldFireAlert(upConfig, state, 10*time.Second, notBreaching)
+	assert.False(t, fired, "breach ended")
+
+	_, fired = predictiveShouldFireAlert(upConfig, state, 10*time.Second, breaching)
+	assert.True(t, fired, "new breach fires immediately")
+
+	state = &predictiveState{}
+	step, fired = predictiveShouldFireAlert(upConfig, state, 10*time.Second, barelyBreaching)
+	assert.True(t, fired)
+	assert.Equal(t, float64(1), step.AdjustmentValue, "step ignores value at end of horizon")
+}
+
+func TestShouldForecast(t *testing.T) {
+	predictive := &v1alpha1.PredictiveScaling{
+		Step: 60,
+	}
+
+	state := &predictiveState{}
+	assert.True(t, state.shouldForecast(predictive, time.Unix(0, 0)), "first forecast")
+
+	state.lastForecast = time.Unix(0, 0)
+	assert.False(t, state.shouldForecast(predictive, time.Unix(30, 0)), "within step")
+	assert.True(t, state.shouldForecast(predictive, time.Unix(60, 0)), "step elapsed")
+}
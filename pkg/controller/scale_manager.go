@@ -10,12 +10,14 @@ import (
 
 	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	corelistersv1 "k8s.io/client-go/listers/core/v1"
+	policylistersv1beta1 "k8s.io/client-go/listers/policy/v1beta1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
 
@@ -28,6 +30,7 @@ import (
 	clisters "github.com/containership/cerebral/pkg/client/listers/cerebral.containership.io/v1alpha1"
 	"github.com/containership/cerebral/pkg/events"
 	"github.com/containership/cerebral/pkg/nodeutil"
+	"github.com/containership/cerebral/pkg/scheduling"
 )
 
 type scaleDirection int
@@ -48,11 +51,23 @@ func (d scaleDirection) String() string {
 	return "unknown"
 }
 
+func scaleDirectionFromString(s string) (scaleDirection, error) {
+	switch s {
+	case "up":
+		return scaleDirectionUp, nil
+	case "down":
+		return scaleDirectionDown, nil
+	}
+
+	return 0, errors.Errorf("invalid scale direction %q", s)
+}
+
 type adjustmentType int
 
 const (
 	adjustmentTypeAbsolute adjustmentType = iota
 	adjustmentTypePercent
+	adjustmentTypePendingPods
 )
 
 func (a adjustmentType) String() string {
@@ -61,6 +76,8 @@ func (a adjustmentType) String() string {
 		return "absolute"
 	case adjustmentTypePercent:
 		return "percent"
+	case adjustmentTypePendingPods:
+		return "pending-pods"
 	}
 
 	return "unknown"
@@ -72,6 +89,8 @@ func adjustmentTypeFromString(s string) (adjustmentType, error) {
 		return adjustmentTypeAbsolute, nil
 	case "percent":
 		return adjustmentTypePercent, nil
+	case "pending-pods":
+		return adjustmentTypePendingPods, nil
 	}
 
 	return 0, errors.Errorf("invalid adjustment type %q", s)
@@ -89,6 +108,14 @@ type ScaleManager struct {
 	nodeLister corelistersv1.NodeLister
 	nodeSynced cache.InformerSynced
 
+	// Pods are needed to resolve pending pod adjustments and to simulate
+	// rescheduling before scaling down
+	podLister corelistersv1.PodLister
+	podSynced cache.InformerSynced
+
+	pdbLister policylistersv1beta1.PodDisruptionBudgetLister
+	pdbSynced cache.InformerSynced
+
 	recorder record.EventRecorder
 
 	scaleRequestCh chan ScaleRequest
@@ -110,6 +137,10 @@ type ScaleRequest struct {
 
 const (
 	scaleManagerName = "ScaleManager"
+
+	// statusUpdateAttempts is the number of times a status update is
+	// attempted if it conflicts with another update
+	statusUpdateAttempts = 5
 )
 
 // NewScaleManager returns a new ScaleManager
@@ -135,6 +166,8 @@ func NewScaleManager(
 
 	asgInformer := cInformerFactory.Cerebral().V1alpha1().AutoscalingGroups()
 	nodeInformer := kubeInformerFactory.Core().V1().Nodes()
+	podInformer := kubeInformerFactory.Core().V1().Pods()
+	pdbInformer := kubeInformerFactory.Policy().V1beta1().PodDisruptionBudgets()
 
 	m.asgLister = asgInformer.Lister()
 	m.asgSynced = asgInformer.Informer().HasSynced
@@ -142,6 +175,12 @@ func NewScaleManager(
 	m.nodeLister = nodeInformer.Lister()
 	m.nodeSynced = nodeInformer.Informer().HasSynced
 
+	m.podLister = podInformer.Lister()
+	m.podSynced = podInformer.Informer().HasSynced
+
+	m.pdbLister = pdbInformer.Lister()
+	m.pdbSynced = pdbInformer.Informer().HasSynced
+
 	return m
 }
 
@@ -155,7 +194,7 @@ func (m *ScaleManager) ScaleRequestChan() chan<- ScaleRequest {
 // It must respond to every request on the request's errCh, with the response being
 // nil if no error occurred.
 func (m *ScaleManager) Run(stopCh <-chan struct{}) error {
-	if ok := cache.WaitForCacheSync(stopCh, m.asgSynced, m.nodeSynced); !ok {
+	if ok := cache.WaitForCacheSync(stopCh, m.asgSynced, m.nodeSynced, m.podSynced, m.pdbSynced); !ok {
 		return errors.Errorf("%s: failed to wait for caches to sync", scaleManagerName)
 	}
 
@@ -201,7 +240,9 @@ func (m *ScaleManager) handleScaleRequest(req ScaleRequest) error {
 
 	// TODO instead of just returning an error here, we should consider blocking further
 	// scale requests for this ASG while we try to update the status
-	err = m.updateAutoscalingGroupStatus(asg)
+	err = updateAutoscalingGroupStatus(m.cerebralclientset, req.asgName, func(status *cerebralv1alpha1.AutoscalingGroupStatus) {
+		status.LastUpdatedAt = metav1.Now()
+	})
 	if err != nil {
 		return errors.Wrapf(err, "updating status for AutoscalingGroup %q", req.asgName)
 	}
@@ -233,26 +274,48 @@ func (m *ScaleManager) handleScaleRequestForASG(asg *cerebralv1alpha1.Autoscalin
 		return false, errors.Wrapf(err, "listing nodes for AutoscalingGroup %q", req.asgName)
 	}
 
+	// Bounds may be overridden by any active schedules
+	minNodes, maxNodes := effectiveBounds(asg, nowFunc())
+
+	adjustmentType, adjustmentValue := req.adjustmentType, req.adjustmentValue
+	if adjustmentType == adjustmentTypePendingPods {
+		needed, err := nodesForPendingPods(m.podLister, asg, nodes, adjustmentValue)
+		if err != nil {
+			return false, errors.Wrapf(err, "calculating nodes for pending pods of AutoscalingGroup %q", req.asgName)
+		}
+
+		adjustmentType, adjustmentValue = adjustmentTypeAbsolute, float64(needed)
+	}
+
 	currNodeCount := len(nodes)
-	targetNodeCount := calculateTargetNodeCount(currNodeCount, asg.Spec.MinNodes, asg.Spec.MaxNodes,
-		req.direction, req.adjustmentType, req.adjustmentValue)
+	targetNodeCount := calculateTargetNodeCount(currNodeCount, minNodes, maxNodes,
+		req.direction, adjustmentType, adjustmentValue)
 
 	if currNodeCount == targetNodeCount {
 		// The scale operation would be a noop, so just ignore it but record
 		// a warning event if this case is interesting
-		if req.direction == scaleDirectionUp && targetNodeCount == asg.Spec.MaxNodes {
+		if req.direction == scaleDirectionUp && targetNodeCount == maxNodes {
 			m.recorder.Event(asg, corev1.EventTypeWarning, events.ScaleIgnored,
 				fmt.Sprintf("Scale %s operation would exceed upper bound of %d nodes",
-					req.direction.String(), asg.Spec.MaxNodes))
-		} else if req.direction == scaleDirectionDown && targetNodeCount == asg.Spec.MinNodes {
+					req.direction.String(), maxNodes))
+		} else if req.direction == scaleDirectionDown && targetNodeCount == minNodes {
 			m.recorder.Event(asg, corev1.EventTypeWarning, events.ScaleIgnored,
 				fmt.Sprintf("Scale %s operation would exceed lower bound of %d nodes",
-					req.direction.String(), asg.Spec.MinNodes))
+					req.direction.String(), minNodes))
 		}
 
 		return false, nil
 	}
 
+	if targetNodeCount < currNodeCount {
+		err := m.simulateScaleDown(nodes, currNodeCount-targetNodeCount)
+		if err != nil {
+			m.recorder.Event(asg, corev1.EventTypeWarning, events.ScaleDownBlocked,
+				fmt.Sprintf("Scale down to %d nodes blocked: %s", targetNodeCount, err))
+			return false, nil
+		}
+	}
+
 	strategy := getAutoscalingGroupStrategy(req.direction, asg)
 
 	scaled, err := engine.SetTargetNodeCount(asg.Spec.NodeSelector, targetNodeCount, strategy)
@@ -277,10 +340,104 @@ func (m *ScaleManager) handleScaleRequestForASG(asg *cerebralv1alpha1.Autoscalin
 	return true, nil
 }
 
-func (m *ScaleManager) updateAutoscalingGroupStatus(asg *cerebralv1alpha1.AutoscalingGroup) error {
-	asgCopy := asg.DeepCopy()
-	asgCopy.Status.LastUpdatedAt = metav1.Now()
-	_, err := m.cerebralclientset.CerebralV1alpha1().AutoscalingGroups().UpdateStatus(asgCopy)
+// nodesForPendingPods returns the number of nodes like those in the group that
+// are required to fit the unschedulable pods that could run on the group,
+// capped at the given maximum if it is nonzero
+func nodesForPendingPods(podLister corelistersv1.PodLister, asg *cerebralv1alpha1.AutoscalingGroup,
+	nodes []*corev1.Node, max float64) (int, error) {
+	pods, err := podLister.List(labels.Everything())
+	if err != nil {
+		return 0, errors.Wrap(err, "listing pods")
+	}
+
+	template := scheduling.TemplateNode(nodes, asg.Spec.NodeSelector)
+	pending := scheduling.PendingPodsForNode(pods, template)
+
+	if len(nodes) == 0 {
+		// There's no node to determine capacity from, so add a single node
+		// if any pending pod could run on the group and let the next alert
+		// refine the count
+		if len(pending) == 0 {
+			return 0, nil
+		}
+
+		return 1, nil
+	}
+
+	var podsOnTemplate []*corev1.Pod
+	for _, pod := range pods {
+		if pod.Spec.NodeName == template.ObjectMeta.Name {
+			podsOnTemplate = append(podsOnTemplate, pod)
+		}
+	}
+
+	needed, unfit := scheduling.NodesNeeded(pending, scheduling.NodeCapacity(template, podsOnTemplate))
+	if len(unfit) > 0 {
+		log.Warnf("%s: %d pending pod(s) would not fit on an empty node of AutoscalingGroup %q",
+			scaleManagerName, len(unfit), asg.ObjectMeta.Name)
+	}
+
+	if max > 0 && needed > int(max) {
+		needed = int(max)
+	}
+
+	return needed, nil
+}
+
+// simulateScaleDown returns an error describing why the pods on the nodes
+// likely to be removed could not be rescheduled onto the rest of the group, or
+// nil if the scale down is safe
+func (m *ScaleManager) simulateScaleDown(nodes []*corev1.Node, removeCount int) error {
+	pods, err := m.podLister.List(labels.Everything())
+	if err != nil {
+		return errors.Wrap(err, "listing pods")
+	}
+
+	pdbs, err := m.pdbLister.List(labels.Everything())
+	if err != nil {
+		return errors.Wrap(err, "listing PodDisruptionBudgets")
+	}
+
+	removed := scheduling.NodesLikelyRemoved(nodes, pods, removeCount)
+
+	removedNames := make(map[string]bool, len(removed))
+	for _, node := range removed {
+		removedNames[node.ObjectMeta.Name] = true
+	}
+
+	var remaining []*corev1.Node
+	for _, node := range nodes {
+		if !removedNames[node.ObjectMeta.Name] {
+			remaining = append(remaining, node)
+		}
+	}
+
+	return scheduling.SimulateNodeRemoval(removed, remaining, pods, pdbs)
+}
+
+// updateAutoscalingGroupStatus applies the given mutation to the latest status
+// of the AutoscalingGroup, retrying on conflicts. The status is written by both
+// the ScaleManager and poll managers, so it must not be updated from a
+// possibly stale cached copy.
+func updateAutoscalingGroupStatus(client cerebral.Interface, asgName string,
+	mutate func(*cerebralv1alpha1.AutoscalingGroupStatus)) error {
+	var err error
+	for i := 0; i < statusUpdateAttempts; i++ {
+		var asg *cerebralv1alpha1.AutoscalingGroup
+		asg, err = client.CerebralV1alpha1().AutoscalingGroups().Get(asgName, metav1.GetOptions{})
+		if err != nil {
+			return errors.Wrapf(err, "getting AutoscalingGroup %q", asgName)
+		}
+
+		asgCopy := asg.DeepCopy()
+		mutate(&asgCopy.Status)
+
+		_, err = client.CerebralV1alpha1().AutoscalingGroups().UpdateStatus(asgCopy)
+		if !kubeerrors.IsConflict(err) {
+			return err
+		}
+	}
+
 	return err
 }
 
@@ -289,7 +446,9 @@ func calculateTargetNodeCount(curr, min, max int,
 	var result int
 
 	switch adjustmentType {
-	case adjustmentTypeAbsolute:
+	case adjustmentTypeAbsolute, adjustmentTypePendingPods:
+		// Pending pod adjustments must be resolved to absolute ones with
+		// nodesForPendingPods first, since their value is only a cap.
 		// As documented, we truncate to an int since float makes no sense and
 		// there's no way to validate via the subset of OpenAPI v3
 		if dir == scaleDirectionUp {
@@ -9,6 +9,7 @@ import (
 	"github.com/stretchr/testify/mock"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 
@@ -37,6 +38,7 @@ type fixture struct {
 	// Objects to put in the store.
 	asgListerObjects  []*v1alpha1.AutoscalingGroup
 	nodeListerObjects []*corev1.Node
+	podListerObjects  []*corev1.Pod
 	// Objects from here preloaded into NewSimpleFake.
 	kubeobjects []runtime.Object
 	objects     []runtime.Object
@@ -116,6 +118,10 @@ func (f *fixture) newScaleManager() *ScaleManager {
 		k8sI.Core().V1().Nodes().Informer().GetIndexer().Add(n)
 	}
 
+	for _, p := range f.podListerObjects {
+		k8sI.Core().V1().Pods().Informer().GetIndexer().Add(p)
+	}
+
 	return c
 }
 
@@ -655,10 +661,50 @@ func TestAdjustmentTypeFromString(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, adjustmentTypePercent, a)
 
+	a, err = adjustmentTypeFromString("pending-pods")
+	assert.Nil(t, err)
+	assert.Equal(t, adjustmentTypePendingPods, a)
+
 	_, err = adjustmentTypeFromString("doesnotexist")
 	assert.Error(t, err)
 }
 
+func TestScaleDirectionFromString(t *testing.T) {
+	d, err := scaleDirectionFromString("up")
+	assert.Nil(t, err)
+	assert.Equal(t, scaleDirectionUp, d)
+
+	d, err = scaleDirectionFromString("down")
+	assert.Nil(t, err)
+	assert.Equal(t, scaleDirectionDown, d)
+
+	_, err = scaleDirectionFromString("sideways")
+	assert.Error(t, err)
+}
+
+func TestUpdateAutoscalingGroupStatus(t *testing.T) {
+	ag := newBasicAutoscalingGroup()
+	ag.Status.PendingAlerts = []v1alpha1.PendingAlert{
+		{AutoscalingPolicy: "cpu", Direction: "up"},
+	}
+
+	client := fake.NewSimpleClientset(ag)
+
+	now := metav1.Now()
+	err := updateAutoscalingGroupStatus(client, ag.Name, func(status *v1alpha1.AutoscalingGroupStatus) {
+		status.LastUpdatedAt = now
+	})
+	assert.NoError(t, err)
+
+	updated, err := client.CerebralV1alpha1().AutoscalingGroups().Get(ag.Name, metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, now.Unix(), updated.Status.LastUpdatedAt.Unix(), "status is updated")
+	assert.Len(t, updated.Status.PendingAlerts, 1, "rest of status is preserved")
+
+	err = updateAutoscalingGroupStatus(client, "asg-dne", func(status *v1alpha1.AutoscalingGroupStatus) {})
+	assert.Error(t, err, "missing ASG")
+}
+
 func TestAdjustmentTypeToString(t *testing.T) {
 	s := adjustmentTypeAbsolute.String()
 	assert.Equal(t, "absolute", s)
@@ -666,8 +712,117 @@ func TestAdjustmentTypeToString(t *testing.T) {
 	s = adjustmentTypePercent.String()
 	assert.Equal(t, "percent", s)
 
+	s = adjustmentTypePendingPods.String()
+	assert.Equal(t, "pending-pods", s)
+
 	var adjustmentTypeDNE adjustmentType
-	adjustmentTypeDNE = 3
+	adjustmentTypeDNE = 4
 	s = adjustmentTypeDNE.String()
 	assert.Equal(t, "unknown", s)
 }
+
+func newPendingPod(name, cpu string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Resources: corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse(cpu),
+						},
+					},
+				},
+			},
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodPending,
+			Conditions: []corev1.PodCondition{
+				{
+					Type:   corev1.PodScheduled,
+					Status: corev1.ConditionFalse,
+					Reason: corev1.PodReasonUnschedulable,
+				},
+			},
+		},
+	}
+}
+
+func TestNodesForPendingPods(t *testing.T) {
+	f := newFixture(t)
+	ag := newBasicAutoscalingGroup()
+
+	m := f.newScaleManager()
+	needed, err := nodesForPendingPods(m.podLister, ag, nil, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, needed, "no node is added to a group without nodes if no pods are pending")
+
+	otherPool := newPendingPod("other-pool", "1")
+	otherPool.Spec.NodeSelector = map[string]string{"pool": "other"}
+	f.podListerObjects = append(f.podListerObjects, otherPool)
+
+	m = f.newScaleManager()
+	needed, err = nodesForPendingPods(m.podLister, ag, nil, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, needed, "no node is added for pending pods that can't run on the group")
+
+	n := newNode("node0", nil)
+	n.Status.Allocatable = corev1.ResourceList{
+		corev1.ResourceCPU:  resource.MustParse("2"),
+		corev1.ResourcePods: resource.MustParse("10"),
+	}
+	f.nodeListerObjects = append(f.nodeListerObjects, n)
+
+	f.podListerObjects = append(f.podListerObjects,
+		newPendingPod("pod0", "1500m"),
+		newPendingPod("pod1", "1500m"),
+		newPendingPod("pod2", "1500m"),
+		newPendingPod("too-big", "4"))
+
+	m = f.newScaleManager()
+	needed, err = nodesForPendingPods(m.podLister, ag, nil, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, needed, "a single node is added if there are no nodes to use as a template")
+
+	nodes := []*corev1.Node{n}
+
+	needed, err = nodesForPendingPods(m.podLister, ag, nodes, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, needed, "one node per pending pod that fits")
+
+	needed, err = nodesForPendingPods(m.podLister, ag, nodes, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, needed, "capped at the adjustment value")
+}
+
+func TestScaleDownBlocked(t *testing.T) {
+	f := newFixture(t)
+	ag := newAutoscalingGroup("test", false, masterNodeTestLabels, 1, 1)
+
+	for i := 0; i < 2; i++ {
+		n := newNode(fmt.Sprintf("node%d", i), masterNodeTestLabels)
+		n.Status.Allocatable = corev1.ResourceList{
+			corev1.ResourceCPU:  resource.MustParse("2"),
+			corev1.ResourcePods: resource.MustParse("10"),
+		}
+		f.nodeListerObjects = append(f.nodeListerObjects, n)
+
+		pod := newPendingPod(fmt.Sprintf("pod%d", i), "1500m")
+		pod.Spec.NodeName = n.ObjectMeta.Name
+		pod.Status = corev1.PodStatus{Phase: corev1.PodRunning}
+		f.podListerObjects = append(f.podListerObjects, pod)
+	}
+
+	f.asgListerObjects = append(f.asgListerObjects, ag)
+	f.objects = append(f.objects, ag)
+
+	// The engine must not be called since the remaining node can't fit both pods
+	autoscaling.Registry().Put(engineName, &mocks.Engine{})
+	defer autoscaling.Registry().Delete(engineName)
+
+	req := newScaleRequest(getKey(ag, t), scaleDirectionDown, adjustmentTypeAbsolute, false)
+	f.runASGScaleRequestExpectNoOp(ag, req)
+}
@@ -0,0 +1,104 @@
+package controller
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/containership/cluster-manager/pkg/log"
+
+	"github.com/containership/cerebral/pkg/apis/cerebral.containership.io/v1alpha1"
+	"github.com/containership/cerebral/pkg/cron"
+)
+
+// An activeSchedule is a ScalingSchedule along with the start of the window
+// that is currently in effect
+type activeSchedule struct {
+	schedule v1alpha1.ScalingSchedule
+	start    time.Time
+}
+
+// scheduleWindowStart returns the start of the window of the given schedule
+// that includes now, or false if the schedule is not currently in effect.
+func scheduleWindowStart(schedule v1alpha1.ScalingSchedule, now time.Time) (time.Time, bool, error) {
+	loc := time.UTC
+	if schedule.TimeZone != "" {
+		var err error
+		loc, err = time.LoadLocation(schedule.TimeZone)
+		if err != nil {
+			return time.Time{}, false, errors.Wrapf(err, "loading time zone %q", schedule.TimeZone)
+		}
+	}
+
+	s, err := cron.Parse(schedule.Schedule)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	// The most recent occurrence is in effect if it started less than a
+	// duration ago, so look for the first occurrence after that point
+	duration := time.Duration(schedule.Duration) * time.Second
+	start := s.Next(now.In(loc).Add(-duration))
+	if start.IsZero() || start.After(now) {
+		return time.Time{}, false, nil
+	}
+
+	return start, true, nil
+}
+
+// activeSchedules returns all schedules of the AutoscalingGroup that are in
+// effect at the given time. Invalid schedules are logged and skipped.
+func activeSchedules(asg *v1alpha1.AutoscalingGroup, now time.Time) []activeSchedule {
+	var active []activeSchedule
+	for _, schedule := range asg.Spec.Schedules {
+		start, ok, err := scheduleWindowStart(schedule, now)
+		if err != nil {
+			log.Warnf("Skipping invalid schedule %q for AutoscalingGroup %q: %s",
+				schedule.Name, asg.ObjectMeta.Name, err)
+			continue
+		}
+
+		if ok {
+			active = append(active, activeSchedule{
+				schedule: schedule,
+				start:    start,
+			})
+		}
+	}
+
+	return active
+}
+
+// effectiveBounds returns the min and max node counts for the AutoscalingGroup
+// at the given time. Bounds specified by active schedules override the bounds
+// in the spec. If multiple active schedules specify the same bound, the
+// largest value wins in order to favor capacity.
+func effectiveBounds(asg *v1alpha1.AutoscalingGroup, now time.Time) (int, int) {
+	var min, max *int
+	for _, a := range activeSchedules(asg, now) {
+		if a.schedule.MinNodes != nil && (min == nil || *a.schedule.MinNodes > *min) {
+			min = a.schedule.MinNodes
+		}
+
+		if a.schedule.MaxNodes != nil && (max == nil || *a.schedule.MaxNodes > *max) {
+			max = a.schedule.MaxNodes
+		}
+	}
+
+	resultMin, resultMax := asg.Spec.MinNodes, asg.Spec.MaxNodes
+	if min != nil {
+		resultMin = *min
+	}
+
+	if max != nil {
+		resultMax = *max
+	}
+
+	// A scheduled min may exceed the max in the spec, in which case the
+	// schedule should win
+	if resultMin > resultMax {
+		resultMax = resultMin
+	}
+
+	return resultMin, resultMax
+}
@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/containership/cerebral/pkg/apis/cerebral.containership.io/v1alpha1"
+)
+
+func intPtr(i int) *int {
+	return &i
+}
+
+// Every day at 09:00 for an hour
+var morningSchedule = v1alpha1.ScalingSchedule{
+	Name:     "morning",
+	Schedule: "0 9 * * *",
+	Duration: 3600,
+	MinNodes: intPtr(5),
+}
+
+func TestScheduleWindowStart(t *testing.T) {
+	start, ok, err := scheduleWindowStart(morningSchedule, time.Date(2019, time.March, 6, 9, 30, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.True(t, ok, "within window")
+	assert.Equal(t, time.Date(2019, time.March, 6, 9, 0, 0, 0, time.UTC), start)
+
+	_, ok, err = scheduleWindowStart(morningSchedule, time.Date(2019, time.March, 6, 10, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.False(t, ok, "window has ended")
+
+	_, ok, err = scheduleWindowStart(morningSchedule, time.Date(2019, time.March, 6, 8, 59, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.False(t, ok, "window has not started")
+
+	tz := morningSchedule
+	tz.TimeZone = "America/New_York"
+	_, ok, err = scheduleWindowStart(tz, time.Date(2019, time.March, 6, 9, 30, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.False(t, ok, "window is evaluated in the given time zone")
+
+	_, ok, err = scheduleWindowStart(tz, time.Date(2019, time.March, 6, 14, 30, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.True(t, ok, "window is evaluated in the given time zone")
+
+	tz.TimeZone = "Not/AZone"
+	_, _, err = scheduleWindowStart(tz, time.Now())
+	assert.Error(t, err, "invalid time zone")
+
+	invalid := morningSchedule
+	invalid.Schedule = "0 9 * *"
+	_, _, err = scheduleWindowStart(invalid, time.Now())
+	assert.Error(t, err, "invalid cron expression")
+}
+
+func TestEffectiveBounds(t *testing.T) {
+	asg := newAutoscalingGroup("test", false, nil, 1, 3)
+	inWindow := time.Date(2019, time.March, 6, 9, 30, 0, 0, time.UTC)
+	outOfWindow := time.Date(2019, time.March, 6, 12, 0, 0, 0, time.UTC)
+
+	min, max := effectiveBounds(asg, inWindow)
+	assert.Equal(t, 1, min, "no schedules uses spec bounds")
+	assert.Equal(t, 3, max, "no schedules uses spec bounds")
+
+	asg.Spec.Schedules = []v1alpha1.ScalingSchedule{morningSchedule}
+	min, max = effectiveBounds(asg, outOfWindow)
+	assert.Equal(t, 1, min, "inactive schedule uses spec bounds")
+	assert.Equal(t, 3, max, "inactive schedule uses spec bounds")
+
+	min, max = effectiveBounds(asg, inWindow)
+	assert.Equal(t, 5, min, "active schedule overrides min")
+	assert.Equal(t, 5, max, "max is raised to scheduled min")
+
+	other := morningSchedule
+	other.Name = "other"
+	other.MinNodes = intPtr(2)
+	other.MaxNodes = intPtr(10)
+	asg.Spec.Schedules = append(asg.Spec.Schedules, other)
+	min, max = effectiveBounds(asg, inWindow)
+	assert.Equal(t, 5, min, "largest scheduled min wins")
+	assert.Equal(t, 10, max, "scheduled max is used")
+}
+
+func TestScheduledTargetNodeCount(t *testing.T) {
+	c := &ScheduledScalingController{
+		appliedWindows: make(map[string]time.Time),
+	}
+
+	asg := newAutoscalingGroup("test", false, nil, 1, 10)
+	schedule := morningSchedule
+	schedule.DesiredNodes = intPtr(8)
+	asg.Spec.Schedules = []v1alpha1.ScalingSchedule{schedule}
+	inWindow := time.Date(2019, time.March, 6, 9, 30, 0, 0, time.UTC)
+
+	target, pending := c.targetNodeCount(asg, 6, inWindow)
+	assert.Equal(t, 8, target, "desired node count is applied")
+	assert.Len(t, pending, 1)
+
+	c.markWindowsApplied(asg, pending)
+	target, pending = c.targetNodeCount(asg, 6, inWindow)
+	assert.Equal(t, 6, target, "desired node count is only applied once per window")
+	assert.Empty(t, pending)
+
+	target, _ = c.targetNodeCount(asg, 2, inWindow)
+	assert.Equal(t, 5, target, "node count is still clamped to the scheduled bounds")
+}
+
+func TestPruneAppliedWindows(t *testing.T) {
+	c := &ScheduledScalingController{
+		appliedWindows: make(map[string]time.Time),
+	}
+
+	asg := newAutoscalingGroup("test", false, nil, 1, 10)
+	schedule := morningSchedule
+	schedule.DesiredNodes = intPtr(8)
+	asg.Spec.Schedules = []v1alpha1.ScalingSchedule{schedule}
+	inWindow := time.Date(2019, time.March, 6, 9, 30, 0, 0, time.UTC)
+
+	_, pending := c.targetNodeCount(asg, 6, inWindow)
+	c.markWindowsApplied(asg, pending)
+
+	c.pruneAppliedWindows([]*v1alpha1.AutoscalingGroup{asg}, inWindow)
+	assert.Len(t, c.appliedWindows, 1, "window in effect is kept")
+
+	c.pruneAppliedWindows([]*v1alpha1.AutoscalingGroup{asg}, inWindow.Add(time.Hour))
+	assert.Empty(t, c.appliedWindows, "ended window is dropped")
+
+	c.markWindowsApplied(asg, pending)
+	c.pruneAppliedWindows(nil, inWindow)
+	assert.Empty(t, c.appliedWindows, "window of deleted AutoscalingGroup is dropped")
+
+	c.markWindowsApplied(asg, pending)
+	renamed := asg.DeepCopy()
+	renamed.Spec.Schedules[0].Name = "renamed"
+	c.pruneAppliedWindows([]*v1alpha1.AutoscalingGroup{renamed}, inWindow)
+	assert.Empty(t, c.appliedWindows, "window of removed schedule is dropped")
+}
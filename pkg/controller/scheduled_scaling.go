@@ -0,0 +1,207 @@
+package controller
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+
+	"k8s.io/apimachinery/pkg/labels"
+
+	kubeinformers "k8s.io/client-go/informers"
+	corelistersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/containership/cluster-manager/pkg/log"
+
+	cerebralv1alpha1 "github.com/containership/cerebral/pkg/apis/cerebral.containership.io/v1alpha1"
+	cinformers "github.com/containership/cerebral/pkg/client/informers/externalversions"
+	clisters "github.com/containership/cerebral/pkg/client/listers/cerebral.containership.io/v1alpha1"
+	"github.com/containership/cerebral/pkg/nodeutil"
+)
+
+const (
+	scheduledScalingControllerName = "ScheduledScalingController"
+
+	// Cron schedules have a granularity of one minute, so evaluating more
+	// often than this is enough to catch every window
+	scheduleEvaluationInterval = 15 * time.Second
+)
+
+// ScheduledScalingController periodically evaluates the schedules of all
+// AutoscalingGroups and requests scaling so that node counts stay within
+// the scheduled bounds.
+type ScheduledScalingController struct {
+	asgLister clisters.AutoscalingGroupLister
+	asgSynced cache.InformerSynced
+
+	nodeLister corelistersv1.NodeLister
+	nodeSynced cache.InformerSynced
+
+	// Key is ASG name and schedule name, value is the start of the window for
+	// which the desired node count was already applied. This ensures the
+	// desired count is applied once per window so that policies may still
+	// scale within the scheduled bounds afterwards. Entries are pruned once
+	// their window ends.
+	appliedWindows map[string]time.Time
+
+	scaleRequestCh chan<- ScaleRequest
+}
+
+// NewScheduledScalingController returns a new ScheduledScalingController
+func NewScheduledScalingController(
+	kubeInformerFactory kubeinformers.SharedInformerFactory,
+	cInformerFactory cinformers.SharedInformerFactory,
+	scaleRequestCh chan<- ScaleRequest) *ScheduledScalingController {
+	c := &ScheduledScalingController{
+		appliedWindows: make(map[string]time.Time),
+		scaleRequestCh: scaleRequestCh,
+	}
+
+	asgInformer := cInformerFactory.Cerebral().V1alpha1().AutoscalingGroups()
+	nodeInformer := kubeInformerFactory.Core().V1().Nodes()
+
+	c.asgLister = asgInformer.Lister()
+	c.asgSynced = asgInformer.Informer().HasSynced
+
+	c.nodeLister = nodeInformer.Lister()
+	c.nodeSynced = nodeInformer.Informer().HasSynced
+
+	return c
+}
+
+// Run runs the ScheduledScalingController until stopCh is closed
+func (c *ScheduledScalingController) Run(stopCh <-chan struct{}) error {
+	log.Infof("Starting %s", scheduledScalingControllerName)
+
+	if ok := cache.WaitForCacheSync(stopCh, c.asgSynced, c.nodeSynced); !ok {
+		return errors.Errorf("%s: failed to wait for caches to sync", scheduledScalingControllerName)
+	}
+
+	ticker := time.NewTicker(scheduleEvaluationInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.evaluateSchedules()
+
+		case <-stopCh:
+			log.Infof("%s: shutting down", scheduledScalingControllerName)
+			return nil
+		}
+	}
+}
+
+func (c *ScheduledScalingController) evaluateSchedules() {
+	asgs, err := c.asgLister.List(labels.NewSelector())
+	if err != nil {
+		log.Errorf("%s: error listing AutoscalingGroups: %s", scheduledScalingControllerName, err)
+		return
+	}
+
+	now := nowFunc()
+	c.pruneAppliedWindows(asgs, now)
+
+	for _, asg := range asgs {
+		if len(asg.Spec.Schedules) == 0 || asg.Spec.Suspended {
+			continue
+		}
+
+		if err := c.reconcileAutoscalingGroup(asg, now); err != nil {
+			log.Errorf("%s: error reconciling schedules for AutoscalingGroup %q: %s",
+				scheduledScalingControllerName, asg.Name, err)
+		}
+	}
+}
+
+func (c *ScheduledScalingController) reconcileAutoscalingGroup(asg *cerebralv1alpha1.AutoscalingGroup, now time.Time) error {
+	ns := nodeutil.GetNodesLabelSelector(asg.Spec.NodeSelector)
+	nodes, err := c.nodeLister.List(ns)
+	if err != nil {
+		return errors.Wrapf(err, "listing nodes for AutoscalingGroup %s", asg.Name)
+	}
+
+	currNodeCount := len(nodes)
+	target, pendingWindows := c.targetNodeCount(asg, currNodeCount, now)
+
+	delta, dir := determineScaleDeltaAndDirection(currNodeCount, target, target)
+	if delta == 0 {
+		c.markWindowsApplied(asg, pendingWindows)
+		return nil
+	}
+
+	log.Infof("%s: AutoscalingGroup %s node count (%d) does not match scheduled target (%d) and is requesting scale %s by %d",
+		scheduledScalingControllerName, asg.Name, currNodeCount, target, dir.String(), delta)
+
+	// Like bounds reconciliation, scheduled scaling does not respect the
+	// cooldown since the schedule is explicitly requested by the user
+	errCh := make(chan error)
+	c.scaleRequestCh <- ScaleRequest{
+		asgName:         asg.Name,
+		direction:       dir,
+		adjustmentType:  adjustmentTypeAbsolute,
+		adjustmentValue: float64(delta),
+		ignoreCooldown:  true,
+		errCh:           errCh,
+	}
+
+	if err := <-errCh; err != nil {
+		return errors.Wrap(err, "requesting scale manager to scale")
+	}
+
+	c.markWindowsApplied(asg, pendingWindows)
+
+	return nil
+}
+
+// targetNodeCount returns the node count the AutoscalingGroup should have
+// according to its schedules at the given time, along with the windows whose
+// desired node count is being applied for the first time
+func (c *ScheduledScalingController) targetNodeCount(asg *cerebralv1alpha1.AutoscalingGroup,
+	currNodeCount int, now time.Time) (int, []activeSchedule) {
+	min, max := effectiveBounds(asg, now)
+	target := fitWithinBounds(currNodeCount, min, max)
+
+	var pending []activeSchedule
+	for _, a := range activeSchedules(asg, now) {
+		if a.schedule.DesiredNodes == nil {
+			continue
+		}
+
+		if applied, ok := c.appliedWindows[appliedWindowKey(asg, a)]; ok && applied.Equal(a.start) {
+			continue
+		}
+
+		pending = append(pending, a)
+		target = fitWithinBounds(*a.schedule.DesiredNodes, min, max)
+	}
+
+	return target, pending
+}
+
+func (c *ScheduledScalingController) markWindowsApplied(asg *cerebralv1alpha1.AutoscalingGroup, windows []activeSchedule) {
+	for _, a := range windows {
+		c.appliedWindows[appliedWindowKey(asg, a)] = a.start
+	}
+}
+
+// pruneAppliedWindows forgets applied windows that have ended or whose
+// AutoscalingGroup or schedule no longer exists
+func (c *ScheduledScalingController) pruneAppliedWindows(asgs []*cerebralv1alpha1.AutoscalingGroup, now time.Time) {
+	active := make(map[string]time.Time)
+	for _, asg := range asgs {
+		for _, a := range activeSchedules(asg, now) {
+			active[appliedWindowKey(asg, a)] = a.start
+		}
+	}
+
+	for key, applied := range c.appliedWindows {
+		if start, ok := active[key]; !ok || !start.Equal(applied) {
+			delete(c.appliedWindows, key)
+		}
+	}
+}
+
+func appliedWindowKey(asg *cerebralv1alpha1.AutoscalingGroup, a activeSchedule) string {
+	return asg.Name + "/" + a.schedule.Name
+}
@@ -0,0 +1,212 @@
+package cron
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A Schedule is a parsed standard 5-field cron expression of the form
+// "minute hour day-of-month month day-of-week".
+type Schedule struct {
+	minute     uint64
+	hour       uint64
+	dayOfMonth uint64
+	month      uint64
+	dayOfWeek  uint64
+
+	// Per standard cron semantics, if both day fields are restricted then a
+	// time matches if either of them matches
+	dayOfMonthStar bool
+	dayOfWeekStar  bool
+}
+
+type fieldBounds struct {
+	name     string
+	min, max int
+}
+
+var (
+	minuteBounds     = fieldBounds{"minute", 0, 59}
+	hourBounds       = fieldBounds{"hour", 0, 23}
+	dayOfMonthBounds = fieldBounds{"day of month", 1, 31}
+	monthBounds      = fieldBounds{"month", 1, 12}
+	dayOfWeekBounds  = fieldBounds{"day of week", 0, 7}
+)
+
+// The furthest into the future we'll search for a matching time before giving
+// up, e.g. for an expression such as "0 0 31 2 *" that never matches
+const maxSearchYears = 5
+
+// Parse parses a standard 5-field cron expression. Each field may be a `*`,
+// a single value, a range `a-b`, a list `a,b,c`, or any of these followed by
+// a step `/n`. A day of week of 7 is treated as Sunday.
+func Parse(spec string) (*Schedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, errors.Errorf("expected 5 fields in cron expression %q but found %d", spec, len(fields))
+	}
+
+	s := &Schedule{}
+	var err error
+
+	if s.minute, err = parseField(fields[0], minuteBounds); err != nil {
+		return nil, err
+	}
+
+	if s.hour, err = parseField(fields[1], hourBounds); err != nil {
+		return nil, err
+	}
+
+	if s.dayOfMonth, err = parseField(fields[2], dayOfMonthBounds); err != nil {
+		return nil, err
+	}
+
+	if s.month, err = parseField(fields[3], monthBounds); err != nil {
+		return nil, err
+	}
+
+	if s.dayOfWeek, err = parseField(fields[4], dayOfWeekBounds); err != nil {
+		return nil, err
+	}
+
+	// Sunday may be specified as either 0 or 7
+	if s.dayOfWeek&(1<<7) != 0 {
+		s.dayOfWeek |= 1
+	}
+
+	s.dayOfMonthStar = strings.HasPrefix(fields[2], "*")
+	s.dayOfWeekStar = strings.HasPrefix(fields[4], "*")
+
+	return s, nil
+}
+
+// Next returns the first time strictly after t that matches the schedule, in
+// the location of t. The zero time is returned if no match can be found.
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(maxSearchYears, 0, 0)
+
+	for t.Before(limit) {
+		if !has(s.month, int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+
+		if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+
+		if !has(s.hour, t.Hour()) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+
+		if !has(s.minute, t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
+
+func (s *Schedule) matchesDay(t time.Time) bool {
+	domMatch := has(s.dayOfMonth, t.Day())
+	dowMatch := has(s.dayOfWeek, int(t.Weekday()))
+
+	if s.dayOfMonthStar || s.dayOfWeekStar {
+		return domMatch && dowMatch
+	}
+
+	return domMatch || dowMatch
+}
+
+func has(bits uint64, val int) bool {
+	return bits&(1<<uint(val)) != 0
+}
+
+// parseField parses a single cron field into a bitset of allowed values
+func parseField(field string, bounds fieldBounds) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		b, err := parsePart(part, bounds)
+		if err != nil {
+			return 0, errors.Wrapf(err, "parsing %s field %q", bounds.name, field)
+		}
+
+		bits |= b
+	}
+
+	return bits, nil
+}
+
+func parsePart(part string, bounds fieldBounds) (uint64, error) {
+	step := 1
+	rangeAndStep := strings.SplitN(part, "/", 2)
+	if len(rangeAndStep) == 2 {
+		var err error
+		step, err = strconv.Atoi(rangeAndStep[1])
+		if err != nil || step <= 0 {
+			return 0, errors.Errorf("invalid step %q", rangeAndStep[1])
+		}
+	}
+
+	start, end := bounds.min, bounds.max
+	switch r := rangeAndStep[0]; {
+	case r == "*":
+		// Use the full bounds
+
+	case strings.Contains(r, "-"):
+		startEnd := strings.SplitN(r, "-", 2)
+		var err error
+		if start, err = parseValue(startEnd[0], bounds); err != nil {
+			return 0, err
+		}
+
+		if end, err = parseValue(startEnd[1], bounds); err != nil {
+			return 0, err
+		}
+
+		if start > end {
+			return 0, errors.Errorf("invalid range %q", r)
+		}
+
+	default:
+		var err error
+		if start, err = parseValue(r, bounds); err != nil {
+			return 0, err
+		}
+
+		// A single value with a step means "starting at"
+		if len(rangeAndStep) == 1 {
+			end = start
+		}
+	}
+
+	var bits uint64
+	for i := start; i <= end; i += step {
+		bits |= 1 << uint(i)
+	}
+
+	return bits, nil
+}
+
+func parseValue(s string, bounds fieldBounds) (int, error) {
+	val, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, errors.Errorf("invalid value %q", s)
+	}
+
+	if val < bounds.min || val > bounds.max {
+		return 0, errors.Errorf("value %d out of range [%d, %d]", val, bounds.min, bounds.max)
+	}
+
+	return val, nil
+}
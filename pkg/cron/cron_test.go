@@ -0,0 +1,75 @@
+package cron
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	_, err := Parse("* * * * *")
+	assert.NoError(t, err, "all wildcards")
+
+	_, err = Parse("*/15 8-18 1,15 * 1-5")
+	assert.NoError(t, err, "steps, ranges, and lists")
+
+	_, err = Parse("0 9 * * 7")
+	assert.NoError(t, err, "Sunday as 7")
+
+	_, err = Parse("* * * *")
+	assert.Error(t, err, "too few fields")
+
+	_, err = Parse("60 * * * *")
+	assert.Error(t, err, "minute out of range")
+
+	_, err = Parse("* 5-2 * * *")
+	assert.Error(t, err, "backwards range")
+
+	_, err = Parse("*/0 * * * *")
+	assert.Error(t, err, "zero step")
+
+	_, err = Parse("a * * * *")
+	assert.Error(t, err, "non-numeric value")
+}
+
+func TestNext(t *testing.T) {
+	// A Wednesday
+	start := time.Date(2019, time.March, 6, 10, 30, 15, 0, time.UTC)
+
+	s, _ := Parse("* * * * *")
+	assert.Equal(t, time.Date(2019, time.March, 6, 10, 31, 0, 0, time.UTC), s.Next(start),
+		"every minute")
+
+	s, _ = Parse("0 9 * * *")
+	assert.Equal(t, time.Date(2019, time.March, 7, 9, 0, 0, 0, time.UTC), s.Next(start),
+		"daily rolls over to next day")
+
+	s, _ = Parse("*/20 * * * *")
+	assert.Equal(t, time.Date(2019, time.March, 6, 10, 40, 0, 0, time.UTC), s.Next(start),
+		"minute step")
+
+	s, _ = Parse("0 8 * * 1-5")
+	assert.Equal(t, time.Date(2019, time.March, 7, 8, 0, 0, 0, time.UTC), s.Next(start),
+		"weekdays only")
+
+	s, _ = Parse("0 8 * * 7")
+	assert.Equal(t, time.Date(2019, time.March, 10, 8, 0, 0, 0, time.UTC), s.Next(start),
+		"Sunday as 7")
+
+	s, _ = Parse("0 0 1 1 *")
+	assert.Equal(t, time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC), s.Next(start),
+		"yearly rolls over to next year")
+
+	s, _ = Parse("0 0 13 * 5")
+	assert.Equal(t, time.Date(2019, time.March, 8, 0, 0, 0, 0, time.UTC), s.Next(start),
+		"restricted day of month and day of week match either")
+
+	s, _ = Parse("0 0 31 2 *")
+	assert.True(t, s.Next(start).IsZero(), "never matches")
+
+	loc := time.FixedZone("UTC-5", -5*60*60)
+	s, _ = Parse("0 9 * * *")
+	assert.Equal(t, time.Date(2019, time.March, 6, 9, 0, 0, 0, loc), s.Next(start.In(loc)),
+		"evaluated in the location of the given time")
+}
@@ -11,9 +11,24 @@ const (
 	// ScaledDown event is created when an AutoscalingGroup is scaled down
 	ScaledDown = "ScaledDown"
 
+	// ScaleArbitrated event is created when alerts from multiple
+	// AutoscalingPolicies are consolidated into a single scale decision
+	ScaleArbitrated = "ScaleArbitrated"
+
+	// ScaleDownBlocked event is created when a scale down is prevented because
+	// the pods on the removed nodes could not be rescheduled
+	ScaleDownBlocked = "ScaleDownBlocked"
+
 	// ScaleIgnored event is created when a scale event is ignored
 	ScaleIgnored = "ScaleIgnored"
 
 	// ScaleError event is created when a scale event errors
 	ScaleError = "ScaleError"
+
+	// MetricPollFailing event is created when polling an AutoscalingPolicy's
+	// metric fails more times in a row than the policy tolerates
+	MetricPollFailing = "MetricPollFailing"
+	// MetricPollRecovered event is created when polling an AutoscalingPolicy's
+	// metric succeeds after MetricPollFailing
+	MetricPollRecovered = "MetricPollRecovered"
 )
@@ -0,0 +1,163 @@
+// Package forecast fits simple models to metric history in order to predict
+// future values.
+package forecast
+
+import (
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/containership/cerebral/pkg/metrics"
+)
+
+const (
+	// minSamples is the fewest samples a trend can reasonably be fit to
+	minSamples = 2
+
+	// backfitIterations is the number of times the trend and seasonal
+	// components are alternately refit
+	backfitIterations = 20
+)
+
+// A Model is a linear trend with an optional seasonal component, fit to a
+// series of samples by least squares
+type Model struct {
+	origin    time.Time
+	intercept float64
+	slope     float64 // per second
+
+	// seasonal holds the average deviation from the trend for each
+	// resolution-sized bucket of the seasonal period
+	seasonal       []float64
+	seasonalPeriod time.Duration
+	resolution     time.Duration
+}
+
+// Fit fits a Model to the given samples. If seasonalPeriod is nonzero, the
+// average deviation from the trend at each point in the period is learned as
+// well, at the given resolution.
+func Fit(samples []metrics.Sample, seasonalPeriod time.Duration, resolution time.Duration) (*Model, error) {
+	if len(samples) < minSamples {
+		return nil, errors.Errorf("at least %d samples are required but got %d", minSamples, len(samples))
+	}
+
+	if seasonalPeriod != 0 && (resolution <= 0 || resolution > seasonalPeriod) {
+		return nil, errors.Errorf("resolution %s must be positive and no longer than seasonal period %s",
+			resolution, seasonalPeriod)
+	}
+
+	m := &Model{
+		origin:         samples[0].Timestamp,
+		seasonalPeriod: seasonalPeriod,
+		resolution:     resolution,
+	}
+
+	if seasonalPeriod == 0 {
+		m.fitTrend(samples)
+		return m, nil
+	}
+
+	numBuckets := int(math.Ceil(float64(seasonalPeriod) / float64(resolution)))
+	m.seasonal = make([]float64, numBuckets)
+
+	// The trend and seasonal components can't be fit independently since
+	// a partial period would skew the trend, so alternate between fitting
+	// each to what the other doesn't explain until they settle
+	deseasonalized := make([]metrics.Sample, len(samples))
+	for i := 0; i < backfitIterations; i++ {
+		for j, s := range samples {
+			deseasonalized[j] = metrics.Sample{
+				Timestamp: s.Timestamp,
+				Value:     s.Value - m.seasonal[m.bucket(s.Timestamp)],
+			}
+		}
+
+		m.fitTrend(deseasonalized)
+		m.fitSeasonal(samples)
+	}
+
+	return m, nil
+}
+
+// fitTrend fits the linear component of the model by least squares
+func (m *Model) fitTrend(samples []metrics.Sample) {
+	var sumX, sumY, sumXY, sumXX float64
+	for _, s := range samples {
+		x := m.x(s.Timestamp)
+		sumX += x
+		sumY += s.Value
+		sumXY += x * s.Value
+		sumXX += x * x
+	}
+
+	n := float64(len(samples))
+	denom := n*sumXX - sumX*sumX
+	if denom == 0 {
+		// All samples are at the same time, so there's no trend to speak of
+		m.slope = 0
+		m.intercept = sumY / n
+		return
+	}
+
+	m.slope = (n*sumXY - sumX*sumY) / denom
+	m.intercept = (sumY - m.slope*sumX) / n
+}
+
+// fitSeasonal fits the seasonal component of the model to the deviation of
+// the samples from the trend. The component is centered on zero so that the
+// level of the series stays with the trend.
+func (m *Model) fitSeasonal(samples []metrics.Sample) {
+	sums := make([]float64, len(m.seasonal))
+	counts := make([]int, len(m.seasonal))
+	for _, s := range samples {
+		b := m.bucket(s.Timestamp)
+		sums[b] += s.Value - m.trend(s.Timestamp)
+		counts[b]++
+	}
+
+	var total float64
+	var filled int
+	for i := range sums {
+		m.seasonal[i] = 0
+		if counts[i] > 0 {
+			m.seasonal[i] = sums[i] / float64(counts[i])
+			total += m.seasonal[i]
+			filled++
+		}
+	}
+
+	mean := total / float64(filled)
+	for i := range m.seasonal {
+		if counts[i] > 0 {
+			m.seasonal[i] -= mean
+		}
+	}
+}
+
+// Predict returns the value predicted by the model at the given time
+func (m *Model) Predict(t time.Time) float64 {
+	v := m.trend(t)
+	if m.seasonal != nil {
+		v += m.seasonal[m.bucket(t)]
+	}
+
+	return v
+}
+
+func (m *Model) x(t time.Time) float64 {
+	return t.Sub(m.origin).Seconds()
+}
+
+func (m *Model) trend(t time.Time) float64 {
+	return m.intercept + m.slope*m.x(t)
+}
+
+func (m *Model) bucket(t time.Time) int {
+	offset := t.Sub(m.origin) % m.seasonalPeriod
+	if offset < 0 {
+		offset += m.seasonalPeriod
+	}
+
+	return int(offset / m.resolution)
+}
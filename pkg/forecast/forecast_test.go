@@ -0,0 +1,106 @@
+package forecast
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/containership/cerebral/pkg/metrics"
+)
+
+var origin = time.Date(2019, time.March, 6, 0, 0, 0, 0, time.UTC)
+
+func buildSamples(n int, step time.Duration, f func(i int) float64) []metrics.Sample {
+	samples := make([]metrics.Sample, n)
+	for i := range samples {
+		samples[i] = metrics.Sample{
+			Timestamp: origin.Add(time.Duration(i) * step),
+			Value:     f(i),
+		}
+	}
+
+	return samples
+}
+
+func TestFit(t *testing.T) {
+	_, err := Fit(nil, 0, time.Minute)
+	assert.Error(t, err, "no samples")
+
+	_, err = Fit(buildSamples(1, time.Minute, func(i int) float64 { return 1 }), 0, time.Minute)
+	assert.Error(t, err, "too few samples")
+
+	_, err = Fit(buildSamples(10, time.Minute, func(i int) float64 { return 1 }), time.Minute, time.Hour)
+	assert.Error(t, err, "resolution longer than seasonal period")
+
+	_, err = Fit(buildSamples(10, time.Minute, func(i int) float64 { return 1 }), time.Hour, 0)
+	assert.Error(t, err, "zero resolution with seasonal period")
+
+	m, err := Fit(buildSamples(10, time.Minute, func(i int) float64 { return 1 }), 0, time.Minute)
+	assert.NoError(t, err, "no seasonal period")
+	assert.NotNil(t, m)
+}
+
+func TestPredictTrend(t *testing.T) {
+	// Increases by 1 every minute starting at 10
+	samples := buildSamples(10, time.Minute, func(i int) float64 { return 10 + float64(i) })
+	m, err := Fit(samples, 0, time.Minute)
+	assert.NoError(t, err)
+
+	assert.InDelta(t, 10, m.Predict(origin), 1e-9, "fits history")
+	assert.InDelta(t, 30, m.Predict(origin.Add(20*time.Minute)), 1e-9, "extrapolates trend")
+
+	flat := buildSamples(10, time.Minute, func(i int) float64 { return 42 })
+	m, err = Fit(flat, 0, time.Minute)
+	assert.NoError(t, err)
+	assert.InDelta(t, 42, m.Predict(origin.Add(time.Hour)), 1e-9, "flat history predicts flat")
+
+	sameTime := []metrics.Sample{
+		{Timestamp: origin, Value: 1},
+		{Timestamp: origin, Value: 3},
+	}
+	m, err = Fit(sameTime, 0, time.Minute)
+	assert.NoError(t, err)
+	assert.InDelta(t, 2, m.Predict(origin.Add(time.Hour)), 1e-9, "samples at the same time predict their mean")
+}
+
+func TestPredictSeasonal(t *testing.T) {
+	// Hourly period with a spike to 90 during the second half of every hour
+	// and a baseline of 10 otherwise, over four hours of history
+	samples := buildSamples(4*60, time.Minute, func(i int) float64 {
+		if i%60 >= 30 {
+			return 90
+		}
+		return 10
+	})
+
+	m, err := Fit(samples, time.Hour, time.Minute)
+	assert.NoError(t, err)
+
+	nextHour := origin.Add(4 * time.Hour)
+	assert.InDelta(t, 10, m.Predict(nextHour.Add(10*time.Minute)), 1,
+		"predicts baseline during first half of period")
+	assert.InDelta(t, 90, m.Predict(nextHour.Add(40*time.Minute)), 1,
+		"predicts spike during second half of period")
+
+	assert.InDelta(t, 10, m.Predict(origin.Add(-50*time.Minute)), 1,
+		"predicts times before the origin")
+
+	// The same spike on top of an increasing trend, with a partial period at
+	// the end of history
+	samples = buildSamples(3*60+45, time.Minute, func(i int) float64 {
+		v := 10 + float64(i)/10
+		if i%60 >= 30 {
+			v += 80
+		}
+		return v
+	})
+
+	m, err = Fit(samples, time.Hour, time.Minute)
+	assert.NoError(t, err)
+
+	assert.InDelta(t, 10+31, m.Predict(origin.Add(310*time.Minute)), 1,
+		"predicts trend during first half of period")
+	assert.InDelta(t, 90+34, m.Predict(origin.Add(340*time.Minute)), 1,
+		"predicts trend and spike during second half of period")
+}
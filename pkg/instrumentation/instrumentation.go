@@ -0,0 +1,155 @@
+// Package instrumentation exposes Prometheus metrics describing the state of
+// Cerebral itself. Metrics are written in the Prometheus text exposition
+// format so that no additional client dependencies are required.
+package instrumentation
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/containership/cerebral/pkg/apis/cerebral.containership.io/v1alpha1"
+)
+
+const namespace = "cerebral"
+
+// A gaugeVec is a set of gauges sharing a name and label names
+type gaugeVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu sync.Mutex
+	// Key is the label values joined by labelValueSeparator
+	values map[string]float64
+}
+
+// labelValueSeparator can't appear in label values, which are Kubernetes
+// object names
+const labelValueSeparator = "\x00"
+
+func newGaugeVec(name, help string, labels ...string) *gaugeVec {
+	return &gaugeVec{
+		name:   namespace + "_" + name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]float64),
+	}
+}
+
+func (g *gaugeVec) set(val float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values[strings.Join(labelValues, labelValueSeparator)] = val
+}
+
+func (g *gaugeVec) delete(labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.values, strings.Join(labelValues, labelValueSeparator))
+}
+
+// writeTo writes the gauges in the Prometheus text exposition format
+func (g *gaugeVec) writeTo(w io.Writer) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(g.values))
+	for k := range g.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		labelValues := strings.Split(k, labelValueSeparator)
+		pairs := make([]string, len(g.labels))
+		for i, label := range g.labels {
+			pairs[i] = fmt.Sprintf("%s=\"%s\"", label, escapeLabelValue(labelValues[i]))
+		}
+
+		_, err := fmt.Fprintf(w, "%s{%s} %s\n", g.name, strings.Join(pairs, ","),
+			strconv.FormatFloat(g.values[k], 'g', -1, 64))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeLabelValue(v string) string {
+	return labelValueEscaper.Replace(v)
+}
+
+var (
+	policyForecastValue = newGaugeVec("autoscaling_policy_forecast_value",
+		"Forecasted value of an AutoscalingPolicy's metric for an AutoscalingGroup at the end of its forecast horizon",
+		"autoscaling_group", "autoscaling_policy")
+
+	policyForecastBreachSeconds = newGaugeVec("autoscaling_policy_forecast_breach_seconds",
+		"Seconds until an AutoscalingPolicy's forecast for an AutoscalingGroup breaches its scale up threshold, or -1 if it does not within the horizon",
+		"autoscaling_group", "autoscaling_policy")
+
+	policyPollConsecutiveFailures = newGaugeVec("autoscaling_policy_poll_consecutive_failures",
+		"Number of consecutive failed polls of an AutoscalingPolicy's metric for an AutoscalingGroup",
+		"autoscaling_group", "autoscaling_policy")
+
+	all = []*gaugeVec{
+		policyForecastValue,
+		policyForecastBreachSeconds,
+		policyPollConsecutiveFailures,
+	}
+)
+
+// SetPolicyForecast records the most recent forecast of an AutoscalingPolicy's
+// metric for an AutoscalingGroup
+func SetPolicyForecast(asgName, aspName string, forecast v1alpha1.PolicyForecast) {
+	policyForecastValue.set(forecast.Value, asgName, aspName)
+
+	breachSeconds := -1.0
+	if forecast.PredictedBreachAt != nil {
+		breachSeconds = forecast.PredictedBreachAt.Sub(forecast.GeneratedAt.Time).Seconds()
+	}
+	policyForecastBreachSeconds.set(breachSeconds, asgName, aspName)
+}
+
+// DeletePolicyForecast removes the forecast metrics of an AutoscalingPolicy's
+// metric for an AutoscalingGroup
+func DeletePolicyForecast(asgName, aspName string) {
+	policyForecastValue.delete(asgName, aspName)
+	policyForecastBreachSeconds.delete(asgName, aspName)
+}
+
+// SetPolicyPollFailures records the number of consecutive failed polls of an
+// AutoscalingPolicy's metric for an AutoscalingGroup
+func SetPolicyPollFailures(asgName, aspName string, failures int) {
+	policyPollConsecutiveFailures.set(float64(failures), asgName, aspName)
+}
+
+// DeletePolicyPollFailures removes the poll failure metrics for an
+// AutoscalingPolicy's metric for an AutoscalingGroup
+func DeletePolicyPollFailures(asgName, aspName string) {
+	policyPollConsecutiveFailures.delete(asgName, aspName)
+}
+
+// Handler returns an http.Handler that serves all metrics
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		for _, g := range all {
+			if err := g.writeTo(w); err != nil {
+				return
+			}
+		}
+	})
+}
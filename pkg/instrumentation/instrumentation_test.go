@@ -0,0 +1,119 @@
+package instrumentation
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/containership/cerebral/pkg/apis/cerebral.containership.io/v1alpha1"
+)
+
+func TestGaugeVecWriteTo(t *testing.T) {
+	g := newGaugeVec("test", "Test gauge", "label")
+
+	var out bytes.Buffer
+	assert.NoError(t, g.writeTo(&out))
+	assert.Equal(t, "# HELP cerebral_test Test gauge\n# TYPE cerebral_test gauge\n", out.String(),
+		"no values")
+
+	g.set(2.5, "b")
+	g.set(-1, "a")
+	g.set(0, `quote"d`)
+
+	out.Reset()
+	assert.NoError(t, g.writeTo(&out))
+	assert.Equal(t, `# HELP cerebral_test Test gauge
+# TYPE cerebral_test gauge
+cerebral_test{label="a"} -1
+cerebral_test{label="b"} 2.5
+cerebral_test{label="quote\"d"} 0
+`, out.String(), "values are sorted and escaped")
+
+	g.delete("b")
+	out.Reset()
+	assert.NoError(t, g.writeTo(&out))
+	assert.NotContains(t, out.String(), `label="b"`, "deleted value is not written")
+
+	g = newGaugeVec("multi", "Multi-label gauge", "first", "second")
+	g.set(1, "b", "a")
+	g.set(2, "a", "b")
+
+	out.Reset()
+	assert.NoError(t, g.writeTo(&out))
+	assert.Equal(t, `# HELP cerebral_multi Multi-label gauge
+# TYPE cerebral_multi gauge
+cerebral_multi{first="a",second="b"} 2
+cerebral_multi{first="b",second="a"} 1
+`, out.String(), "multiple labels")
+
+	g.delete("a", "b")
+	out.Reset()
+	assert.NoError(t, g.writeTo(&out))
+	assert.NotContains(t, out.String(), `first="a"`, "deleted value is not written")
+}
+
+func TestSetPolicyForecast(t *testing.T) {
+	defer DeletePolicyForecast("asg", "asp")
+	defer DeletePolicyForecast("other", "asp")
+
+	now := time.Unix(1000, 0)
+	breachAt := metav1.NewTime(now.Add(90 * time.Second))
+	SetPolicyForecast("asg", "asp", v1alpha1.PolicyForecast{
+		GeneratedAt:       metav1.NewTime(now),
+		Value:             42,
+		PredictedBreachAt: &breachAt,
+	})
+	SetPolicyForecast("other", "asp", v1alpha1.PolicyForecast{
+		GeneratedAt: metav1.NewTime(now),
+		Value:       7,
+	})
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(t, rec.Body.String(),
+		`cerebral_autoscaling_policy_forecast_value{autoscaling_group="asg",autoscaling_policy="asp"} 42`)
+	assert.Contains(t, rec.Body.String(),
+		`cerebral_autoscaling_policy_forecast_breach_seconds{autoscaling_group="asg",autoscaling_policy="asp"} 90`)
+	assert.Contains(t, rec.Body.String(),
+		`cerebral_autoscaling_policy_forecast_value{autoscaling_group="other",autoscaling_policy="asp"} 7`,
+		"forecasts are recorded per AutoscalingGroup")
+
+	SetPolicyForecast("asg", "asp", v1alpha1.PolicyForecast{
+		GeneratedAt: metav1.NewTime(now),
+		Value:       42,
+	})
+
+	rec = httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(t, rec.Body.String(),
+		`cerebral_autoscaling_policy_forecast_breach_seconds{autoscaling_group="asg",autoscaling_policy="asp"} -1`,
+		"no breach")
+
+	DeletePolicyForecast("asg", "asp")
+	rec = httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.NotContains(t, rec.Body.String(), `autoscaling_group="asg",autoscaling_policy="asp"`, "deleted")
+	assert.Contains(t, rec.Body.String(), `autoscaling_group="other",autoscaling_policy="asp"`,
+		"other AutoscalingGroups are not deleted")
+}
+
+func TestSetPolicyPollFailures(t *testing.T) {
+	defer DeletePolicyPollFailures("asg", "asp")
+
+	SetPolicyPollFailures("asg", "asp", 3)
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(t, rec.Body.String(),
+		`cerebral_autoscaling_policy_poll_consecutive_failures{autoscaling_group="asg",autoscaling_policy="asp"} 3`)
+
+	DeletePolicyPollFailures("asg", "asp")
+	rec = httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.NotContains(t, rec.Body.String(), `autoscaling_policy="asp"`, "deleted")
+}
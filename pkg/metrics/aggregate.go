@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// AggregationCluster divides the total across all nodes by the total
+	// allocatable across all nodes. Backends calculate it themselves since it
+	// can't be derived from the per-node values.
+	AggregationCluster = "cluster"
+	// AggregationPercentile selects the given percentile of the per-node values
+	AggregationPercentile = "percentile"
+)
+
+// validAggregations are the aggregations that can be used by backends
+// returning per-node utilization or allocation percentages
+var validAggregations = []string{
+	AggregationCluster,    // ratio of the totals across all nodes
+	"min",                 // minimum of the per-node values
+	"max",                 // maximum of the per-node values
+	"avg",                 // average of the per-node values
+	AggregationPercentile, // percentile of the per-node values
+}
+
+// ValidateAggregation returns an error if the aggregation is not valid or if
+// the percentile aggregation is used without a percentile within [0, 100]
+func ValidateAggregation(aggregation string, percentile *float64) error {
+	valid := false
+	for _, a := range validAggregations {
+		if a == aggregation {
+			valid = true
+			break
+		}
+	}
+
+	if !valid {
+		return errors.Errorf("invalid aggregation %s", aggregation)
+	}
+
+	if aggregation != AggregationPercentile {
+		return nil
+	}
+
+	if percentile == nil {
+		return errors.New("percentile must be specified for percentile aggregation")
+	}
+
+	if *percentile < 0 || *percentile > 100 {
+		return errors.Errorf("invalid percentile %f", *percentile)
+	}
+
+	return nil
+}
+
+// Aggregate reduces the per-node values using the given aggregation, which
+// must not be the cluster aggregation. The percentile is only used by the
+// percentile aggregation.
+func Aggregate(values []float64, aggregation string, percentile *float64) (float64, error) {
+	if len(values) == 0 {
+		return 0, errors.New("no values to aggregate")
+	}
+
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	switch aggregation {
+	case "min":
+		return sorted[0], nil
+
+	case "max":
+		return sorted[len(sorted)-1], nil
+
+	case "avg":
+		var sum float64
+		for _, v := range sorted {
+			sum += v
+		}
+		return sum / float64(len(sorted)), nil
+
+	case AggregationPercentile:
+		if percentile == nil {
+			return 0, errors.New("percentile must be specified for percentile aggregation")
+		}
+		return percentileOf(sorted, *percentile), nil
+	}
+
+	return 0, errors.Errorf("cannot aggregate per-node values using %s aggregation", aggregation)
+}
+
+// percentileOf returns the p-th percentile of the sorted values, linearly
+// interpolating between the closest ranks
+func percentileOf(sorted []float64, p float64) float64 {
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+
+	return sorted[lower] + (rank-float64(lower))*(sorted[upper]-sorted[lower])
+}
@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func float64Ptr(f float64) *float64 {
+	return &f
+}
+
+func TestValidateAggregation(t *testing.T) {
+	assert.NoError(t, ValidateAggregation("cluster", nil), "cluster aggregation")
+	assert.NoError(t, ValidateAggregation("max", nil), "per-node aggregation")
+	assert.NoError(t, ValidateAggregation("percentile", float64Ptr(90)), "percentile aggregation")
+
+	assert.Error(t, ValidateAggregation("", nil), "empty aggregation")
+	assert.Error(t, ValidateAggregation("median", nil), "invalid aggregation")
+	assert.Error(t, ValidateAggregation("percentile", nil), "percentile not provided")
+	assert.Error(t, ValidateAggregation("percentile", float64Ptr(101)), "percentile out of range")
+	assert.Error(t, ValidateAggregation("percentile", float64Ptr(-1)), "percentile out of range")
+}
+
+func TestAggregate(t *testing.T) {
+	values := []float64{20, 0, 10}
+
+	v, err := Aggregate(values, "min", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, v, "min")
+
+	v, err = Aggregate(values, "max", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 20.0, v, "max")
+
+	v, err = Aggregate(values, "avg", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 10.0, v, "avg")
+
+	v, err = Aggregate(values, "percentile", float64Ptr(75))
+	assert.NoError(t, err)
+	assert.Equal(t, 15.0, v, "percentile")
+	assert.Equal(t, []float64{20, 0, 10}, values, "values are not modified")
+
+	_, err = Aggregate(nil, "max", nil)
+	assert.Error(t, err, "no values to aggregate")
+
+	_, err = Aggregate(values, "percentile", nil)
+	assert.Error(t, err, "percentile not provided")
+
+	_, err = Aggregate(values, "cluster", nil)
+	assert.Error(t, err, "cluster aggregation is calculated by backends")
+}
+
+func TestPercentileOf(t *testing.T) {
+	sorted := []float64{10, 20, 30, 40, 50}
+	assert.Equal(t, float64(10), percentileOf(sorted, 0))
+	assert.Equal(t, float64(30), percentileOf(sorted, 50))
+	assert.Equal(t, float64(45), percentileOf(sorted, 87.5))
+	assert.Equal(t, float64(50), percentileOf(sorted, 100))
+
+	assert.Equal(t, float64(7), percentileOf([]float64{7}, 90), "single value")
+}
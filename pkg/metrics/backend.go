@@ -1,5 +1,27 @@
 package metrics
 
+import (
+	"time"
+)
+
+// AutoscalingGroupKey is the metric configuration key under which the name of
+// the AutoscalingGroup being polled is passed to backends. It's set by
+// Cerebral and should not be provided by users.
+const AutoscalingGroupKey = "cerebral.containership.io/autoscaling-group"
+
+// WithAutoscalingGroup returns a copy of the metric configuration with the
+// name of the AutoscalingGroup being polled added to it
+func WithAutoscalingGroup(configuration map[string]string, asgName string) map[string]string {
+	c := make(map[string]string, len(configuration)+1)
+	for k, v := range configuration {
+		c[k] = v
+	}
+
+	c[AutoscalingGroupKey] = asgName
+
+	return c
+}
+
 // A Backend is used to interface with a metrics backend.
 type Backend interface {
 	// GetValue queries the backend and returns the raw numerical value of the
@@ -7,3 +29,53 @@ type Backend interface {
 	// at this point in time.
 	GetValue(metric string, configuration map[string]string, nodeSelector map[string]string) (float64, error)
 }
+
+// An AutoscalingGroupScopedBackend is a Backend whose values may depend on the
+// AutoscalingGroup passed under AutoscalingGroupKey. The values of other
+// backends are assumed to be the same for every AutoscalingGroup, so
+// identical queries made on behalf of different AutoscalingGroups are cached
+// and deduplicated together.
+type AutoscalingGroupScopedBackend interface {
+	Backend
+
+	// UsesAutoscalingGroup returns true if the value of the metric with the
+	// given configuration may depend on the AutoscalingGroup being polled.
+	UsesAutoscalingGroup(metric string, configuration map[string]string) bool
+}
+
+// A RangeBackend is a Backend that is also able to query historical values of
+// a metric. Not all backends are able to support this, so callers should
+// check for this interface before relying on it.
+type RangeBackend interface {
+	Backend
+
+	// GetValueRange queries the backend and returns the raw numerical values
+	// of the requested metric (with the given configuration) for the given
+	// nodes between start and end, at the given resolution.
+	GetValueRange(metric string, configuration map[string]string, nodeSelector map[string]string,
+		start, end time.Time, step time.Duration) ([]Sample, error)
+}
+
+// wrapper is implemented by Backends that wrap another Backend
+type wrapper interface {
+	Unwrap() Backend
+}
+
+// Unwrap returns the Backend underneath any Backends wrapping it, e.g. for
+// caching, so that callers can inspect its concrete type
+func Unwrap(backend Backend) Backend {
+	for {
+		w, ok := backend.(wrapper)
+		if !ok {
+			return backend
+		}
+
+		backend = w.Unwrap()
+	}
+}
+
+// A Sample is the value of a metric at a point in time
+type Sample struct {
+	Timestamp time.Time
+	Value     float64
+}
@@ -0,0 +1,18 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithAutoscalingGroup(t *testing.T) {
+	c := WithAutoscalingGroup(nil, "asg")
+	assert.Equal(t, map[string]string{AutoscalingGroupKey: "asg"}, c, "nil configuration")
+
+	configuration := map[string]string{"query": "up"}
+	c = WithAutoscalingGroup(configuration, "asg")
+	assert.Equal(t, "up", c["query"], "configuration copied")
+	assert.Equal(t, "asg", c[AutoscalingGroupKey], "AutoscalingGroup added")
+	assert.NotContains(t, configuration, AutoscalingGroupKey, "original configuration not modified")
+}
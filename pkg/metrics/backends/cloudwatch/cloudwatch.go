@@ -0,0 +1,370 @@
+package cloudwatch
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	awscloudwatch "github.com/aws/aws-sdk-go/service/cloudwatch"
+
+	corelistersv1 "k8s.io/client-go/listers/core/v1"
+
+	"github.com/containership/cerebral/pkg/metrics"
+	"github.com/containership/cerebral/pkg/nodeutil"
+	"github.com/containership/cluster-manager/pkg/log"
+)
+
+// API is the subset of the CloudWatch API used by the backend
+type API interface {
+	GetMetricData(*awscloudwatch.GetMetricDataInput) (*awscloudwatch.GetMetricDataOutput, error)
+}
+
+// Backend implements a metrics backend for AWS CloudWatch. It requires a node
+// lister so that it can restrict queries to the EC2 instances backing the
+// nodes being queried. Nodes accessed via the lister must not be mutated.
+type Backend struct {
+	client API
+
+	nodeLister corelistersv1.NodeLister
+}
+
+// maxMetricDataQueries is the maximum number of queries allowed in a single
+// GetMetricData request
+const maxMetricDataQueries = 100
+
+// metricQuery is a metric to be queried for a single instance or, if the
+// instance ID is empty, for the entire metric
+type metricQuery struct {
+	instanceID string
+	metric     *awscloudwatch.Metric
+}
+
+// NewClient returns a new client for talking to CloudWatch in the given
+// region, or an error. If the region is empty, it's pulled from the
+// environment or the EC2 metadata service.
+func NewClient(region string, nodeLister corelistersv1.NodeLister) (metrics.Backend, error) {
+	if nodeLister == nil {
+		return nil, errors.New("node lister must be provided")
+	}
+
+	if region == "" {
+		region = getRegion()
+	}
+
+	// Note that aws-sdk-go pulls AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+	// directly from the environment
+	sess, err := session.NewSession(aws.NewConfig().WithRegion(region))
+	if err != nil {
+		return nil, errors.Wrap(err, "creating new AWS session")
+	}
+
+	return Backend{
+		client:     awscloudwatch.New(sess),
+		nodeLister: nodeLister,
+	}, nil
+}
+
+// GetValue implements the metrics.Backend interface
+func (b Backend) GetValue(metric string, configuration map[string]string, nodeSelector map[string]string) (float64, error) {
+	config, queries, err := b.buildQueries(metric, configuration, nodeSelector)
+	if err != nil {
+		return 0, err
+	}
+
+	end := time.Now()
+	results, err := b.performQuery(queries, config.period, config.Statistic, end.Add(-config.rangeDuration), end)
+	if err != nil {
+		return 0, err
+	}
+
+	// Datapoints are returned newest first, so use the latest datapoint of
+	// each metric
+	values := make([]float64, 0, len(results))
+	for i, result := range results {
+		if len(result.values) == 0 {
+			log.Debugf("CloudWatch metric %s has no datapoints for instance %q",
+				aws.StringValue(queries[i].metric.MetricName), queries[i].instanceID)
+			continue
+		}
+
+		values = append(values, result.values[0])
+	}
+
+	if len(values) == 0 {
+		return 0, errors.Errorf("no datapoints returned for metric %s", metric)
+	}
+
+	return metrics.Reduce(values, config.Aggregation)
+}
+
+// GetValueRange implements the metrics.RangeBackend interface
+func (b Backend) GetValueRange(metric string, configuration map[string]string, nodeSelector map[string]string,
+	start, end time.Time, step time.Duration) ([]metrics.Sample, error) {
+	config, queries, err := b.buildQueries(metric, configuration, nodeSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validatePeriod(step); err != nil {
+		return nil, errors.Wrap(err, "validating step")
+	}
+
+	results, err := b.performQuery(queries, step, config.Statistic, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	// Instances may not have datapoints at the same timestamps, so reduce the
+	// values present at each timestamp
+	var timestamps []time.Time
+	values := make(map[time.Time][]float64)
+	for _, result := range results {
+		for i, ts := range result.timestamps {
+			if _, ok := values[ts]; !ok {
+				timestamps = append(timestamps, ts)
+			}
+			values[ts] = append(values[ts], result.values[i])
+		}
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool {
+		return timestamps[i].Before(timestamps[j])
+	})
+
+	samples := make([]metrics.Sample, 0, len(timestamps))
+	for _, ts := range timestamps {
+		value, err := metrics.Reduce(values[ts], config.Aggregation)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reducing values at %s", ts)
+		}
+
+		samples = append(samples, metrics.Sample{
+			Timestamp: ts,
+			Value:     value,
+		})
+	}
+
+	return samples, nil
+}
+
+// buildQueries returns the validated configuration and the metrics to query
+// for the given metric across the instances backing the nodes matching the
+// node selector
+func (b Backend) buildQueries(metric string, configuration map[string]string,
+	nodeSelector map[string]string) (metricConfiguration, []metricQuery, error) {
+	config := metricConfiguration{}
+	if err := config.defaultAndValidate(configuration); err != nil {
+		return config, nil, errors.Wrap(err, "validating configuration")
+	}
+
+	switch metric {
+	case MetricCPUPercentUtilization.String():
+		config.Namespace = "AWS/EC2"
+		config.MetricName = "CPUUtilization"
+		config.InstanceDimension = instanceIDDimension
+
+	case MetricMemoryPercentUtilization.String():
+		// Published by the CloudWatch agent, not by EC2 itself
+		config.Namespace = "CWAgent"
+		config.MetricName = "mem_used_percent"
+		config.InstanceDimension = instanceIDDimension
+
+	case MetricCustom.String():
+		if config.Namespace == "" || config.MetricName == "" {
+			return config, nil, errors.New("configuration keys \"namespace\" and \"metricName\" must be provided for a custom metric")
+		}
+
+	default:
+		return config, nil, errors.Errorf("unknown metric %q", metric)
+	}
+
+	if config.InstanceDimension == "" {
+		return config, []metricQuery{
+			{metric: buildMetric(config, "")},
+		}, nil
+	}
+
+	instanceIDs, err := b.listInstanceIDs(nodeSelector)
+	if err != nil {
+		return config, nil, err
+	}
+
+	if len(instanceIDs) == 0 {
+		return config, nil, errors.Errorf("no nodes match node selector %v", nodeSelector)
+	}
+
+	queries := make([]metricQuery, len(instanceIDs))
+	for i, id := range instanceIDs {
+		queries[i] = metricQuery{
+			instanceID: id,
+			metric:     buildMetric(config, id),
+		}
+	}
+
+	return config, queries, nil
+}
+
+// listInstanceIDs returns the sorted EC2 instance IDs of the nodes matching
+// the node selector
+func (b Backend) listInstanceIDs(nodeSelector map[string]string) ([]string, error) {
+	selector := nodeutil.GetNodesLabelSelector(nodeSelector)
+	nodes, err := b.nodeLister.List(selector)
+	if err != nil {
+		return nil, errors.Wrap(err, "listing nodes")
+	}
+
+	instanceIDs := make([]string, len(nodes))
+	for i, node := range nodes {
+		if node.Spec.ProviderID == "" {
+			return nil, errors.Errorf("node %s does not have providerID available", node.ObjectMeta.Name)
+		}
+
+		instanceIDs[i] = instanceIDFromProviderID(node.Spec.ProviderID)
+	}
+
+	sort.Strings(instanceIDs)
+
+	return instanceIDs, nil
+}
+
+// buildMetric returns the CloudWatch metric for the configuration, with the
+// instance dimension set to the given instance ID if it's not empty
+func buildMetric(config metricConfiguration, instanceID string) *awscloudwatch.Metric {
+	names := make([]string, 0, len(config.Dimensions))
+	for name := range config.Dimensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var dimensions []*awscloudwatch.Dimension
+	for _, name := range names {
+		dimensions = append(dimensions, &awscloudwatch.Dimension{
+			Name:  aws.String(name),
+			Value: aws.String(config.Dimensions[name]),
+		})
+	}
+
+	if instanceID != "" {
+		dimensions = append(dimensions, &awscloudwatch.Dimension{
+			Name:  aws.String(config.InstanceDimension),
+			Value: aws.String(instanceID),
+		})
+	}
+
+	return &awscloudwatch.Metric{
+		Namespace:  aws.String(config.Namespace),
+		MetricName: aws.String(config.MetricName),
+		Dimensions: dimensions,
+	}
+}
+
+// queryResult contains the datapoints of a single metric, newest first
+type queryResult struct {
+	timestamps []time.Time
+	values     []float64
+}
+
+// performQuery gets the datapoints of each metric between start and end. The
+// results are in the same order as the queries.
+func (b Backend) performQuery(queries []metricQuery, period time.Duration, statistic string,
+	start, end time.Time) ([]queryResult, error) {
+	results := make([]queryResult, len(queries))
+
+	for offset := 0; offset < len(queries); offset += maxMetricDataQueries {
+		batch := queries[offset:]
+		if len(batch) > maxMetricDataQueries {
+			batch = batch[:maxMetricDataQueries]
+		}
+
+		dataQueries := make([]*awscloudwatch.MetricDataQuery, len(batch))
+		for i, q := range batch {
+			dataQueries[i] = &awscloudwatch.MetricDataQuery{
+				Id: aws.String(fmt.Sprintf("m%d", offset+i)),
+				MetricStat: &awscloudwatch.MetricStat{
+					Metric: q.metric,
+					Period: aws.Int64(int64(period / time.Second)),
+					Stat:   aws.String(statistic),
+				},
+				ReturnData: aws.Bool(true),
+			}
+		}
+
+		input := &awscloudwatch.GetMetricDataInput{
+			StartTime:         aws.Time(start),
+			EndTime:           aws.Time(end),
+			MetricDataQueries: dataQueries,
+			ScanBy:            aws.String(awscloudwatch.ScanByTimestampDescending),
+		}
+
+		for {
+			log.Debugf("Performing CloudWatch GetMetricData request with %d queries", len(dataQueries))
+
+			output, err := b.client.GetMetricData(input)
+			if err != nil {
+				return nil, errors.Wrap(err, "getting metric data from CloudWatch")
+			}
+
+			if output == nil {
+				return nil, errors.New("CloudWatch returned nil result for get metric data")
+			}
+
+			for _, r := range output.MetricDataResults {
+				if aws.StringValue(r.StatusCode) == awscloudwatch.StatusCodeInternalError {
+					return nil, errors.Errorf("CloudWatch returned an internal error for query %s",
+						aws.StringValue(r.Id))
+				}
+
+				var i int
+				if _, err := fmt.Sscanf(aws.StringValue(r.Id), "m%d", &i); err != nil || i < 0 || i >= len(results) {
+					return nil, errors.Errorf("CloudWatch returned result for unknown query %q", aws.StringValue(r.Id))
+				}
+
+				if len(r.Timestamps) != len(r.Values) {
+					return nil, errors.Errorf("CloudWatch returned %d timestamps but %d values for query %s",
+						len(r.Timestamps), len(r.Values), aws.StringValue(r.Id))
+				}
+
+				for j := range r.Values {
+					results[i].timestamps = append(results[i].timestamps, aws.TimeValue(r.Timestamps[j]))
+					results[i].values = append(results[i].values, aws.Float64Value(r.Values[j]))
+				}
+			}
+
+			if aws.StringValue(output.NextToken) == "" {
+				break
+			}
+
+			input.NextToken = output.NextToken
+		}
+	}
+
+	return results, nil
+}
+
+// Get the AWS instance ID from a provider ID.
+// This does not perform any validation; we'll just rely on AWS to validate it
+func instanceIDFromProviderID(providerID string) string {
+	fields := strings.Split(providerID, "/")
+	return fields[len(fields)-1]
+}
+
+// Get the current AWS region, either from the environment or from the EC2
+// metadata service if the env var is not set.
+// This function is borrowed from https://github.com/kubernetes/autoscaler/tree/master/cluster-autoscaler
+func getRegion(cfg ...*aws.Config) string {
+	region, present := os.LookupEnv("AWS_REGION")
+	if !present {
+		svc := ec2metadata.New(session.New(), cfg...)
+		if r, err := svc.Region(); err == nil {
+			region = r
+		}
+	}
+	return region
+}
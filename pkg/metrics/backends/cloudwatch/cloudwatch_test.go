@@ -0,0 +1,339 @@
+package cloudwatch
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awscloudwatch "github.com/aws/aws-sdk-go/service/cloudwatch"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/containership/cerebral/pkg/kubernetestest"
+	"github.com/containership/cerebral/pkg/metrics/backends/cloudwatch/mocks"
+)
+
+const providerID0 = "aws:///us-east-1a/i-0a2ade0106d44fd46"
+const providerID1 = "aws:///us-east-1b/i-01234567890123456"
+
+var (
+	node0 = corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "test-0",
+			Labels: map[string]string{"test": ""},
+		},
+		Spec: corev1.NodeSpec{
+			ProviderID: providerID0,
+		},
+	}
+
+	node1 = corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "test-1",
+			Labels: map[string]string{"test": ""},
+		},
+		Spec: corev1.NodeSpec{
+			ProviderID: providerID1,
+		},
+	}
+
+	nodeWithoutProviderID = corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "no-provider-id",
+			Labels: map[string]string{"no-provider-id": ""},
+		},
+	}
+
+	t0 = time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	t1 = t0.Add(time.Minute)
+)
+
+// result returns a metric data result for the given query with the given
+// datapoints, newest first
+func result(id string, timestamps []time.Time, values []float64) *awscloudwatch.MetricDataResult {
+	return &awscloudwatch.MetricDataResult{
+		Id:         aws.String(id),
+		StatusCode: aws.String(awscloudwatch.StatusCodeComplete),
+		Timestamps: aws.TimeSlice(timestamps),
+		Values:     aws.Float64Slice(values),
+	}
+}
+
+// queriesInstance returns a matcher for inputs whose nth query is for the
+// given instance
+func queriesInstance(n int, instanceID string) interface{} {
+	return mock.MatchedBy(func(input *awscloudwatch.GetMetricDataInput) bool {
+		if len(input.MetricDataQueries) <= n {
+			return false
+		}
+
+		for _, d := range input.MetricDataQueries[n].MetricStat.Metric.Dimensions {
+			if aws.StringValue(d.Name) == "InstanceId" && aws.StringValue(d.Value) == instanceID {
+				return true
+			}
+		}
+
+		return false
+	})
+}
+
+func TestNewClient(t *testing.T) {
+	_, err := NewClient("us-east-1", nil)
+	assert.Error(t, err, "error on nil NodeLister")
+
+	client, err := NewClient("us-east-1", kubernetestest.BuildNodeLister(nil))
+	assert.NoError(t, err)
+	assert.NotNil(t, client)
+}
+
+func TestGetValue(t *testing.T) {
+	mockAPI := mocks.API{}
+	b := Backend{
+		client:     &mockAPI,
+		nodeLister: kubernetestest.BuildNodeLister([]corev1.Node{node0, node1, nodeWithoutProviderID}),
+	}
+
+	mockAPI.On("GetMetricData", mock.MatchedBy(func(input *awscloudwatch.GetMetricDataInput) bool {
+		q := input.MetricDataQueries[0]
+		return len(input.MetricDataQueries) == 2 &&
+			aws.StringValue(q.MetricStat.Metric.Namespace) == "AWS/EC2" &&
+			aws.StringValue(q.MetricStat.Metric.MetricName) == "CPUUtilization" &&
+			aws.StringValue(q.MetricStat.Stat) == "Average" &&
+			aws.Int64Value(q.MetricStat.Period) == 300 &&
+			aws.TimeValue(input.EndTime).Sub(aws.TimeValue(input.StartTime)) == 10*time.Minute &&
+			aws.StringValue(input.ScanBy) == awscloudwatch.ScanByTimestampDescending
+	})).Return(&awscloudwatch.GetMetricDataOutput{
+		MetricDataResults: []*awscloudwatch.MetricDataResult{
+			result("m0", []time.Time{t1, t0}, []float64{20, 100}),
+			result("m1", []time.Time{t1, t0}, []float64{40, 100}),
+		},
+	}, nil).Once()
+
+	val, err := b.GetValue("cpu_percent_utilization", nil, map[string]string{"test": ""})
+	assert.NoError(t, err)
+	assert.Equal(t, 30.0, val, "latest datapoints are averaged across instances")
+
+	// Results may be split across pages and a result may have no datapoints
+	mockAPI.On("GetMetricData", mock.MatchedBy(func(input *awscloudwatch.GetMetricDataInput) bool {
+		return input.NextToken == nil &&
+			aws.StringValue(input.MetricDataQueries[0].MetricStat.Metric.Namespace) == "CWAgent"
+	})).Return(&awscloudwatch.GetMetricDataOutput{
+		MetricDataResults: []*awscloudwatch.MetricDataResult{
+			result("m0", nil, nil),
+		},
+		NextToken: aws.String("next"),
+	}, nil).Once()
+
+	mockAPI.On("GetMetricData", mock.MatchedBy(func(input *awscloudwatch.GetMetricDataInput) bool {
+		return aws.StringValue(input.NextToken) == "next"
+	})).Return(&awscloudwatch.GetMetricDataOutput{
+		MetricDataResults: []*awscloudwatch.MetricDataResult{
+			result("m1", []time.Time{t1}, []float64{75}),
+		},
+	}, nil).Once()
+
+	val, err = b.GetValue("memory_percent_utilization", map[string]string{"aggregation": "max"},
+		map[string]string{"test": ""})
+	assert.NoError(t, err)
+	assert.Equal(t, 75.0, val, "instances without datapoints are ignored")
+
+	// Custom metrics in arbitrary namespaces are not queried per instance
+	mockAPI.On("GetMetricData", mock.MatchedBy(func(input *awscloudwatch.GetMetricDataInput) bool {
+		q := input.MetricDataQueries[0]
+		return len(input.MetricDataQueries) == 1 &&
+			aws.StringValue(q.MetricStat.Metric.Namespace) == "AWS/SQS" &&
+			aws.StringValue(q.MetricStat.Metric.MetricName) == "ApproximateNumberOfMessagesVisible" &&
+			len(q.MetricStat.Metric.Dimensions) == 1 &&
+			aws.StringValue(q.MetricStat.Metric.Dimensions[0].Name) == "QueueName" &&
+			aws.StringValue(q.MetricStat.Metric.Dimensions[0].Value) == "jobs" &&
+			aws.StringValue(q.MetricStat.Stat) == "Maximum" &&
+			aws.Int64Value(q.MetricStat.Period) == 60
+	})).Return(&awscloudwatch.GetMetricDataOutput{
+		MetricDataResults: []*awscloudwatch.MetricDataResult{
+			result("m0", []time.Time{t1, t0}, []float64{1200, 800}),
+		},
+	}, nil).Once()
+
+	val, err = b.GetValue("custom", map[string]string{
+		"namespace":           "AWS/SQS",
+		"metricName":          "ApproximateNumberOfMessagesVisible",
+		"dimension.QueueName": "jobs",
+		"statistic":           "Maximum",
+		"period":              "1m",
+	}, map[string]string{"no-provider-id": ""})
+	assert.NoError(t, err)
+	assert.Equal(t, 1200.0, val, "latest datapoint is used")
+
+	mockAPI.On("GetMetricData", queriesInstance(0, "i-0a2ade0106d44fd46")).
+		Return(&awscloudwatch.GetMetricDataOutput{
+			MetricDataResults: []*awscloudwatch.MetricDataResult{
+				result("m0", []time.Time{t1}, []float64{5}),
+				result("m1", []time.Time{t1}, []float64{7}),
+			},
+		}, nil).Once()
+
+	val, err = b.GetValue("custom", map[string]string{
+		"namespace":         "ContainerInsights",
+		"metricName":        "node_network_total_bytes",
+		"instanceDimension": "InstanceId",
+		"aggregation":       "sum",
+	}, map[string]string{"test": ""})
+	assert.NoError(t, err)
+	assert.Equal(t, 12.0, val, "custom metrics may be queried per instance")
+
+	_, err = b.GetValue("custom", map[string]string{"namespace": "AWS/SQS"}, nil)
+	assert.Error(t, err, "custom metric requires metric name")
+
+	_, err = b.GetValue("cpu_percent_utilization", map[string]string{"statistic": "Mean"}, nil)
+	assert.Error(t, err, "invalid configuration")
+
+	_, err = b.GetValue("not a valid metric", nil, nil)
+	assert.Error(t, err, "unknown metric requested")
+
+	_, err = b.GetValue("cpu_percent_utilization", nil, map[string]string{"no-provider-id": ""})
+	assert.Error(t, err, "node without provider ID")
+
+	_, err = b.GetValue("cpu_percent_utilization", nil, map[string]string{"no": "match"})
+	assert.Error(t, err, "no nodes selected")
+
+	mockAPI.On("GetMetricData", mock.Anything).
+		Return(&awscloudwatch.GetMetricDataOutput{
+			MetricDataResults: []*awscloudwatch.MetricDataResult{
+				result("m0", nil, nil),
+				result("m1", nil, nil),
+			},
+		}, nil).Once()
+	_, err = b.GetValue("cpu_percent_utilization", nil, map[string]string{"test": ""})
+	assert.Error(t, err, "no datapoints")
+
+	mockAPI.On("GetMetricData", mock.Anything).
+		Return(&awscloudwatch.GetMetricDataOutput{
+			MetricDataResults: []*awscloudwatch.MetricDataResult{
+				{
+					Id:         aws.String("m0"),
+					StatusCode: aws.String(awscloudwatch.StatusCodeInternalError),
+				},
+			},
+		}, nil).Once()
+	_, err = b.GetValue("cpu_percent_utilization", nil, map[string]string{"test": ""})
+	assert.Error(t, err, "internal error status")
+
+	mockAPI.On("GetMetricData", mock.Anything).
+		Return(&awscloudwatch.GetMetricDataOutput{
+			MetricDataResults: []*awscloudwatch.MetricDataResult{
+				result("x", nil, nil),
+			},
+		}, nil).Once()
+	_, err = b.GetValue("cpu_percent_utilization", nil, map[string]string{"test": ""})
+	assert.Error(t, err, "unknown query ID")
+
+	mockAPI.On("GetMetricData", mock.Anything).Return(nil, nil).Once()
+	_, err = b.GetValue("cpu_percent_utilization", nil, map[string]string{"test": ""})
+	assert.Error(t, err, "nil output")
+
+	mockAPI.On("GetMetricData", mock.Anything).Return(nil, errors.New("some AWS error")).Once()
+	_, err = b.GetValue("cpu_percent_utilization", nil, map[string]string{"test": ""})
+	assert.Error(t, err, "error when AWS errors")
+
+	mockAPI.AssertExpectations(t)
+}
+
+func TestGetValueBatches(t *testing.T) {
+	nodes := make([]corev1.Node, maxMetricDataQueries+1)
+	for i := range nodes {
+		nodes[i] = corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("node-%03d", i)},
+			Spec:       corev1.NodeSpec{ProviderID: fmt.Sprintf("aws:///us-east-1a/i-%03d", i)},
+		}
+	}
+
+	mockAPI := mocks.API{}
+	b := Backend{
+		client:     &mockAPI,
+		nodeLister: kubernetestest.BuildNodeLister(nodes),
+	}
+
+	mockAPI.On("GetMetricData", mock.MatchedBy(func(input *awscloudwatch.GetMetricDataInput) bool {
+		return len(input.MetricDataQueries) == maxMetricDataQueries
+	})).Return(&awscloudwatch.GetMetricDataOutput{
+		MetricDataResults: []*awscloudwatch.MetricDataResult{
+			result("m0", []time.Time{t1}, []float64{10}),
+		},
+	}, nil).Once()
+
+	mockAPI.On("GetMetricData", mock.MatchedBy(func(input *awscloudwatch.GetMetricDataInput) bool {
+		return len(input.MetricDataQueries) == 1 &&
+			aws.StringValue(input.MetricDataQueries[0].Id) == fmt.Sprintf("m%d", maxMetricDataQueries)
+	})).Return(&awscloudwatch.GetMetricDataOutput{
+		MetricDataResults: []*awscloudwatch.MetricDataResult{
+			result(fmt.Sprintf("m%d", maxMetricDataQueries), []time.Time{t1}, []float64{20}),
+		},
+	}, nil).Once()
+
+	val, err := b.GetValue("cpu_percent_utilization", map[string]string{"aggregation": "sum"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 30.0, val, "queries are batched")
+	mockAPI.AssertExpectations(t)
+}
+
+func TestGetValueRange(t *testing.T) {
+	mockAPI := mocks.API{}
+	b := Backend{
+		client:     &mockAPI,
+		nodeLister: kubernetestest.BuildNodeLister([]corev1.Node{node0, node1}),
+	}
+
+	mockAPI.On("GetMetricData", mock.MatchedBy(func(input *awscloudwatch.GetMetricDataInput) bool {
+		return aws.Int64Value(input.MetricDataQueries[0].MetricStat.Period) == 60 &&
+			aws.TimeValue(input.StartTime).Equal(t0) &&
+			aws.TimeValue(input.EndTime).Equal(t1)
+	})).Return(&awscloudwatch.GetMetricDataOutput{
+		MetricDataResults: []*awscloudwatch.MetricDataResult{
+			result("m0", []time.Time{t1, t0}, []float64{20, 10}),
+			result("m1", []time.Time{t1}, []float64{40}),
+		},
+	}, nil).Once()
+
+	samples, err := b.GetValueRange("cpu_percent_utilization", nil, nil, t0, t1, time.Minute)
+	assert.NoError(t, err)
+	if assert.Len(t, samples, 2) {
+		assert.Equal(t, t0, samples[0].Timestamp, "samples are sorted oldest first")
+		assert.Equal(t, 10.0, samples[0].Value, "values present at each timestamp are reduced")
+		assert.Equal(t, t1, samples[1].Timestamp)
+		assert.Equal(t, 30.0, samples[1].Value)
+	}
+
+	_, err = b.GetValueRange("cpu_percent_utilization", nil, nil, t0, t1, 90*time.Second)
+	assert.Error(t, err, "invalid step")
+
+	_, err = b.GetValueRange("not a valid metric", nil, nil, t0, t1, time.Minute)
+	assert.Error(t, err, "unknown metric requested")
+
+	mockAPI.On("GetMetricData", mock.Anything).Return(nil, errors.New("some AWS error")).Once()
+	_, err = b.GetValueRange("cpu_percent_utilization", nil, nil, t0, t1, time.Minute)
+	assert.Error(t, err, "error when AWS errors")
+
+	mockAPI.AssertExpectations(t)
+}
+
+func TestInstanceIDFromProviderID(t *testing.T) {
+	instanceID := instanceIDFromProviderID(providerID0)
+	assert.Equal(t, "i-0a2ade0106d44fd46", instanceID)
+}
+
+func TestGetRegion(t *testing.T) {
+	expected := "us-east-1"
+
+	os.Setenv("AWS_REGION", expected)
+	defer os.Unsetenv("AWS_REGION")
+
+	region := getRegion()
+	assert.Equal(t, expected, region, "AWS region pulled from env if set")
+}
@@ -0,0 +1,200 @@
+package cloudwatch
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/containership/cerebral/pkg/metrics"
+)
+
+// Metric is a metric exposed by this backend
+type Metric int
+
+const (
+	// MetricCPUPercentUtilization is used to gather info about the CPU usage of nodes
+	MetricCPUPercentUtilization Metric = iota
+	// MetricMemoryPercentUtilization is used to gather info about the memory usage of nodes
+	MetricMemoryPercentUtilization
+	// MetricCustom is used to query an arbitrary CloudWatch metric
+	MetricCustom
+)
+
+// String is a stringer for Metric
+func (m Metric) String() string {
+	switch m {
+	case MetricCPUPercentUtilization:
+		return "cpu_percent_utilization"
+	case MetricMemoryPercentUtilization:
+		return "memory_percent_utilization"
+	case MetricCustom:
+		return "custom"
+	}
+
+	return "unknown"
+}
+
+// dimensionKeyPrefix is the prefix of configuration keys specifying metric
+// dimensions for custom metrics, e.g. dimension.QueueName
+const dimensionKeyPrefix = "dimension."
+
+// instanceIDDimension is the dimension containing the EC2 instance ID for the
+// metrics published by EC2 and the CloudWatch agent
+const instanceIDDimension = "InstanceId"
+
+// See https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/cloudwatch_concepts.html#Statistic
+var validStatistics = []string{
+	"Average",
+	"Sum",
+	"Minimum",
+	"Maximum",
+	"SampleCount",
+}
+
+// Percentile statistics, e.g. p99 or p99.9
+var validPercentileRegex = regexp.MustCompile(`^p\d{1,2}(\.\d+)?$`)
+
+const defaultStatistic = "Average"
+const defaultPeriod = "5m"
+const defaultRange = "10m"
+const defaultAggregation = "avg"
+
+type metricConfiguration struct {
+	// -- Generic
+	// Statistic is the CloudWatch statistic of each metric
+	Statistic string `json:"statistic"`
+	// Period is the granularity of the datapoints returned
+	Period string `json:"period"`
+	// Range is how far back datapoints are requested
+	Range string `json:"range"`
+	// Aggregation reduces the values of all instances to a single value
+	Aggregation string `json:"aggregation"`
+
+	// -- Custom
+	Namespace  string `json:"namespace"`
+	MetricName string `json:"metricName"`
+	// InstanceDimension is the dimension to query each selected instance by.
+	// If empty, the metric is queried once rather than per instance.
+	InstanceDimension string `json:"instanceDimension"`
+	// Dimensions are parsed from the dimension.<name> configuration keys
+	Dimensions map[string]string `json:"-"`
+
+	period        time.Duration
+	rangeDuration time.Duration
+}
+
+// defaults and validates the metricConfiguration. Intended to be called with an
+// empty struct that we'll fill in here using the caller-provided configuration.
+func (c *metricConfiguration) defaultAndValidate(configuration map[string]string) error {
+	// Round trip the config through JSON parser to populate our struct
+	j, _ := json.Marshal(configuration)
+	json.Unmarshal(j, c)
+
+	if err := c.defaultAndValidateStatistic(); err != nil {
+		return err
+	}
+
+	if err := c.defaultAndValidatePeriodAndRange(); err != nil {
+		return err
+	}
+
+	if err := c.defaultAndValidateAggregation(); err != nil {
+		return err
+	}
+
+	for k, v := range configuration {
+		if !strings.HasPrefix(k, dimensionKeyPrefix) {
+			continue
+		}
+
+		name := strings.TrimPrefix(k, dimensionKeyPrefix)
+		if name == "" {
+			return errors.Errorf("dimension name missing from configuration key %q", k)
+		}
+
+		if c.Dimensions == nil {
+			c.Dimensions = make(map[string]string)
+		}
+		c.Dimensions[name] = v
+	}
+
+	return nil
+}
+
+func (c *metricConfiguration) defaultAndValidateStatistic() error {
+	if c.Statistic == "" {
+		c.Statistic = defaultStatistic
+	}
+
+	for _, s := range validStatistics {
+		if s == c.Statistic {
+			return nil
+		}
+	}
+
+	if validPercentileRegex.MatchString(c.Statistic) {
+		return nil
+	}
+
+	return errors.Errorf("invalid statistic %s", c.Statistic)
+}
+
+func (c *metricConfiguration) defaultAndValidatePeriodAndRange() error {
+	if c.Period == "" {
+		c.Period = defaultPeriod
+	}
+
+	period, err := time.ParseDuration(c.Period)
+	if err != nil {
+		return errors.Errorf("invalid period %s", c.Period)
+	}
+
+	if err := validatePeriod(period); err != nil {
+		return err
+	}
+
+	if c.Range == "" {
+		c.Range = defaultRange
+	}
+
+	rangeDuration, err := time.ParseDuration(c.Range)
+	if err != nil {
+		return errors.Errorf("invalid range %s", c.Range)
+	}
+
+	if rangeDuration < period {
+		return errors.Errorf("range %s must be at least the period %s", c.Range, c.Period)
+	}
+
+	c.period = period
+	c.rangeDuration = rangeDuration
+
+	return nil
+}
+
+func (c *metricConfiguration) defaultAndValidateAggregation() error {
+	if c.Aggregation == "" {
+		c.Aggregation = defaultAggregation
+	}
+
+	return metrics.ValidateReducer(c.Aggregation)
+}
+
+// validatePeriod returns an error if the period is not supported by
+// CloudWatch, which only supports high resolution periods of 1, 5, 10, or 30
+// seconds and otherwise requires a multiple of 60 seconds
+func validatePeriod(period time.Duration) error {
+	if period%time.Second == 0 {
+		switch s := int64(period / time.Second); {
+		case s == 1, s == 5, s == 10, s == 30:
+			return nil
+		case s > 0 && s%60 == 0:
+			return nil
+		}
+	}
+
+	return errors.Errorf("invalid period %s, must be 1s, 5s, 10s, 30s, or a multiple of 60s", period)
+}
@@ -0,0 +1,85 @@
+package cloudwatch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricString(t *testing.T) {
+	assert.Equal(t, "cpu_percent_utilization", MetricCPUPercentUtilization.String())
+	assert.Equal(t, "memory_percent_utilization", MetricMemoryPercentUtilization.String())
+	assert.Equal(t, "custom", MetricCustom.String())
+	assert.Equal(t, "unknown", Metric(-1).String())
+}
+
+func TestDefaultAndValidate(t *testing.T) {
+	c := metricConfiguration{}
+	err := c.defaultAndValidate(nil)
+	assert.NoError(t, err, "nil config provided is ok")
+	assert.Equal(t, defaultStatistic, c.Statistic, "statistic defaulted")
+	assert.Equal(t, 5*time.Minute, c.period, "period defaulted")
+	assert.Equal(t, 10*time.Minute, c.rangeDuration, "range defaulted")
+	assert.Equal(t, defaultAggregation, c.Aggregation, "aggregation defaulted")
+	assert.Nil(t, c.Dimensions, "no dimensions by default")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{
+		"statistic":             "p99.9",
+		"period":                "1m",
+		"range":                 "5m",
+		"aggregation":           "max",
+		"namespace":             "AWS/SQS",
+		"metricName":            "ApproximateNumberOfMessagesVisible",
+		"dimension.QueueName":   "jobs",
+		"dimension.Environment": "prod",
+	})
+	assert.NoError(t, err, "good config")
+	assert.Equal(t, "p99.9", c.Statistic, "percentile statistic")
+	assert.Equal(t, time.Minute, c.period, "period not defaulted if provided")
+	assert.Equal(t, 5*time.Minute, c.rangeDuration, "range not defaulted if provided")
+	assert.Equal(t, "max", c.Aggregation, "aggregation not defaulted if provided")
+	assert.Equal(t, "AWS/SQS", c.Namespace)
+	assert.Equal(t, "ApproximateNumberOfMessagesVisible", c.MetricName)
+	assert.Equal(t, map[string]string{"QueueName": "jobs", "Environment": "prod"}, c.Dimensions, "dimensions parsed")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{"statistic": "Mean"})
+	assert.Error(t, err, "bad statistic")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{"period": "90s"})
+	assert.Error(t, err, "period not a multiple of 60s")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{"period": "BADBADNOTGOOD"})
+	assert.Error(t, err, "bad period")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{"range": "BADBADNOTGOOD"})
+	assert.Error(t, err, "bad range")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{"period": "5m", "range": "1m"})
+	assert.Error(t, err, "range less than period")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{"aggregation": "median"})
+	assert.Error(t, err, "bad aggregation")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{"dimension.": "value"})
+	assert.Error(t, err, "missing dimension name")
+}
+
+func TestValidatePeriod(t *testing.T) {
+	for _, p := range []time.Duration{time.Second, 5 * time.Second, 10 * time.Second, 30 * time.Second,
+		time.Minute, 5 * time.Minute, time.Hour} {
+		assert.NoError(t, validatePeriod(p), "valid period %s", p)
+	}
+
+	for _, p := range []time.Duration{0, 2 * time.Second, 90 * time.Second, 1500 * time.Millisecond, -time.Minute} {
+		assert.Error(t, validatePeriod(p), "invalid period %s", p)
+	}
+}
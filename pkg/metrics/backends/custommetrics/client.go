@@ -0,0 +1,124 @@
+package custommetrics
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// The custom metrics API served by adapters such as prometheus-adapter
+	customMetricsAPIPath = "/apis/custom.metrics.k8s.io/v1beta1"
+	// The external metrics API served by adapters such as KEDA or the
+	// Datadog cluster agent
+	externalMetricsAPIPath = "/apis/external.metrics.k8s.io/v1beta1"
+)
+
+// MetricValue is the value of a metric describing a single object as
+// reported by the custom metrics API
+type MetricValue struct {
+	DescribedObject corev1.ObjectReference `json:"describedObject"`
+	MetricName      string                 `json:"metricName"`
+	Timestamp       metav1.Time            `json:"timestamp"`
+	Value           resource.Quantity      `json:"value"`
+}
+
+// MetricValueList is a list of MetricValues
+type MetricValueList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []MetricValue `json:"items"`
+}
+
+// ExternalMetricValue is the value of a metric not associated with any
+// Kubernetes object as reported by the external metrics API
+type ExternalMetricValue struct {
+	MetricName   string            `json:"metricName"`
+	MetricLabels map[string]string `json:"metricLabels"`
+	Timestamp    metav1.Time       `json:"timestamp"`
+	Value        resource.Quantity `json:"value"`
+}
+
+// ExternalMetricValueList is a list of ExternalMetricValues
+type ExternalMetricValueList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []ExternalMetricValue `json:"items"`
+}
+
+// Client gets metric values from the custom and external metrics APIs
+type Client interface {
+	// GetObjectMetrics returns the metric for the named object of the given
+	// group resource (e.g. "nodes" or "deployments.apps"). The name may be
+	// "*" to get the metric for all objects matching the selector. An empty
+	// namespace is used for cluster-scoped objects.
+	GetObjectMetrics(groupResource, namespace, name, metricName string, selector labels.Selector) ([]MetricValue, error)
+	// GetExternalMetrics returns all series of the external metric in the
+	// namespace whose labels match the selector
+	GetExternalMetrics(namespace, metricName string, selector labels.Selector) ([]ExternalMetricValue, error)
+}
+
+type restClient struct {
+	client rest.Interface
+}
+
+// NewRESTClient returns a Client that requests the metrics APIs using the
+// given REST client, which must be configured for the API server root (such
+// as the discovery REST client)
+func NewRESTClient(client rest.Interface) Client {
+	return restClient{
+		client: client,
+	}
+}
+
+// GetObjectMetrics implements the Client interface
+func (c restClient) GetObjectMetrics(groupResource, namespace, name, metricName string,
+	selector labels.Selector) ([]MetricValue, error) {
+	segments := []string{customMetricsAPIPath}
+	if namespace != "" {
+		segments = append(segments, "namespaces", namespace)
+	}
+	segments = append(segments, groupResource, name, metricName)
+
+	body, err := c.client.Get().
+		AbsPath(segments...).
+		Param("labelSelector", selector.String()).
+		DoRaw()
+	if err != nil {
+		return nil, errors.Wrapf(err, "requesting custom metric %q", metricName)
+	}
+
+	var list MetricValueList
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, errors.Wrapf(err, "decoding custom metric %q", metricName)
+	}
+
+	return list.Items, nil
+}
+
+// GetExternalMetrics implements the Client interface
+func (c restClient) GetExternalMetrics(namespace, metricName string,
+	selector labels.Selector) ([]ExternalMetricValue, error) {
+	body, err := c.client.Get().
+		AbsPath(externalMetricsAPIPath, "namespaces", namespace, metricName).
+		Param("labelSelector", selector.String()).
+		DoRaw()
+	if err != nil {
+		return nil, errors.Wrapf(err, "requesting external metric %q", metricName)
+	}
+
+	var list ExternalMetricValueList
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, errors.Wrapf(err, "decoding external metric %q", metricName)
+	}
+
+	return list.Items, nil
+}
@@ -0,0 +1,94 @@
+package custommetrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+)
+
+const objectMetricsResponse = `{
+  "kind": "MetricValueList",
+  "apiVersion": "custom.metrics.k8s.io/v1beta1",
+  "metadata": {},
+  "items": [
+    {
+      "describedObject": {"kind": "Service", "namespace": "web", "name": "frontend", "apiVersion": "/v1"},
+      "metricName": "requests_per_second",
+      "timestamp": "2019-03-06T09:30:00Z",
+      "value": "1500m"
+    }
+  ]
+}`
+
+const externalMetricsResponse = `{
+  "kind": "ExternalMetricValueList",
+  "apiVersion": "external.metrics.k8s.io/v1beta1",
+  "metadata": {},
+  "items": [
+    {
+      "metricName": "queue_messages_ready",
+      "metricLabels": {"queue": "jobs"},
+      "timestamp": "2019-03-06T09:30:00Z",
+      "value": "42"
+    }
+  ]
+}`
+
+func buildRESTClient(t *testing.T, handler http.HandlerFunc) (Client, func()) {
+	server := httptest.NewServer(handler)
+
+	client, err := rest.UnversionedRESTClientFor(&rest.Config{
+		Host: server.URL,
+		ContentConfig: rest.ContentConfig{
+			NegotiatedSerializer: scheme.Codecs,
+		},
+	})
+	assert.NoError(t, err)
+
+	return NewRESTClient(client), server.Close
+}
+
+func TestRESTClient(t *testing.T) {
+	client, cleanup := buildRESTClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/apis/custom.metrics.k8s.io/v1beta1/namespaces/web/services/*/requests_per_second":
+			if r.URL.Query().Get("labelSelector") != "tier=frontend" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(objectMetricsResponse))
+		case "/apis/custom.metrics.k8s.io/v1beta1/nodes/*/node_load1":
+			w.Write([]byte(`{"items": []}`))
+		case "/apis/external.metrics.k8s.io/v1beta1/namespaces/default/queue_messages_ready":
+			w.Write([]byte(externalMetricsResponse))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	defer cleanup()
+
+	selector, _ := labels.Parse("tier=frontend")
+	objectValues, err := client.GetObjectMetrics("services", "web", "*", "requests_per_second", selector)
+	assert.NoError(t, err)
+	assert.Len(t, objectValues, 1)
+	assert.Equal(t, "frontend", objectValues[0].DescribedObject.Name)
+	assert.Equal(t, int64(1500), objectValues[0].Value.MilliValue())
+
+	nodeValues, err := client.GetObjectMetrics("nodes", "", "*", "node_load1", labels.Everything())
+	assert.NoError(t, err, "cluster-scoped objects")
+	assert.Empty(t, nodeValues)
+
+	externalValues, err := client.GetExternalMetrics("default", "queue_messages_ready", labels.Everything())
+	assert.NoError(t, err)
+	assert.Len(t, externalValues, 1)
+	assert.Equal(t, int64(42), externalValues[0].Value.Value())
+
+	_, err = client.GetExternalMetrics("default", "does_not_exist", labels.Everything())
+	assert.Error(t, err, "metric not found")
+}
@@ -0,0 +1,127 @@
+package custommetrics
+
+import (
+	"github.com/pkg/errors"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/containership/cerebral/pkg/metrics"
+	"github.com/containership/cerebral/pkg/nodeutil"
+	"github.com/containership/cluster-manager/pkg/log"
+)
+
+// Backend implements a metrics backend for the Kubernetes custom and external
+// metrics APIs (custom.metrics.k8s.io and external.metrics.k8s.io), which are
+// served by metrics adapters such as prometheus-adapter, KEDA, or the Datadog
+// cluster agent.
+type Backend struct {
+	client Client
+}
+
+// NewClient returns a new client for talking to a custom metrics Backend, or an error
+func NewClient(client Client) (metrics.Backend, error) {
+	if client == nil {
+		return nil, errors.New("metrics API client must be provided")
+	}
+
+	return Backend{
+		client: client,
+	}, nil
+}
+
+// GetValue implements the metrics.Backend interface
+func (b Backend) GetValue(metric string, configuration map[string]string, nodeSelector map[string]string) (float64, error) {
+	switch metric {
+	case MetricNode.String(), MetricObject.String(), MetricExternal.String():
+	default:
+		return 0, errors.Errorf("unknown metric %q", metric)
+	}
+
+	config := metricConfiguration{}
+	if err := config.defaultAndValidate(metric, configuration); err != nil {
+		return 0, errors.Wrapf(err, "validating configuration for metric %s", metric)
+	}
+
+	var quantities []resource.Quantity
+	switch metric {
+	case MetricNode.String():
+		// Nodes are selected by the autoscaling group rather than the
+		// configured selector
+		selector := nodeutil.GetNodesLabelSelector(nodeSelector)
+		values, err := b.client.GetObjectMetrics("nodes", "", "*", config.MetricName, selector)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, v := range values {
+			quantities = append(quantities, v.Value)
+		}
+
+	case MetricObject.String():
+		values, err := b.client.GetObjectMetrics(config.Resource, config.Namespace, config.Name,
+			config.MetricName, config.selector)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, v := range values {
+			quantities = append(quantities, v.Value)
+		}
+
+	case MetricExternal.String():
+		values, err := b.client.GetExternalMetrics(config.Namespace, config.MetricName, config.selector)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, v := range values {
+			quantities = append(quantities, v.Value)
+		}
+	}
+
+	if len(quantities) == 0 {
+		return 0, errors.Errorf("no values returned for metric %q", config.MetricName)
+	}
+
+	log.Debugf("Aggregating %d values of metric %q using %s", len(quantities), config.MetricName, config.Aggregation)
+
+	return aggregate(config.Aggregation, quantities), nil
+}
+
+// aggregate reduces the quantities using the given aggregation. There must be
+// at least one quantity.
+func aggregate(aggregation string, quantities []resource.Quantity) float64 {
+	values := make([]float64, len(quantities))
+	for i, q := range quantities {
+		// Use milli-units so that fractional values (e.g. 500m) aren't lost
+		values[i] = float64(q.MilliValue()) / 1000
+	}
+
+	result := values[0]
+	switch aggregation {
+	case "min":
+		for _, v := range values[1:] {
+			if v < result {
+				result = v
+			}
+		}
+
+	case "max":
+		for _, v := range values[1:] {
+			if v > result {
+				result = v
+			}
+		}
+
+	case "sum", "avg":
+		for _, v := range values[1:] {
+			result += v
+		}
+
+		if aggregation == "avg" {
+			result /= float64(len(values))
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,163 @@
+package custommetrics
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+type objectMetricsRequest struct {
+	groupResource string
+	namespace     string
+	name          string
+	metricName    string
+	selector      string
+}
+
+type externalMetricsRequest struct {
+	namespace  string
+	metricName string
+	selector   string
+}
+
+type fakeClient struct {
+	objectValues   []MetricValue
+	externalValues []ExternalMetricValue
+	err            error
+
+	objectRequests   []objectMetricsRequest
+	externalRequests []externalMetricsRequest
+}
+
+func (c *fakeClient) GetObjectMetrics(groupResource, namespace, name, metricName string,
+	selector labels.Selector) ([]MetricValue, error) {
+	c.objectRequests = append(c.objectRequests, objectMetricsRequest{
+		groupResource, namespace, name, metricName, selector.String(),
+	})
+	return c.objectValues, c.err
+}
+
+func (c *fakeClient) GetExternalMetrics(namespace, metricName string,
+	selector labels.Selector) ([]ExternalMetricValue, error) {
+	c.externalRequests = append(c.externalRequests, externalMetricsRequest{
+		namespace, metricName, selector.String(),
+	})
+	return c.externalValues, c.err
+}
+
+func buildMetricValues(values ...string) []MetricValue {
+	var result []MetricValue
+	for _, v := range values {
+		result = append(result, MetricValue{Value: resource.MustParse(v)})
+	}
+	return result
+}
+
+func TestNewClient(t *testing.T) {
+	_, err := NewClient(nil)
+	assert.Error(t, err, "client required")
+
+	_, err = NewClient(&fakeClient{})
+	assert.NoError(t, err)
+}
+
+func TestGetValueNode(t *testing.T) {
+	client := &fakeClient{
+		objectValues: buildMetricValues("10", "20", "60"),
+	}
+	backend := Backend{client: client}
+
+	value, err := backend.GetValue("node_metric", map[string]string{
+		"metricName": "node_load1",
+	}, map[string]string{"pool": "workers"})
+	assert.NoError(t, err)
+	assert.Equal(t, float64(30), value, "node values averaged by default")
+	assert.Equal(t, objectMetricsRequest{"nodes", "", "*", "node_load1", "pool=workers"},
+		client.objectRequests[0], "nodes selected by node selector")
+
+	value, err = backend.GetValue("node_metric", map[string]string{
+		"metricName":  "node_load1",
+		"aggregation": "max",
+	}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(60), value, "max of node values")
+}
+
+func TestGetValueObject(t *testing.T) {
+	client := &fakeClient{
+		objectValues: buildMetricValues("1500m", "500m"),
+	}
+	backend := Backend{client: client}
+
+	value, err := backend.GetValue("object_metric", map[string]string{
+		"metricName": "requests_per_second",
+		"resource":   "services",
+		"namespace":  "web",
+		"name":       "*",
+		"selector":   "tier=frontend",
+	}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(2), value, "object values summed by default")
+	assert.Equal(t, objectMetricsRequest{"services", "web", "*", "requests_per_second", "tier=frontend"},
+		client.objectRequests[0])
+
+	_, err = backend.GetValue("object_metric", map[string]string{
+		"metricName": "requests_per_second",
+		"name":       "*",
+	}, nil)
+	assert.Error(t, err, "resource required")
+}
+
+func TestGetValueExternal(t *testing.T) {
+	client := &fakeClient{
+		externalValues: []ExternalMetricValue{
+			{Value: resource.MustParse("42")},
+		},
+	}
+	backend := Backend{client: client}
+
+	value, err := backend.GetValue("external_metric", map[string]string{
+		"metricName": "queue_messages_ready",
+		"selector":   "queue=jobs",
+	}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(42), value)
+	assert.Equal(t, externalMetricsRequest{defaultNamespace, "queue_messages_ready", "queue=jobs"},
+		client.externalRequests[0], "namespace defaulted")
+
+	client.externalValues = nil
+	_, err = backend.GetValue("external_metric", map[string]string{
+		"metricName": "queue_messages_ready",
+	}, nil)
+	assert.Error(t, err, "no values returned")
+
+	client.err = errors.New("adapter unavailable")
+	_, err = backend.GetValue("external_metric", map[string]string{
+		"metricName": "queue_messages_ready",
+	}, nil)
+	assert.Error(t, err, "metrics API error")
+}
+
+func TestGetValueUnknownMetric(t *testing.T) {
+	backend := Backend{client: &fakeClient{}}
+
+	_, err := backend.GetValue("not a valid metric", nil, nil)
+	assert.Error(t, err, "unknown metric requested")
+}
+
+func TestAggregate(t *testing.T) {
+	quantities := []resource.Quantity{
+		resource.MustParse("250m"),
+		resource.MustParse("1"),
+		resource.MustParse("2750m"),
+	}
+
+	assert.Equal(t, float64(4), aggregate("sum", quantities))
+	assert.Equal(t, float64(0.25), aggregate("min", quantities))
+	assert.Equal(t, float64(2.75), aggregate("max", quantities))
+	assert.Equal(t, float64(4)/3, aggregate("avg", quantities))
+}
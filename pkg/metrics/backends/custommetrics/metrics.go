@@ -0,0 +1,132 @@
+package custommetrics
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Metric is a metric exposed by this backend
+type Metric int
+
+const (
+	// MetricNode is used to gather a custom metric describing each node
+	MetricNode Metric = iota
+	// MetricObject is used to gather a custom metric describing any object
+	MetricObject
+	// MetricExternal is used to gather an external metric
+	MetricExternal
+)
+
+// String is a stringer for Metric
+func (m Metric) String() string {
+	switch m {
+	case MetricNode:
+		return "node_metric"
+	case MetricObject:
+		return "object_metric"
+	case MetricExternal:
+		return "external_metric"
+	}
+
+	return "unknown"
+}
+
+var validAggregations = []string{
+	"sum", // sum of the values
+	"min", // minimum of the values
+	"max", // maximum of the values
+	"avg", // average of the values
+}
+
+// Nodes are typically compared to a per-node threshold, whereas objects and
+// external metrics are combined like the HorizontalPodAutoscaler does
+const defaultNodeAggregation = "avg"
+const defaultAggregation = "sum"
+
+const defaultNamespace = "default"
+
+// TODO consider splitting into multiple types instead of overloading this
+// single struct and ignoring irrelevant fields
+type metricConfiguration struct {
+	// -- Generic
+	MetricName  string `json:"metricName"`
+	Selector    string `json:"selector"`
+	Aggregation string `json:"aggregation"`
+
+	// -- Object
+	// Resource is the plural group resource of the described object, e.g.
+	// "services" or "deployments.apps"
+	Resource string `json:"resource"`
+	// Name is the name of the described object, or "*" for all objects
+	// matching the selector
+	Name string `json:"name"`
+
+	// -- Object and external
+	// Namespace is the namespace of the described object, or empty for
+	// cluster-scoped objects. It is required for external metrics.
+	Namespace string `json:"namespace"`
+
+	// -- Not user-specifiable
+	selector labels.Selector
+}
+
+// defaults and validates the metricConfiguration for the metric. Intended to
+// be called with an empty struct that we'll fill in here using the
+// caller-provided configuration.
+func (c *metricConfiguration) defaultAndValidate(metric string, configuration map[string]string) error {
+	// Round trip the config through JSON parser to populate our struct
+	j, _ := json.Marshal(configuration)
+	json.Unmarshal(j, c)
+
+	if c.MetricName == "" {
+		return errors.New("metricName must be provided")
+	}
+
+	selector, err := labels.Parse(c.Selector)
+	if err != nil {
+		return errors.Wrapf(err, "invalid selector %q", c.Selector)
+	}
+	c.selector = selector
+
+	if err := c.defaultAndValidateAggregation(metric); err != nil {
+		return err
+	}
+
+	switch metric {
+	case MetricObject.String():
+		if c.Resource == "" {
+			return errors.New("resource must be provided")
+		}
+
+		if c.Name == "" {
+			return errors.New("name must be provided")
+		}
+
+	case MetricExternal.String():
+		if c.Namespace == "" {
+			c.Namespace = defaultNamespace
+		}
+	}
+
+	return nil
+}
+
+func (c *metricConfiguration) defaultAndValidateAggregation(metric string) error {
+	if c.Aggregation == "" {
+		c.Aggregation = defaultAggregation
+		if metric == MetricNode.String() {
+			c.Aggregation = defaultNodeAggregation
+		}
+	}
+
+	for _, a := range validAggregations {
+		if a == c.Aggregation {
+			return nil
+		}
+	}
+
+	return errors.Errorf("invalid aggregation %s", c.Aggregation)
+}
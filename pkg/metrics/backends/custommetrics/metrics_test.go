@@ -0,0 +1,58 @@
+package custommetrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultAndValidate(t *testing.T) {
+	c := metricConfiguration{}
+	err := c.defaultAndValidate("node_metric", nil)
+	assert.Error(t, err, "metricName required")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate("node_metric", map[string]string{
+		"metricName": "node_load1",
+	})
+	assert.NoError(t, err, "good node config")
+	assert.Equal(t, defaultNodeAggregation, c.Aggregation, "node aggregation defaulted")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate("external_metric", map[string]string{
+		"metricName": "queue_messages_ready",
+	})
+	assert.NoError(t, err, "good external config")
+	assert.Equal(t, defaultAggregation, c.Aggregation, "aggregation defaulted")
+	assert.Equal(t, defaultNamespace, c.Namespace, "namespace defaulted")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate("object_metric", map[string]string{
+		"metricName": "requests_per_second",
+		"resource":   "deployments.apps",
+		"name":       "web",
+	})
+	assert.NoError(t, err, "good object config")
+	assert.Equal(t, "", c.Namespace, "namespace not defaulted for objects")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate("object_metric", map[string]string{
+		"metricName": "requests_per_second",
+		"resource":   "deployments.apps",
+	})
+	assert.Error(t, err, "name required for objects")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate("external_metric", map[string]string{
+		"metricName":  "queue_messages_ready",
+		"aggregation": "not-valid",
+	})
+	assert.Error(t, err, "bad aggregation")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate("external_metric", map[string]string{
+		"metricName": "queue_messages_ready",
+		"selector":   "queue in (jobs",
+	})
+	assert.Error(t, err, "bad selector")
+}
@@ -0,0 +1,268 @@
+package datadog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+
+	corelistersv1 "k8s.io/client-go/listers/core/v1"
+
+	"github.com/containership/cerebral/pkg/metrics"
+	"github.com/containership/cerebral/pkg/nodeutil"
+	"github.com/containership/cluster-manager/pkg/log"
+)
+
+// DefaultAddress is the address of the Datadog API for the US1 site
+const DefaultAddress = "https://api.datadoghq.com"
+
+const (
+	datadogRequestTimeout = 10 * time.Second
+)
+
+// HTTPClient performs HTTP requests. It's satisfied by *http.Client and
+// allows the client to be swapped out, e.g. in tests.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Backend implements a metrics backend for Datadog. It requires a node lister
+// so that it can restrict queries to the hosts of the nodes being queried.
+// Nodes accessed via the lister must not be mutated.
+type Backend struct {
+	queryURL string
+	apiKey   string
+	appKey   string
+
+	httpClient HTTPClient
+
+	nodeLister corelistersv1.NodeLister
+}
+
+// Average CPU usage across the given hosts
+const cpuQueryTemplateString = `100 - {{.Aggregation}}:system.cpu.idle{ {{- .HostFilter -}} }`
+
+var cpuQueryTemplate = template.Must(template.New("cpu").Parse(cpuQueryTemplateString))
+
+// Average memory usage across the given hosts
+const memoryQueryTemplateString = `100 - 100 * {{.Aggregation}}:system.mem.pct_usable{ {{- .HostFilter -}} }`
+
+var memoryQueryTemplate = template.Must(template.New("mem").Parse(memoryQueryTemplateString))
+
+// NewClient returns a new client for talking to the Datadog API at the given
+// address using the given API and application keys, or an error
+func NewClient(address string, apiKey string, appKey string, httpClient HTTPClient,
+	nodeLister corelistersv1.NodeLister) (metrics.Backend, error) {
+	if address == "" {
+		return nil, errors.New("address must not be empty")
+	}
+
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing address")
+	}
+
+	if u.Scheme == "" || u.Host == "" {
+		return nil, errors.Errorf("address %q must be in the format scheme://host:<port>", address)
+	}
+
+	if apiKey == "" || appKey == "" {
+		return nil, errors.New("API key and application key must be provided")
+	}
+
+	if httpClient == nil {
+		return nil, errors.New("HTTP client must be provided")
+	}
+
+	if nodeLister == nil {
+		return nil, errors.New("node lister must be provided")
+	}
+
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/api/v1/query"
+
+	return Backend{
+		queryURL:   u.String(),
+		apiKey:     apiKey,
+		appKey:     appKey,
+		httpClient: httpClient,
+		nodeLister: nodeLister,
+	}, nil
+}
+
+// GetValue implements the metrics.Backend interface
+func (b Backend) GetValue(metric string, configuration map[string]string, nodeSelector map[string]string) (float64, error) {
+	config := metricConfiguration{}
+	if err := config.defaultAndValidate(configuration); err != nil {
+		return 0, errors.Wrap(err, "validating configuration")
+	}
+
+	selector := nodeutil.GetNodesLabelSelector(nodeSelector)
+	nodes, err := b.nodeLister.List(selector)
+	if err != nil {
+		return 0, errors.Wrap(err, "listing nodes")
+	}
+
+	hostnames := make([]string, len(nodes))
+	for i, node := range nodes {
+		hostnames[i] = node.ObjectMeta.Name
+	}
+
+	config.HostFilter = buildHostFilter(hostnames)
+
+	var tmpl *template.Template
+	switch metric {
+	case MetricCPUPercentUtilization.String():
+		tmpl = cpuQueryTemplate
+
+	case MetricMemoryPercentUtilization.String():
+		tmpl = memoryQueryTemplate
+
+	case MetricCustom.String():
+		query, ok := configuration["query"]
+		if !ok {
+			return 0, errors.New("configuration key \"query\" must be provided for a custom query")
+		}
+
+		tmpl, err = template.New("query").Parse(query)
+		if err != nil {
+			return 0, errors.Wrap(err, "parsing custom query template")
+		}
+
+	default:
+		return 0, errors.Errorf("unknown metric %q", metric)
+	}
+
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, config); err != nil {
+		return 0, errors.Wrapf(err, "building %s query", metric)
+	}
+
+	end := time.Now()
+	return b.performQuery(out.String(), end.Add(-config.rangeDuration), end, config.Reducer)
+}
+
+// queryResponse is the body of a Datadog timeseries query response
+type queryResponse struct {
+	Status string   `json:"status"`
+	Error  string   `json:"error"`
+	Errors []string `json:"errors"`
+	Series []struct {
+		Scope string `json:"scope"`
+		// Each point is a [timestamp, value] pair where the value may be null
+		Pointlist [][]*float64 `json:"pointlist"`
+	} `json:"series"`
+}
+
+// performQuery performs the query over the given time range. Each series is
+// reduced to the average of its points, and the series are then reduced to a
+// single value using the given reducer.
+func (b Backend) performQuery(query string, from, to time.Time, reducer string) (float64, error) {
+	log.Debugf("Performing Datadog query: %s", query)
+
+	params := url.Values{
+		"query": []string{query},
+		"from":  []string{strconv.FormatInt(from.Unix(), 10)},
+		"to":    []string{strconv.FormatInt(to.Unix(), 10)},
+	}
+
+	req, err := http.NewRequest(http.MethodGet, b.queryURL+"?"+params.Encode(), nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "building Datadog query request")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), datadogRequestTimeout)
+	defer cancel()
+
+	req = req.WithContext(ctx)
+	req.Header.Set("DD-API-KEY", b.apiKey)
+	req.Header.Set("DD-APPLICATION-KEY", b.appKey)
+	req.Header.Set("Accept", "application/json")
+
+	res, err := b.httpClient.Do(req)
+	if err != nil {
+		return 0, errors.Wrapf(err, "querying Datadog with query %q", query)
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return 0, errors.Wrapf(err, "reading result of Datadog query %q", query)
+	}
+
+	var qr queryResponse
+	jsonErr := json.Unmarshal(body, &qr)
+
+	if res.StatusCode != http.StatusOK {
+		if jsonErr == nil && len(qr.Errors) != 0 {
+			return 0, errors.Errorf("querying Datadog with query %q returned status %d: %s",
+				query, res.StatusCode, strings.Join(qr.Errors, ", "))
+		}
+
+		return 0, errors.Errorf("querying Datadog with query %q returned status %d", query, res.StatusCode)
+	}
+
+	if jsonErr != nil {
+		return 0, errors.Wrapf(jsonErr, "parsing result of Datadog query %q", query)
+	}
+
+	if qr.Status == "error" {
+		return 0, errors.Errorf("querying Datadog with query %q failed: %s", query, qr.Error)
+	}
+
+	values := make([]float64, 0, len(qr.Series))
+	for _, series := range qr.Series {
+		var sum float64
+		var count int
+		for _, point := range series.Pointlist {
+			if len(point) != 2 || point[1] == nil {
+				continue
+			}
+
+			sum += *point[1]
+			count++
+		}
+
+		if count == 0 {
+			log.Debugf("Datadog series %q has no points", series.Scope)
+			continue
+		}
+
+		values = append(values, sum/float64(count))
+	}
+
+	result, err := metrics.Reduce(values, reducer)
+	if err != nil {
+		return 0, errors.Wrap(err, "reducing series")
+	}
+
+	return result, nil
+}
+
+// buildHostFilter returns a Datadog tag filter matching any of the given
+// hosts, or matching all hosts if there are none
+func buildHostFilter(hostnames []string) string {
+	if len(hostnames) == 0 {
+		return "*"
+	}
+
+	sorted := make([]string, len(hostnames))
+	copy(sorted, hostnames)
+	sort.Strings(sorted)
+
+	tags := make([]string, len(sorted))
+	for i, hostname := range sorted {
+		tags[i] = fmt.Sprintf("host:%s", hostname)
+	}
+
+	return strings.Join(tags, " OR ")
+}
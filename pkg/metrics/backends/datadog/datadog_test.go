@@ -0,0 +1,195 @@
+package datadog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelistersv1 "k8s.io/client-go/listers/core/v1"
+
+	"github.com/containership/cerebral/pkg/kubernetestest"
+)
+
+const (
+	validURL    = "https://api.datadoghq.com"
+	nonValidURL = "datadoghq"
+)
+
+var nodes = []corev1.Node{
+	{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "node-1",
+			Labels: map[string]string{"pool": "web"},
+		},
+	},
+	{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "node-0",
+			Labels: map[string]string{"pool": "web"},
+		},
+	},
+}
+
+const singleSeriesResponse = `{
+	"status": "ok",
+	"series": [
+		{"scope": "host:node-0,host:node-1", "pointlist": [[1546300800000, 10], [1546300860000, null], [1546300920000, 20]]}
+	]
+}`
+
+const multipleSeriesResponse = `{
+	"status": "ok",
+	"series": [
+		{"scope": "host:node-0", "pointlist": [[1546300800000, 10]]},
+		{"scope": "host:node-1", "pointlist": [[1546300800000, 30]]}
+	]
+}`
+
+// datadogTestServer returns a server that responds to queries with the given
+// status and body and records the query parameters of the last request
+func datadogTestServer(t *testing.T, status int, body string, params *map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/query", r.URL.Path)
+		assert.Equal(t, "api-key", r.Header.Get("DD-API-KEY"))
+		assert.Equal(t, "app-key", r.Header.Get("DD-APPLICATION-KEY"))
+
+		if params != nil {
+			*params = map[string]string{
+				"query": r.URL.Query().Get("query"),
+				"from":  r.URL.Query().Get("from"),
+				"to":    r.URL.Query().Get("to"),
+			}
+		}
+
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+}
+
+func TestNewClient(t *testing.T) {
+	client, err := NewClient(validURL, "api-key", "app-key", &http.Client{}, corelistersv1.NewNodeLister(nil))
+	assert.NotNil(t, client)
+	assert.NoError(t, err, "valid config is ok")
+	assert.Equal(t, validURL+"/api/v1/query", client.(Backend).queryURL)
+
+	_, err = NewClient("", "api-key", "app-key", &http.Client{}, corelistersv1.NewNodeLister(nil))
+	assert.Error(t, err, "error on empty URL")
+
+	_, err = NewClient(nonValidURL, "api-key", "app-key", &http.Client{}, corelistersv1.NewNodeLister(nil))
+	assert.Error(t, err, "error on URL without scheme")
+
+	_, err = NewClient(validURL, "", "app-key", &http.Client{}, corelistersv1.NewNodeLister(nil))
+	assert.Error(t, err, "error on empty API key")
+
+	_, err = NewClient(validURL, "api-key", "", &http.Client{}, corelistersv1.NewNodeLister(nil))
+	assert.Error(t, err, "error on empty application key")
+
+	_, err = NewClient(validURL, "api-key", "app-key", nil, corelistersv1.NewNodeLister(nil))
+	assert.Error(t, err, "error on nil HTTP client")
+
+	_, err = NewClient(validURL, "api-key", "app-key", &http.Client{}, nil)
+	assert.Error(t, err, "error on nil NodeLister")
+}
+
+func TestGetValue(t *testing.T) {
+	var params map[string]string
+	server := datadogTestServer(t, http.StatusOK, singleSeriesResponse, &params)
+	defer server.Close()
+
+	client, err := NewClient(server.URL, "api-key", "app-key", server.Client(), kubernetestest.BuildNodeLister(nodes))
+	assert.NoError(t, err)
+
+	val, err := client.GetValue("cpu_percent_utilization", nil, map[string]string{"pool": "web"})
+	assert.NoError(t, err)
+	assert.Equal(t, 15.0, val, "null points are ignored and points are averaged")
+	assert.Equal(t, "100 - avg:system.cpu.idle{host:node-0 OR host:node-1}", params["query"],
+		"hostnames are injected as a host tag filter")
+
+	from, _ := strconv.ParseInt(params["from"], 10, 64)
+	to, _ := strconv.ParseInt(params["to"], 10, 64)
+	assert.Equal(t, int64(300), to-from, "range is defaulted")
+
+	_, err = client.GetValue("memory_percent_utilization", map[string]string{
+		"aggregation": "max",
+		"range":       "1m",
+	}, map[string]string{"pool": "other"})
+	assert.NoError(t, err)
+	assert.Equal(t, "100 - 100 * max:system.mem.pct_usable{*}", params["query"], "no nodes match all hosts")
+
+	from, _ = strconv.ParseInt(params["from"], 10, 64)
+	to, _ = strconv.ParseInt(params["to"], 10, 64)
+	assert.Equal(t, int64(60), to-from, "range is configurable")
+
+	_, err = client.GetValue("custom", map[string]string{
+		"query": "sum:kubernetes.pods.running{env:prod AND ({{.HostFilter}})}",
+	}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "sum:kubernetes.pods.running{env:prod AND (host:node-0 OR host:node-1)}", params["query"])
+
+	_, err = client.GetValue("custom", nil, nil)
+	assert.Error(t, err, "custom query requires query")
+
+	_, err = client.GetValue("custom", map[string]string{"query": "{{.Missing"}, nil)
+	assert.Error(t, err, "invalid custom query template")
+
+	_, err = client.GetValue("cpu_percent_utilization", map[string]string{"aggregation": "mean"}, nil)
+	assert.Error(t, err, "invalid configuration")
+
+	_, err = client.GetValue("not a valid metric", nil, nil)
+	assert.Error(t, err, "unknown metric requested")
+
+	multiple := datadogTestServer(t, http.StatusOK, multipleSeriesResponse, nil)
+	defer multiple.Close()
+
+	client, _ = NewClient(multiple.URL, "api-key", "app-key", multiple.Client(), kubernetestest.BuildNodeLister(nodes))
+	_, err = client.GetValue("cpu_percent_utilization", nil, nil)
+	assert.Error(t, err, "multiple series require a reducer")
+
+	val, err = client.GetValue("cpu_percent_utilization", map[string]string{"reducer": "max"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 30.0, val, "series are reduced")
+
+	empty := datadogTestServer(t, http.StatusOK, `{"status": "ok", "series": []}`, nil)
+	defer empty.Close()
+
+	client, _ = NewClient(empty.URL, "api-key", "app-key", empty.Client(), kubernetestest.BuildNodeLister(nodes))
+	_, err = client.GetValue("cpu_percent_utilization", map[string]string{"reducer": "sum"}, nil)
+	assert.Error(t, err, "no series")
+
+	queryError := datadogTestServer(t, http.StatusOK, `{"status": "error", "error": "bad query"}`, nil)
+	defer queryError.Close()
+
+	client, _ = NewClient(queryError.URL, "api-key", "app-key", queryError.Client(), kubernetestest.BuildNodeLister(nodes))
+	_, err = client.GetValue("cpu_percent_utilization", nil, nil)
+	if assert.Error(t, err, "error when query fails") {
+		assert.Contains(t, err.Error(), "bad query", "error message is surfaced")
+	}
+
+	forbidden := datadogTestServer(t, http.StatusForbidden, `{"errors": ["Forbidden"]}`, nil)
+	defer forbidden.Close()
+
+	client, _ = NewClient(forbidden.URL, "api-key", "app-key", forbidden.Client(), kubernetestest.BuildNodeLister(nodes))
+	_, err = client.GetValue("cpu_percent_utilization", nil, nil)
+	if assert.Error(t, err, "error when Datadog errors") {
+		assert.Contains(t, err.Error(), "Forbidden", "error message is surfaced")
+	}
+
+	invalid := datadogTestServer(t, http.StatusOK, `not json`, nil)
+	defer invalid.Close()
+
+	client, _ = NewClient(invalid.URL, "api-key", "app-key", invalid.Client(), kubernetestest.BuildNodeLister(nodes))
+	_, err = client.GetValue("cpu_percent_utilization", nil, nil)
+	assert.Error(t, err, "error on invalid response")
+}
+
+func TestBuildHostFilter(t *testing.T) {
+	assert.Equal(t, "*", buildHostFilter(nil), "no hostnames match all hosts")
+	assert.Equal(t, "host:node-0", buildHostFilter([]string{"node-0"}))
+	assert.Equal(t, "host:node-0 OR host:node-1", buildHostFilter([]string{"node-1", "node-0"}),
+		"hostnames are sorted")
+}
@@ -0,0 +1,113 @@
+package datadog
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/containership/cerebral/pkg/metrics"
+)
+
+// Metric is a metric exposed by this backend
+type Metric int
+
+const (
+	// MetricCPUPercentUtilization is used to gather info about the CPU usage of nodes
+	MetricCPUPercentUtilization Metric = iota
+	// MetricMemoryPercentUtilization is used to gather info about the memory usage of nodes
+	MetricMemoryPercentUtilization
+	// MetricCustom is used to perform a custom Datadog query
+	MetricCustom
+)
+
+// String is a stringer for Metric
+func (m Metric) String() string {
+	switch m {
+	case MetricCPUPercentUtilization:
+		return "cpu_percent_utilization"
+	case MetricMemoryPercentUtilization:
+		return "memory_percent_utilization"
+	case MetricCustom:
+		return "custom"
+	}
+
+	return "unknown"
+}
+
+// See https://docs.datadoghq.com/metrics/#space-aggregation
+var validAggregations = []string{
+	"avg", // average the values of all hosts
+	"sum", // sum the values of all hosts
+	"min", // select the minimum value of all hosts
+	"max", // select the maximum value of all hosts
+}
+
+const defaultAggregation = "avg"
+const defaultRange = "5m"
+
+type metricConfiguration struct {
+	// -- Generic
+	Aggregation string `json:"aggregation"`
+	Range       string `json:"range"`
+	// Reducer reduces results containing multiple series to a single value.
+	// If empty, results must contain a single series.
+	Reducer string `json:"reducer"`
+
+	// -- Not user-specifiable
+	// Unfortunately we're required to export these fields for use in templates
+	HostFilter string `json:"-"`
+
+	rangeDuration time.Duration
+}
+
+// defaults and validates the metricConfiguration. Intended to be called with an
+// empty struct that we'll fill in here using the caller-provided configuration.
+func (c *metricConfiguration) defaultAndValidate(configuration map[string]string) error {
+	// Round trip the config through JSON parser to populate our struct
+	j, _ := json.Marshal(configuration)
+	json.Unmarshal(j, c)
+
+	if err := c.defaultAndValidateAggregation(); err != nil {
+		return err
+	}
+
+	if err := c.defaultAndValidateRange(); err != nil {
+		return err
+	}
+
+	if err := metrics.ValidateReducer(c.Reducer); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *metricConfiguration) defaultAndValidateAggregation() error {
+	if c.Aggregation == "" {
+		c.Aggregation = defaultAggregation
+	}
+
+	for _, a := range validAggregations {
+		if a == c.Aggregation {
+			return nil
+		}
+	}
+
+	return errors.Errorf("invalid aggregation %s", c.Aggregation)
+}
+
+func (c *metricConfiguration) defaultAndValidateRange() error {
+	if c.Range == "" {
+		c.Range = defaultRange
+	}
+
+	d, err := time.ParseDuration(c.Range)
+	if err != nil || d < time.Second {
+		return errors.Errorf("invalid range %s", c.Range)
+	}
+
+	c.rangeDuration = d
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package datadog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricString(t *testing.T) {
+	assert.Equal(t, "cpu_percent_utilization", MetricCPUPercentUtilization.String())
+	assert.Equal(t, "memory_percent_utilization", MetricMemoryPercentUtilization.String())
+	assert.Equal(t, "custom", MetricCustom.String())
+	assert.Equal(t, "unknown", Metric(-1).String())
+}
+
+func TestDefaultAndValidate(t *testing.T) {
+	c := metricConfiguration{}
+	err := c.defaultAndValidate(nil)
+	assert.NoError(t, err, "nil config provided is ok")
+	assert.Equal(t, defaultAggregation, c.Aggregation, "aggregation defaulted")
+	assert.Equal(t, defaultRange, c.Range, "range defaulted")
+	assert.Equal(t, 5*time.Minute, c.rangeDuration, "range parsed")
+	assert.Empty(t, c.Reducer, "no reducer by default")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{
+		"aggregation": "max",
+		"range":       "90s",
+		"reducer":     "avg",
+	})
+	assert.NoError(t, err, "good config")
+	assert.Equal(t, "max", c.Aggregation, "aggregation not defaulted if provided")
+	assert.Equal(t, 90*time.Second, c.rangeDuration, "range not defaulted if provided")
+	assert.Equal(t, "avg", c.Reducer)
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{
+		"aggregation": "mean",
+	})
+	assert.Error(t, err, "bad aggregation")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{
+		"range": "5d",
+	})
+	assert.Error(t, err, "bad range")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{
+		"range": "10ms",
+	})
+	assert.Error(t, err, "range too small")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{
+		"reducer": "median",
+	})
+	assert.Error(t, err, "bad reducer")
+}
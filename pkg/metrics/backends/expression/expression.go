@@ -0,0 +1,278 @@
+package expression
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/containership/cerebral/pkg/metrics"
+	"github.com/containership/cluster-manager/pkg/log"
+)
+
+// expressionKeyPrefix prefixes backend configuration keys defining an
+// expression. The rest of the key is the name of the metric the expression
+// is exposed as.
+const expressionKeyPrefix = "expression."
+
+// Backend implements a metrics backend that evaluates arithmetic expressions
+// over the metrics of other backends in the registry. Referenced backends are
+// looked up each time an expression is evaluated, so they may be created,
+// replaced, or deleted independently of this backend.
+type Backend struct {
+	name        string
+	expressions map[string]node
+
+	// termConfigurations holds the default configuration of each term
+	// referenced by any expression
+	termConfigurations map[term]map[string]string
+
+	registry metrics.RegistryInterface
+}
+
+// NewClient returns a new expression backend with the given name and
+// configuration that looks up referenced backends in the given registry, or
+// an error if any expression is invalid.
+func NewClient(name string, configuration map[string]string, registry metrics.RegistryInterface) (metrics.Backend, error) {
+	if registry == nil {
+		return nil, errors.New("registry must be provided")
+	}
+
+	b := Backend{
+		name:               name,
+		expressions:        make(map[string]node),
+		termConfigurations: make(map[term]map[string]string),
+		registry:           registry,
+	}
+
+	for key, value := range configuration {
+		if !strings.HasPrefix(key, expressionKeyPrefix) {
+			continue
+		}
+
+		metric := strings.TrimPrefix(key, expressionKeyPrefix)
+		if metric == "" {
+			return nil, errors.Errorf("configuration key %q must include a metric name", key)
+		}
+
+		expr, err := parse(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing expression for metric %q", metric)
+		}
+
+		for _, t := range terms(expr) {
+			if t.backend == name {
+				return nil, errors.Errorf("expression for metric %q references its own backend %q", metric, name)
+			}
+
+			b.termConfigurations[t] = make(map[string]string)
+		}
+
+		b.expressions[metric] = expr
+	}
+
+	if len(b.expressions) == 0 {
+		return nil, errors.Errorf("at least one %q<metric> configuration key must be provided", expressionKeyPrefix)
+	}
+
+	for key, value := range configuration {
+		if strings.HasPrefix(key, expressionKeyPrefix) {
+			continue
+		}
+
+		t, termKey, ok := b.splitTermKey(key)
+		if !ok {
+			return nil, errors.Errorf("configuration key %q does not configure a term of any expression", key)
+		}
+
+		b.termConfigurations[t][termKey] = value
+	}
+
+	return b, nil
+}
+
+// GetValue implements the metrics.Backend interface. Configuration keys of
+// the form backend:metric.key are passed to the term backend:metric as key,
+// overriding the backend's configuration.
+func (b Backend) GetValue(metric string, configuration map[string]string, nodeSelector map[string]string) (float64, error) {
+	expr, ok := b.expressions[metric]
+	if !ok {
+		return 0, errors.Errorf("unknown metric %q", metric)
+	}
+
+	termConfigurations, err := b.buildTermConfigurations(configuration)
+	if err != nil {
+		return 0, errors.Wrap(err, "validating configuration")
+	}
+
+	if err := b.checkCycles([]term{{backend: b.name, metric: metric}}); err != nil {
+		return 0, err
+	}
+
+	// Terms referenced more than once are only queried once
+	values := make(map[term]float64)
+	lookup := func(t term) (float64, error) {
+		if v, ok := values[t]; ok {
+			return v, nil
+		}
+
+		backend, err := b.registry.Get(t.backend)
+		if err != nil {
+			return 0, errors.Wrapf(err, "getting backend for term %s", t)
+		}
+
+		v, err := backend.GetValue(t.metric, termConfigurations[t], nodeSelector)
+		if err != nil {
+			return 0, errors.Wrapf(err, "getting value of term %s", t)
+		}
+
+		log.Debugf("Expression term %s has value %f", t, v)
+
+		values[t] = v
+		return v, nil
+	}
+
+	v, err := expr.evaluate(lookup)
+	if err != nil {
+		return 0, errors.Wrapf(err, "evaluating expression for metric %q", metric)
+	}
+
+	return v, nil
+}
+
+// UsesAutoscalingGroup implements the metrics.AutoscalingGroupScopedBackend
+// interface. The AutoscalingGroup is passed to every term, so the expression
+// depends on it if any term does.
+func (b Backend) UsesAutoscalingGroup(metric string, configuration map[string]string) bool {
+	expr, ok := b.expressions[metric]
+	if !ok {
+		return false
+	}
+
+	// Queries that will fail are never cached, so it doesn't matter what's
+	// returned for them, but cycles must not be followed
+	if err := b.checkCycles([]term{{backend: b.name, metric: metric}}); err != nil {
+		return true
+	}
+
+	termConfigurations, err := b.buildTermConfigurations(configuration)
+	if err != nil {
+		return true
+	}
+
+	for _, t := range terms(expr) {
+		backend, err := b.registry.Get(t.backend)
+		if err != nil {
+			return true
+		}
+
+		scoped, ok := metrics.Unwrap(backend).(metrics.AutoscalingGroupScopedBackend)
+		if ok && scoped.UsesAutoscalingGroup(t.metric, termConfigurations[t]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkCycles returns an error if the expression of the last term in the path
+// references any term in the path, directly or through the expressions of
+// other expression backends in the registry. Referenced backends may be
+// created or replaced at any time, so this must be checked each time an
+// expression is evaluated rather than only when the backend is created.
+func (b Backend) checkCycles(path []term) error {
+	expr, ok := b.expressions[path[len(path)-1].metric]
+	if !ok {
+		// Unknown metrics are reported when the term is looked up
+		return nil
+	}
+
+	for _, t := range terms(expr) {
+		for i, seen := range path {
+			if t == seen {
+				cycle := make([]string, 0, len(path)-i+1)
+				for _, c := range path[i:] {
+					cycle = append(cycle, c.String())
+				}
+				cycle = append(cycle, t.String())
+
+				return errors.Errorf("expression cycle %s", strings.Join(cycle, " -> "))
+			}
+		}
+
+		backend, err := b.registry.Get(t.backend)
+		if err != nil {
+			// Missing backends are reported when the term is looked up
+			continue
+		}
+
+		next, ok := metrics.Unwrap(backend).(Backend)
+		if !ok {
+			continue
+		}
+
+		// Don't let sibling terms share the backing array
+		if err := next.checkCycles(append(path[:len(path):len(path)], t)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// buildTermConfigurations returns the configuration of each term, starting
+// from the backend's configuration and applying the term keys of the given
+// configuration. The AutoscalingGroup being polled is passed to every term.
+func (b Backend) buildTermConfigurations(configuration map[string]string) (map[term]map[string]string, error) {
+	result := make(map[term]map[string]string, len(b.termConfigurations))
+	for t, defaults := range b.termConfigurations {
+		c := make(map[string]string, len(defaults))
+		for k, v := range defaults {
+			c[k] = v
+		}
+
+		result[t] = c
+	}
+
+	for key, value := range configuration {
+		if key == metrics.AutoscalingGroupKey {
+			for t := range result {
+				result[t][key] = value
+			}
+
+			continue
+		}
+
+		t, termKey, ok := b.splitTermKey(key)
+		if !ok {
+			return nil, errors.Errorf("configuration key %q does not configure a term of any expression", key)
+		}
+
+		result[t][termKey] = value
+	}
+
+	return result, nil
+}
+
+// splitTermKey splits a configuration key of the form backend:metric.key into
+// the term and key. Since names may contain ".", the longest matching term is
+// used.
+func (b Backend) splitTermKey(key string) (term, string, bool) {
+	candidates := make([]term, 0, len(b.termConfigurations))
+	for t := range b.termConfigurations {
+		candidates = append(candidates, t)
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return len(candidates[i].String()) > len(candidates[j].String())
+	})
+
+	for _, t := range candidates {
+		prefix := t.String() + "."
+		if strings.HasPrefix(key, prefix) && len(key) > len(prefix) {
+			return t, strings.TrimPrefix(key, prefix), true
+		}
+	}
+
+	return term{}, "", false
+}
@@ -0,0 +1,314 @@
+package expression
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/containership/cerebral/pkg/metrics"
+)
+
+// fakeBackend returns values by metric and records the configurations and
+// node selectors it's called with
+type fakeBackend struct {
+	values         map[string]float64
+	configurations map[string]map[string]string
+	nodeSelectors  map[string]map[string]string
+	calls          int
+}
+
+func newFakeBackend(values map[string]float64) *fakeBackend {
+	return &fakeBackend{
+		values:         values,
+		configurations: make(map[string]map[string]string),
+		nodeSelectors:  make(map[string]map[string]string),
+	}
+}
+
+func (b *fakeBackend) GetValue(metric string, configuration map[string]string, nodeSelector map[string]string) (float64, error) {
+	b.calls++
+	b.configurations[metric] = configuration
+	b.nodeSelectors[metric] = nodeSelector
+
+	v, ok := b.values[metric]
+	if !ok {
+		return 0, errors.Errorf("unknown metric %q", metric)
+	}
+
+	return v, nil
+}
+
+// scopedFakeBackend is a fakeBackend whose values depend on the
+// AutoscalingGroup for the given metrics
+type scopedFakeBackend struct {
+	*fakeBackend
+	scoped map[string]bool
+}
+
+func (b scopedFakeBackend) UsesAutoscalingGroup(metric string, _ map[string]string) bool {
+	return b.scoped[metric]
+}
+
+// fakeRegistry is a metrics.RegistryInterface backed by a map
+type fakeRegistry map[string]metrics.Backend
+
+func (r fakeRegistry) Get(name string) (metrics.Backend, error) {
+	backend, ok := r[name]
+	if !ok {
+		return nil, errors.Errorf("backend %q does not exist", name)
+	}
+
+	return backend, nil
+}
+
+func (r fakeRegistry) Delete(name string) {
+	delete(r, name)
+}
+
+func (r fakeRegistry) Put(name string, backend metrics.Backend) {
+	r[name] = backend
+}
+
+func TestNewClient(t *testing.T) {
+	registry := fakeRegistry{}
+
+	client, err := NewClient("combined", map[string]string{
+		"expression.pressure":                  "max(prom:cpu_percent_utilization, k8s:memory_percent_allocation) * 1.1",
+		"expression.cpu":                       "prom:cpu_percent_utilization",
+		"prom:cpu_percent_utilization.reducer": "max",
+	}, registry)
+	assert.NoError(t, err, "valid configuration")
+	if assert.NotNil(t, client) {
+		b := client.(Backend)
+		assert.Len(t, b.expressions, 2)
+		assert.Equal(t, map[term]map[string]string{
+			{backend: "prom", metric: "cpu_percent_utilization"}:  {"reducer": "max"},
+			{backend: "k8s", metric: "memory_percent_allocation"}: {},
+		}, b.termConfigurations)
+	}
+
+	_, err = NewClient("combined", map[string]string{"expression.cpu": "prom:cpu"}, nil)
+	assert.Error(t, err, "error on nil registry")
+
+	_, err = NewClient("combined", nil, registry)
+	assert.Error(t, err, "error on no expressions")
+
+	_, err = NewClient("combined", map[string]string{"expression.": "prom:cpu"}, registry)
+	assert.Error(t, err, "error on empty metric name")
+
+	_, err = NewClient("combined", map[string]string{"expression.cpu": "max(prom:cpu"}, registry)
+	assert.Error(t, err, "error on invalid expression")
+
+	_, err = NewClient("combined", map[string]string{"expression.cpu": "combined:other + 1"}, registry)
+	assert.Error(t, err, "error on self reference")
+
+	_, err = NewClient("combined", map[string]string{
+		"expression.cpu":   "prom:cpu",
+		"prom:memory.step": "1m",
+	}, registry)
+	assert.Error(t, err, "error on configuration of unknown term")
+
+	_, err = NewClient("combined", map[string]string{
+		"expression.cpu": "prom:cpu",
+		"reducer":        "max",
+	}, registry)
+	assert.Error(t, err, "error on unscoped configuration")
+}
+
+func TestGetValue(t *testing.T) {
+	prom := newFakeBackend(map[string]float64{
+		"cpu_percent_utilization": 60,
+		"custom":                  2,
+	})
+	k8s := newFakeBackend(map[string]float64{
+		"memory_percent_allocation": 80,
+	})
+	registry := fakeRegistry{
+		"prom": prom,
+		"k8s":  k8s,
+	}
+
+	client, err := NewClient("combined", map[string]string{
+		"expression.pressure":                  "max(prom:cpu_percent_utilization, k8s:memory_percent_allocation) * 1.1",
+		"expression.repeated":                  "prom:cpu_percent_utilization / 2 + prom:cpu_percent_utilization",
+		"expression.ratio":                     "prom:cpu_percent_utilization / prom:custom",
+		"expression.missing":                   "prom:missing",
+		"expression.unavailable":               "other:metric",
+		"prom:cpu_percent_utilization.reducer": "max",
+		"prom:custom.query":                    "sum(rate(requests_total[5m]))",
+	}, registry)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	nodeSelector := map[string]string{"pool": "web"}
+	val, err := client.GetValue("pressure", map[string]string{
+		metrics.AutoscalingGroupKey:             "web",
+		"prom:cpu_percent_utilization.reducer":  "avg",
+		"k8s:memory_percent_allocation.perNode": "true",
+	}, nodeSelector)
+	assert.NoError(t, err)
+	assert.InDelta(t, 88.0, val, 1e-9)
+
+	assert.Equal(t, map[string]string{
+		"reducer":                   "avg",
+		metrics.AutoscalingGroupKey: "web",
+	}, prom.configurations["cpu_percent_utilization"], "policy configuration overrides backend configuration")
+	assert.Equal(t, map[string]string{
+		"perNode":                   "true",
+		metrics.AutoscalingGroupKey: "web",
+	}, k8s.configurations["memory_percent_allocation"], "configuration is scoped to terms")
+	assert.Equal(t, nodeSelector, prom.nodeSelectors["cpu_percent_utilization"], "node selector is passed to terms")
+
+	_, err = client.GetValue("pressure", nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"reducer": "max"}, prom.configurations["cpu_percent_utilization"],
+		"backend configuration is not modified by policy configuration")
+
+	prom.calls = 0
+	val, err = client.GetValue("repeated", nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 90.0, val)
+	assert.Equal(t, 1, prom.calls, "repeated terms are queried once")
+
+	val, err = client.GetValue("ratio", nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 30.0, val)
+	assert.Equal(t, map[string]string{"query": "sum(rate(requests_total[5m]))"}, prom.configurations["custom"])
+
+	_, err = client.GetValue("missing", nil, nil)
+	assert.Error(t, err, "error when term backend errors")
+
+	_, err = client.GetValue("unavailable", nil, nil)
+	assert.Error(t, err, "error when term backend does not exist")
+
+	_, err = client.GetValue("pressure", map[string]string{"prom:memory.reducer": "max"}, nil)
+	assert.Error(t, err, "error on configuration of unknown term")
+
+	_, err = client.GetValue("not a valid metric", nil, nil)
+	assert.Error(t, err, "unknown metric requested")
+
+	prom.values["custom"] = 0
+	_, err = client.GetValue("ratio", nil, nil)
+	assert.Error(t, err, "error on division by zero")
+
+	registry.Delete("k8s")
+	_, err = client.GetValue("pressure", nil, nil)
+	assert.Error(t, err, "error when term backend is deleted")
+}
+
+func TestGetValueCycle(t *testing.T) {
+	registry := fakeRegistry{
+		"prom": newFakeBackend(map[string]float64{"cpu": 50}),
+	}
+
+	a, err := NewClient("a", map[string]string{
+		"expression.x":     "b:y + 1",
+		"expression.chain": "b:z * 2",
+	}, registry)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	b, err := NewClient("b", map[string]string{
+		"expression.y": "a:x + 1",
+		"expression.z": "prom:cpu + c:w",
+	}, registry)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	c, err := NewClient("c", map[string]string{
+		"expression.w": "prom:cpu",
+	}, registry)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for name, wrap := range map[string]func(metrics.Backend) metrics.Backend{
+		"plain": func(b metrics.Backend) metrics.Backend {
+			return b
+		},
+		"cached": func(b metrics.Backend) metrics.Backend {
+			return metrics.NewCachedBackend(b, 0)
+		},
+	} {
+		registry.Put("a", wrap(a))
+		registry.Put("b", wrap(b))
+		registry.Put("c", wrap(c))
+
+		_, err = a.GetValue("x", nil, nil)
+		assert.EqualError(t, err, "expression cycle a:x -> b:y -> a:x", "%s: error on cycle", name)
+
+		_, err = b.GetValue("y", nil, nil)
+		assert.EqualError(t, err, "expression cycle b:y -> a:x -> b:y", "%s: error on cycle", name)
+
+		val, err := a.GetValue("chain", nil, nil)
+		assert.NoError(t, err, "%s: terms shared across backends are not cycles", name)
+		assert.Equal(t, 200.0, val)
+	}
+}
+
+func TestUsesAutoscalingGroup(t *testing.T) {
+	registry := fakeRegistry{
+		"prom": metrics.NewCachedBackend(scopedFakeBackend{
+			fakeBackend: newFakeBackend(nil),
+			scoped:      map[string]bool{"custom": true},
+		}, 0),
+		"k8s": newFakeBackend(nil),
+	}
+
+	client, err := NewClient("combined", map[string]string{
+		"expression.unscoped": "prom:cpu + k8s:memory",
+		"expression.scoped":   "prom:custom + k8s:memory",
+		"expression.missing":  "other:metric",
+	}, registry)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	b := client.(Backend)
+	assert.False(t, b.UsesAutoscalingGroup("unscoped", nil), "no term depends on the AutoscalingGroup")
+	assert.True(t, b.UsesAutoscalingGroup("scoped", nil), "a term depends on the AutoscalingGroup")
+	assert.False(t, b.UsesAutoscalingGroup("unknown", nil), "unknown metric")
+	assert.True(t, b.UsesAutoscalingGroup("missing", nil), "term backend does not exist")
+
+	nested, err := NewClient("nested", map[string]string{
+		"expression.scoped":   "combined:scoped * 2",
+		"expression.unscoped": "combined:unscoped * 2",
+	}, registry)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	registry.Put("combined", metrics.NewCachedBackend(client, 0))
+	b = nested.(Backend)
+	assert.True(t, b.UsesAutoscalingGroup("scoped", nil), "nested expression depends on the AutoscalingGroup")
+	assert.False(t, b.UsesAutoscalingGroup("unscoped", nil), "nested expression doesn't depend on the AutoscalingGroup")
+}
+
+func TestSplitTermKey(t *testing.T) {
+	b := Backend{
+		termConfigurations: map[term]map[string]string{
+			{backend: "prom", metric: "custom"}:    {},
+			{backend: "prom", metric: "custom.v2"}: {},
+		},
+	}
+
+	tm, key, ok := b.splitTermKey("prom:custom.query")
+	assert.True(t, ok)
+	assert.Equal(t, term{backend: "prom", metric: "custom"}, tm)
+	assert.Equal(t, "query", key)
+
+	tm, key, ok = b.splitTermKey("prom:custom.v2.query")
+	assert.True(t, ok)
+	assert.Equal(t, term{backend: "prom", metric: "custom.v2"}, tm, "longest term matches")
+	assert.Equal(t, "query", key)
+
+	_, _, ok = b.splitTermKey("prom:custom.")
+	assert.False(t, ok, "key must not be empty")
+
+	_, _, ok = b.splitTermKey("prom:other.query")
+	assert.False(t, ok, "unknown term")
+}
@@ -0,0 +1,395 @@
+package expression
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"github.com/containership/cerebral/pkg/metrics"
+)
+
+// term references a metric of another backend, written as backend:metric
+type term struct {
+	backend string
+	metric  string
+}
+
+func (t term) String() string {
+	return fmt.Sprintf("%s:%s", t.backend, t.metric)
+}
+
+// node is a node of a parsed expression
+type node interface {
+	// evaluate evaluates the node, looking up the values of terms using the
+	// given function
+	evaluate(lookup func(term) (float64, error)) (float64, error)
+}
+
+type numberNode float64
+
+type termNode term
+
+type negateNode struct {
+	operand node
+}
+
+type binaryNode struct {
+	op          byte
+	left, right node
+}
+
+type callNode struct {
+	function string
+	args     []node
+}
+
+// functions are the functions that may be called in an expression, along with
+// the minimum and maximum number of arguments. A maximum of zero means that
+// any number of arguments is allowed.
+var functions = map[string]struct {
+	minArgs int
+	maxArgs int
+}{
+	"max": {minArgs: 1},
+	"min": {minArgs: 1},
+	"sum": {minArgs: 1},
+	"avg": {minArgs: 1},
+	"abs": {minArgs: 1, maxArgs: 1},
+}
+
+func (n numberNode) evaluate(_ func(term) (float64, error)) (float64, error) {
+	return float64(n), nil
+}
+
+func (n termNode) evaluate(lookup func(term) (float64, error)) (float64, error) {
+	return lookup(term(n))
+}
+
+func (n negateNode) evaluate(lookup func(term) (float64, error)) (float64, error) {
+	v, err := n.operand.evaluate(lookup)
+	return -v, err
+}
+
+func (n binaryNode) evaluate(lookup func(term) (float64, error)) (float64, error) {
+	left, err := n.left.evaluate(lookup)
+	if err != nil {
+		return 0, err
+	}
+
+	right, err := n.right.evaluate(lookup)
+	if err != nil {
+		return 0, err
+	}
+
+	switch n.op {
+	case '+':
+		return left + right, nil
+	case '-':
+		return left - right, nil
+	case '*':
+		return left * right, nil
+	case '/':
+		if right == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return left / right, nil
+	}
+
+	return 0, errors.Errorf("unknown operator %q", n.op)
+}
+
+func (n callNode) evaluate(lookup func(term) (float64, error)) (float64, error) {
+	args := make([]float64, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.evaluate(lookup)
+		if err != nil {
+			return 0, err
+		}
+
+		args[i] = v
+	}
+
+	if n.function == "abs" {
+		return math.Abs(args[0]), nil
+	}
+
+	// The remaining functions are the reducers of the same name
+	return metrics.Reduce(args, n.function)
+}
+
+// terms returns the distinct terms referenced by the node, in the order they
+// first appear
+func terms(n node) []term {
+	var result []term
+	seen := make(map[term]bool)
+
+	var walk func(node)
+	walk = func(n node) {
+		switch n := n.(type) {
+		case termNode:
+			if !seen[term(n)] {
+				seen[term(n)] = true
+				result = append(result, term(n))
+			}
+		case negateNode:
+			walk(n.operand)
+		case binaryNode:
+			walk(n.left)
+			walk(n.right)
+		case callNode:
+			for _, arg := range n.args {
+				walk(arg)
+			}
+		}
+	}
+
+	walk(n)
+
+	return result
+}
+
+// parser is a recursive descent parser for expressions of the grammar:
+//
+//	expr    = product { ("+" | "-") product }
+//	product = unary { ("*" | "/") unary }
+//	unary   = "-" unary | primary
+//	primary = number | name ":" name | name "(" expr { "," expr } ")" | "(" expr ")"
+//
+// Names may contain letters, digits, "_", "." and "-", but must start with a
+// letter. Since "-" is allowed in names, subtraction following a term must be
+// separated from it by whitespace.
+type parser struct {
+	input string
+	pos   int
+}
+
+// parse parses the expression, returning an error describing the position of
+// the first syntax error
+func parse(input string) (node, error) {
+	p := &parser{input: input}
+
+	n, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		return nil, p.errorf("unexpected %q", p.input[p.pos])
+	}
+
+	return n, nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return errors.Errorf("position %d: %s", p.pos+1, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.input) {
+		switch p.input[p.pos] {
+		case ' ', '\t', '\n', '\r':
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+// peek returns the next non-space character, or 0 at the end of the input
+func (p *parser) peek() byte {
+	p.skipSpace()
+	if p.pos >= len(p.input) {
+		return 0
+	}
+
+	return p.input[p.pos]
+}
+
+func (p *parser) parseExpr() (node, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := p.peek()
+		if op != '+' && op != '-' {
+			return left, nil
+		}
+		p.pos++
+
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+
+		left = binaryNode{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) parseProduct() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := p.peek()
+		if op != '*' && op != '/' {
+			return left, nil
+		}
+		p.pos++
+
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		left = binaryNode{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.peek() == '-' {
+		p.pos++
+
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return negateNode{operand: operand}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	c := p.peek()
+	switch {
+	case c == 0:
+		return nil, p.errorf("unexpected end of expression")
+
+	case c == '(':
+		p.pos++
+
+		n, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+
+		if p.peek() != ')' {
+			return nil, p.errorf("expected \")\"")
+		}
+		p.pos++
+
+		return n, nil
+
+	case isDigit(c) || c == '.':
+		return p.parseNumber()
+
+	case isLetter(c):
+		start := p.pos
+		name := p.parseName()
+
+		// Whitespace is not allowed within a term or between a function
+		// name and its arguments
+		if p.pos < len(p.input) && p.input[p.pos] == ':' {
+			p.pos++
+
+			if p.pos >= len(p.input) || !isLetter(p.input[p.pos]) {
+				return nil, p.errorf("expected metric name after %q", name+":")
+			}
+
+			return termNode{backend: name, metric: p.parseName()}, nil
+		}
+
+		if p.pos < len(p.input) && p.input[p.pos] == '(' {
+			p.pos++
+			return p.parseCall(name, start)
+		}
+
+		p.pos = start
+		return nil, p.errorf("expected term of the form backend:metric or function call but got %q", name)
+	}
+
+	return nil, p.errorf("unexpected %q", c)
+}
+
+func (p *parser) parseNumber() (node, error) {
+	start := p.pos
+	for p.pos < len(p.input) && (isDigit(p.input[p.pos]) || p.input[p.pos] == '.') {
+		p.pos++
+	}
+
+	v, err := strconv.ParseFloat(p.input[start:p.pos], 64)
+	if err != nil {
+		p.pos = start
+		return nil, p.errorf("invalid number %q", p.input[start:p.pos])
+	}
+
+	return numberNode(v), nil
+}
+
+func (p *parser) parseName() string {
+	start := p.pos
+	for p.pos < len(p.input) && isNameChar(p.input[p.pos]) {
+		p.pos++
+	}
+
+	return p.input[start:p.pos]
+}
+
+// parseCall parses the arguments of a call to the named function starting
+// at the given position. The opening parenthesis must already be consumed.
+func (p *parser) parseCall(name string, start int) (node, error) {
+	f, ok := functions[name]
+	if !ok {
+		p.pos = start
+		return nil, p.errorf("unknown function %q", name)
+	}
+
+	var args []node
+	if p.peek() != ')' {
+		for {
+			arg, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+
+			args = append(args, arg)
+
+			if p.peek() != ',' {
+				break
+			}
+			p.pos++
+		}
+	}
+
+	if p.peek() != ')' {
+		return nil, p.errorf("expected \",\" or \")\"")
+	}
+	p.pos++
+
+	if len(args) < f.minArgs || (f.maxArgs != 0 && len(args) > f.maxArgs) {
+		p.pos = start
+		return nil, p.errorf("wrong number of arguments to %s: %d", name, len(args))
+	}
+
+	return callNode{function: name, args: args}, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameChar(c byte) bool {
+	return isLetter(c) || isDigit(c) || c == '_' || c == '.' || c == '-'
+}
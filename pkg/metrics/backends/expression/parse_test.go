@@ -0,0 +1,132 @@
+package expression
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// lookupValues returns a lookup function returning the given term values
+func lookupValues(values map[string]float64) func(term) (float64, error) {
+	return func(t term) (float64, error) {
+		v, ok := values[t.String()]
+		if !ok {
+			return 0, errors.Errorf("unknown term %s", t)
+		}
+
+		return v, nil
+	}
+}
+
+func TestParseAndEvaluate(t *testing.T) {
+	lookup := lookupValues(map[string]float64{
+		"prom:cpu_percent_utilization":  60,
+		"k8s:memory_percent_allocation": 80,
+		"my-backend.v2:metric-name":     4,
+	})
+
+	tests := []struct {
+		expression string
+		expected   float64
+		message    string
+	}{
+		{"42", 42, "number"},
+		{"0.5", 0.5, "decimal"},
+		{".5", 0.5, "decimal without leading zero"},
+		{"prom:cpu_percent_utilization", 60, "term"},
+		{"1 + 2 * 3", 7, "precedence"},
+		{"(1 + 2) * 3", 9, "parentheses"},
+		{"10 - 4 - 3", 3, "left associative subtraction"},
+		{"12 / 3 / 2", 2, "left associative division"},
+		{"-2 * -3", 6, "unary minus"},
+		{"--2", 2, "double negation"},
+		{"max(prom:cpu_percent_utilization, k8s:memory_percent_allocation) * 1.1", 88, "max"},
+		{"min(prom:cpu_percent_utilization, k8s:memory_percent_allocation)", 60, "min"},
+		{"avg(prom:cpu_percent_utilization, k8s:memory_percent_allocation)", 70, "avg"},
+		{"sum(1, 2, 3)", 6, "sum"},
+		{"abs(1 - 5)", 4, "abs"},
+		{"0.25 * prom:cpu_percent_utilization + 0.75 * k8s:memory_percent_allocation", 75, "weighted sum"},
+		{"my-backend.v2:metric-name - 1", 3, "names with dashes and dots"},
+		{" \tmax( 1 ,2 )\n", 2, "whitespace"},
+	}
+
+	for _, test := range tests {
+		expr, err := parse(test.expression)
+		if !assert.NoError(t, err, test.message) {
+			continue
+		}
+
+		v, err := expr.evaluate(lookup)
+		assert.NoError(t, err, test.message)
+		assert.InDelta(t, test.expected, v, 1e-9, test.message)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		expression string
+		message    string
+	}{
+		{"", "empty"},
+		{"   ", "only whitespace"},
+		{"1 +", "missing operand"},
+		{"(1 + 2", "missing closing parenthesis"},
+		{"1 + 2)", "extra closing parenthesis"},
+		{"1 2", "missing operator"},
+		{"prom", "bare name"},
+		{"prom:", "missing metric name"},
+		{"prom :cpu", "whitespace in term"},
+		{"median(1, 2)", "unknown function"},
+		{"max()", "too few arguments"},
+		{"abs(1, 2)", "too many arguments"},
+		{"max(1,)", "trailing comma"},
+		{"max (1)", "whitespace before arguments"},
+		{"1.2.3", "invalid number"},
+		{"1 % 2", "unknown operator"},
+		{"1e3", "exponents are not supported"},
+	}
+
+	for _, test := range tests {
+		_, err := parse(test.expression)
+		assert.Error(t, err, test.message)
+	}
+
+	_, err := parse("1 + median(2)")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "position 5", "error includes position")
+	}
+}
+
+func TestEvaluateErrors(t *testing.T) {
+	lookup := lookupValues(map[string]float64{"prom:zero": 0})
+
+	expr, err := parse("1 / prom:zero")
+	assert.NoError(t, err)
+	_, err = expr.evaluate(lookup)
+	assert.Error(t, err, "division by zero")
+
+	expr, err = parse("max(1, prom:missing)")
+	assert.NoError(t, err)
+	_, err = expr.evaluate(lookup)
+	assert.Error(t, err, "term errors are returned")
+
+	expr, err = parse("-abs(prom:missing)")
+	assert.NoError(t, err)
+	_, err = expr.evaluate(lookup)
+	assert.Error(t, err, "term errors are returned through unary operators")
+}
+
+func TestTerms(t *testing.T) {
+	expr, err := parse("max(b:y, a:x) + -b:y * (c:z - a:x)")
+	assert.NoError(t, err)
+	assert.Equal(t, []term{
+		{backend: "b", metric: "y"},
+		{backend: "a", metric: "x"},
+		{backend: "c", metric: "z"},
+	}, terms(expr), "distinct terms in order of appearance")
+
+	expr, err = parse("1 + 2")
+	assert.NoError(t, err)
+	assert.Empty(t, terms(expr), "no terms")
+}
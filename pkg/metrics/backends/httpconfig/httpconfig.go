@@ -0,0 +1,246 @@
+package httpconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// headerKeyPrefix is the prefix of configuration keys specifying extra
+// headers, e.g. header.X-Scope-OrgID
+const headerKeyPrefix = "header."
+
+// SecretGetter returns the data of the Secret with the given namespace and name
+type SecretGetter func(namespace, name string) (map[string][]byte, error)
+
+// Config configures authentication, TLS, and extra headers for HTTP requests
+// made by a metrics backend
+type Config struct {
+	BearerToken string
+
+	Username string
+	Password string
+
+	// PEM encoded CA bundle, client certificate, and client key
+	CA   []byte
+	Cert []byte
+	Key  []byte
+
+	InsecureSkipVerify bool
+
+	Headers map[string]string
+}
+
+// FromConfiguration parses a Config from MetricsBackend configuration.
+// Credentials and certificates are never given inline; instead the key is
+// suffixed with Secret to read the value from a Secret reference of the form
+// <namespace>/<name>/<key>, or with Env to read the value from the environment
+// variable with the given name, e.g. bearerTokenSecret or passwordEnv.
+func FromConfiguration(configuration map[string]string, getSecret SecretGetter) (Config, error) {
+	var c Config
+	var err error
+
+	if c.BearerToken, err = Resolve(configuration, "bearerToken", getSecret); err != nil {
+		return c, err
+	}
+
+	c.Username = configuration["username"]
+	if c.Password, err = Resolve(configuration, "password", getSecret); err != nil {
+		return c, err
+	}
+
+	if c.BearerToken != "" && (c.Username != "" || c.Password != "") {
+		return c, errors.New("bearer token and basic auth are mutually exclusive")
+	}
+
+	if c.Password != "" && c.Username == "" {
+		return c, errors.New("username is required for basic auth")
+	}
+
+	var ca, cert, key string
+	if ca, err = Resolve(configuration, "ca", getSecret); err != nil {
+		return c, err
+	}
+	if cert, err = Resolve(configuration, "cert", getSecret); err != nil {
+		return c, err
+	}
+	if key, err = Resolve(configuration, "key", getSecret); err != nil {
+		return c, err
+	}
+
+	if (cert == "") != (key == "") {
+		return c, errors.New("client certificate and key must be provided together")
+	}
+
+	c.CA, c.Cert, c.Key = []byte(ca), []byte(cert), []byte(key)
+
+	if v, ok := configuration["insecureSkipVerify"]; ok {
+		if c.InsecureSkipVerify, err = strconv.ParseBool(v); err != nil {
+			return c, errors.Wrapf(err, "parsing insecureSkipVerify %q", v)
+		}
+	}
+
+	for k, v := range configuration {
+		if !strings.HasPrefix(k, headerKeyPrefix) {
+			continue
+		}
+
+		name := strings.TrimPrefix(k, headerKeyPrefix)
+		if name == "" {
+			return c, errors.Errorf("header name missing from configuration key %q", k)
+		}
+
+		if c.Headers == nil {
+			c.Headers = make(map[string]string)
+		}
+		c.Headers[name] = v
+	}
+
+	return c, nil
+}
+
+// Resolve returns the value for the given key from either the Secret
+// reference (keySecret) or the environment variable (keyEnv) named in the
+// configuration, or empty if neither is provided
+func Resolve(configuration map[string]string, key string, getSecret SecretGetter) (string, error) {
+	ref, fromSecret := configuration[key+"Secret"]
+	env, fromEnv := configuration[key+"Env"]
+
+	switch {
+	case fromSecret && fromEnv:
+		return "", errors.Errorf("only one of %sSecret and %sEnv may be provided", key, key)
+
+	case fromSecret:
+		value, err := secretValue(ref, getSecret)
+		if err != nil {
+			return "", errors.Wrapf(err, "reading %sSecret", key)
+		}
+		return value, nil
+
+	case fromEnv:
+		value, ok := os.LookupEnv(env)
+		if !ok {
+			return "", errors.Errorf("environment variable %s for %sEnv is not set", env, key)
+		}
+		return value, nil
+	}
+
+	return "", nil
+}
+
+func secretValue(ref string, getSecret SecretGetter) (string, error) {
+	parts := strings.Split(ref, "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return "", errors.Errorf("invalid Secret reference %q, expected <namespace>/<name>/<key>", ref)
+	}
+
+	if getSecret == nil {
+		return "", errors.New("Secrets cannot be read")
+	}
+
+	data, err := getSecret(parts[0], parts[1])
+	if err != nil {
+		return "", errors.Wrapf(err, "getting Secret %s/%s", parts[0], parts[1])
+	}
+
+	value, ok := data[parts[2]]
+	if !ok {
+		return "", errors.Errorf("key %q not found in Secret %s/%s", parts[2], parts[0], parts[1])
+	}
+
+	return string(value), nil
+}
+
+// TLSConfig returns the TLS configuration for the Config, or nil if the
+// defaults should be used
+func (c Config) TLSConfig() (*tls.Config, error) {
+	if len(c.CA) == 0 && len(c.Cert) == 0 && !c.InsecureSkipVerify {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+
+	if len(c.CA) != 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(c.CA) {
+			return nil, errors.New("no certificates found in CA bundle")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if len(c.Cert) != 0 {
+		cert, err := tls.X509KeyPair(c.Cert, c.Key)
+		if err != nil {
+			return nil, errors.Wrap(err, "loading client certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// RoundTripper returns an http.RoundTripper which uses the TLS configuration
+// and adds authentication and extra headers to every request
+func (c Config) RoundTripper() (http.RoundTripper, error) {
+	tlsConfig, err := c.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	// These match the defaults used by the Prometheus client
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+		TLSClientConfig:     tlsConfig,
+	}
+
+	if c.BearerToken == "" && c.Username == "" && len(c.Headers) == 0 {
+		return transport, nil
+	}
+
+	return &roundTripper{
+		config: c,
+		next:   transport,
+	}, nil
+}
+
+type roundTripper struct {
+	config Config
+	next   http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (rt *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper must not modify the request, so modify a copy instead
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		r.Header[k] = append([]string(nil), v...)
+	}
+
+	for k, v := range rt.config.Headers {
+		r.Header.Set(k, v)
+	}
+
+	if rt.config.BearerToken != "" {
+		r.Header.Set("Authorization", "Bearer "+rt.config.BearerToken)
+	} else if rt.config.Username != "" {
+		r.SetBasicAuth(rt.config.Username, rt.config.Password)
+	}
+
+	return rt.next.RoundTrip(r)
+}
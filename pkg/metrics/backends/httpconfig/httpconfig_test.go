@@ -0,0 +1,175 @@
+package httpconfig
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func getSecret(namespace, name string) (map[string][]byte, error) {
+	if namespace != "monitoring" || name != "prometheus" {
+		return nil, errors.New("not found")
+	}
+
+	return map[string][]byte{
+		"token":    []byte("secret-token"),
+		"password": []byte("secret-password"),
+	}, nil
+}
+
+func TestFromConfiguration(t *testing.T) {
+	c, err := FromConfiguration(nil, getSecret)
+	assert.NoError(t, err, "nil configuration is ok")
+	assert.Equal(t, Config{CA: []byte{}, Cert: []byte{}, Key: []byte{}}, c, "nothing configured")
+
+	c, err = FromConfiguration(map[string]string{
+		"bearerTokenSecret": "monitoring/prometheus/token",
+	}, getSecret)
+	assert.NoError(t, err)
+	assert.Equal(t, "secret-token", c.BearerToken, "bearer token from Secret")
+
+	os.Setenv("HTTPCONFIG_TEST_PASSWORD", "env-password")
+	defer os.Unsetenv("HTTPCONFIG_TEST_PASSWORD")
+	c, err = FromConfiguration(map[string]string{
+		"username":    "cerebral",
+		"passwordEnv": "HTTPCONFIG_TEST_PASSWORD",
+	}, getSecret)
+	assert.NoError(t, err)
+	assert.Equal(t, "cerebral", c.Username)
+	assert.Equal(t, "env-password", c.Password, "password from environment")
+
+	c, err = FromConfiguration(map[string]string{
+		"insecureSkipVerify":   "true",
+		"header.X-Scope-OrgID": "tenant-1",
+	}, getSecret)
+	assert.NoError(t, err)
+	assert.True(t, c.InsecureSkipVerify)
+	assert.Equal(t, map[string]string{"X-Scope-OrgID": "tenant-1"}, c.Headers, "extra headers")
+
+	_, err = FromConfiguration(map[string]string{
+		"bearerTokenSecret": "monitoring/prometheus/token",
+		"bearerTokenEnv":    "HTTPCONFIG_TEST_PASSWORD",
+	}, getSecret)
+	assert.Error(t, err, "Secret and environment variable are mutually exclusive")
+
+	_, err = FromConfiguration(map[string]string{
+		"bearerTokenSecret": "monitoring/prometheus/token",
+		"username":          "cerebral",
+	}, getSecret)
+	assert.Error(t, err, "bearer token and basic auth are mutually exclusive")
+
+	_, err = FromConfiguration(map[string]string{
+		"passwordSecret": "monitoring/prometheus/password",
+	}, getSecret)
+	assert.Error(t, err, "password requires username")
+
+	_, err = FromConfiguration(map[string]string{
+		"bearerTokenSecret": "monitoring/prometheus",
+	}, getSecret)
+	assert.Error(t, err, "invalid Secret reference")
+
+	_, err = FromConfiguration(map[string]string{
+		"bearerTokenSecret": "monitoring/other/token",
+	}, getSecret)
+	assert.Error(t, err, "Secret not found")
+
+	_, err = FromConfiguration(map[string]string{
+		"bearerTokenSecret": "monitoring/prometheus/missing",
+	}, getSecret)
+	assert.Error(t, err, "key not found in Secret")
+
+	_, err = FromConfiguration(map[string]string{
+		"bearerTokenEnv": "HTTPCONFIG_TEST_UNSET",
+	}, getSecret)
+	assert.Error(t, err, "environment variable not set")
+
+	_, err = FromConfiguration(map[string]string{
+		"certSecret": "monitoring/prometheus/token",
+	}, getSecret)
+	assert.Error(t, err, "certificate requires key")
+
+	_, err = FromConfiguration(map[string]string{
+		"insecureSkipVerify": "maybe",
+	}, getSecret)
+	assert.Error(t, err, "invalid insecureSkipVerify")
+
+	_, err = FromConfiguration(map[string]string{
+		"header.": "value",
+	}, getSecret)
+	assert.Error(t, err, "missing header name")
+}
+
+func TestTLSConfig(t *testing.T) {
+	tlsConfig, err := Config{}.TLSConfig()
+	assert.NoError(t, err)
+	assert.Nil(t, tlsConfig, "defaults are used")
+
+	_, err = Config{CA: []byte("not a certificate")}.TLSConfig()
+	assert.Error(t, err, "invalid CA bundle")
+
+	_, err = Config{Cert: []byte("not a certificate"), Key: []byte("not a key")}.TLSConfig()
+	assert.Error(t, err, "invalid client certificate")
+
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+
+	key, err := x509.MarshalPKCS8PrivateKey(server.TLS.Certificates[0].PrivateKey)
+	assert.NoError(t, err)
+
+	tlsConfig, err = Config{
+		CA:   pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}),
+		Cert: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}),
+		Key:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}),
+	}.TLSConfig()
+	assert.NoError(t, err)
+	assert.NotNil(t, tlsConfig.RootCAs, "CA bundle loaded")
+	assert.Len(t, tlsConfig.Certificates, 1, "client certificate loaded")
+}
+
+func TestRoundTripper(t *testing.T) {
+	var got *http.Request
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+	}))
+	defer server.Close()
+
+	rt, err := Config{}.RoundTripper()
+	assert.NoError(t, err)
+	_, err = (&http.Client{Transport: rt}).Get(server.URL)
+	assert.Error(t, err, "server certificate is not trusted by default")
+
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	rt, err = Config{
+		CA:          ca,
+		BearerToken: "token",
+		Headers:     map[string]string{"X-Scope-OrgID": "tenant-1"},
+	}.RoundTripper()
+	assert.NoError(t, err)
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	_, err = (&http.Client{Transport: rt}).Do(req)
+	assert.NoError(t, err, "server certificate trusted with CA bundle")
+	assert.Equal(t, "Bearer token", got.Header.Get("Authorization"), "bearer token added")
+	assert.Equal(t, "tenant-1", got.Header.Get("X-Scope-OrgID"), "extra header added")
+	assert.Empty(t, req.Header.Get("Authorization"), "original request not modified")
+
+	rt, err = Config{
+		Username:           "cerebral",
+		Password:           "password",
+		InsecureSkipVerify: true,
+	}.RoundTripper()
+	assert.NoError(t, err)
+
+	_, err = (&http.Client{Transport: rt}).Get(server.URL)
+	assert.NoError(t, err, "server certificate not verified")
+	username, password, ok := got.BasicAuth()
+	assert.True(t, ok, "basic auth added")
+	assert.Equal(t, "cerebral", username)
+	assert.Equal(t, "password", password)
+}
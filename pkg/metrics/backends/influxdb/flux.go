@@ -0,0 +1,419 @@
+package influxdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+
+	corelistersv1 "k8s.io/client-go/listers/core/v1"
+
+	"github.com/containership/cerebral/pkg/metrics"
+	"github.com/containership/cerebral/pkg/metrics/backends/httpconfig"
+	"github.com/containership/cluster-manager/pkg/log"
+)
+
+// FluxBackend implements a metrics backend for InfluxDB 2.x, which is queried
+// using Flux and authenticated using tokens.
+type FluxBackend struct {
+	queryURL string
+	token    string
+
+	httpClient *http.Client
+
+	nodeLister corelistersv1.NodeLister
+}
+
+const (
+	fluxRequestTimeout = 10 * time.Second
+)
+
+// Aggregate CPU usage across the given nodes for the given range
+const fluxCPUQueryTemplateString = `
+from(bucket: "{{.Bucket}}")
+	|> range(start: -{{.Range}})
+	|> filter(fn: (r) => r._measurement == "cpu" and r._field == "usage_idle" and r.cpu == "cpu-total")
+	|> filter(fn: (r) => {{.HostFilter}})
+	|> group()
+	|> {{.Aggregation}}()
+	|> map(fn: (r) => ({r with _value: 100.0 - r._value}))
+`
+
+var fluxCPUQueryTemplate = template.Must(template.New("flux-cpu").Parse(fluxCPUQueryTemplateString))
+
+// Aggregate memory usage across the given nodes for the given range
+const fluxMemoryQueryTemplateString = `
+from(bucket: "{{.Bucket}}")
+	|> range(start: -{{.Range}})
+	|> filter(fn: (r) => r._measurement == "mem" and r._field == "used_percent")
+	|> filter(fn: (r) => {{.HostFilter}})
+	|> group()
+	|> {{.Aggregation}}()
+`
+
+var fluxMemoryQueryTemplate = template.Must(template.New("flux-mem").Parse(fluxMemoryQueryTemplateString))
+
+// Aggregate CPU usage across the given nodes for each interval between start and end
+const fluxCPURangeQueryTemplateString = `
+from(bucket: "{{.Bucket}}")
+	|> range(start: {{.Start}}, stop: {{.End}})
+	|> filter(fn: (r) => r._measurement == "cpu" and r._field == "usage_idle" and r.cpu == "cpu-total")
+	|> filter(fn: (r) => {{.HostFilter}})
+	|> group()
+	|> aggregateWindow(every: {{.Interval}}, fn: {{.Aggregation}}, createEmpty: false)
+	|> map(fn: (r) => ({r with _value: 100.0 - r._value}))
+`
+
+var fluxCPURangeQueryTemplate = template.Must(template.New("flux-cpu-range").Parse(fluxCPURangeQueryTemplateString))
+
+// Aggregate memory usage across the given nodes for each interval between start and end
+const fluxMemoryRangeQueryTemplateString = `
+from(bucket: "{{.Bucket}}")
+	|> range(start: {{.Start}}, stop: {{.End}})
+	|> filter(fn: (r) => r._measurement == "mem" and r._field == "used_percent")
+	|> filter(fn: (r) => {{.HostFilter}})
+	|> group()
+	|> aggregateWindow(every: {{.Interval}}, fn: {{.Aggregation}}, createEmpty: false)
+`
+
+var fluxMemoryRangeQueryTemplate = template.Must(template.New("flux-mem-range").Parse(fluxMemoryRangeQueryTemplateString))
+
+// NewV2Client returns a new client for talking to an InfluxDB 2.x Backend in
+// the given org using the given token, or an error. The HTTP config is used to
+// configure TLS and add extra headers to requests, but must not specify other
+// credentials.
+func NewV2Client(address string, org string, token string, httpConfig httpconfig.Config,
+	nodeLister corelistersv1.NodeLister) (metrics.Backend, error) {
+	if address == "" {
+		return nil, errors.New("address must not be empty")
+	}
+
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing address")
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.Errorf("unsupported protocol scheme %q, address should be of the form scheme://host:<port>", u.Scheme)
+	}
+
+	if org == "" {
+		return nil, errors.New("org must not be empty")
+	}
+
+	if token == "" {
+		return nil, errors.New("token must not be empty")
+	}
+
+	if nodeLister == nil {
+		return nil, errors.New("node lister must be provided")
+	}
+
+	if httpConfig.BearerToken != "" || httpConfig.Username != "" {
+		return nil, errors.New("InfluxDB 2.x only supports token authentication")
+	}
+
+	roundTripper, err := httpConfig.RoundTripper()
+	if err != nil {
+		return nil, errors.Wrap(err, "configuring HTTP transport")
+	}
+
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/api/v2/query"
+	u.RawQuery = url.Values{"org": []string{org}}.Encode()
+
+	return FluxBackend{
+		queryURL:   u.String(),
+		token:      token,
+		httpClient: &http.Client{Transport: roundTripper},
+		nodeLister: nodeLister,
+	}, nil
+}
+
+// GetValue implements the metrics.Backend interface
+func (b FluxBackend) GetValue(metric string, configuration map[string]string, nodeSelector map[string]string) (float64, error) {
+	config := fluxMetricConfiguration{}
+	if err := config.defaultAndValidate(configuration); err != nil {
+		return 0, errors.Wrap(err, "validating configuration")
+	}
+
+	hostnames, err := listHostnames(b.nodeLister, nodeSelector, config.NodeLabel)
+	if err != nil {
+		return 0, err
+	}
+
+	if skip, err := handleEmptySelection(hostnames, config.EmptySelection); skip || err != nil {
+		return 0, err
+	}
+
+	config.HostFilter = buildHostFilter(hostnames, config.HostTag)
+
+	var query string
+	switch metric {
+	case MetricCPUPercentUtilization.String():
+		query, err = executeFluxTemplate(fluxCPUQueryTemplate, config)
+
+	case MetricMemoryPercentUtilization.String():
+		query, err = executeFluxTemplate(fluxMemoryQueryTemplate, config)
+
+	case MetricCustom.String():
+		text, ok := configuration["query"]
+		if !ok {
+			return 0, errors.New("configuration key \"query\" must be provided for a custom query")
+		}
+
+		var tmpl *template.Template
+		tmpl, err = template.New("query").Parse(text)
+		if err != nil {
+			return 0, errors.Wrap(err, "parsing custom query template")
+		}
+
+		query, err = executeFluxTemplate(tmpl, config)
+
+	default:
+		return 0, errors.Errorf("unknown metric %q", metric)
+	}
+
+	if err != nil {
+		return 0, errors.Wrapf(err, "building %s query", metric)
+	}
+
+	rows, err := b.performQuery(query)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(rows) != 1 {
+		return 0, errors.Errorf("expected query to return a single value but it returned %d", len(rows))
+	}
+
+	return rows[0].value, nil
+}
+
+// GetValueRange implements the metrics.RangeBackend interface
+func (b FluxBackend) GetValueRange(metric string, configuration map[string]string, nodeSelector map[string]string,
+	start, end time.Time, step time.Duration) ([]metrics.Sample, error) {
+	config := fluxMetricConfiguration{}
+	if err := config.defaultAndValidate(configuration); err != nil {
+		return nil, errors.Wrap(err, "validating configuration")
+	}
+
+	var tmpl *template.Template
+	switch metric {
+	case MetricCPUPercentUtilization.String():
+		tmpl = fluxCPURangeQueryTemplate
+
+	case MetricMemoryPercentUtilization.String():
+		tmpl = fluxMemoryRangeQueryTemplate
+
+	case MetricCustom.String():
+		// A custom query already has its own time constraints which we can't
+		// safely rewrite
+		return nil, errors.New("range queries are not supported for custom metrics")
+
+	default:
+		return nil, errors.Errorf("unknown metric %q", metric)
+	}
+
+	if step < time.Second {
+		return nil, errors.Errorf("step %s must be at least one second", step)
+	}
+
+	hostnames, err := listHostnames(b.nodeLister, nodeSelector, config.NodeLabel)
+	if err != nil {
+		return nil, err
+	}
+
+	if skip, err := handleEmptySelection(hostnames, config.EmptySelection); skip || err != nil {
+		return nil, err
+	}
+
+	config.HostFilter = buildHostFilter(hostnames, config.HostTag)
+	config.Start = start.UTC().Format(time.RFC3339)
+	config.End = end.UTC().Format(time.RFC3339)
+	config.Interval = fmt.Sprintf("%ds", int64(step/time.Second))
+
+	query, err := executeFluxTemplate(tmpl, config)
+	if err != nil {
+		return nil, errors.Wrapf(err, "building %s range query", metric)
+	}
+
+	rows, err := b.performQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	samples := make([]metrics.Sample, 0, len(rows))
+	for _, row := range rows {
+		if row.time.IsZero() {
+			return nil, errors.New("expected range query results to have a _time column")
+		}
+
+		samples = append(samples, metrics.Sample{
+			Timestamp: row.time,
+			Value:     row.value,
+		})
+	}
+
+	return samples, nil
+}
+
+// fluxRow is a single row of a Flux query result
+type fluxRow struct {
+	time  time.Time
+	value float64
+}
+
+// fluxError is the body of an unsuccessful InfluxDB 2.x API response
+type fluxError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (b FluxBackend) performQuery(query string) ([]fluxRow, error) {
+	log.Debugf("Performing InfluxDB Flux query: %s", query)
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"query": query,
+		"type":  "flux",
+		"dialect": map[string]interface{}{
+			"header":      true,
+			"annotations": []string{},
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), fluxRequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodPost, b.queryURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "building InfluxDB query request")
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Token "+b.token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/csv")
+
+	res, err := b.httpClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "querying InfluxDB with query %q", query)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(res.Body)
+
+		var fe fluxError
+		if json.Unmarshal(msg, &fe) == nil && fe.Message != "" {
+			return nil, errors.Errorf("querying InfluxDB with query %q returned status %d: %s",
+				query, res.StatusCode, fe.Message)
+		}
+
+		return nil, errors.Errorf("querying InfluxDB with query %q returned status %d", query, res.StatusCode)
+	}
+
+	rows, err := parseFluxCSV(res.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing result of InfluxDB query %q", query)
+	}
+
+	return rows, nil
+}
+
+// parseFluxCSV parses the _time and _value columns of each row of an
+// unannotated Flux CSV result. Each table in the result may have its own
+// header row, so header rows are detected by their result column.
+func parseFluxCSV(r io.Reader) ([]fluxRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	var rows []fluxRow
+	timeIndex, valueIndex := -1, -1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(record) > 1 && record[1] == "result" {
+			timeIndex, valueIndex = -1, -1
+			for i, column := range record {
+				switch column {
+				case "_time":
+					timeIndex = i
+				case "_value":
+					valueIndex = i
+				}
+			}
+
+			if valueIndex == -1 {
+				return nil, errors.New("result has no _value column")
+			}
+
+			continue
+		}
+
+		if valueIndex == -1 {
+			return nil, errors.New("result has no header row")
+		}
+
+		if valueIndex >= len(record) {
+			return nil, errors.Errorf("row has %d columns but _value is column %d", len(record), valueIndex)
+		}
+
+		value, err := strconv.ParseFloat(record[valueIndex], 64)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing _value")
+		}
+
+		row := fluxRow{value: value}
+		if timeIndex != -1 && timeIndex < len(record) {
+			row.time, err = time.Parse(time.RFC3339Nano, record[timeIndex])
+			if err != nil {
+				return nil, errors.Wrap(err, "parsing _time")
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+func executeFluxTemplate(tmpl *template.Template, config fluxMetricConfiguration) (string, error) {
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, config); err != nil {
+		return "", err
+	}
+
+	return out.String(), nil
+}
+
+// buildHostFilter returns a Flux predicate matching rows from any of the
+// given hosts by the given tag, or matching all rows if there are none
+func buildHostFilter(hostnames []string, tag string) string {
+	if len(hostnames) == 0 {
+		return "true"
+	}
+
+	predicates := make([]string, len(hostnames))
+	for i, hostname := range hostnames {
+		predicates[i] = fmt.Sprintf("r[%s] == %s", strconv.Quote(tag), strconv.Quote(hostname))
+	}
+
+	return strings.Join(predicates, " or ")
+}
@@ -0,0 +1,198 @@
+package influxdb
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	corelistersv1 "k8s.io/client-go/listers/core/v1"
+
+	"github.com/containership/cerebral/pkg/kubernetestest"
+	"github.com/containership/cerebral/pkg/metrics/backends/httpconfig"
+)
+
+const fluxSingleValueCSV = `,result,table,_value
+,_result,0,42.5
+`
+
+const fluxRangeCSV = `,result,table,_time,_value
+,_result,0,2019-01-01T00:00:00Z,1.5
+,_result,0,2019-01-01T00:01:00Z,2.5
+`
+
+// fluxTestServer returns a server that responds to Flux queries with the
+// given status and body and records the last query received
+func fluxTestServer(t *testing.T, status int, body string, query *string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v2/query", r.URL.Path)
+		assert.Equal(t, "my-org", r.URL.Query().Get("org"))
+		assert.Equal(t, "Token my-token", r.Header.Get("Authorization"))
+
+		var req struct {
+			Query string `json:"query"`
+			Type  string `json:"type"`
+		}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "flux", req.Type)
+		if query != nil {
+			*query = req.Query
+		}
+
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+}
+
+func TestNewV2Client(t *testing.T) {
+	client, err := NewV2Client(validURL, "my-org", "my-token", httpconfig.Config{}, corelistersv1.NewNodeLister(nil))
+	assert.NotNil(t, client)
+	assert.NoError(t, err, "valid config is ok")
+	assert.Equal(t, validURL+"/api/v2/query?org=my-org", client.(FluxBackend).queryURL)
+
+	_, err = NewV2Client("", "my-org", "my-token", httpconfig.Config{}, corelistersv1.NewNodeLister(nil))
+	assert.Error(t, err, "error on empty URL")
+
+	_, err = NewV2Client(nonValidURL, "my-org", "my-token", httpconfig.Config{}, corelistersv1.NewNodeLister(nil))
+	assert.Error(t, err, "error on URL without scheme")
+
+	_, err = NewV2Client(validURL, "", "my-token", httpconfig.Config{}, corelistersv1.NewNodeLister(nil))
+	assert.Error(t, err, "error on empty org")
+
+	_, err = NewV2Client(validURL, "my-org", "", httpconfig.Config{}, corelistersv1.NewNodeLister(nil))
+	assert.Error(t, err, "error on empty token")
+
+	_, err = NewV2Client(validURL, "my-org", "my-token", httpconfig.Config{}, nil)
+	assert.Error(t, err, "error on nil NodeLister")
+
+	_, err = NewV2Client(validURL, "my-org", "my-token", httpconfig.Config{
+		InsecureSkipVerify: true,
+		Headers:            map[string]string{"X-Custom": "value"},
+	}, corelistersv1.NewNodeLister(nil))
+	assert.NoError(t, err, "TLS options and extra headers are ok")
+
+	_, err = NewV2Client(validURL, "my-org", "my-token", httpconfig.Config{Username: "cerebral"},
+		corelistersv1.NewNodeLister(nil))
+	assert.Error(t, err, "other credentials are not supported")
+}
+
+func TestFluxGetValue(t *testing.T) {
+	nodes := influxNodes
+
+	var query string
+	server := fluxTestServer(t, http.StatusOK, fluxSingleValueCSV, &query)
+	defer server.Close()
+
+	client, err := NewV2Client(server.URL, "my-org", "my-token", httpconfig.Config{}, kubernetestest.BuildNodeLister(nodes))
+	assert.NoError(t, err)
+
+	val, err := client.GetValue("cpu_percent_utilization", map[string]string{"bucket": "metrics"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 42.5, val)
+	assert.Contains(t, query, `from(bucket: "metrics")`, "bucket is templated")
+	assert.Contains(t, query, `r["host"] == "hostname-0"`, "hostnames are templated")
+
+	_, err = client.GetValue("memory_percent_utilization", emptyConfiguration, nil)
+	assert.NoError(t, err)
+	assert.Contains(t, query, `from(bucket: "telegraf")`, "bucket is defaulted")
+	assert.Contains(t, query, "|> mean()", "aggregation is defaulted")
+
+	_, err = client.GetValue("custom", map[string]string{
+		"query": `from(bucket: "{{.Bucket}}") |> range(start: -{{.Range}}) |> filter(fn: (r) => {{.HostFilter}})`,
+	}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, `from(bucket: "telegraf") |> range(start: -1m) |> filter(fn: (r) => r["host"] == "hostname-0")`, query)
+
+	_, err = client.GetValue("custom", emptyConfiguration, nil)
+	assert.Error(t, err, "custom query requires query")
+
+	_, err = client.GetValue("cpu_percent_utilization", map[string]string{"aggregation": "integral"}, nil)
+	assert.Error(t, err, "invalid aggregation")
+
+	_, err = client.GetValue("not a valid metric", emptyConfiguration, nil)
+	assert.Error(t, err, "unknown metric requested")
+
+	multiple := fluxTestServer(t, http.StatusOK, fluxRangeCSV, nil)
+	defer multiple.Close()
+
+	client, _ = NewV2Client(multiple.URL, "my-org", "my-token", httpconfig.Config{}, kubernetestest.BuildNodeLister(nodes))
+	_, err = client.GetValue("cpu_percent_utilization", emptyConfiguration, nil)
+	assert.Error(t, err, "multiple values error")
+
+	failing := fluxTestServer(t, http.StatusBadRequest, `{"code":"invalid","message":"bad query"}`, nil)
+	defer failing.Close()
+
+	client, _ = NewV2Client(failing.URL, "my-org", "my-token", httpconfig.Config{}, kubernetestest.BuildNodeLister(nodes))
+	_, err = client.GetValue("cpu_percent_utilization", emptyConfiguration, nil)
+	if assert.Error(t, err, "error when InfluxDB errors") {
+		assert.Contains(t, err.Error(), "bad query", "error message is surfaced")
+	}
+}
+
+func TestFluxGetValueRange(t *testing.T) {
+	var query string
+	server := fluxTestServer(t, http.StatusOK, fluxRangeCSV, &query)
+	defer server.Close()
+
+	client, err := NewV2Client(server.URL, "my-org", "my-token", httpconfig.Config{},
+		kubernetestest.BuildNodeLister(influxNodes))
+	assert.NoError(t, err)
+
+	backend := client.(FluxBackend)
+	end := time.Date(2019, 1, 1, 0, 1, 0, 0, time.UTC)
+	start := end.Add(-time.Minute)
+
+	samples, err := backend.GetValueRange("memory_percent_utilization", emptyConfiguration, nil, start, end, time.Minute)
+	assert.NoError(t, err)
+	assert.Len(t, samples, 2)
+	assert.Equal(t, end, samples[1].Timestamp)
+	assert.Equal(t, 2.5, samples[1].Value)
+	assert.Contains(t, query, "range(start: 2019-01-01T00:00:00Z, stop: 2019-01-01T00:01:00Z)")
+	assert.Contains(t, query, "aggregateWindow(every: 60s, fn: mean, createEmpty: false)")
+
+	_, err = backend.GetValueRange("cpu_percent_utilization", emptyConfiguration, nil, start, end, time.Millisecond)
+	assert.Error(t, err, "step too small")
+
+	_, err = backend.GetValueRange("custom", goodCustomQueryConfiguration, nil, start, end, time.Minute)
+	assert.Error(t, err, "custom range queries are not supported")
+}
+
+func TestParseFluxCSV(t *testing.T) {
+	rows, err := parseFluxCSV(strings.NewReader(""))
+	assert.NoError(t, err)
+	assert.Empty(t, rows, "empty result")
+
+	rows, err = parseFluxCSV(strings.NewReader(fluxRangeCSV))
+	assert.NoError(t, err)
+	assert.Len(t, rows, 2)
+	assert.Equal(t, time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC), rows[0].time)
+	assert.Equal(t, 1.5, rows[0].value)
+
+	// Tables with different schemas each have their own header
+	rows, err = parseFluxCSV(strings.NewReader(fluxSingleValueCSV + "\n" + fluxRangeCSV))
+	assert.NoError(t, err)
+	assert.Len(t, rows, 3)
+	assert.True(t, rows[0].time.IsZero(), "no _time column")
+
+	_, err = parseFluxCSV(strings.NewReader(",_result,0,1\n"))
+	assert.Error(t, err, "no header row")
+
+	_, err = parseFluxCSV(strings.NewReader(",result,table\n"))
+	assert.Error(t, err, "no _value column")
+
+	_, err = parseFluxCSV(strings.NewReader(",result,table,_value\n,_result,0,abc\n"))
+	assert.Error(t, err, "invalid value")
+}
+
+func TestBuildHostFilter(t *testing.T) {
+	assert.Equal(t, "true", buildHostFilter(noHostnames, "host"), "no hostnames match all hosts")
+	assert.Equal(t, `r["host"] == "hostname-0"`, buildHostFilter(oneHostname, "host"))
+	assert.Equal(t, `r["host"] == "hostname-0" or r["host"] == "hostname-1" or r["host"] == "hostname-2"`,
+		buildHostFilter(multipleHostnames, "host"))
+	assert.Equal(t, `r["hostname"] == "say \"hi\""`, buildHostFilter([]string{`say "hi"`}, "hostname"),
+		"custom tag and escaped hostname")
+}
@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"text/template"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -13,11 +15,13 @@ import (
 	corelistersv1 "k8s.io/client-go/listers/core/v1"
 
 	"github.com/containership/cerebral/pkg/metrics"
+	"github.com/containership/cerebral/pkg/metrics/backends/httpconfig"
 	"github.com/containership/cerebral/pkg/nodeutil"
 	"github.com/containership/cluster-manager/pkg/log"
 )
 
-// Backend implements a metrics backend for InfluxDB.
+// Backend implements a metrics backend for InfluxDB 1.x, which is queried
+// using InfluxQL.
 type Backend struct {
 	influxDB influxdbclient.Client
 
@@ -40,8 +44,28 @@ WHERE time > now() - {{.Range}} AND {{.HostList}}
 
 var memoryQueryTemplate = template.Must(template.New("mem").Parse(memoryQueryTemplateString))
 
-// NewClient returns a new client for talking to an InfluxDB Backend, or an error
-func NewClient(address string, nodeLister corelistersv1.NodeLister) (metrics.Backend, error) {
+// Aggregate CPU usage across the given nodes for each interval between start and end
+const cpuRangeQueryTemplateString = `
+SELECT {{.Aggregation}}("usage_idle") AS "mean_usage_idle" FROM "{{.Database}}"."{{.RetentionPolicy}}"."cpu"
+WHERE time > '{{.Start}}' AND time <= '{{.End}}' AND {{.HostList}}
+GROUP BY time({{.Interval}}) fill(none)
+`
+
+var cpuRangeQueryTemplate = template.Must(template.New("cpu-range").Parse(cpuRangeQueryTemplateString))
+
+// Aggregate memory usage across the given nodes for each interval between start and end
+const memoryRangeQueryTemplateString = `
+SELECT {{.Aggregation}}("used_percent") AS "mean_used_percent" FROM "{{.Database}}"."{{.RetentionPolicy}}"."mem"
+WHERE time > '{{.Start}}' AND time <= '{{.End}}' AND {{.HostList}}
+GROUP BY time({{.Interval}}) fill(none)
+`
+
+var memoryRangeQueryTemplate = template.Must(template.New("mem-range").Parse(memoryRangeQueryTemplateString))
+
+// NewClient returns a new client for talking to an InfluxDB Backend, or an
+// error. InfluxDB 1.x only supports basic auth, so the HTTP config must not
+// specify a bearer token or extra headers.
+func NewClient(address string, httpConfig httpconfig.Config, nodeLister corelistersv1.NodeLister) (metrics.Backend, error) {
 	if address == "" {
 		// As explicitly stated in the InfluxDB client,
 		// Addr should be of the form "http://host:<port>"
@@ -53,8 +77,21 @@ func NewClient(address string, nodeLister corelistersv1.NodeLister) (metrics.Bac
 		return nil, errors.New("node lister must be provided")
 	}
 
+	if httpConfig.BearerToken != "" || len(httpConfig.Headers) != 0 {
+		return nil, errors.New("bearer tokens and extra headers are not supported for InfluxDB 1.x")
+	}
+
+	tlsConfig, err := httpConfig.TLSConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "configuring TLS")
+	}
+
 	client, err := influxdbclient.NewHTTPClient(influxdbclient.HTTPConfig{
-		Addr: address,
+		Addr:               address,
+		Username:           httpConfig.Username,
+		Password:           httpConfig.Password,
+		InsecureSkipVerify: httpConfig.InsecureSkipVerify,
+		TLSConfig:          tlsConfig,
 	})
 
 	if err != nil {
@@ -69,17 +106,6 @@ func NewClient(address string, nodeLister corelistersv1.NodeLister) (metrics.Bac
 
 // GetValue implements the metrics.Backend interface
 func (b Backend) GetValue(metric string, configuration map[string]string, nodeSelector map[string]string) (float64, error) {
-	selector := nodeutil.GetNodesLabelSelector(nodeSelector)
-	nodes, err := b.nodeLister.List(selector)
-	if err != nil {
-		return 0, errors.Wrap(err, "listing nodes")
-	}
-
-	hostnames := make([]string, len(nodes))
-	for i, node := range nodes {
-		hostnames[i] = node.ObjectMeta.Labels["kubernetes.io/hostname"]
-	}
-
 	// default and validate the configuration before using it to build
 	// and perform the query to InfluxDB
 	config := metricConfiguration{}
@@ -87,6 +113,15 @@ func (b Backend) GetValue(metric string, configuration map[string]string, nodeSe
 		return 0, errors.Wrap(err, "validating configuration")
 	}
 
+	hostnames, err := listHostnames(b.nodeLister, nodeSelector, config.NodeLabel)
+	if err != nil {
+		return 0, err
+	}
+
+	if skip, err := handleEmptySelection(hostnames, config.EmptySelection); skip || err != nil {
+		return 0, err
+	}
+
 	switch metric {
 	case MetricCPUPercentUtilization.String():
 		query, err := buildCPUQuery(hostnames, configuration)
@@ -114,6 +149,48 @@ func (b Backend) GetValue(metric string, configuration map[string]string, nodeSe
 	}
 }
 
+// GetValueRange implements the metrics.RangeBackend interface
+func (b Backend) GetValueRange(metric string, configuration map[string]string, nodeSelector map[string]string,
+	start, end time.Time, step time.Duration) ([]metrics.Sample, error) {
+	config := metricConfiguration{}
+	if err := config.defaultAndValidate(configuration); err != nil {
+		return nil, errors.Wrap(err, "validating configuration")
+	}
+
+	var tmpl *template.Template
+	switch metric {
+	case MetricCPUPercentUtilization.String():
+		tmpl = cpuRangeQueryTemplate
+
+	case MetricMemoryPercentUtilization.String():
+		tmpl = memoryRangeQueryTemplate
+
+	case MetricCustom.String():
+		// A custom query already has its own time constraints which we can't
+		// safely rewrite
+		return nil, errors.New("range queries are not supported for custom metrics")
+
+	default:
+		return nil, errors.Errorf("unknown metric %q", metric)
+	}
+
+	hostnames, err := listHostnames(b.nodeLister, nodeSelector, config.NodeLabel)
+	if err != nil {
+		return nil, err
+	}
+
+	if skip, err := handleEmptySelection(hostnames, config.EmptySelection); skip || err != nil {
+		return nil, err
+	}
+
+	query, err := buildRangeQuery(tmpl, hostnames, configuration, start, end, step)
+	if err != nil {
+		return nil, errors.Wrapf(err, "building %s range query", metric)
+	}
+
+	return b.performRangeQuery(config.Database, query)
+}
+
 func (b Backend) performQuery(db string, query string) (float64, error) {
 	log.Debugf("Performing InfluxDB query: %s", query)
 
@@ -154,13 +231,76 @@ func (b Backend) performQuery(db string, query string) (float64, error) {
 	return result, nil
 }
 
+func (b Backend) performRangeQuery(db string, query string) ([]metrics.Sample, error) {
+	log.Debugf("Performing InfluxDB range query: %s", query)
+
+	res, err := b.influxDB.Query(influxdbclient.Query{
+		Command:  query,
+		Database: db,
+	})
+
+	switch {
+	case err != nil:
+		return nil, errors.Wrapf(err, "querying InfluxDB with string %q", query)
+
+	case res == nil:
+		return nil, errors.Errorf("querying InfluxDB with string %q returned nil", query)
+
+	case res.Error() != nil:
+		return nil, errors.Wrapf(res.Error(), "querying InfluxDB with string %q", query)
+
+	case len(res.Results) != 1:
+		return nil, errors.New("querying InfluxDB returned an unexpected number of results")
+	}
+
+	series := res.Results[0].Series
+	if len(series) != 1 {
+		return nil, errors.New("expected Series to have an item")
+	}
+
+	var samples []metrics.Sample
+	for _, row := range series[0].Values {
+		if len(row) != 2 || row[1] == nil {
+			// Intervals without any data have no value
+			continue
+		}
+
+		ts, ok := row[0].(string)
+		if !ok {
+			return nil, errors.Errorf("unexpected timestamp type %T", row[0])
+		}
+
+		t, err := time.Parse(time.RFC3339Nano, ts)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing timestamp")
+		}
+
+		num, ok := row[1].(json.Number)
+		if !ok {
+			return nil, errors.Errorf("unexpected value type %T", row[1])
+		}
+
+		val, err := num.Float64()
+		if err != nil {
+			return nil, err
+		}
+
+		samples = append(samples, metrics.Sample{
+			Timestamp: t,
+			Value:     val,
+		})
+	}
+
+	return samples, nil
+}
+
 func buildCPUQuery(hostnames []string, configuration map[string]string) (string, error) {
 	config := metricConfiguration{}
 	if err := config.defaultAndValidate(configuration); err != nil {
 		return "", errors.Wrap(err, "validating configuration")
 	}
 
-	config.HostList = buildHostList(hostnames)
+	config.HostList = buildHostList(hostnames, config.HostTag)
 
 	var out bytes.Buffer
 	if err := cpuQueryTemplate.Execute(&out, config); err != nil {
@@ -176,7 +316,7 @@ func buildMemoryQuery(hostnames []string, configuration map[string]string) (stri
 		return "", errors.Wrap(err, "validating configuration")
 	}
 
-	config.HostList = buildHostList(hostnames)
+	config.HostList = buildHostList(hostnames, config.HostTag)
 
 	var out bytes.Buffer
 	if err := memoryQueryTemplate.Execute(&out, config); err != nil {
@@ -186,18 +326,22 @@ func buildMemoryQuery(hostnames []string, configuration map[string]string) (stri
 	return out.String(), nil
 }
 
-// For a custom query, there should be a single `query` key provided in the
-// configuration map. No further configuration keys are currently supported.
+// For a custom query, a `query` key must be provided in the configuration
+// map. The host mapping configuration keys are also supported.
 func buildCustomQuery(hostnames []string, configuration map[string]string) (string, error) {
 	var query string
 	var ok bool
 	query, ok = configuration["query"]
 	if !ok {
-		return "", errors.New("single configuration key \"query\" must be provided for a custom query")
+		return "", errors.New("configuration key \"query\" must be provided for a custom query")
 	}
 
 	config := metricConfiguration{}
-	config.HostList = buildHostList(hostnames)
+	if err := config.defaultAndValidate(configuration); err != nil {
+		return "", errors.Wrap(err, "validating configuration")
+	}
+
+	config.HostList = buildHostList(hostnames, config.HostTag)
 
 	template, err := template.New("query").Parse(query)
 	if err != nil {
@@ -212,7 +356,75 @@ func buildCustomQuery(hostnames []string, configuration map[string]string) (stri
 	return out.String(), nil
 }
 
-func buildHostList(hostnames []string) string {
+func buildRangeQuery(tmpl *template.Template, hostnames []string, configuration map[string]string,
+	start, end time.Time, step time.Duration) (string, error) {
+	config := metricConfiguration{}
+	if err := config.defaultAndValidate(configuration); err != nil {
+		return "", errors.Wrap(err, "validating configuration")
+	}
+
+	if step < time.Second {
+		return "", errors.Errorf("step %s must be at least one second", step)
+	}
+
+	config.HostList = buildHostList(hostnames, config.HostTag)
+	config.Start = start.UTC().Format(time.RFC3339)
+	config.End = end.UTC().Format(time.RFC3339)
+	config.Interval = fmt.Sprintf("%ds", int64(step/time.Second))
+
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, config); err != nil {
+		return "", err
+	}
+
+	return out.String(), nil
+}
+
+// listHostnames returns the host identities of the nodes matching the node
+// selector, which are the values of the given node label
+func listHostnames(nodeLister corelistersv1.NodeLister, nodeSelector map[string]string,
+	nodeLabel string) ([]string, error) {
+	selector := nodeutil.GetNodesLabelSelector(nodeSelector)
+	nodes, err := nodeLister.List(selector)
+	if err != nil {
+		return nil, errors.Wrap(err, "listing nodes")
+	}
+
+	hostnames := make([]string, len(nodes))
+	for i, node := range nodes {
+		hostname, ok := node.ObjectMeta.Labels[nodeLabel]
+		if !ok {
+			return nil, errors.Errorf("node %s does not have label %s", node.ObjectMeta.Name, nodeLabel)
+		}
+
+		hostnames[i] = hostname
+	}
+
+	return hostnames, nil
+}
+
+// handleEmptySelection returns true if no hosts are selected and the query
+// should be skipped, or an error if no hosts are selected and that's not
+// allowed by the given behavior. Otherwise, the query should be performed.
+func handleEmptySelection(hostnames []string, behavior string) (bool, error) {
+	if len(hostnames) != 0 {
+		return false, nil
+	}
+
+	switch behavior {
+	case emptySelectionZero:
+		return true, nil
+
+	case emptySelectionAll:
+		return false, nil
+	}
+
+	return false, errors.New("no nodes match the node selector")
+}
+
+// buildHostList returns an InfluxQL condition matching any of the given
+// hosts by the given tag, or matching all hosts if there are none
+func buildHostList(hostnames []string, tag string) string {
 	// if hostnames is nil or of length zero, simply return "(true)"
 	// to match all nodes
 	if hostnames == nil || len(hostnames) == 0 {
@@ -221,7 +433,8 @@ func buildHostList(hostnames []string) string {
 
 	var hostList string
 	for i, hostname := range hostnames {
-		hostList += fmt.Sprintf("\"host\"='%s'", hostname)
+		hostname = strings.Replace(hostname, `'`, `\'`, -1)
+		hostList += fmt.Sprintf("\"%s\"='%s'", tag, hostname)
 		if i != len(hostnames)-1 {
 			hostList += " OR "
 		}
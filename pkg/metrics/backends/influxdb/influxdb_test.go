@@ -3,7 +3,9 @@ package influxdb
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -12,9 +14,12 @@ import (
 	"github.com/influxdata/influxdb/models"
 
 	"github.com/containership/cerebral/pkg/metrics/backends/influxdb/mocks"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	corelistersv1 "k8s.io/client-go/listers/core/v1"
 
 	"github.com/containership/cerebral/pkg/kubernetestest"
+	"github.com/containership/cerebral/pkg/metrics/backends/httpconfig"
 )
 
 var (
@@ -41,25 +46,50 @@ var (
 	noHostnames       []string
 	oneHostname       = []string{"hostname-0"}
 	multipleHostnames = []string{"hostname-0", "hostname-1", "hostname-2"}
+
+	influxNodes = []corev1.Node{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "node-0",
+				Labels: map[string]string{
+					"kubernetes.io/hostname": "hostname-0",
+					"example.com/host-id":    "id-0",
+				},
+			},
+		},
+	}
 )
 
 func TestNewClient(t *testing.T) {
-	client, err := NewClient(validURL, corelistersv1.NewNodeLister(nil))
+	client, err := NewClient(validURL, httpconfig.Config{}, corelistersv1.NewNodeLister(nil))
 	assert.NotNil(t, client)
 	assert.NoError(t, err, "any valid URL is ok")
 
-	_, err = NewClient(nonValidURL, corelistersv1.NewNodeLister(nil))
+	_, err = NewClient(nonValidURL, httpconfig.Config{}, corelistersv1.NewNodeLister(nil))
 	assert.Error(t, err, "check non valid URL returns error")
 
-	_, err = NewClient("", corelistersv1.NewNodeLister(nil))
+	_, err = NewClient("", httpconfig.Config{}, corelistersv1.NewNodeLister(nil))
 	assert.Error(t, err, "error on empty URL")
 
-	_, err = NewClient(validURL, nil)
+	_, err = NewClient(validURL, httpconfig.Config{}, nil)
 	assert.Error(t, err, "error on nil NodeLister")
+
+	_, err = NewClient(validURL, httpconfig.Config{
+		Username:           "cerebral",
+		Password:           "password",
+		InsecureSkipVerify: true,
+	}, corelistersv1.NewNodeLister(nil))
+	assert.NoError(t, err, "basic auth and TLS options are ok")
+
+	_, err = NewClient(validURL, httpconfig.Config{BearerToken: "token"}, corelistersv1.NewNodeLister(nil))
+	assert.Error(t, err, "bearer tokens are not supported")
+
+	_, err = NewClient(validURL, httpconfig.Config{CA: []byte("invalid")}, corelistersv1.NewNodeLister(nil))
+	assert.Error(t, err, "error on invalid CA bundle")
 }
 
 func TestGetValue(t *testing.T) {
-	nodeLister := kubernetestest.BuildNodeLister(nil)
+	nodeLister := kubernetestest.BuildNodeLister(influxNodes)
 
 	mockInfluxDB := mocks.Client{}
 	// Return error
@@ -225,6 +255,138 @@ func TestGetValue(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestGetValueRange(t *testing.T) {
+	nodeLister := kubernetestest.BuildNodeLister(influxNodes)
+
+	mockInfluxDB := mocks.Client{}
+	backend := Backend{
+		influxDB:   &mockInfluxDB,
+		nodeLister: nodeLister,
+	}
+
+	end := time.Date(2018, time.December, 25, 16, 13, 0, 0, time.UTC)
+	start := end.Add(-time.Minute)
+
+	// Return error
+	mockInfluxDB.On("Query", mock.Anything).
+		Return(nil, fmt.Errorf("some InfluxDB error")).Once()
+
+	_, err := backend.GetValueRange("cpu_percent_utilization", goodConfiguration, nil, start, end, 30*time.Second)
+	assert.Error(t, err, "error when InfluxDB errors")
+
+	// Return multiple intervals, one of which has no data
+	mockInfluxDB.On("Query", mock.Anything).
+		Return(&influxdbclient.Response{
+			Results: []influxdbclient.Result{
+				{
+					Series: []models.Row{
+						{
+							Name:    "cpu",
+							Columns: []string{"time", "mean_usage_idle"},
+							Values: [][]interface{}{
+								{"2018-12-25T16:12:00Z", json.Number("36.5")},
+								{"2018-12-25T16:12:30Z", nil},
+								{"2018-12-25T16:13:00Z", json.Number("40")},
+							},
+						},
+					},
+				},
+			},
+		}, nil).Once()
+
+	samples, err := backend.GetValueRange("cpu_percent_utilization", goodConfiguration, nil, start, end, 30*time.Second)
+	assert.NoError(t, err, "multiple intervals are ok")
+	assert.Len(t, samples, 2, "intervals without data are skipped")
+	assert.Equal(t, end, samples[1].Timestamp)
+	assert.Equal(t, 40.0, samples[1].Value)
+
+	_, err = backend.GetValueRange("custom", goodCustomQueryConfiguration, nil, start, end, 30*time.Second)
+	assert.Error(t, err, "custom range queries are not supported")
+
+	_, err = backend.GetValueRange("unknown", goodConfiguration, nil, start, end, 30*time.Second)
+	assert.Error(t, err, "unknown metric requested")
+}
+
+func TestGetValueHostMapping(t *testing.T) {
+	mockInfluxDB := mocks.Client{}
+	mockInfluxDB.On("Query", mock.MatchedBy(func(q influxdbclient.Query) bool {
+		return strings.Contains(q.Command, `("host_id"='id-0')`)
+	})).Return(&influxdbclient.Response{
+		Results: []influxdbclient.Result{
+			{
+				Series: []models.Row{
+					{
+						Name:    "cpu",
+						Columns: []string{"time", "mean_usage_idle"},
+						Values: [][]interface{}{
+							{"2018-12-25T16:12:06.249608977Z", json.Number("10")},
+						},
+					},
+				},
+			},
+		},
+	}, nil).Once()
+
+	backend := Backend{
+		influxDB:   &mockInfluxDB,
+		nodeLister: kubernetestest.BuildNodeLister(influxNodes),
+	}
+
+	val, err := backend.GetValue("cpu_percent_utilization", map[string]string{
+		"nodeLabel": "example.com/host-id",
+		"hostTag":   "host_id",
+	}, nil)
+	assert.NoError(t, err, "node label and host tag are configurable")
+	assert.Equal(t, 10.0, val)
+
+	_, err = backend.GetValue("cpu_percent_utilization", map[string]string{
+		"nodeLabel": "example.com/missing",
+	}, nil)
+	assert.Error(t, err, "error if a node is missing the node label")
+
+	noNodes := map[string]string{"no": "match"}
+	_, err = backend.GetValue("cpu_percent_utilization", emptyConfiguration, noNodes)
+	assert.Error(t, err, "error on empty selection by default")
+
+	val, err = backend.GetValue("cpu_percent_utilization", map[string]string{
+		"emptySelection": "zero",
+	}, noNodes)
+	assert.NoError(t, err, "zero on empty selection")
+	assert.Equal(t, 0.0, val)
+
+	samples, err := backend.GetValueRange("cpu_percent_utilization", map[string]string{
+		"emptySelection": "zero",
+	}, noNodes, time.Unix(0, 0), time.Unix(60, 0), time.Minute)
+	assert.NoError(t, err, "no samples on empty selection")
+	assert.Empty(t, samples)
+
+	mockInfluxDB.On("Query", mock.MatchedBy(func(q influxdbclient.Query) bool {
+		return strings.Contains(q.Command, "(true)")
+	})).Return(nil, fmt.Errorf("some InfluxDB error")).Once()
+
+	_, err = backend.GetValue("cpu_percent_utilization", map[string]string{
+		"emptySelection": "all",
+	}, noNodes)
+	assert.Error(t, err, "all hosts are queried on empty selection")
+	mockInfluxDB.AssertExpectations(t)
+}
+
+func TestBuildRangeQuery(t *testing.T) {
+	end := time.Date(2018, time.December, 25, 16, 13, 0, 0, time.UTC)
+	start := end.Add(-time.Hour)
+
+	query, err := buildRangeQuery(cpuRangeQueryTemplate, oneHostname, goodConfiguration, start, end, time.Minute)
+	assert.NoError(t, err, "good configuration is ok")
+	assert.Contains(t, query, "time > '2018-12-25T15:13:00Z' AND time <= '2018-12-25T16:13:00Z'")
+	assert.Contains(t, query, "GROUP BY time(60s)")
+
+	_, err = buildRangeQuery(cpuRangeQueryTemplate, oneHostname, goodConfiguration, start, end, time.Millisecond)
+	assert.Error(t, err, "sub-second step errors")
+
+	_, err = buildRangeQuery(memoryRangeQueryTemplate, oneHostname, badAggregationConfiguration, start, end, time.Minute)
+	assert.Error(t, err, "invalid aggregation errors")
+}
+
 func TestBuildCPUQuery(t *testing.T) {
 	_, err := buildCPUQuery(oneHostname, goodConfiguration)
 	assert.NoError(t, err, "good configuration is ok")
@@ -256,15 +418,18 @@ func TestBuildCustomQuery(t *testing.T) {
 }
 
 func TestBuildHostList(t *testing.T) {
-	hostList := buildHostList(nil)
+	hostList := buildHostList(nil, "host")
 	assert.Equal(t, "(true)", hostList, "nil hostnames results in (true)")
 
-	hostList = buildHostList(noHostnames)
+	hostList = buildHostList(noHostnames, "host")
 	assert.Equal(t, "(true)", hostList, "no hostnames results in (true)")
 
-	hostList = buildHostList(oneHostname)
+	hostList = buildHostList(oneHostname, "host")
 	assert.Equal(t, "(\"host\"='hostname-0')", hostList, "single hostname hostList")
 
-	hostList = buildHostList(multipleHostnames)
+	hostList = buildHostList(multipleHostnames, "host")
 	assert.Equal(t, "(\"host\"='hostname-0' OR \"host\"='hostname-1' OR \"host\"='hostname-2')", hostList, "multiple hostname hostList")
+
+	hostList = buildHostList([]string{"it's"}, "hostname")
+	assert.Equal(t, `("hostname"='it\'s')`, hostList, "custom tag and escaped hostname")
 }
@@ -3,6 +3,7 @@ package influxdb
 import (
 	"encoding/json"
 	"regexp"
+	"strings"
 
 	"github.com/pkg/errors"
 )
@@ -55,6 +56,54 @@ const defaultDatabase = "telegraf"
 const defaultRange = "1m"
 const defaultRetentionPolicy = "rp_90d"
 
+const (
+	// emptySelectionError returns an error if no nodes are selected
+	emptySelectionError = "error"
+	// emptySelectionAll queries all hosts if no nodes are selected
+	emptySelectionAll = "all"
+	// emptySelectionZero returns zero without querying if no nodes are selected
+	emptySelectionZero = "zero"
+)
+
+const defaultNodeLabel = "kubernetes.io/hostname"
+const defaultHostTag = "host"
+const defaultEmptySelection = emptySelectionError
+
+// hostMapping configures how nodes are identified in InfluxDB
+type hostMapping struct {
+	// NodeLabel is the node label containing the node's host identity
+	NodeLabel string `json:"nodeLabel"`
+	// HostTag is the tag containing the host identity in InfluxDB
+	HostTag string `json:"hostTag"`
+	// EmptySelection specifies what to do if no nodes are selected
+	EmptySelection string `json:"emptySelection"`
+}
+
+func (h *hostMapping) defaultAndValidate() error {
+	if h.NodeLabel == "" {
+		h.NodeLabel = defaultNodeLabel
+	}
+
+	if h.HostTag == "" {
+		h.HostTag = defaultHostTag
+	}
+
+	if strings.ContainsAny(h.HostTag, `"\`) {
+		return errors.Errorf("invalid host tag %s", h.HostTag)
+	}
+
+	if h.EmptySelection == "" {
+		h.EmptySelection = defaultEmptySelection
+	}
+
+	switch h.EmptySelection {
+	case emptySelectionError, emptySelectionAll, emptySelectionZero:
+		return nil
+	}
+
+	return errors.Errorf("invalid empty selection %s", h.EmptySelection)
+}
+
 // TODO consider splitting into multiple types instead of overloading this
 // single struct and ignoring irrelevant fields
 type metricConfiguration struct {
@@ -64,9 +113,16 @@ type metricConfiguration struct {
 	Range           string `json:"range"`
 	RetentionPolicy string `json:"retentionPolicy"`
 
+	hostMapping
+
 	// -- Not user-specifiable
 	// Unfortunately we're required to export these fields for use in templates
 	HostList string
+
+	// Only used for range queries
+	Start    string
+	End      string
+	Interval string
 }
 
 // defaults and validates the metricConfiguration. Intended to be called with an
@@ -92,6 +148,10 @@ func (c *metricConfiguration) defaultAndValidate(configuration map[string]string
 		return err
 	}
 
+	if err := c.hostMapping.defaultAndValidate(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -136,3 +196,80 @@ func (c *metricConfiguration) defaultAndValidateRetentionPolicy() error {
 
 	return nil
 }
+
+// See https://v2.docs.influxdata.com/v2.0/reference/flux/stdlib/built-in/transformations/aggregates/
+var validFluxAggregations = []string{
+	"count",  // number of non-null field values
+	"mean",   // arithmetic mean (average) of field values
+	"median", // middle value from a sorted list of field values
+	"mode",   // most frequent value in a list of field values
+	"spread", // difference between the minimum and maximum field values
+	"stddev", // standard deviation of field values
+	"sum",    // sum of field values
+	"max",    // greatest field value
+	"min",    // lowest field value
+}
+
+const defaultBucket = "telegraf"
+
+// fluxMetricConfiguration is the metric configuration used for InfluxDB 2.x
+type fluxMetricConfiguration struct {
+	// -- Generic
+	Aggregation string `json:"aggregation"`
+	Bucket      string `json:"bucket"`
+	Range       string `json:"range"`
+
+	hostMapping
+
+	// -- Not user-specifiable
+	// Unfortunately we're required to export these fields for use in templates
+	HostFilter string `json:"-"`
+
+	// Only used for range queries
+	Start    string `json:"-"`
+	End      string `json:"-"`
+	Interval string `json:"-"`
+}
+
+// defaults and validates the fluxMetricConfiguration. Intended to be called
+// with an empty struct that we'll fill in here using the caller-provided
+// configuration.
+func (c *fluxMetricConfiguration) defaultAndValidate(configuration map[string]string) error {
+	// Round trip the config through JSON parser to populate our struct
+	j, _ := json.Marshal(configuration)
+	json.Unmarshal(j, c)
+
+	if c.Aggregation == "" {
+		c.Aggregation = defaultAggregation
+	}
+
+	valid := false
+	for _, a := range validFluxAggregations {
+		if a == c.Aggregation {
+			valid = true
+			break
+		}
+	}
+
+	if !valid {
+		return errors.Errorf("invalid aggregation %s", c.Aggregation)
+	}
+
+	if c.Bucket == "" {
+		c.Bucket = defaultBucket
+	}
+
+	if strings.ContainsAny(c.Bucket, `"\`) {
+		return errors.Errorf("invalid bucket %s", c.Bucket)
+	}
+
+	if c.Range == "" {
+		c.Range = defaultRange
+	}
+
+	if !validRangeRegex.MatchString(c.Range) {
+		return errors.Errorf("invalid range %s", c.Range)
+	}
+
+	return c.hostMapping.defaultAndValidate()
+}
@@ -55,3 +55,73 @@ func TestDefaultAndValidate(t *testing.T) {
 	assert.Error(t, err, "bad range")
 
 }
+
+func TestFluxDefaultAndValidate(t *testing.T) {
+	c := fluxMetricConfiguration{}
+	err := c.defaultAndValidate(nil)
+	assert.NoError(t, err, "nil config provided is ok")
+	assert.Equal(t, defaultAggregation, c.Aggregation, "aggregation defaulted")
+	assert.Equal(t, defaultBucket, c.Bucket, "bucket defaulted")
+	assert.Equal(t, defaultRange, c.Range, "range defaulted")
+
+	c = fluxMetricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{
+		"aggregation": "stddev",
+		"bucket":      "metrics",
+		"range":       "5m",
+	})
+	assert.NoError(t, err, "good config")
+	assert.Equal(t, "stddev", c.Aggregation, "aggregation not defaulted if provided")
+	assert.Equal(t, "metrics", c.Bucket, "bucket not defaulted if provided")
+	assert.Equal(t, "5m", c.Range, "range not defaulted if provided")
+
+	c = fluxMetricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{
+		"aggregation": "stdev",
+	})
+	assert.Error(t, err, "InfluxQL aggregation is not valid in Flux")
+
+	c = fluxMetricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{
+		"bucket": `bad"bucket`,
+	})
+	assert.Error(t, err, "bad bucket")
+
+	c = fluxMetricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{
+		"range": "BADBADNOTGOOD",
+	})
+	assert.Error(t, err, "bad range")
+}
+
+func TestHostMappingDefaultAndValidate(t *testing.T) {
+	c := metricConfiguration{}
+	err := c.defaultAndValidate(nil)
+	assert.NoError(t, err, "nil config provided is ok")
+	assert.Equal(t, defaultNodeLabel, c.NodeLabel, "node label defaulted")
+	assert.Equal(t, defaultHostTag, c.HostTag, "host tag defaulted")
+	assert.Equal(t, defaultEmptySelection, c.EmptySelection, "empty selection defaulted")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{
+		"nodeLabel":      "example.com/host-id",
+		"hostTag":        "host_id",
+		"emptySelection": "zero",
+	})
+	assert.NoError(t, err, "good config")
+	assert.Equal(t, "example.com/host-id", c.NodeLabel, "node label not defaulted if provided")
+	assert.Equal(t, "host_id", c.HostTag, "host tag not defaulted if provided")
+	assert.Equal(t, "zero", c.EmptySelection, "empty selection not defaulted if provided")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{
+		"hostTag": `bad"tag`,
+	})
+	assert.Error(t, err, "bad host tag")
+
+	f := fluxMetricConfiguration{}
+	err = f.defaultAndValidate(map[string]string{
+		"emptySelection": "BADBADNOTGOOD",
+	})
+	assert.Error(t, err, "bad empty selection")
+}
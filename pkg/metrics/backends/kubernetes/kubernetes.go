@@ -1,37 +1,46 @@
 package kubernetes
 
 import (
+	"math"
+
 	"github.com/pkg/errors"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	corelistersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
 
 	"github.com/containership/cerebral/pkg/metrics"
 	"github.com/containership/cerebral/pkg/nodeutil"
+	"github.com/containership/cerebral/pkg/scheduling"
 	"github.com/containership/cluster-manager/pkg/log"
 )
 
 // Backend implements a metrics backend for Kubernetes. It requires a pod
-// lister so that it can gather info about where pods are running.
+// indexer with the nodeutil.PodNodeNameIndex index so that it can gather info
+// about where pods are running without listing every pod in the cluster.
 type Backend struct {
 	nodeLister corelistersv1.NodeLister
-	podLister  corelistersv1.PodLister
+	podIndexer cache.Indexer
 }
 
 // NewClient returns a new client for talking to a Kubernetes Backend, or an error
-func NewClient(nodeLister corelistersv1.NodeLister, podLister corelistersv1.PodLister) (metrics.Backend, error) {
+func NewClient(nodeLister corelistersv1.NodeLister, podIndexer cache.Indexer) (metrics.Backend, error) {
 	if nodeLister == nil {
 		return nil, errors.New("node lister must be provided")
 	}
 
-	if podLister == nil {
-		return nil, errors.New("pod lister must be provided")
+	if podIndexer == nil {
+		return nil, errors.New("pod indexer must be provided")
+	}
+
+	if _, ok := podIndexer.GetIndexers()[nodeutil.PodNodeNameIndex]; !ok {
+		return nil, errors.Errorf("pod indexer must have index %q", nodeutil.PodNodeNameIndex)
 	}
 
 	return Backend{
 		nodeLister: nodeLister,
-		podLister:  podLister,
+		podIndexer: podIndexer,
 	}, nil
 }
 
@@ -43,162 +52,212 @@ func (b Backend) GetValue(metric string, configuration map[string]string, nodeSe
 		return 0, errors.Wrap(err, "listing nodes")
 	}
 
+	config := metricConfiguration{}
+	if err := config.defaultAndValidate(configuration); err != nil {
+		return 0, errors.Wrapf(err, "validating configuration for metric %s", metric)
+	}
+
+	switch metric {
+	case MetricPendingPods.String(),
+		MetricPendingCPURequest.String(),
+		MetricPendingMemoryRequest.String():
+		pending, err := b.getPendingPodsForNodes(nodes, nodeSelector)
+		if err != nil {
+			return 0, errors.Wrapf(err, "getting pending pods for metric %s", metric)
+		}
+
+		return b.calculatePendingValue(metric, filterPods(pending, config)), nil
+	}
+
+	resources, err := allocatedResources(metric, config)
+	if err != nil {
+		return 0, err
+	}
+
 	pods, err := b.getAllocatedPodsOnNodes(nodes)
 	if err != nil {
 		return 0, errors.Wrapf(err, "getting pods on nodes for metric %s", metric)
 	}
 
-	// NOTE: some of the below calculate* functions use `resource.MilliValue()`
-	// from apimachinery. This can technically overflow for a value q where
-	// |q|*1000 > MaxInt64. If this ends up being an issue, we should add a
-	// check for overflow.
+	pods = filterPods(pods, config)
+
+	if config.Aggregation == metrics.AggregationCluster {
+		return calculateAllocationPercentage(resources, pods, nodes), nil
+	}
+
+	values := perNodeValues(resources, pods, nodes)
+	if len(values) == 0 {
+		return 0, errors.Errorf("no nodes with allocatable resources for metric %s", metric)
+	}
+
+	return metrics.Aggregate(values, config.Aggregation, config.Percentile)
+}
+
+// allocatedResources returns the resources whose combined allocation is
+// measured by the metric
+func allocatedResources(metric string, config metricConfiguration) ([]corev1.ResourceName, error) {
 	switch metric {
 	case MetricCPUPercentAllocation.String():
-		value := b.calculateCPUAllocationPercentage(pods, nodes)
-		return value, nil
+		return []corev1.ResourceName{corev1.ResourceCPU}, nil
 
 	case MetricGPUPercentAllocation.String():
-		value := b.calculateGPUAllocationPercentage(pods, nodes)
-		return value, nil
+		var resources []corev1.ResourceName
+		for _, vendor := range GPUVendors() {
+			resources = append(resources, corev1.ResourceName(vendor))
+		}
+		return resources, nil
 
 	case MetricMemoryPercentAllocation.String():
-		value := b.calculateMemoryAllocationPercentage(pods, nodes)
-		return value, nil
+		return []corev1.ResourceName{corev1.ResourceMemory}, nil
 
 	case MetricEphemeralStoragePercentAllocation.String():
-		value := b.calculateEphemeralStorageAllocationPercentage(pods, nodes)
-		return value, nil
+		return []corev1.ResourceName{corev1.ResourceEphemeralStorage}, nil
 
 	case MetricPodPercentAllocation.String():
-		value := b.calculatePodAllocationPercentage(pods, nodes)
-		return value, nil
+		return []corev1.ResourceName{corev1.ResourcePods}, nil
 
-	default:
-		return 0, errors.Errorf("unknown metric %q", metric)
+	case MetricResourcePercentAllocation.String():
+		if len(config.resources) == 0 {
+			return nil, errors.Errorf("resource must be specified for metric %s", metric)
+		}
+		return config.resources, nil
 	}
-}
 
-func (b Backend) getAllocatedPodsOnNodes(nodes []*corev1.Node) ([]*corev1.Pod, error) {
-	var allocatedPodsOnNodes []*corev1.Pod
+	return nil, errors.Errorf("unknown metric %q", metric)
+}
 
-	// Pass an empty selector to list all pods
-	pods, err := b.podLister.List(labels.NewSelector())
-	if err != nil {
-		return nil, errors.Wrap(err, "listing pods")
+// perNodeValues returns the allocation percentage of each node individually.
+// Nodes without any of the resources allocatable (e.g. nodes without GPUs) are
+// skipped since their percentage is undefined.
+func perNodeValues(resources []corev1.ResourceName, pods []*corev1.Pod, nodes []*corev1.Node) []float64 {
+	podsByNode := make(map[string][]*corev1.Pod)
+	for _, pod := range pods {
+		podsByNode[pod.Spec.NodeName] = append(podsByNode[pod.Spec.NodeName], pod)
 	}
 
-	// Only filter to pods running on nodes we care about
-	for _, pod := range pods {
-		for _, node := range nodes {
-			// Succeeded and Failed pods do not count towards node allocation
-			if pod.Spec.NodeName == node.ObjectMeta.Name &&
-				pod.Status.Phase != "Succeeded" &&
-				pod.Status.Phase != "Failed" {
-				allocatedPodsOnNodes = append(allocatedPodsOnNodes, pod)
-			}
+	var values []float64
+	for _, node := range nodes {
+		value := calculateAllocationPercentage(resources, podsByNode[node.ObjectMeta.Name], []*corev1.Node{node})
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			continue
 		}
+
+		values = append(values, value)
 	}
 
-	return allocatedPodsOnNodes, nil
+	return values
 }
 
-func (b Backend) calculateCPUAllocationPercentage(pods []*corev1.Pod, nodes []*corev1.Node) float64 {
-	log.Debugf("Performing cpu allocation calculation of %d pods across %d nodes", len(pods), len(nodes))
+// getPendingPodsForNodes returns the unschedulable pods that could be
+// scheduled on a node like those given. If there are no nodes, a node with
+// only the labels of the node selector is assumed.
+func (b Backend) getPendingPodsForNodes(nodes []*corev1.Node, nodeSelector map[string]string) ([]*corev1.Pod, error) {
+	template := scheduling.TemplateNode(nodes, nodeSelector)
 
-	var allocatableCPUs, requestedCPUs int64
-
-	// calculate sum of allocatable CPUs across nodes
-	for _, node := range nodes {
-		allocatableCPUs += node.Status.Allocatable.Cpu().MilliValue()
-	}
-
-	// calculate sum of requested CPUs across pods
-	for _, pod := range pods {
-		for _, container := range pod.Spec.Containers {
-			requestedCPUs += container.Resources.Requests.Cpu().MilliValue()
-		}
+	// Pending pods haven't been assigned to a node
+	pods, err := nodeutil.PodsOnNode(b.podIndexer, "")
+	if err != nil {
+		return nil, errors.Wrap(err, "listing unscheduled pods")
 	}
 
-	return (100 * (float64(requestedCPUs) / float64(allocatableCPUs)))
+	return scheduling.PendingPodsForNode(pods, template), nil
 }
 
-func (b Backend) calculateGPUAllocationPercentage(pods []*corev1.Pod, nodes []*corev1.Node) float64 {
-	log.Debugf("Performing gpu allocation calculation of %d pods across %d nodes", len(pods), len(nodes))
+func (b Backend) calculatePendingValue(metric string, pending []*corev1.Pod) float64 {
+	log.Debugf("Performing %s calculation of %d pending pods", metric, len(pending))
 
-	var allocatableGPUs, requestedGPUs int64
+	if metric == MetricPendingPods.String() {
+		return float64(len(pending))
+	}
 
-	// calculate sum of allocatable GPUs across nodes
-	for _, node := range nodes {
-		for _, vendor := range GPUVendors() {
-			if val, ok := node.Status.Allocatable[corev1.ResourceName(vendor)]; ok {
-				allocatableGPUs += val.MilliValue()
-			}
-		}
+	resourceName := corev1.ResourceCPU
+	if metric == MetricPendingMemoryRequest.String() {
+		resourceName = corev1.ResourceMemory
 	}
 
-	// calculate sum of requested GPUs across pods
-	for _, pod := range pods {
-		for _, container := range pod.Spec.Containers {
-			for _, vendor := range GPUVendors() {
-				if val, ok := container.Resources.Requests[corev1.ResourceName(vendor)]; ok {
-					requestedGPUs += val.MilliValue()
-				}
-			}
-		}
+	var total int64
+	for _, pod := range pending {
+		total += scheduling.PodRequests(pod)[resourceName]
 	}
 
-	return (100 * (float64(requestedGPUs) / float64(allocatableGPUs)))
+	// Requests are in milli-units, so CPU is returned in cores and memory in bytes
+	return float64(total) / 1000
 }
 
-func (b Backend) calculateMemoryAllocationPercentage(pods []*corev1.Pod, nodes []*corev1.Node) float64 {
-	log.Debugf("Performing memory allocation calculation of %d pods across %d nodes", len(pods), len(nodes))
+// filterPods returns the pods that are not excluded by the configuration
+func filterPods(pods []*corev1.Pod, config metricConfiguration) []*corev1.Pod {
+	var result []*corev1.Pod
+	for _, pod := range pods {
+		if config.ExcludeDaemonSets && scheduling.IsDaemonSetPod(pod) {
+			continue
+		}
 
-	var allocatableMemory, requestedMemory int64
+		if config.ExcludeMirrorPods && scheduling.IsMirrorPod(pod) {
+			continue
+		}
 
-	// calculate sum of allocatable memory across nodes
-	for _, node := range nodes {
-		allocatableMemory += node.Status.Allocatable.Memory().MilliValue()
-	}
+		if config.excludedNamespaces[pod.ObjectMeta.Namespace] {
+			continue
+		}
 
-	// calculate sum of requested memory across pods
-	for _, pod := range pods {
-		for _, container := range pod.Spec.Containers {
-			requestedMemory += container.Resources.Requests.Memory().MilliValue()
+		if !config.podSelector.Matches(labels.Set(pod.ObjectMeta.Labels)) {
+			continue
 		}
+
+		result = append(result, pod)
 	}
 
-	return (100 * (float64(requestedMemory) / float64(allocatableMemory)))
+	return result
 }
 
-func (b Backend) calculateEphemeralStorageAllocationPercentage(pods []*corev1.Pod, nodes []*corev1.Node) float64 {
-	log.Debugf("Performing ephemeral storage allocation calculation of %d pods across %d nodes", len(pods), len(nodes))
-
-	var allocatableStorage, requestedStorage int64
+func (b Backend) getAllocatedPodsOnNodes(nodes []*corev1.Node) ([]*corev1.Pod, error) {
+	var allocatedPodsOnNodes []*corev1.Pod
 
-	// calculate sum of allocatable ephemeral storage across nodes
 	for _, node := range nodes {
-		allocatableStorage += node.Status.Allocatable.StorageEphemeral().MilliValue()
-	}
+		pods, err := nodeutil.PodsOnNode(b.podIndexer, node.ObjectMeta.Name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "listing pods on node %s", node.ObjectMeta.Name)
+		}
 
-	// calculate sum of requested ephemeral storage across pods
-	for _, pod := range pods {
-		for _, container := range pod.Spec.Containers {
-			requestedStorage += container.Resources.Requests.StorageEphemeral().MilliValue()
+		for _, pod := range pods {
+			// Succeeded and Failed pods do not count towards node allocation
+			if pod.Status.Phase != "Succeeded" &&
+				pod.Status.Phase != "Failed" {
+				allocatedPodsOnNodes = append(allocatedPodsOnNodes, pod)
+			}
 		}
 	}
 
-	return (100 * (float64(requestedStorage) / float64(allocatableStorage)))
+	return allocatedPodsOnNodes, nil
 }
 
-func (b Backend) calculatePodAllocationPercentage(pods []*corev1.Pod, nodes []*corev1.Node) float64 {
-	log.Debugf("Performing pod allocation calculation of %d pods across %d nodes", len(pods), len(nodes))
+// calculateAllocationPercentage returns the percentage of the combined
+// allocatable amount of the resources across the nodes that is requested by
+// the pods
+func calculateAllocationPercentage(resources []corev1.ResourceName, pods []*corev1.Pod, nodes []*corev1.Node) float64 {
+	log.Debugf("Performing %v allocation calculation of %d pods across %d nodes", resources, len(pods), len(nodes))
 
-	var allocatablePods int64
+	// NOTE: `resource.MilliValue()` from apimachinery can technically
+	// overflow for a value q where |q|*1000 > MaxInt64. If this ends up being
+	// an issue, we should add a check for overflow.
+	var allocatable, requested int64
 
-	// calculate sum of allocatable pods across nodes
+	// calculate sum of allocatable resources across nodes
 	for _, node := range nodes {
-		allocatablePods += node.Status.Allocatable.Pods().Value()
+		for _, name := range resources {
+			if val, ok := node.Status.Allocatable[name]; ok {
+				allocatable += val.MilliValue()
+			}
+		}
+	}
+
+	// calculate sum of effective requested resources across pods
+	for _, pod := range pods {
+		requests := scheduling.PodRequests(pod)
+		for _, name := range resources {
+			requested += requests[name]
+		}
 	}
 
-	return (100 * (float64(len(pods)) / float64(allocatablePods)))
+	return (100 * (float64(requested) / float64(allocatable)))
 }
@@ -3,21 +3,21 @@ package kubernetes
 import (
 	"math"
 	"testing"
-	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/informers"
-	"k8s.io/client-go/kubernetes/fake"
-	corelistersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
 
 	"github.com/containership/cerebral/pkg/kubernetestest"
+	"github.com/containership/cerebral/pkg/nodeutil"
 )
 
 var (
+	trueVar = true
+
 	nodeAllocatable = corev1.ResourceList{
 		corev1.ResourceName("nvidia.com/gpu"): resource.MustParse("1"),
 		corev1.ResourceName("amd.com/gpu"):    resource.MustParse("1"),
@@ -129,6 +129,35 @@ var (
 		},
 	}
 
+	podPending = &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "pod-pending-0",
+			Namespace: "default",
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Resources: corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{
+							corev1.ResourceCPU:    resource.MustParse("250m"),
+							corev1.ResourceMemory: *resource.NewQuantity(128, resource.DecimalSI),
+						},
+					},
+				},
+			},
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodPending,
+			Conditions: []corev1.PodCondition{
+				{
+					Type:   corev1.PodScheduled,
+					Status: corev1.ConditionFalse,
+					Reason: corev1.PodReasonUnschedulable,
+				},
+			},
+		},
+	}
+
 	podFailed = &corev1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "pod-failed-2",
@@ -143,9 +172,33 @@ var (
 	}
 )
 
+var (
+	cpu              = []corev1.ResourceName{corev1.ResourceCPU}
+	gpu              = []corev1.ResourceName{"amd.com/gpu", "nvidia.com/gpu"}
+	memory           = []corev1.ResourceName{corev1.ResourceMemory}
+	ephemeralStorage = []corev1.ResourceName{corev1.ResourceEphemeralStorage}
+	podResource      = []corev1.ResourceName{corev1.ResourcePods}
+)
+
 var backend = Backend{
 	nodeLister: kubernetestest.BuildNodeLister([]corev1.Node{*node0, *node1, *node2}),
-	podLister:  buildPodLister([]corev1.Pod{*podFailed, *podRunning, *podRunning2, *podFailed}),
+	podIndexer: buildPodIndexer([]corev1.Pod{*podFailed, *podRunning, *podRunning2, *podFailed}),
+}
+
+func TestNewClient(t *testing.T) {
+	nodeLister := kubernetestest.BuildNodeLister(nil)
+
+	_, err := NewClient(nodeLister, buildPodIndexer(nil))
+	assert.NoError(t, err)
+
+	_, err = NewClient(nil, buildPodIndexer(nil))
+	assert.Error(t, err, "error on nil node lister")
+
+	_, err = NewClient(nodeLister, nil)
+	assert.Error(t, err, "error on nil pod indexer")
+
+	_, err = NewClient(nodeLister, cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}))
+	assert.Error(t, err, "error on pod indexer without node name index")
 }
 
 func TestGetValue(t *testing.T) {
@@ -164,8 +217,93 @@ func TestGetValue(t *testing.T) {
 	_, err = backend.GetValue("pod_percent_allocation", nil, nil)
 	assert.NoError(t, err, "successfully get pod allocation metric")
 
+	_, err = backend.GetValue("pending_pods", nil, nil)
+	assert.NoError(t, err, "successfully get pending pods metric")
+
+	_, err = backend.GetValue("resource_percent_allocation", map[string]string{"resource": "nvidia.com/gpu"}, nil)
+	assert.NoError(t, err, "successfully get resource allocation metric")
+
+	_, err = backend.GetValue("resource_percent_allocation", nil, nil)
+	assert.Error(t, err, "resource not provided")
+
 	_, err = backend.GetValue("not a valid metric", nil, nil)
 	assert.Error(t, err, "unknown metric requested")
+
+	_, err = backend.GetValue("cpu_percent_allocation", map[string]string{"aggregation": "bad"}, nil)
+	assert.Error(t, err, "invalid configuration")
+}
+
+func TestGetValueAggregation(t *testing.T) {
+	// Per-node CPU allocation is 0%, 20%, and 10%
+	value, err := backend.GetValue("cpu_percent_allocation", map[string]string{"aggregation": "cluster"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(10), value, "cluster aggregation is the ratio of totals")
+
+	value, err = backend.GetValue("cpu_percent_allocation", map[string]string{"aggregation": "max"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(20), value, "max of per-node values")
+
+	value, err = backend.GetValue("cpu_percent_allocation", map[string]string{"aggregation": "min"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(0), value, "min of per-node values")
+
+	value, err = backend.GetValue("cpu_percent_allocation", map[string]string{"aggregation": "avg"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(10), value, "avg of per-node values")
+
+	value, err = backend.GetValue("cpu_percent_allocation", map[string]string{
+		"aggregation": "percentile",
+		"percentile":  "75",
+	}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(15), value, "percentile of per-node values")
+}
+
+func TestPerNodeValues(t *testing.T) {
+	gpuless := node0.DeepCopy()
+	gpuless.Status.Allocatable = corev1.ResourceList{
+		corev1.ResourceCPU: resource.MustParse("1"),
+	}
+
+	nodeList := []*corev1.Node{gpuless, node1}
+	podList, _ := backend.getAllocatedPodsOnNodes(nodeList)
+
+	values := perNodeValues(gpu, podList, nodeList)
+	assert.Equal(t, []float64{150}, values, "nodes without the resource are skipped")
+}
+
+func TestGetPendingPodsForNodes(t *testing.T) {
+	pendingBackend := Backend{
+		nodeLister: kubernetestest.BuildNodeLister([]corev1.Node{*node0}),
+		podIndexer: buildPodIndexer([]corev1.Pod{*podPending, *podRunning}),
+	}
+
+	pods, err := pendingBackend.getPendingPodsForNodes([]*corev1.Node{node0}, nil)
+	assert.NoError(t, err)
+	assert.Len(t, pods, 1, "only unschedulable pods are returned")
+
+	selected := podPending.DeepCopy()
+	selected.ObjectMeta.Name = "pod-pending-selected"
+	selected.Spec.NodeSelector = map[string]string{"pool": "gpu"}
+	pendingBackend.podIndexer = buildPodIndexer([]corev1.Pod{*podPending, *selected})
+
+	pods, err = pendingBackend.getPendingPodsForNodes(nil, map[string]string{"pool": "gpu"})
+	assert.NoError(t, err)
+	assert.Len(t, pods, 2, "node selector labels are used without nodes")
+
+	pods, err = pendingBackend.getPendingPodsForNodes([]*corev1.Node{node0}, nil)
+	assert.NoError(t, err)
+	assert.Len(t, pods, 1, "pods selecting other nodes are excluded")
+}
+
+func TestCalculatePendingValue(t *testing.T) {
+	pending := []*corev1.Pod{podPending, podPending}
+
+	assert.Equal(t, float64(2), backend.calculatePendingValue("pending_pods", pending))
+	assert.Equal(t, float64(0.5), backend.calculatePendingValue("pending_cpu_request", pending),
+		"cpu is in cores")
+	assert.Equal(t, float64(256), backend.calculatePendingValue("pending_memory_request", pending),
+		"memory is in bytes")
 }
 
 func TestGetPodsOnNodes(t *testing.T) {
@@ -194,27 +332,122 @@ func TestCalculateCPUAllocationPercentage(t *testing.T) {
 	var nodeList = []*corev1.Node{node0, node1, node2}
 	var podList, _ = backend.getAllocatedPodsOnNodes(nodeList)
 
-	percentage := backend.calculateCPUAllocationPercentage(podList, nodeList)
+	percentage := calculateAllocationPercentage(cpu, podList, nodeList)
 	assert.Equal(t, float64(10), percentage, "returns correct allocation percentage")
 }
 
+func TestAllocatedResources(t *testing.T) {
+	config := metricConfiguration{}
+	assert.NoError(t, config.defaultAndValidate(nil))
+
+	resources, err := allocatedResources("gpu_percent_allocation", config)
+	assert.NoError(t, err)
+	assert.Equal(t, gpu, resources, "all GPU vendors are combined")
+
+	_, err = allocatedResources("resource_percent_allocation", config)
+	assert.Error(t, err, "resource is required")
+
+	config = metricConfiguration{}
+	assert.NoError(t, config.defaultAndValidate(map[string]string{
+		"resource": "hugepages-2Mi, intel.com/gpu",
+	}))
+	resources, err = allocatedResources("resource_percent_allocation", config)
+	assert.NoError(t, err)
+	assert.Equal(t, []corev1.ResourceName{"hugepages-2Mi", "intel.com/gpu"}, resources)
+
+	_, err = allocatedResources("not a valid metric", config)
+	assert.Error(t, err, "unknown metric")
+}
+
+func TestCalculateExtendedResourceAllocationPercentage(t *testing.T) {
+	fpga := []corev1.ResourceName{"example.com/fpga"}
+
+	node := node0.DeepCopy()
+	node.Status.Allocatable = corev1.ResourceList{
+		"example.com/fpga": resource.MustParse("4"),
+	}
+
+	pod := podRunning.DeepCopy()
+	pod.Spec.Containers[0].Resources.Requests["example.com/fpga"] = resource.MustParse("1")
+
+	percentage := calculateAllocationPercentage(fpga, []*corev1.Pod{pod}, []*corev1.Node{node})
+	assert.Equal(t, float64(25), percentage, "returns correct allocation percentage for an extended resource")
+}
+
+func TestCalculateCPUAllocationPercentageInitContainers(t *testing.T) {
+	pod := podRunning.DeepCopy()
+	pod.Spec.InitContainers = []corev1.Container{
+		{
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU: resource.MustParse("500m"),
+				},
+			},
+		},
+	}
+
+	percentage := calculateAllocationPercentage(cpu, []*corev1.Pod{pod}, []*corev1.Node{node1})
+	assert.Equal(t, float64(50), percentage, "larger init container request is the effective request")
+}
+
+func TestFilterPods(t *testing.T) {
+	daemon := podRunning.DeepCopy()
+	daemon.ObjectMeta.OwnerReferences = []metav1.OwnerReference{
+		{Kind: "DaemonSet", Controller: &trueVar},
+	}
+
+	mirror := podRunning.DeepCopy()
+	mirror.ObjectMeta.Annotations = map[string]string{corev1.MirrorPodAnnotationKey: ""}
+
+	system := podRunning.DeepCopy()
+	system.ObjectMeta.Namespace = "kube-system"
+
+	labeled := podRunning.DeepCopy()
+	labeled.ObjectMeta.Labels = map[string]string{"app": "web"}
+
+	pods := []*corev1.Pod{daemon, mirror, system, labeled}
+
+	config := metricConfiguration{}
+	assert.NoError(t, config.defaultAndValidate(nil))
+	assert.Len(t, filterPods(pods, config), 4, "nothing excluded by default")
+
+	config = metricConfiguration{}
+	assert.NoError(t, config.defaultAndValidate(map[string]string{
+		"excludeDaemonSets": "true",
+		"excludeMirrorPods": "true",
+	}))
+	assert.Equal(t, []*corev1.Pod{system, labeled}, filterPods(pods, config), "DaemonSet and mirror pods excluded")
+
+	config = metricConfiguration{}
+	assert.NoError(t, config.defaultAndValidate(map[string]string{
+		"excludeNamespaces": "kube-system, monitoring",
+	}))
+	assert.Equal(t, []*corev1.Pod{daemon, mirror, labeled}, filterPods(pods, config), "namespace excluded")
+
+	config = metricConfiguration{}
+	assert.NoError(t, config.defaultAndValidate(map[string]string{
+		"podSelector": "app=web",
+	}))
+	assert.Equal(t, []*corev1.Pod{labeled}, filterPods(pods, config), "only matching pods included")
+}
+
 func TestCalculateGPUAllocationPercentage(t *testing.T) {
 	// 6 total gpus, 3 amd requested
 	var nodeList = []*corev1.Node{node0, node1}
 	var podList, _ = backend.getAllocatedPodsOnNodes(nodeList)
-	percentage := backend.calculateGPUAllocationPercentage(podList, nodeList)
+	percentage := calculateAllocationPercentage(gpu, podList, nodeList)
 	assert.Equal(t, float64(75), percentage, "returns correct allocation percentage for 1 pod requesting 3 nvidia gpus")
 
 	// 6 total gpus, 3 nvidia requested
 	nodeList = []*corev1.Node{node0, node2}
 	podList, _ = backend.getAllocatedPodsOnNodes(nodeList)
-	percentage = backend.calculateGPUAllocationPercentage(podList, nodeList)
+	percentage = calculateAllocationPercentage(gpu, podList, nodeList)
 	assert.Equal(t, float64(75), percentage, "returns correct allocation percentage for 1 pod requesting 3 amd gpus")
 
 	// 6 total gpus, 3 amd and 3 nvidia requested, failed and succeeded are not included calculation
 	nodeList = []*corev1.Node{node0, node1, node2}
 	podList, _ = backend.getAllocatedPodsOnNodes(nodeList)
-	percentage = backend.calculateGPUAllocationPercentage(podList, nodeList)
+	percentage = calculateAllocationPercentage(gpu, podList, nodeList)
 	assert.Equal(t, float64(100), percentage, "returns correct allocation percentage for 2 pods request 3 amd and 3 nvidia gpus")
 }
 
@@ -222,7 +455,7 @@ func TestCalculateMemoryAllocationPercentage(t *testing.T) {
 	var nodeList = []*corev1.Node{node0, node1, node2}
 	var podList, _ = backend.getAllocatedPodsOnNodes(nodeList)
 
-	percentage := backend.calculateMemoryAllocationPercentage(podList, nodeList)
+	percentage := calculateAllocationPercentage(memory, podList, nodeList)
 	assert.Equal(t, float64(50), percentage, "returns correct allocation percentage")
 }
 
@@ -230,7 +463,7 @@ func TestCalculateEphemeralStorageAllocationPercentage(t *testing.T) {
 	var nodeList = []*corev1.Node{node0, node1, node2}
 	var podList, _ = backend.getAllocatedPodsOnNodes(nodeList)
 
-	percentage := backend.calculateEphemeralStorageAllocationPercentage(podList, nodeList)
+	percentage := calculateAllocationPercentage(ephemeralStorage, podList, nodeList)
 	assert.Equal(t, float64(25), percentage, "returns correct allocation percentage")
 }
 
@@ -238,29 +471,24 @@ func TestCalculatePodAllocationPercentage(t *testing.T) {
 	var nodeList = []*corev1.Node{node0, node1, node2}
 	var podList, _ = backend.getAllocatedPodsOnNodes(nodeList)
 
-	percentage := backend.calculatePodAllocationPercentage(podList, nodeList)
+	percentage := calculateAllocationPercentage(podResource, podList, nodeList)
 
 	// we need to round to do a sane assertion
 	assert.Equal(t, float64(33.33), math.Floor(percentage*100)/100, "returns correct allocation percentage")
 }
 
-// Get a pod lister. Copies of the pods are added to the cache; not the pods themselves.
-func buildPodLister(pods []corev1.Pod) corelistersv1.PodLister {
-	// We don't need anything related to the client or informer; we're simply
-	// using this as an easy way to build a cache
-	client := &fake.Clientset{}
-	kubeInformerFactory := informers.NewSharedInformerFactory(client, 30*time.Second)
-	informer := kubeInformerFactory.Core().V1().Pods()
+// Get a pod indexer with the node name index. Copies of the pods are added to
+// the cache; not the pods themselves.
+func buildPodIndexer(pods []corev1.Pod) cache.Indexer {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, nodeutil.PodNodeNameIndexers())
 
 	for _, pod := range pods {
-		// TODO why is DeepCopy() required here? Without it, each Add() duplicates
-		// the first member added.
-		err := informer.Informer().GetStore().Add(pod.DeepCopy())
+		err := indexer.Add(pod.DeepCopy())
 		if err != nil {
 			// Should be a programming error
 			panic(err)
 		}
 	}
 
-	return informer.Lister()
+	return indexer
 }
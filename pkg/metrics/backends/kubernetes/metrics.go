@@ -1,5 +1,17 @@
 package kubernetes
 
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/containership/cerebral/pkg/metrics"
+)
+
 // Metric is a metric exposed by this backend
 type Metric int
 
@@ -14,6 +26,14 @@ const (
 	MetricEphemeralStoragePercentAllocation
 	// MetricPodPercentAllocation is used to gather info about the Pod allocation of nodes
 	MetricPodPercentAllocation
+	// MetricPendingPods is used to count unschedulable pods that could run on the nodes
+	MetricPendingPods
+	// MetricPendingCPURequest is used to sum the CPU requests of unschedulable pods that could run on the nodes
+	MetricPendingCPURequest
+	// MetricPendingMemoryRequest is used to sum the memory requests of unschedulable pods that could run on the nodes
+	MetricPendingMemoryRequest
+	// MetricResourcePercentAllocation is used to gather info about the allocation of any resources of nodes
+	MetricResourcePercentAllocation
 )
 
 // GPUVendors returns array of supported GPU vendors
@@ -37,7 +57,92 @@ func (m Metric) String() string {
 		return "ephemeral_storage_percent_allocation"
 	case MetricPodPercentAllocation:
 		return "pod_percent_allocation"
+	case MetricPendingPods:
+		return "pending_pods"
+	case MetricPendingCPURequest:
+		return "pending_cpu_request"
+	case MetricPendingMemoryRequest:
+		return "pending_memory_request"
+	case MetricResourcePercentAllocation:
+		return "resource_percent_allocation"
 	}
 
 	return "unknown"
 }
+
+const defaultAggregation = metrics.AggregationCluster
+
+type metricConfiguration struct {
+	Aggregation string `json:"aggregation"`
+
+	// Percentile is only used by the percentile aggregation and must be
+	// within [0, 100]
+	Percentile *float64 `json:"percentile,string"`
+
+	// -- Resource
+	// Resource is a comma-separated list of resource names whose allocation
+	// is combined by the resource_percent_allocation metric
+	Resource string `json:"resource"`
+
+	// -- Pod filtering
+	ExcludeDaemonSets bool   `json:"excludeDaemonSets,string"`
+	ExcludeMirrorPods bool   `json:"excludeMirrorPods,string"`
+	ExcludeNamespaces string `json:"excludeNamespaces"`
+	PodSelector       string `json:"podSelector"`
+
+	// -- Not user-specifiable
+	resources          []corev1.ResourceName
+	excludedNamespaces map[string]bool
+	podSelector        labels.Selector
+}
+
+// defaults and validates the metricConfiguration. Intended to be called with an
+// empty struct that we'll fill in here using the caller-provided configuration.
+func (c *metricConfiguration) defaultAndValidate(configuration map[string]string) error {
+	// Round trip the config through JSON parser to populate our struct
+	j, _ := json.Marshal(configuration)
+	if err := json.Unmarshal(j, c); err != nil {
+		return errors.Wrap(err, "parsing configuration")
+	}
+
+	if c.Aggregation == "" {
+		c.Aggregation = defaultAggregation
+	}
+
+	if err := metrics.ValidateAggregation(c.Aggregation, c.Percentile); err != nil {
+		return err
+	}
+
+	c.parseResources()
+
+	if err := c.parsePodFilters(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *metricConfiguration) parseResources() {
+	for _, name := range strings.Split(c.Resource, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			c.resources = append(c.resources, corev1.ResourceName(name))
+		}
+	}
+}
+
+func (c *metricConfiguration) parsePodFilters() error {
+	c.excludedNamespaces = make(map[string]bool)
+	for _, ns := range strings.Split(c.ExcludeNamespaces, ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			c.excludedNamespaces[ns] = true
+		}
+	}
+
+	selector, err := labels.Parse(c.PodSelector)
+	if err != nil {
+		return errors.Wrapf(err, "invalid pod selector %q", c.PodSelector)
+	}
+	c.podSelector = selector
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package kubernetes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultAndValidate(t *testing.T) {
+	c := metricConfiguration{}
+	err := c.defaultAndValidate(nil)
+	assert.NoError(t, err, "nil config provided is ok")
+	assert.Equal(t, defaultAggregation, c.Aggregation, "aggregation defaulted")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{
+		"aggregation": "max",
+	})
+	assert.NoError(t, err, "good config")
+	assert.Equal(t, "max", c.Aggregation, "aggregation not defaulted if provided")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{
+		"aggregation": "percentile",
+		"percentile":  "90",
+	})
+	assert.NoError(t, err, "good percentile config")
+	assert.Equal(t, float64(90), *c.Percentile)
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{
+		"aggregation": "not-valid",
+	})
+	assert.Error(t, err, "bad aggregation")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{
+		"aggregation": "percentile",
+	})
+	assert.Error(t, err, "percentile not provided")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{
+		"aggregation": "percentile",
+		"percentile":  "101",
+	})
+	assert.Error(t, err, "percentile out of range")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{
+		"aggregation": "percentile",
+		"percentile":  "ninety",
+	})
+	assert.Error(t, err, "percentile not a number")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{
+		"excludeDaemonSets": "yes",
+	})
+	assert.Error(t, err, "excludeDaemonSets not a bool")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{
+		"podSelector": "app in (web",
+	})
+	assert.Error(t, err, "invalid pod selector")
+}
@@ -0,0 +1,94 @@
+package metricsserver
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+
+	"github.com/containership/cerebral/pkg/metrics"
+)
+
+// Metric is a metric exposed by this backend
+type Metric int
+
+const (
+	// MetricCPUPercentUtilization is used to gather info about the CPU usage of nodes
+	MetricCPUPercentUtilization Metric = iota
+	// MetricMemoryPercentUtilization is used to gather info about the memory usage of nodes
+	MetricMemoryPercentUtilization
+)
+
+// String is a stringer for Metric
+func (m Metric) String() string {
+	switch m {
+	case MetricCPUPercentUtilization:
+		return "cpu_percent_utilization"
+	case MetricMemoryPercentUtilization:
+		return "memory_percent_utilization"
+	}
+
+	return "unknown"
+}
+
+const (
+	// sourceNodes uses the usage reported for the nodes as a whole, which
+	// includes system daemons and anything else running outside of pods
+	sourceNodes = "nodes"
+	// sourcePods uses the sum of the usage reported for the pods on the nodes
+	sourcePods = "pods"
+)
+
+var validSources = []string{
+	sourceNodes,
+	sourcePods,
+}
+
+const defaultSource = sourceNodes
+const defaultAggregation = metrics.AggregationCluster
+
+type metricConfiguration struct {
+	Source      string `json:"source"`
+	Aggregation string `json:"aggregation"`
+
+	// Percentile is only used by the percentile aggregation and must be
+	// within [0, 100]
+	Percentile *float64 `json:"percentile,string"`
+}
+
+// defaults and validates the metricConfiguration. Intended to be called with an
+// empty struct that we'll fill in here using the caller-provided configuration.
+func (c *metricConfiguration) defaultAndValidate(configuration map[string]string) error {
+	// Round trip the config through JSON parser to populate our struct
+	j, _ := json.Marshal(configuration)
+	if err := json.Unmarshal(j, c); err != nil {
+		return errors.Wrap(err, "parsing configuration")
+	}
+
+	if err := c.defaultAndValidateSource(); err != nil {
+		return err
+	}
+
+	if c.Aggregation == "" {
+		c.Aggregation = defaultAggregation
+	}
+
+	if err := metrics.ValidateAggregation(c.Aggregation, c.Percentile); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *metricConfiguration) defaultAndValidateSource() error {
+	if c.Source == "" {
+		c.Source = defaultSource
+	}
+
+	for _, s := range validSources {
+		if s == c.Source {
+			return nil
+		}
+	}
+
+	return errors.Errorf("invalid source %s", c.Source)
+}
@@ -0,0 +1,50 @@
+package metricsserver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultAndValidate(t *testing.T) {
+	c := metricConfiguration{}
+	err := c.defaultAndValidate(nil)
+	assert.NoError(t, err, "nil config provided is ok")
+	assert.Equal(t, defaultSource, c.Source, "source defaulted")
+	assert.Equal(t, defaultAggregation, c.Aggregation, "aggregation defaulted")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{
+		"source":      "pods",
+		"aggregation": "max",
+	})
+	assert.NoError(t, err, "good config")
+	assert.Equal(t, "pods", c.Source, "source not defaulted if provided")
+	assert.Equal(t, "max", c.Aggregation, "aggregation not defaulted if provided")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{
+		"source": "not-valid",
+	})
+	assert.Error(t, err, "bad source")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{
+		"aggregation": "not-valid",
+	})
+	assert.Error(t, err, "bad aggregation")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{
+		"aggregation": "percentile",
+		"percentile":  "90",
+	})
+	assert.NoError(t, err, "good percentile config")
+	assert.Equal(t, float64(90), *c.Percentile)
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{
+		"aggregation": "percentile",
+	})
+	assert.Error(t, err, "percentile not provided")
+}
@@ -0,0 +1,179 @@
+package metricsserver
+
+import (
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corelistersv1 "k8s.io/client-go/listers/core/v1"
+	metricsclient "k8s.io/metrics/pkg/client/clientset/versioned"
+
+	"github.com/containership/cerebral/pkg/metrics"
+	"github.com/containership/cerebral/pkg/nodeutil"
+	"github.com/containership/cluster-manager/pkg/log"
+)
+
+// Backend implements a metrics backend for the Kubernetes resource metrics
+// API (metrics.k8s.io) served by metrics-server. It requires node and pod
+// listers in order to determine allocatable resources and where pods are
+// running.
+type Backend struct {
+	client metricsclient.Interface
+
+	nodeLister corelistersv1.NodeLister
+	podLister  corelistersv1.PodLister
+}
+
+// NewClient returns a new client for talking to a metrics-server Backend, or an error
+func NewClient(client metricsclient.Interface, nodeLister corelistersv1.NodeLister, podLister corelistersv1.PodLister) (metrics.Backend, error) {
+	if client == nil {
+		return nil, errors.New("metrics API client must be provided")
+	}
+
+	if nodeLister == nil {
+		return nil, errors.New("node lister must be provided")
+	}
+
+	if podLister == nil {
+		return nil, errors.New("pod lister must be provided")
+	}
+
+	return Backend{
+		client:     client,
+		nodeLister: nodeLister,
+		podLister:  podLister,
+	}, nil
+}
+
+// GetValue implements the metrics.Backend interface
+func (b Backend) GetValue(metric string, configuration map[string]string, nodeSelector map[string]string) (float64, error) {
+	var resourceName corev1.ResourceName
+	switch metric {
+	case MetricCPUPercentUtilization.String():
+		resourceName = corev1.ResourceCPU
+
+	case MetricMemoryPercentUtilization.String():
+		resourceName = corev1.ResourceMemory
+
+	default:
+		return 0, errors.Errorf("unknown metric %q", metric)
+	}
+
+	config := metricConfiguration{}
+	if err := config.defaultAndValidate(configuration); err != nil {
+		return 0, errors.Wrapf(err, "validating configuration for metric %s", metric)
+	}
+
+	selector := nodeutil.GetNodesLabelSelector(nodeSelector)
+	nodes, err := b.nodeLister.List(selector)
+	if err != nil {
+		return 0, errors.Wrap(err, "listing nodes")
+	}
+
+	var usage map[string]int64
+	if config.Source == sourcePods {
+		usage, err = b.getPodUsageByNode(resourceName)
+	} else {
+		usage, err = b.getNodeUsage(resourceName)
+	}
+	if err != nil {
+		return 0, errors.Wrapf(err, "getting usage for metric %s", metric)
+	}
+
+	return calculateUtilizationPercentage(config, resourceName, nodes, usage)
+}
+
+// getNodeUsage returns the usage of the resource keyed by node name
+func (b Backend) getNodeUsage(resourceName corev1.ResourceName) (map[string]int64, error) {
+	nodeMetrics, err := b.client.MetricsV1beta1().NodeMetricses().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "listing node metrics")
+	}
+
+	usage := make(map[string]int64, len(nodeMetrics.Items))
+	for _, m := range nodeMetrics.Items {
+		if val, ok := m.Usage[resourceName]; ok {
+			usage[m.ObjectMeta.Name] = val.MilliValue()
+		}
+	}
+
+	return usage, nil
+}
+
+// getPodUsageByNode returns the sum of the usage of the resource by the pods
+// on each node, keyed by node name
+func (b Backend) getPodUsageByNode(resourceName corev1.ResourceName) (map[string]int64, error) {
+	podMetrics, err := b.client.MetricsV1beta1().PodMetricses(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "listing pod metrics")
+	}
+
+	// Pass an empty selector to list all pods
+	pods, err := b.podLister.List(labels.NewSelector())
+	if err != nil {
+		return nil, errors.Wrap(err, "listing pods")
+	}
+
+	// Pod metrics don't include the node, so look it up from the pod
+	nodeByPod := make(map[string]string, len(pods))
+	for _, pod := range pods {
+		if pod.Spec.NodeName == "" || pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+
+		nodeByPod[pod.ObjectMeta.Namespace+"/"+pod.ObjectMeta.Name] = pod.Spec.NodeName
+	}
+
+	usage := make(map[string]int64)
+	for _, m := range podMetrics.Items {
+		nodeName, ok := nodeByPod[m.ObjectMeta.Namespace+"/"+m.ObjectMeta.Name]
+		if !ok {
+			continue
+		}
+
+		for _, container := range m.Containers {
+			if val, ok := container.Usage[resourceName]; ok {
+				usage[nodeName] += val.MilliValue()
+			}
+		}
+	}
+
+	return usage, nil
+}
+
+// calculateUtilizationPercentage returns the percentage of the allocatable
+// resource of the nodes that is used, aggregated as configured. Nodes without
+// usage reported (e.g. nodes that just joined) are ignored.
+func calculateUtilizationPercentage(config metricConfiguration, resourceName corev1.ResourceName,
+	nodes []*corev1.Node, usage map[string]int64) (float64, error) {
+	log.Debugf("Performing %s utilization calculation across %d nodes", resourceName, len(nodes))
+
+	var totalUsed, totalAllocatable int64
+	var values []float64
+	for _, node := range nodes {
+		used, ok := usage[node.ObjectMeta.Name]
+		if !ok {
+			continue
+		}
+
+		allocatable := node.Status.Allocatable[resourceName]
+		if allocatable.IsZero() {
+			continue
+		}
+
+		totalUsed += used
+		totalAllocatable += allocatable.MilliValue()
+		values = append(values, 100*float64(used)/float64(allocatable.MilliValue()))
+	}
+
+	if len(values) == 0 {
+		return 0, errors.Errorf("no nodes with %s usage reported", resourceName)
+	}
+
+	if config.Aggregation == metrics.AggregationCluster {
+		return 100 * float64(totalUsed) / float64(totalAllocatable), nil
+	}
+
+	return metrics.Aggregate(values, config.Aggregation, config.Percentile)
+}
@@ -0,0 +1,256 @@
+package metricsserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	corelistersv1 "k8s.io/client-go/listers/core/v1"
+	kubetesting "k8s.io/client-go/testing"
+	metricsv1beta1 "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+	metricsclient "k8s.io/metrics/pkg/client/clientset/versioned"
+	metricsfake "k8s.io/metrics/pkg/client/clientset/versioned/fake"
+
+	"github.com/containership/cerebral/pkg/kubernetestest"
+)
+
+var (
+	nodeAllocatable = corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("2"),
+		corev1.ResourceMemory: resource.MustParse("4Gi"),
+	}
+
+	node0 = corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node-0",
+		},
+		Status: corev1.NodeStatus{
+			Allocatable: nodeAllocatable,
+		},
+	}
+	node1 = corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node-1",
+		},
+		Status: corev1.NodeStatus{
+			Allocatable: nodeAllocatable,
+		},
+	}
+	node2 = corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node-2",
+		},
+		Status: corev1.NodeStatus{
+			Allocatable: nodeAllocatable,
+		},
+	}
+
+	pod0 = corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "pod-0",
+			Namespace: "default",
+		},
+		Spec: corev1.PodSpec{
+			NodeName: node0.ObjectMeta.Name,
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+		},
+	}
+	pod1 = corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "pod-1",
+			Namespace: "default",
+		},
+		Spec: corev1.PodSpec{
+			NodeName: node1.ObjectMeta.Name,
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+		},
+	}
+
+	// node-2 has just joined and has no usage reported yet
+	client = buildMetricsClient(
+		[]metricsv1beta1.NodeMetrics{
+			buildNodeMetrics("node-0", "500m", "1Gi"),
+			buildNodeMetrics("node-1", "1500m", "3Gi"),
+		},
+		[]metricsv1beta1.PodMetrics{
+			buildPodMetrics("pod-0", "200m", "100m"),
+			buildPodMetrics("pod-1", "1"),
+			buildPodMetrics("pod-deleted", "2"),
+		},
+		nil,
+	)
+
+	backend = Backend{
+		client:     client,
+		nodeLister: kubernetestest.BuildNodeLister([]corev1.Node{node0, node1, node2}),
+		podLister:  buildPodLister([]corev1.Pod{pod0, pod1}),
+	}
+)
+
+// Get a fake metrics clientset that lists the given metrics, or the error if
+// it's not nil. Reactors are used because the fake object tracker guesses the
+// resource from the kind (e.g. nodemetricses), which doesn't match the
+// resources (nodes and pods) that the metrics API serves.
+func buildMetricsClient(nodeMetrics []metricsv1beta1.NodeMetrics,
+	podMetrics []metricsv1beta1.PodMetrics, err error) metricsclient.Interface {
+	client := &metricsfake.Clientset{}
+
+	client.AddReactor("list", "nodes", func(action kubetesting.Action) (bool, runtime.Object, error) {
+		return true, &metricsv1beta1.NodeMetricsList{Items: nodeMetrics}, err
+	})
+
+	client.AddReactor("list", "pods", func(action kubetesting.Action) (bool, runtime.Object, error) {
+		return true, &metricsv1beta1.PodMetricsList{Items: podMetrics}, err
+	})
+
+	return client
+}
+
+func buildNodeMetrics(name, cpu, memory string) metricsv1beta1.NodeMetrics {
+	return metricsv1beta1.NodeMetrics{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Timestamp: metav1.Now(),
+		Window:    metav1.Duration{Duration: 30 * time.Second},
+		Usage: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(cpu),
+			corev1.ResourceMemory: resource.MustParse(memory),
+		},
+	}
+}
+
+func buildPodMetrics(name string, containerCPUs ...string) metricsv1beta1.PodMetrics {
+	m := metricsv1beta1.PodMetrics{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+		},
+	}
+
+	for _, cpu := range containerCPUs {
+		m.Containers = append(m.Containers, metricsv1beta1.ContainerMetrics{
+			Usage: corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse(cpu),
+			},
+		})
+	}
+
+	return m
+}
+
+func TestNewClient(t *testing.T) {
+	_, err := NewClient(nil, backend.nodeLister, backend.podLister)
+	assert.Error(t, err, "client required")
+
+	_, err = NewClient(client, nil, backend.podLister)
+	assert.Error(t, err, "node lister required")
+
+	_, err = NewClient(client, backend.nodeLister, nil)
+	assert.Error(t, err, "pod lister required")
+
+	_, err = NewClient(client, backend.nodeLister, backend.podLister)
+	assert.NoError(t, err)
+}
+
+func TestGetValue(t *testing.T) {
+	value, err := backend.GetValue("cpu_percent_utilization", nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(50), value, "node usage across nodes with metrics")
+
+	value, err = backend.GetValue("memory_percent_utilization", nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(50), value, "node usage across nodes with metrics")
+
+	value, err = backend.GetValue("cpu_percent_utilization", map[string]string{"aggregation": "max"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(75), value, "max of per-node usage")
+
+	value, err = backend.GetValue("cpu_percent_utilization", map[string]string{
+		"aggregation": "percentile",
+		"percentile":  "25",
+	}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(37.5), value, "percentile of per-node usage")
+
+	value, err = backend.GetValue("cpu_percent_utilization", map[string]string{"source": "pods"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(32.5), value, "pod usage summed per node, unknown pods ignored")
+
+	_, err = backend.GetValue("memory_percent_utilization", map[string]string{"source": "pods"}, nil)
+	assert.Error(t, err, "no memory usage reported for pods")
+
+	_, err = backend.GetValue("cpu_percent_utilization", map[string]string{"source": "bad"}, nil)
+	assert.Error(t, err, "invalid configuration")
+
+	_, err = backend.GetValue("not a valid metric", nil, nil)
+	assert.Error(t, err, "unknown metric requested")
+
+	failing := backend
+	failing.client = buildMetricsClient(nil, nil, errors.New("unavailable"))
+	_, err = failing.GetValue("cpu_percent_utilization", nil, nil)
+	assert.Error(t, err, "metrics API error")
+
+	_, err = failing.GetValue("cpu_percent_utilization", map[string]string{"source": "pods"}, nil)
+	assert.Error(t, err, "metrics API error")
+}
+
+func TestCalculateUtilizationPercentage(t *testing.T) {
+	nodes := []*corev1.Node{&node0, &node1}
+	usage := map[string]int64{
+		"node-0": 500,
+		"node-1": 1500,
+	}
+
+	value, err := calculateUtilizationPercentage(metricConfiguration{Aggregation: "cluster"}, corev1.ResourceCPU, nodes, usage)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(50), value)
+
+	value, err = calculateUtilizationPercentage(metricConfiguration{Aggregation: "min"}, corev1.ResourceCPU, nodes, usage)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(25), value)
+
+	value, err = calculateUtilizationPercentage(metricConfiguration{Aggregation: "avg"}, corev1.ResourceCPU, nodes, usage)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(50), value)
+
+	p := float64(50)
+	value, err = calculateUtilizationPercentage(metricConfiguration{Aggregation: "percentile", Percentile: &p},
+		corev1.ResourceCPU, nodes, usage)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(50), value)
+
+	_, err = calculateUtilizationPercentage(metricConfiguration{Aggregation: "cluster"}, corev1.ResourceCPU, nodes, nil)
+	assert.Error(t, err, "no usage reported")
+}
+
+// Get a pod lister. Copies of the pods are added to the cache; not the pods themselves.
+func buildPodLister(pods []corev1.Pod) corelistersv1.PodLister {
+	// We don't need anything related to the client or informer; we're simply
+	// using this as an easy way to build a cache
+	client := &fake.Clientset{}
+	kubeInformerFactory := informers.NewSharedInformerFactory(client, 30*time.Second)
+	informer := kubeInformerFactory.Core().V1().Pods()
+
+	for _, pod := range pods {
+		err := informer.Informer().GetStore().Add(pod.DeepCopy())
+		if err != nil {
+			// Should be a programming error
+			panic(err)
+		}
+	}
+
+	return informer.Lister()
+}
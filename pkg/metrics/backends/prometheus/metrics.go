@@ -5,6 +5,8 @@ import (
 	"regexp"
 
 	"github.com/pkg/errors"
+
+	"github.com/containership/cerebral/pkg/metrics"
 )
 
 // Metric is a metric exposed by this backend
@@ -64,21 +66,69 @@ var validNodeCPUMetricNames = []string{
 // remove this config option.
 var defaultNodeCPUMetricName = validNodeCPUMetricNames[1]
 
+const (
+	// nodeMappingPodIP selects series by the IPs of node exporter pods
+	// discovered using the Prometheus targets API
+	nodeMappingPodIP = "pod-ip"
+	// nodeMappingLabel selects series by a label containing the node name
+	nodeMappingLabel = "node-label"
+	// nodeMappingNone does not filter series by node at all
+	nodeMappingNone = "none"
+)
+
+// Default to pod IP discovery to remain compatible with existing Containership
+// CKE clusters
+const defaultNodeMapping = nodeMappingPodIP
+
+const defaultNodeLabel = "node"
+
+// Matches jobs such as <namespace>/node-export-monitor/<index> created by the
+// Prometheus operator for the node-export-monitor ServiceMonitor
+const defaultNodeExporterJob = "[^/]*/node-export-monitor(/.*)?"
+
+// See https://prometheus.io/docs/concepts/data_model/#metric-names-and-labels
+var validLabelNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
 // TODO consider splitting into multiple types instead of overloading this
 // single struct and ignoring irrelevant fields
 type metricConfiguration struct {
 	// -- Generic
 	Aggregation string `json:"aggregation"`
 	Range       string `json:"range"`
+	// Reducer reduces results containing multiple series to a single value.
+	// If empty, results must contain a single series.
+	Reducer string `json:"reducer"`
 
 	// -- CPU
 	// NodeCPUMetricName specifies the underlying Prometheus metric name for the cpu metric
 	// This is mainly required due to the name changing in Prometheus 0.16.0
 	NodeCPUMetricName string `json:"cpuMetricName"`
 
+	// -- Node mapping
+	// NodeMapping specifies how series are matched to the nodes being queried
+	NodeMapping string `json:"nodeMapping"`
+	// NodeLabel is the series label containing the node name when using
+	// the node-label mapping
+	NodeLabel string `json:"nodeLabel"`
+	// NodeExporterJob is a regex matching the job of node exporter targets
+	// when using the pod-ip mapping
+	NodeExporterJob string `json:"nodeExporterJob"`
+
 	// -- Not user-specifiable
 	// Unfortunately we're required to export these fields for use in templates
-	PodIPsRegex string
+	PodIPsRegex    string `json:"-"`
+	NodeNamesRegex string `json:"-"`
+	NodeMatcher    string `json:"-"`
+
+	NodeNames        []string          `json:"-"`
+	AutoscalingGroup string            `json:"-"`
+	NodeSelector     map[string]string `json:"-"`
+	Selector         string            `json:"-"`
+	// Configuration is the raw configuration so that custom queries can
+	// reference arbitrary keys, e.g. {{.Configuration.service}}
+	Configuration map[string]string `json:"-"`
+
+	nodeExporterJobRegex *regexp.Regexp
 }
 
 // defaults and validates the metricConfiguration. Intended to be called with an
@@ -88,6 +138,9 @@ func (c *metricConfiguration) defaultAndValidate(configuration map[string]string
 	j, _ := json.Marshal(configuration)
 	json.Unmarshal(j, c)
 
+	c.Configuration = configuration
+	c.AutoscalingGroup = configuration[metrics.AutoscalingGroupKey]
+
 	if err := c.defaultAndValidateAggregation(); err != nil {
 		return err
 	}
@@ -96,10 +149,18 @@ func (c *metricConfiguration) defaultAndValidate(configuration map[string]string
 		return err
 	}
 
+	if err := metrics.ValidateReducer(c.Reducer); err != nil {
+		return err
+	}
+
 	if err := c.defaultAndValidateNodeCPUMetricName(); err != nil {
 		return err
 	}
 
+	if err := c.defaultAndValidateNodeMapping(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -142,3 +203,40 @@ func (c *metricConfiguration) defaultAndValidateNodeCPUMetricName() error {
 
 	return errors.Errorf("invalid node cpu metric name %s", c.NodeCPUMetricName)
 }
+
+func (c *metricConfiguration) defaultAndValidateNodeMapping() error {
+	if c.NodeMapping == "" {
+		c.NodeMapping = defaultNodeMapping
+	}
+
+	switch c.NodeMapping {
+	case nodeMappingPodIP:
+		if c.NodeExporterJob == "" {
+			c.NodeExporterJob = defaultNodeExporterJob
+		}
+
+		// Anchor the regex the same way Prometheus anchors label matchers
+		regex, err := regexp.Compile("^(?:" + c.NodeExporterJob + ")$")
+		if err != nil {
+			return errors.Wrapf(err, "invalid node exporter job %s", c.NodeExporterJob)
+		}
+
+		c.nodeExporterJobRegex = regex
+
+	case nodeMappingLabel:
+		if c.NodeLabel == "" {
+			c.NodeLabel = defaultNodeLabel
+		}
+
+		if !validLabelNameRegex.MatchString(c.NodeLabel) {
+			return errors.Errorf("invalid node label %s", c.NodeLabel)
+		}
+
+	case nodeMappingNone:
+
+	default:
+		return errors.Errorf("invalid node mapping %s", c.NodeMapping)
+	}
+
+	return nil
+}
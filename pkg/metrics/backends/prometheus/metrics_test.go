@@ -66,3 +66,53 @@ func TestDefaultAndValidate(t *testing.T) {
 	})
 	assert.Error(t, err, "bad CPU metric name")
 }
+
+func TestDefaultAndValidateNodeMapping(t *testing.T) {
+	c := metricConfiguration{}
+	err := c.defaultAndValidate(nil)
+	assert.NoError(t, err, "nil config provided is ok")
+	assert.Equal(t, defaultNodeMapping, c.NodeMapping, "node mapping defaulted")
+	assert.Equal(t, defaultNodeExporterJob, c.NodeExporterJob, "node exporter job defaulted")
+	assert.True(t, c.nodeExporterJobRegex.MatchString("containership-core/node-export-monitor/0"))
+	assert.False(t, c.nodeExporterJobRegex.MatchString("node-export-monitor"), "job regex is anchored")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{
+		"nodeMapping": "node-label",
+	})
+	assert.NoError(t, err, "good config")
+	assert.Equal(t, defaultNodeLabel, c.NodeLabel, "node label defaulted")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{
+		"nodeMapping": "node-label",
+		"nodeLabel":   "instance",
+	})
+	assert.NoError(t, err, "good config")
+	assert.Equal(t, "instance", c.NodeLabel, "node label not defaulted if provided")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{
+		"nodeMapping": "none",
+	})
+	assert.NoError(t, err, "no node mapping")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{
+		"nodeMapping": "bad",
+	})
+	assert.Error(t, err, "bad node mapping")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{
+		"nodeMapping": "node-label",
+		"nodeLabel":   "0node",
+	})
+	assert.Error(t, err, "bad node label")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{
+		"nodeExporterJob": "[",
+	})
+	assert.Error(t, err, "bad node exporter job regex")
+}
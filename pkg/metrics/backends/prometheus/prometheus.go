@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 
@@ -18,17 +21,32 @@ import (
 	corelistersv1 "k8s.io/client-go/listers/core/v1"
 
 	"github.com/containership/cerebral/pkg/metrics"
+	"github.com/containership/cerebral/pkg/metrics/backends/httpconfig"
 	"github.com/containership/cerebral/pkg/nodeutil"
 	"github.com/containership/cluster-manager/pkg/log"
 )
 
-// Backend implements a metrics backend for Prometheus. It requires a pod
-// lister so that it can gather info about the prom-exporter pods. Pods
-// accessed via the lister must not be mutated.
+// Backend implements a metrics backend for Prometheus. It requires a node
+// lister so that it can restrict queries to the series of the nodes being
+// queried. Nodes accessed via the lister must not be mutated.
 type Backend struct {
 	prometheus prometheus.API
 
 	nodeLister corelistersv1.NodeLister
+
+	// targets caches the targets used by the pod-ip node mapping, if set
+	targets *targetsCache
+}
+
+// targetsCache caches the active targets returned by Prometheus for a TTL.
+// Every query using the pod-ip node mapping needs them, but they rarely
+// change.
+type targetsCache struct {
+	ttl time.Duration
+
+	sync.Mutex
+	result  prometheus.TargetsResult
+	expires time.Time
 }
 
 const (
@@ -39,7 +57,7 @@ const (
 const cpuQueryTemplateString = `
 100 - (
 	{{.Aggregation}}(
-		irate({{.NodeCPUMetricName}}{mode='idle',instance=~'{{.PodIPsRegex}}'}[{{.Range}}])
+		irate({{.NodeCPUMetricName}}{mode='idle'{{with .NodeMatcher}},{{.}}{{end}}}[{{.Range}}])
 	) * 100
 )`
 
@@ -48,14 +66,24 @@ var cpuQueryTemplate = template.Must(template.New("cpu").Parse(cpuQueryTemplateS
 // Average memory usage across the given nodes for the given range
 const memoryQueryTemplateString = `
 100 * {{.Aggregation}}(
-	1 - (avg_over_time(node_memory_MemAvailable{instance=~'{{.PodIPsRegex}}'}[{{.Range}}])
-		  / avg_over_time(node_memory_MemTotal{instance=~'{{.PodIPsRegex}}'}[{{.Range}}]))
+	1 - (avg_over_time(node_memory_MemAvailable{ {{- .NodeMatcher -}} }[{{.Range}}])
+		  / avg_over_time(node_memory_MemTotal{ {{- .NodeMatcher -}} }[{{.Range}}]))
 )`
 
 var memoryQueryTemplate = template.Must(template.New("mem").Parse(memoryQueryTemplateString))
 
-// NewClient returns a new client for talking to a Prometheus Backend, or an error
-func NewClient(address string, nodeLister corelistersv1.NodeLister) (metrics.Backend, error) {
+// Matches custom query templates that may reference the AutoscalingGroup,
+// either directly or via the raw configuration. Keys of the raw configuration
+// accessed as fields, e.g. {{.Configuration.service}}, can't be the
+// AutoscalingGroup key since it's not a valid identifier.
+var autoscalingGroupTemplateRegex = regexp.MustCompile(`AutoscalingGroup|\.Configuration([^.\w]|$)`)
+
+// NewClient returns a new client for talking to a Prometheus Backend, or an
+// error. The HTTP config is used to authenticate, configure TLS, and add extra
+// headers to requests, e.g. when Prometheus is behind an authenticating proxy.
+// Targets are cached for the given TTL, or not at all if it's zero.
+func NewClient(address string, httpConfig httpconfig.Config, nodeLister corelistersv1.NodeLister,
+	cacheTTL time.Duration) (metrics.Backend, error) {
 	if address == "" {
 		// Under the hood, prometheusclient uses url.Parse() which allows
 		// relative URLs, etc. Empty would be allowed, so disallow it
@@ -67,8 +95,14 @@ func NewClient(address string, nodeLister corelistersv1.NodeLister) (metrics.Bac
 		return nil, errors.New("node lister must be provided")
 	}
 
+	roundTripper, err := httpConfig.RoundTripper()
+	if err != nil {
+		return nil, errors.Wrap(err, "configuring HTTP transport")
+	}
+
 	client, err := prometheusclient.NewClient(prometheusclient.Config{
-		Address: address,
+		Address:      address,
+		RoundTripper: roundTripper,
 	})
 	if err != nil {
 		return nil, errors.Wrap(err, "instantiating prometheus client")
@@ -79,65 +113,135 @@ func NewClient(address string, nodeLister corelistersv1.NodeLister) (metrics.Bac
 	return Backend{
 		prometheus: api,
 		nodeLister: nodeLister,
+		targets:    &targetsCache{ttl: cacheTTL},
 	}, nil
 }
 
+// UsesAutoscalingGroup implements the metrics.AutoscalingGroupScopedBackend
+// interface. Only custom queries can reference the AutoscalingGroup.
+func (b Backend) UsesAutoscalingGroup(metric string, configuration map[string]string) bool {
+	return metric == MetricCustom.String() && autoscalingGroupTemplateRegex.MatchString(configuration["query"])
+}
+
 // GetValue implements the metrics.Backend interface
 func (b Backend) GetValue(metric string, configuration map[string]string, nodeSelector map[string]string) (float64, error) {
-	selector := nodeutil.GetNodesLabelSelector(nodeSelector)
-	nodes, err := b.nodeLister.List(selector)
+	query, config, err := b.buildQuery(metric, configuration, nodeSelector)
 	if err != nil {
-		return 0, errors.Wrap(err, "listing nodes")
+		return 0, err
 	}
 
-	podIPs, err := b.getNodeExporterPodIPsOnNodes(nodes)
+	return b.performQuery(query, config.Reducer)
+}
+
+// GetValueRange implements the metrics.RangeBackend interface
+func (b Backend) GetValueRange(metric string, configuration map[string]string, nodeSelector map[string]string,
+	start, end time.Time, step time.Duration) ([]metrics.Sample, error) {
+	query, config, err := b.buildQuery(metric, configuration, nodeSelector)
 	if err != nil {
-		return 0, errors.Wrapf(err, "getting Prometheus node exporter pod IPs for metric %s", metric)
+		return nil, err
+	}
+
+	return b.performRangeQuery(query, prometheus.Range{
+		Start: start,
+		End:   end,
+		Step:  step,
+	}, config.Reducer)
+}
+
+// buildQuery builds the query string for the given metric and configuration
+// across the nodes matching the node selector. The validated configuration is
+// returned along with the query.
+func (b Backend) buildQuery(metric string, configuration map[string]string,
+	nodeSelector map[string]string) (string, metricConfiguration, error) {
+	config := metricConfiguration{}
+	if err := config.defaultAndValidate(configuration); err != nil {
+		return "", config, errors.Wrap(err, "validating configuration")
+	}
+
+	if err := b.populateNodes(&config, nodeSelector); err != nil {
+		return "", config, errors.Wrapf(err, "matching series to nodes for metric %s", metric)
 	}
 
+	var query string
+	var err error
 	switch metric {
 	case MetricCPUPercentUtilization.String():
-		query, err := buildCPUQuery(podIPs, configuration)
-		if err != nil {
-			return 0, errors.Wrap(err, "building query")
-		}
-		return b.performQuery(query)
+		query, err = buildCPUQuery(config)
 
 	case MetricMemoryPercentUtilization.String():
-		query, err := buildMemoryQuery(podIPs, configuration)
-		if err != nil {
-			return 0, errors.Wrap(err, "building query")
-		}
-		return b.performQuery(query)
+		query, err = buildMemoryQuery(config)
 
 	case MetricCustom.String():
-		query, err := buildCustomQuery(podIPs, configuration)
+		query, err = buildCustomQuery(config, configuration)
+
+	default:
+		return "", config, errors.Errorf("unknown metric %q", metric)
+	}
+
+	if err != nil {
+		return "", config, errors.Wrap(err, "building query")
+	}
+
+	return query, config, nil
+}
+
+// populateNodes fills in the template fields describing the nodes matching
+// the node selector, including those used to restrict queries to the series
+// of those nodes according to the configured node mapping
+func (b Backend) populateNodes(config *metricConfiguration, nodeSelector map[string]string) error {
+	selector := nodeutil.GetNodesLabelSelector(nodeSelector)
+	nodes, err := b.nodeLister.List(selector)
+	if err != nil {
+		return errors.Wrap(err, "listing nodes")
+	}
+
+	config.NodeNames = make([]string, len(nodes))
+	for i, node := range nodes {
+		config.NodeNames[i] = node.ObjectMeta.Name
+	}
+	sort.Strings(config.NodeNames)
+
+	config.NodeNamesRegex = buildNodeNamesRegex(config.NodeNames)
+	config.NodeSelector = nodeSelector
+	config.Selector = selector.String()
+
+	switch config.NodeMapping {
+	case nodeMappingPodIP:
+		podIPs, err := b.getNodeExporterPodIPsOnNodes(nodes, config.nodeExporterJobRegex)
 		if err != nil {
-			return 0, errors.Wrap(err, "building query")
+			return errors.Wrap(err, "getting Prometheus node exporter pod IPs")
 		}
-		return b.performQuery(query)
 
-	default:
-		return 0, errors.Errorf("unknown metric %q", metric)
+		config.PodIPsRegex = buildPodIPsRegex(podIPs)
+		config.NodeMatcher = fmt.Sprintf("instance=~'%s'", config.PodIPsRegex)
+
+	case nodeMappingLabel:
+		config.NodeMatcher = fmt.Sprintf("%s=~'%s'", config.NodeLabel, config.NodeNamesRegex)
 	}
+
+	return nil
 }
 
-func (b Backend) getNodeExporterPodIPsOnNodes(nodes []*corev1.Node) ([]string, error) {
+// getNodeExporterPodIPsOnNodes returns the pod IPs of the node exporter
+// targets running on the given nodes. Targets are node exporters if their job
+// matches jobRegex.
+func (b Backend) getNodeExporterPodIPsOnNodes(nodes []*corev1.Node, jobRegex *regexp.Regexp) ([]string, error) {
 	var podIPs []string
 
-	ctx, cancel := context.WithTimeout(context.Background(), prometheusRequestTimeout)
-	defer cancel()
+	targets, err := b.getTargets()
+	if err != nil {
+		return nil, errors.Wrap(err, "getting Prometheus targets")
+	}
 
-	// Filter only prom-exporter job, and further filter down by node IPs
-	targets, _ := b.prometheus.Targets(ctx)
+	// Filter only node exporter jobs, and further filter down by node name
 	for _, active := range targets.Active {
-		jobName := string(active.DiscoveredLabels["job"])
-		split := strings.Split(jobName, "/")
-		if split[1] == "node-export-monitor" {
-			for _, node := range nodes {
-				if string(active.DiscoveredLabels["__meta_kubernetes_pod_node_name"]) == node.ObjectMeta.Name {
-					podIPs = append(podIPs, string(active.DiscoveredLabels["__meta_kubernetes_pod_ip"]))
-				}
+		if !jobRegex.MatchString(string(active.DiscoveredLabels["job"])) {
+			continue
+		}
+
+		for _, node := range nodes {
+			if string(active.DiscoveredLabels["__meta_kubernetes_pod_node_name"]) == node.ObjectMeta.Name {
+				podIPs = append(podIPs, string(active.DiscoveredLabels["__meta_kubernetes_pod_ip"]))
 			}
 		}
 	}
@@ -149,7 +253,41 @@ func (b Backend) getNodeExporterPodIPsOnNodes(nodes []*corev1.Node) ([]string, e
 	return podIPs, nil
 }
 
-func (b Backend) performQuery(query string) (float64, error) {
+// getTargets returns the active targets of Prometheus, from the cache if
+// they haven't expired
+func (b Backend) getTargets() (prometheus.TargetsResult, error) {
+	if b.targets == nil {
+		return b.queryTargets()
+	}
+
+	b.targets.Lock()
+	defer b.targets.Unlock()
+
+	if time.Now().Before(b.targets.expires) {
+		return b.targets.result, nil
+	}
+
+	result, err := b.queryTargets()
+	if err != nil {
+		return result, err
+	}
+
+	if b.targets.ttl > 0 {
+		b.targets.result = result
+		b.targets.expires = time.Now().Add(b.targets.ttl)
+	}
+
+	return result, nil
+}
+
+func (b Backend) queryTargets() (prometheus.TargetsResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), prometheusRequestTimeout)
+	defer cancel()
+
+	return b.prometheus.Targets(ctx)
+}
+
+func (b Backend) performQuery(query string, reducer string) (float64, error) {
 	log.Debugf("Performing prometheus query: %s", query)
 
 	ctx, cancel := context.WithTimeout(context.Background(), prometheusRequestTimeout)
@@ -162,12 +300,19 @@ func (b Backend) performQuery(query string) (float64, error) {
 
 	var result float64
 	switch v := val.(type) {
+	case *model.Scalar:
+		result = float64(v.Value)
+
 	case model.Vector:
-		if len(v) != 1 {
-			return 0, errors.Errorf("expected vector to have a single element but it has %d", len(v))
+		values := make([]float64, len(v))
+		for i, sample := range v {
+			values[i] = float64(sample.Value)
 		}
 
-		result = float64(v[0].Value)
+		result, err = metrics.Reduce(values, reducer)
+		if err != nil {
+			return 0, errors.Wrap(err, "reducing vector")
+		}
 
 	default:
 		return 0, errors.Errorf("unexpected prometheus value type %T: %#v", v, v)
@@ -176,14 +321,61 @@ func (b Backend) performQuery(query string) (float64, error) {
 	return result, nil
 }
 
-func buildCPUQuery(podIPs []string, configuration map[string]string) (string, error) {
-	config := metricConfiguration{}
-	if err := config.defaultAndValidate(configuration); err != nil {
-		return "", errors.Wrap(err, "validating configuration")
+func (b Backend) performRangeQuery(query string, r prometheus.Range, reducer string) ([]metrics.Sample, error) {
+	log.Debugf("Performing prometheus range query: %s", query)
+
+	ctx, cancel := context.WithTimeout(context.Background(), prometheusRequestTimeout)
+	defer cancel()
+
+	val, err := b.prometheus.QueryRange(ctx, query, r)
+	if err != nil {
+		return nil, errors.Wrapf(err, "querying prometheus range with string %q", query)
 	}
 
-	config.PodIPsRegex = buildPodIPsRegex(podIPs)
+	var samples []metrics.Sample
+	switch v := val.(type) {
+	case model.Matrix:
+		if len(v) == 0 || (len(v) > 1 && reducer == "") {
+			return nil, errors.Errorf("expected matrix to have a single series but it has %d", len(v))
+		}
 
+		// Series may not have values at the same timestamps, so reduce the
+		// values present at each timestamp in series order
+		var timestamps []model.Time
+		values := make(map[model.Time][]float64)
+		for _, series := range v {
+			for _, pair := range series.Values {
+				if _, ok := values[pair.Timestamp]; !ok {
+					timestamps = append(timestamps, pair.Timestamp)
+				}
+				values[pair.Timestamp] = append(values[pair.Timestamp], float64(pair.Value))
+			}
+		}
+
+		sort.Slice(timestamps, func(i, j int) bool {
+			return timestamps[i].Before(timestamps[j])
+		})
+
+		for _, ts := range timestamps {
+			value, err := metrics.Reduce(values[ts], reducer)
+			if err != nil {
+				return nil, errors.Wrapf(err, "reducing matrix at %s", ts.Time())
+			}
+
+			samples = append(samples, metrics.Sample{
+				Timestamp: ts.Time(),
+				Value:     value,
+			})
+		}
+
+	default:
+		return nil, errors.Errorf("unexpected prometheus value type %T: %#v", v, v)
+	}
+
+	return samples, nil
+}
+
+func buildCPUQuery(config metricConfiguration) (string, error) {
 	var out bytes.Buffer
 	if err := cpuQueryTemplate.Execute(&out, config); err != nil {
 		return "", err
@@ -192,14 +384,7 @@ func buildCPUQuery(podIPs []string, configuration map[string]string) (string, er
 	return out.String(), nil
 }
 
-func buildMemoryQuery(podIPs []string, configuration map[string]string) (string, error) {
-	config := metricConfiguration{}
-	if err := config.defaultAndValidate(configuration); err != nil {
-		return "", errors.Wrap(err, "validating configuration")
-	}
-
-	config.PodIPsRegex = buildPodIPsRegex(podIPs)
-
+func buildMemoryQuery(config metricConfiguration) (string, error) {
 	var out bytes.Buffer
 	if err := memoryQueryTemplate.Execute(&out, config); err != nil {
 		return "", err
@@ -208,19 +393,16 @@ func buildMemoryQuery(podIPs []string, configuration map[string]string) (string,
 	return out.String(), nil
 }
 
-// For a custom query, there should be a single `query` key provided in the
-// configuration map. No further configuration keys are currently supported.
-func buildCustomQuery(podIPs []string, configuration map[string]string) (string, error) {
-	var query string
-	var ok bool
-	query, ok = configuration["query"]
+// For a custom query, a `query` key must be provided in the configuration map.
+// The query is a template which is executed with the rest of the validated
+// configuration, including the fields describing the nodes being queried and
+// the raw configuration.
+func buildCustomQuery(config metricConfiguration, configuration map[string]string) (string, error) {
+	query, ok := configuration["query"]
 	if !ok {
-		return "", errors.New("single configuration key \"query\" must be provided for a custom query")
+		return "", errors.New("configuration key \"query\" must be provided for a custom query")
 	}
 
-	config := metricConfiguration{}
-	config.PodIPsRegex = buildPodIPsRegex(podIPs)
-
 	template, err := template.New("query").Parse(query)
 	if err != nil {
 		return "", errors.Wrap(err, "parsing custom query template")
@@ -245,3 +427,19 @@ func buildPodIPsRegex(podIPs []string) string {
 
 	return regex
 }
+
+// buildNodeNamesRegex returns a regex matching any of the node names, with an
+// optional port so that it also matches instance labels such as node-0:9100.
+// Regex metacharacters are escaped for use within a PromQL string.
+func buildNodeNamesRegex(names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = strings.Replace(regexp.QuoteMeta(name), `\`, `\\`, -1)
+	}
+
+	return fmt.Sprintf("(%s)(:[0-9]+)?", strings.Join(quoted, "|"))
+}
@@ -2,6 +2,7 @@ package prometheus
 
 import (
 	"fmt"
+	"regexp"
 	"testing"
 	"time"
 
@@ -18,6 +19,8 @@ import (
 	corelistersv1 "k8s.io/client-go/listers/core/v1"
 
 	"github.com/containership/cerebral/pkg/kubernetestest"
+	"github.com/containership/cerebral/pkg/metrics"
+	"github.com/containership/cerebral/pkg/metrics/backends/httpconfig"
 	"github.com/containership/cerebral/pkg/metrics/backends/prometheus/mocks"
 )
 
@@ -120,20 +123,31 @@ var (
 		"__meta_kubernetes_pod_ip":        "1.1.1.192",
 		"job":                             "random/cadvisor/random",
 	}
+
+	dlNoSlashJob = model.LabelSet{
+		"__meta_kubernetes_pod_node_name": "other-0",
+		"__meta_kubernetes_pod_ip":        "1.1.1.193",
+		"job":                             "kubelet",
+	}
+
+	defaultJobRegex = regexp.MustCompile("^(?:" + defaultNodeExporterJob + ")$")
 )
 
 func TestNewClient(t *testing.T) {
 	// Should never fail with any valid URL because it's only constructing an
 	// http.Client under the hood
-	client, err := NewClient(validURL, corelistersv1.NewNodeLister(nil))
+	client, err := NewClient(validURL, httpconfig.Config{}, corelistersv1.NewNodeLister(nil), 0)
 	assert.NotNil(t, client)
 	assert.NoError(t, err, "any valid URL is ok")
 
-	client, err = NewClient("", corelistersv1.NewNodeLister(nil))
+	client, err = NewClient("", httpconfig.Config{}, corelistersv1.NewNodeLister(nil), 0)
 	assert.Error(t, err, "error on empty URL")
 
-	_, err = NewClient(validURL, nil)
+	_, err = NewClient(validURL, httpconfig.Config{}, nil, 0)
 	assert.Error(t, err, "error on nil NodeLister")
+
+	_, err = NewClient(validURL, httpconfig.Config{CA: []byte("invalid")}, corelistersv1.NewNodeLister(nil), 0)
+	assert.Error(t, err, "error on invalid HTTP config")
 }
 
 func TestGetValue(t *testing.T) {
@@ -174,12 +188,18 @@ func TestGetValue(t *testing.T) {
 	_, err = backend.GetValue("cpu_percent_utilization", goodConfiguration, nil)
 	assert.Error(t, err, "error on nil result")
 
-	// Return unexpected non-Vector type
+	// Return single element vector as expected
 	mockProm.On("Query", mock.Anything, mock.Anything, mock.Anything).
-		Return(&model.Scalar{}, nil).Once()
+		Return(model.Vector{
+			{
+				Metric:    model.Metric{},
+				Value:     0.5,
+				Timestamp: 1234,
+			},
+		}, nil).Once()
 
 	_, err = backend.GetValue("cpu_percent_utilization", goodConfiguration, nil)
-	assert.Error(t, err, "error on non-vector result")
+	assert.NoError(t, err, "single element vector is ok")
 
 	// Return single element vector as expected
 	mockProm.On("Query", mock.Anything, mock.Anything, mock.Anything).
@@ -189,12 +209,17 @@ func TestGetValue(t *testing.T) {
 				Value:     0.5,
 				Timestamp: 1234,
 			},
+			{
+				Metric:    model.Metric{},
+				Value:     1.75,
+				Timestamp: 1234,
+			},
 		}, nil).Once()
 
 	_, err = backend.GetValue("cpu_percent_utilization", goodConfiguration, nil)
-	assert.NoError(t, err, "single element vector is ok")
+	assert.Error(t, err, "multiple element vector errors")
 
-	// Return single element vector as expected
+	// Return multiple element vector with a reducer configured
 	mockProm.On("Query", mock.Anything, mock.Anything, mock.Anything).
 		Return(model.Vector{
 			{
@@ -209,13 +234,122 @@ func TestGetValue(t *testing.T) {
 			},
 		}, nil).Once()
 
+	val, err := backend.GetValue("cpu_percent_utilization", map[string]string{"reducer": "sum"}, nil)
+	assert.NoError(t, err, "multiple element vector is ok with reducer")
+	assert.Equal(t, 2.25, val, "vector is reduced")
+
+	// Return empty vector with a reducer configured
+	mockProm.On("Query", mock.Anything, mock.Anything, mock.Anything).
+		Return(model.Vector{}, nil).Once()
+
+	_, err = backend.GetValue("cpu_percent_utilization", map[string]string{"reducer": "sum"}, nil)
+	assert.Error(t, err, "empty vector errors even with reducer")
+
+	// Return scalar
+	mockProm.On("Query", mock.Anything, mock.Anything, mock.Anything).
+		Return(&model.Scalar{Value: 3, Timestamp: 1234}, nil).Once()
+
+	val, err = backend.GetValue("cpu_percent_utilization", goodConfiguration, nil)
+	assert.NoError(t, err, "scalar is ok")
+	assert.Equal(t, 3.0, val)
+
+	// Return unexpected non-Vector type
+	mockProm.On("Query", mock.Anything, mock.Anything, mock.Anything).
+		Return(&model.String{}, nil).Once()
+
 	_, err = backend.GetValue("cpu_percent_utilization", goodConfiguration, nil)
-	assert.Error(t, err, "multiple element vector errors")
+	assert.Error(t, err, "error on string result")
+
+	_, err = backend.GetValue("cpu_percent_utilization", map[string]string{"reducer": "median"}, nil)
+	assert.Error(t, err, "invalid reducer")
 
 	_, err = backend.GetValue("not a valid metric", goodConfiguration, nil)
 	assert.Error(t, err, "unknown metric requested")
 }
 
+func TestGetValueRange(t *testing.T) {
+	nodeLister := kubernetestest.BuildNodeLister(nil)
+
+	mockProm := mocks.API{}
+	mockProm.On("Targets", mock.Anything).Return(prometheus.TargetsResult{}, nil)
+
+	backend := Backend{
+		prometheus: &mockProm,
+		nodeLister: nodeLister,
+	}
+
+	end := time.Unix(1000, 0)
+	start := end.Add(-time.Minute)
+
+	// Return error
+	mockProm.On("QueryRange", mock.Anything, mock.Anything, mock.Anything).
+		Return(nil, fmt.Errorf("some prometheus error")).Once()
+
+	_, err := backend.GetValueRange("cpu_percent_utilization", goodConfiguration, nil, start, end, 30*time.Second)
+	assert.Error(t, err, "error when prometheus errors")
+
+	// Return unexpected non-Matrix type
+	mockProm.On("QueryRange", mock.Anything, mock.Anything, mock.Anything).
+		Return(model.Vector{}, nil).Once()
+
+	_, err = backend.GetValueRange("cpu_percent_utilization", goodConfiguration, nil, start, end, 30*time.Second)
+	assert.Error(t, err, "error on non-matrix result")
+
+	// Return multiple series
+	mockProm.On("QueryRange", mock.Anything, mock.Anything, mock.Anything).
+		Return(model.Matrix{{}, {}}, nil).Once()
+
+	_, err = backend.GetValueRange("cpu_percent_utilization", goodConfiguration, nil, start, end, 30*time.Second)
+	assert.Error(t, err, "multiple series errors")
+
+	// Return single series as expected
+	mockProm.On("QueryRange", mock.Anything, mock.Anything, mock.Anything).
+		Return(model.Matrix{
+			{
+				Metric: model.Metric{},
+				Values: []model.SamplePair{
+					{Timestamp: model.TimeFromUnix(start.Unix()), Value: 0.5},
+					{Timestamp: model.TimeFromUnix(end.Unix()), Value: 1.5},
+				},
+			},
+		}, nil).Once()
+
+	samples, err := backend.GetValueRange("cpu_percent_utilization", goodConfiguration, nil, start, end, 30*time.Second)
+	assert.NoError(t, err, "single series is ok")
+	assert.Len(t, samples, 2)
+	assert.Equal(t, end, samples[1].Timestamp)
+	assert.Equal(t, 1.5, samples[1].Value)
+
+	// Return multiple series with a reducer configured
+	mockProm.On("QueryRange", mock.Anything, mock.Anything, mock.Anything).
+		Return(model.Matrix{
+			{
+				Metric: model.Metric{},
+				Values: []model.SamplePair{
+					{Timestamp: model.TimeFromUnix(start.Unix()), Value: 0.5},
+					{Timestamp: model.TimeFromUnix(end.Unix()), Value: 1.5},
+				},
+			},
+			{
+				Metric: model.Metric{},
+				Values: []model.SamplePair{
+					{Timestamp: model.TimeFromUnix(end.Unix()), Value: 2.5},
+				},
+			},
+		}, nil).Once()
+
+	samples, err = backend.GetValueRange("cpu_percent_utilization", map[string]string{"reducer": "max"},
+		nil, start, end, 30*time.Second)
+	assert.NoError(t, err, "multiple series are ok with reducer")
+	assert.Len(t, samples, 2)
+	assert.Equal(t, start, samples[0].Timestamp)
+	assert.Equal(t, 0.5, samples[0].Value, "value present in one series")
+	assert.Equal(t, 2.5, samples[1].Value, "values reduced across series")
+
+	_, err = backend.GetValueRange("not a valid metric", goodConfiguration, nil, start, end, 30*time.Second)
+	assert.Error(t, err, "unknown metric requested")
+}
+
 func TestGetNodeExporterPodIPsOnNodes(t *testing.T) {
 	mockProm := mocks.API{}
 	mockProm.On("Targets", mock.Anything).Return(
@@ -230,6 +364,9 @@ func TestGetNodeExporterPodIPsOnNodes(t *testing.T) {
 				{
 					DiscoveredLabels: dlOtherNode,
 				},
+				{
+					DiscoveredLabels: dlNoSlashJob,
+				},
 			},
 		}, nil)
 
@@ -238,13 +375,13 @@ func TestGetNodeExporterPodIPsOnNodes(t *testing.T) {
 	}
 
 	// Empty cache but no nodes requested
-	ips, err := backend.getNodeExporterPodIPsOnNodes(nil)
+	ips, err := backend.getNodeExporterPodIPsOnNodes(nil, defaultJobRegex)
 	assert.NoError(t, err)
 	assert.Empty(t, ips, "no nodes with empty pod cache --> no IPs")
 
 	nodes := []*corev1.Node{&promNode0, &promNode1}
 
-	ips, err = backend.getNodeExporterPodIPsOnNodes(nodes)
+	ips, err = backend.getNodeExporterPodIPsOnNodes(nodes, defaultJobRegex)
 
 	assert.NoError(t, err)
 	assert.Len(t, ips, len(nodes), "proper number of pod IPs found")
@@ -252,43 +389,202 @@ func TestGetNodeExporterPodIPsOnNodes(t *testing.T) {
 	assert.Contains(t, ips, podIP1, "found expected pod IP 1")
 
 	// Cache still full with valid pods, querying for zero nodes
-	ips, err = backend.getNodeExporterPodIPsOnNodes(nil)
+	ips, err = backend.getNodeExporterPodIPsOnNodes(nil, defaultJobRegex)
 	assert.NoError(t, err)
 	assert.Empty(t, ips, "no nodes with full pod cache --> no IPs")
 
 	// Add another node which is not running exporter
 	nodes = []*corev1.Node{&promNode0, &promNode1, &otherNode0}
-	ips, err = backend.getNodeExporterPodIPsOnNodes(nodes)
+	ips, err = backend.getNodeExporterPodIPsOnNodes(nodes, defaultJobRegex)
 	assert.Error(t, err, "not every node running node exporter")
+
+	// Match a job without a slash instead
+	nodes = []*corev1.Node{&otherNode0}
+	ips, err = backend.getNodeExporterPodIPsOnNodes(nodes, regexp.MustCompile("^(?:kubelet)$"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.1.1.193"}, ips, "custom job regex")
+
+	mockProm = mocks.API{}
+	mockProm.On("Targets", mock.Anything).Return(prometheus.TargetsResult{}, fmt.Errorf("some prometheus error"))
+	backend.prometheus = &mockProm
+	_, err = backend.getNodeExporterPodIPsOnNodes(nodes, defaultJobRegex)
+	assert.Error(t, err, "error when prometheus errors")
 }
 
-func TestBuildCPUQuery(t *testing.T) {
-	_, err := buildCPUQuery(oneIP, goodConfiguration)
-	assert.NoError(t, err, "good configuration is ok")
+func TestGetTargets(t *testing.T) {
+	mockProm := mocks.API{}
+	mockProm.On("Targets", mock.Anything).Return(prometheus.TargetsResult{}, fmt.Errorf("some prometheus error")).Once()
+	mockProm.On("Targets", mock.Anything).Return(
+		prometheus.TargetsResult{
+			Active: []prometheus.ActiveTarget{
+				{
+					DiscoveredLabels: dlNode0,
+				},
+			},
+		}, nil)
+
+	backend := Backend{
+		prometheus: &mockProm,
+		targets:    &targetsCache{ttl: time.Minute},
+	}
+
+	_, err := backend.getTargets()
+	assert.Error(t, err, "error when prometheus errors")
+
+	for i := 0; i < 3; i++ {
+		targets, err := backend.getTargets()
+		assert.NoError(t, err)
+		assert.Len(t, targets.Active, 1)
+	}
+	mockProm.AssertNumberOfCalls(t, "Targets", 2)
+
+	backend.targets.expires = time.Now()
+	_, err = backend.getTargets()
+	assert.NoError(t, err)
+	mockProm.AssertNumberOfCalls(t, "Targets", 3)
+
+	backend.targets = &targetsCache{}
+	_, err = backend.getTargets()
+	assert.NoError(t, err)
+	_, err = backend.getTargets()
+	assert.NoError(t, err)
+	mockProm.AssertNumberOfCalls(t, "Targets", 5)
+}
+
+func TestUsesAutoscalingGroup(t *testing.T) {
+	backend := Backend{}
+
+	assert.False(t, backend.UsesAutoscalingGroup("cpu_percent_utilization", nil))
+	assert.False(t, backend.UsesAutoscalingGroup("custom", map[string]string{
+		"query": "sum(rate(requests_total{ {{- .NodeMatcher -}} }[5m]))",
+	}))
+	assert.False(t, backend.UsesAutoscalingGroup("custom", map[string]string{
+		"query": "sum(rate(requests_total{service='{{.Configuration.service}}'}[5m]))",
+	}), "configuration fields can't be the AutoscalingGroup")
+	assert.True(t, backend.UsesAutoscalingGroup("custom", map[string]string{
+		"query": "sum(queue_depth{asg='{{.AutoscalingGroup}}'})",
+	}))
+	assert.True(t, backend.UsesAutoscalingGroup("custom", map[string]string{
+		"query": `sum(queue_depth{asg='{{index .Configuration "cerebral.containership.io/autoscaling-group"}}'})`,
+	}))
+}
+
+func TestBuildQueryNodeMapping(t *testing.T) {
+	mockProm := mocks.API{}
+	mockProm.On("Targets", mock.Anything).Return(
+		prometheus.TargetsResult{
+			Active: []prometheus.ActiveTarget{
+				{
+					DiscoveredLabels: dlNode0,
+				},
+				{
+					DiscoveredLabels: dlNode1,
+				},
+			},
+		}, nil)
+
+	backend := Backend{
+		prometheus: &mockProm,
+		nodeLister: kubernetestest.BuildNodeLister([]corev1.Node{promNode0, promNode1}),
+	}
 
-	_, err = buildCPUQuery(oneIP, emptyConfiguration)
-	assert.NoError(t, err, "empty configuration is ok (defaults)")
+	query, _, err := backend.buildQuery("cpu_percent_utilization", emptyConfiguration, nil)
+	assert.NoError(t, err)
+	assert.Contains(t, query, "{mode='idle',instance=~'192.168.0.1:.*|192.168.1.1:.*'}", "pod IP mapping by default")
+
+	query, _, err = backend.buildQuery("memory_percent_utilization", map[string]string{
+		"nodeMapping": "node-label",
+	}, nil)
+	assert.NoError(t, err)
+	assert.Contains(t, query, "node_memory_MemAvailable{node=~'(prom-0|prom-1)(:[0-9]+)?'}", "node label mapping")
 
-	_, err = buildCPUQuery(oneIP, badAggregationConfiguration)
+	query, _, err = backend.buildQuery("cpu_percent_utilization", map[string]string{
+		"nodeMapping": "node-label",
+		"nodeLabel":   "kubernetes_node",
+	}, nil)
+	assert.NoError(t, err)
+	assert.Contains(t, query, "{mode='idle',kubernetes_node=~'(prom-0|prom-1)(:[0-9]+)?'}", "custom node label")
+
+	query, _, err = backend.buildQuery("memory_percent_utilization", map[string]string{
+		"nodeMapping": "none",
+	}, nil)
+	assert.NoError(t, err)
+	assert.Contains(t, query, "node_memory_MemAvailable{}", "no node filtering")
+
+	query, _, err = backend.buildQuery("custom", map[string]string{
+		"nodeMapping": "none",
+		"query":       "up{ {{- .NodeMatcher -}} }",
+	}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "up{}", query, "custom query with no node filtering")
+
+	_, _, err = backend.buildQuery("cpu_percent_utilization", map[string]string{
+		"nodeMapping": "node-label",
+		"nodeLabel":   "not-valid",
+	}, nil)
+	assert.Error(t, err, "invalid node label")
+
+	_, _, err = backend.buildQuery("cpu_percent_utilization", map[string]string{
+		"nodeExporterJob": "(",
+	}, nil)
+	assert.Error(t, err, "invalid node exporter job regex")
+
+	_, _, err = backend.buildQuery("cpu_percent_utilization", badAggregationConfiguration, nil)
 	assert.Error(t, err, "invalid aggregation errors")
 }
 
-func TestBuildMemoryQuery(t *testing.T) {
-	_, err := buildMemoryQuery(oneIP, goodConfiguration)
+func TestBuildQueryTemplateData(t *testing.T) {
+	backend := Backend{
+		nodeLister: kubernetestest.BuildNodeLister([]corev1.Node{promNode1, promNode0}),
+	}
+
+	query, _, err := backend.buildQuery("custom", map[string]string{
+		"nodeMapping":               "none",
+		"service":                   "checkout",
+		metrics.AutoscalingGroupKey: "workers",
+		"query": `{{.AutoscalingGroup}} {{.Configuration.service}} {{index .NodeSelector "role"}} ` +
+			`{{.Selector}} {{range .NodeNames}}{{.}} {{end}}`,
+	}, map[string]string{"role": "worker"})
+	assert.NoError(t, err)
+	assert.Equal(t, "workers checkout worker role=worker ", query, "template data populated without matching nodes")
+
+	query, config, err := backend.buildQuery("custom", map[string]string{
+		"nodeMapping": "none",
+		"reducer":     "first",
+		"query":       `{{range .NodeNames}}{{.}} {{end}}`,
+	}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "prom-0 prom-1 ", query, "node names are sorted")
+	assert.Equal(t, "first", config.Reducer, "validated configuration returned")
+}
+
+func TestBuildCPUQuery(t *testing.T) {
+	config := metricConfiguration{}
+	assert.NoError(t, config.defaultAndValidate(goodConfiguration))
+
+	_, err := buildCPUQuery(config)
 	assert.NoError(t, err, "good configuration is ok")
+}
 
-	_, err = buildMemoryQuery(oneIP, emptyConfiguration)
-	assert.NoError(t, err, "empty configuration is ok (defaults)")
+func TestBuildMemoryQuery(t *testing.T) {
+	config := metricConfiguration{}
+	assert.NoError(t, config.defaultAndValidate(goodConfiguration))
 
-	_, err = buildMemoryQuery(oneIP, badAggregationConfiguration)
-	assert.Error(t, err, "invalid aggregation errors")
+	_, err := buildMemoryQuery(config)
+	assert.NoError(t, err, "good configuration is ok")
 }
 
 func TestBuildCustomQuery(t *testing.T) {
-	_, err := buildCustomQuery(oneIP, goodCustomQueryConfiguration)
+	config := metricConfiguration{}
+	assert.NoError(t, config.defaultAndValidate(goodCustomQueryConfiguration))
+	config.PodIPsRegex = buildPodIPsRegex(oneIP)
+
+	query, err := buildCustomQuery(config, goodCustomQueryConfiguration)
 	assert.NoError(t, err, "good custom query configuration is ok")
+	assert.Contains(t, query, "avg(", "aggregation is templated")
+	assert.Contains(t, query, "instance=~'10.0.0.1:.*'", "pod IPs regex is templated")
 
-	_, err = buildCustomQuery(oneIP, emptyConfiguration)
+	_, err = buildCustomQuery(config, emptyConfiguration)
 	assert.Error(t, err, "empty configuration is invalid (requires query)")
 }
 
@@ -306,6 +602,18 @@ func TestBuildPodIPsRegex(t *testing.T) {
 	assert.Equal(t, "10.0.0.1:.*|10.0.0.2:.*|10.0.0.3:.*", regex, "multiple IP regex")
 }
 
+func TestBuildNodeNamesRegex(t *testing.T) {
+	regex := buildNodeNamesRegex(nil)
+	assert.Empty(t, regex, "no node names results in empty regex")
+
+	regex = buildNodeNamesRegex([]string{"node-0"})
+	assert.Equal(t, "(node-0)(:[0-9]+)?", regex, "single node regex")
+
+	regex = buildNodeNamesRegex([]string{"node-0", "ip-10-0-0-1.ec2.internal"})
+	assert.Equal(t, `(node-0|ip-10-0-0-1\\.ec2\\.internal)(:[0-9]+)?`, regex,
+		"dots are escaped for PromQL strings")
+}
+
 // Get a pod lister. Copies of the pods are added to the cache; not the pods themselves.
 func buildPodLister(pods []corev1.Pod) corelistersv1.PodLister {
 	// We don't need anything related to the client or informer; we're simply
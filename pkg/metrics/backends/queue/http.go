@@ -0,0 +1,98 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/containership/cerebral/pkg/metrics/backends/httpconfig"
+	"github.com/containership/cluster-manager/pkg/log"
+)
+
+const (
+	httpRequestTimeout = 10 * time.Second
+)
+
+// HTTPClient performs HTTP requests. It's satisfied by *http.Client and
+// allows the client to be swapped out, e.g. in tests.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// newHTTPClient returns the validated address with any trailing slash removed
+// and an HTTP client configured using the given configuration
+func newHTTPClient(configuration map[string]string, getSecret httpconfig.SecretGetter) (string, HTTPClient, error) {
+	address := configuration["address"]
+	if address == "" {
+		return "", nil, errors.New("address must not be empty")
+	}
+
+	u, err := url.Parse(address)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "parsing address")
+	}
+
+	if u.Scheme == "" || u.Host == "" {
+		return "", nil, errors.Errorf("address %q must be in the format scheme://host:<port>", address)
+	}
+
+	httpConfig, err := httpconfig.FromConfiguration(configuration, getSecret)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "parsing HTTP configuration")
+	}
+
+	roundTripper, err := httpConfig.RoundTripper()
+	if err != nil {
+		return "", nil, errors.Wrap(err, "configuring HTTP transport")
+	}
+
+	return strings.TrimSuffix(address, "/"), &http.Client{Transport: roundTripper}, nil
+}
+
+// getJSON performs a GET request for the given URL and decodes the JSON
+// response into out
+func getJSON(client HTTPClient, u string, out interface{}) error {
+	log.Debugf("Performing queue backend request: GET %s", u)
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return errors.Wrap(err, "building request")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), httpRequestTimeout)
+	defer cancel()
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "requesting %s", u)
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return errors.Wrapf(err, "reading response from %s", u)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		msg := strings.TrimSpace(string(body))
+		if len(msg) > 256 {
+			msg = msg[:256]
+		}
+		return errors.Errorf("requesting %s returned status %d: %s", u, res.StatusCode, msg)
+	}
+
+	if err := json.Unmarshal(body, out); err != nil {
+		return errors.Wrapf(err, "parsing response from %s", u)
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package queue
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/pkg/errors"
+
+	"github.com/containership/cerebral/pkg/metrics/backends/httpconfig"
+)
+
+// kafkaClient reports the lag of a Kafka consumer group using the v3 API of
+// the Kafka REST Proxy
+type kafkaClient struct {
+	address    string
+	cluster    string
+	httpClient HTTPClient
+}
+
+// kafkaLags is the list of partition lags of a consumer group returned by the
+// Kafka REST Proxy
+type kafkaLags struct {
+	Data []struct {
+		TopicName   string  `json:"topic_name"`
+		PartitionID int     `json:"partition_id"`
+		Lag         float64 `json:"lag"`
+	} `json:"data"`
+}
+
+func newKafkaClient(configuration map[string]string, getSecret httpconfig.SecretGetter) (queueClient, error) {
+	address, httpClient, err := newHTTPClient(configuration, getSecret)
+	if err != nil {
+		return nil, errors.Wrap(err, "configuring Kafka client")
+	}
+
+	cluster := configuration["cluster"]
+	if cluster == "" {
+		return nil, errors.New("Kafka cluster ID must be provided")
+	}
+
+	return kafkaClient{
+		address:    address,
+		cluster:    cluster,
+		httpClient: httpClient,
+	}, nil
+}
+
+// Backlog implements queueClient
+func (c kafkaClient) Backlog(config metricConfiguration) (float64, error) {
+	if config.Group == "" {
+		return 0, errors.New("group must be provided")
+	}
+
+	u := fmt.Sprintf("%s/v3/clusters/%s/consumer-groups/%s/lags",
+		c.address, url.PathEscape(c.cluster), url.PathEscape(config.Group))
+
+	var lags kafkaLags
+	if err := getJSON(c.httpClient, u, &lags); err != nil {
+		return 0, errors.Wrapf(err, "getting lag of Kafka consumer group %q", config.Group)
+	}
+
+	var backlog float64
+	var found bool
+	for _, partition := range lags.Data {
+		if config.Topic != "" && partition.TopicName != config.Topic {
+			continue
+		}
+
+		backlog += partition.Lag
+		found = true
+	}
+
+	if !found && config.Topic != "" {
+		return 0, errors.Errorf("Kafka consumer group %q has no partitions of topic %q", config.Group, config.Topic)
+	}
+
+	return backlog, nil
+}
@@ -0,0 +1,84 @@
+package queue
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const kafkaLagsResponse = `{
+	"kind": "KafkaConsumerLagList",
+	"data": [
+		{"topic_name": "events", "partition_id": 0, "current_offset": 10, "log_end_offset": 15, "lag": 5},
+		{"topic_name": "events", "partition_id": 1, "current_offset": 20, "log_end_offset": 22, "lag": 2},
+		{"topic_name": "audit", "partition_id": 0, "current_offset": 0, "log_end_offset": 100, "lag": 100}
+	]
+}`
+
+func TestNewKafkaClient(t *testing.T) {
+	_, err := newKafkaClient(map[string]string{"address": "http://kafka-rest:8082"}, nil)
+	assert.Error(t, err, "error on missing cluster")
+
+	_, err = newKafkaClient(map[string]string{"cluster": "my-cluster"}, nil)
+	assert.Error(t, err, "error on missing address")
+
+	client, err := newKafkaClient(map[string]string{
+		"address": "http://kafka-rest:8082/",
+		"cluster": "my-cluster",
+	}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://kafka-rest:8082", client.(kafkaClient).address, "trailing slash removed")
+}
+
+func TestKafkaBacklog(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+
+		switch r.URL.Path {
+		case "/v3/clusters/my-cluster/consumer-groups/workers/lags":
+			w.Write([]byte(kafkaLagsResponse))
+		case "/v3/clusters/my-cluster/consumer-groups/invalid/lags":
+			w.Write([]byte(`not json`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error_code": 404, "message": "Consumer group not found"}`))
+		}
+	}))
+	defer server.Close()
+
+	getSecret := func(namespace, name string) (map[string][]byte, error) {
+		return map[string][]byte{"token": []byte("token")}, nil
+	}
+
+	client, err := newKafkaClient(map[string]string{
+		"address":           server.URL,
+		"cluster":           "my-cluster",
+		"bearerTokenSecret": "kafka/rest-proxy/token",
+	}, getSecret)
+	assert.NoError(t, err)
+	c := client.(kafkaClient)
+
+	backlog, err := c.Backlog(metricConfiguration{Group: "workers"})
+	assert.NoError(t, err)
+	assert.Equal(t, 107.0, backlog, "lag summed across all partitions")
+
+	backlog, err = c.Backlog(metricConfiguration{Group: "workers", Topic: "events"})
+	assert.NoError(t, err)
+	assert.Equal(t, 7.0, backlog, "lag restricted to topic")
+
+	_, err = c.Backlog(metricConfiguration{Group: "workers", Topic: "missing"})
+	assert.Error(t, err, "group has no partitions of topic")
+
+	_, err = c.Backlog(metricConfiguration{Group: "missing"})
+	if assert.Error(t, err, "unknown group") {
+		assert.Contains(t, err.Error(), "Consumer group not found", "error message is surfaced")
+	}
+
+	_, err = c.Backlog(metricConfiguration{Group: "invalid"})
+	assert.Error(t, err, "invalid response")
+
+	_, err = c.Backlog(metricConfiguration{})
+	assert.Error(t, err, "group required")
+}
@@ -0,0 +1,82 @@
+package queue
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// Metric is a metric exposed by this backend
+type Metric int
+
+const (
+	// MetricBacklog is used to gather the number of pending messages of a
+	// queue, or the lag of a consumer group
+	MetricBacklog Metric = iota
+)
+
+// String is a stringer for Metric
+func (m Metric) String() string {
+	switch m {
+	case MetricBacklog:
+		return "backlog"
+	}
+
+	return "unknown"
+}
+
+type metricConfiguration struct {
+	// -- Generic
+	// PerNode divides the backlog by the number of nodes selected
+	PerNode string `json:"perNode"`
+	// IncludeInFlight includes messages that have been received but not yet
+	// acknowledged in the backlog
+	IncludeInFlight string `json:"includeInFlight"`
+
+	// -- SQS and RabbitMQ
+	Queue string `json:"queue"`
+	// VHost is the RabbitMQ virtual host of the queue
+	VHost string `json:"vhost"`
+
+	// -- Kafka and Redis streams
+	Group string `json:"group"`
+	// Topic optionally restricts Kafka consumer group lag to a single topic
+	Topic string `json:"topic"`
+
+	// -- Redis
+	Key string `json:"key"`
+
+	perNode         bool
+	includeInFlight bool
+}
+
+const defaultVHost = "/"
+
+// defaults and validates the metricConfiguration. Intended to be called with an
+// empty struct that we'll fill in here using the caller-provided configuration.
+// Provider specific fields are validated by each provider.
+func (c *metricConfiguration) defaultAndValidate(configuration map[string]string) error {
+	// Round trip the config through JSON parser to populate our struct
+	j, _ := json.Marshal(configuration)
+	json.Unmarshal(j, c)
+
+	var err error
+	if c.PerNode != "" {
+		if c.perNode, err = strconv.ParseBool(c.PerNode); err != nil {
+			return errors.Wrapf(err, "parsing perNode %q", c.PerNode)
+		}
+	}
+
+	if c.IncludeInFlight != "" {
+		if c.includeInFlight, err = strconv.ParseBool(c.IncludeInFlight); err != nil {
+			return errors.Wrapf(err, "parsing includeInFlight %q", c.IncludeInFlight)
+		}
+	}
+
+	if c.VHost == "" {
+		c.VHost = defaultVHost
+	}
+
+	return nil
+}
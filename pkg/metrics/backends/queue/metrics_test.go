@@ -0,0 +1,48 @@
+package queue
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricString(t *testing.T) {
+	assert.Equal(t, "backlog", MetricBacklog.String())
+	assert.Equal(t, "unknown", Metric(-1).String())
+}
+
+func TestDefaultAndValidate(t *testing.T) {
+	c := metricConfiguration{}
+	err := c.defaultAndValidate(nil)
+	assert.NoError(t, err, "nil config provided is ok")
+	assert.False(t, c.perNode, "not normalized per node by default")
+	assert.False(t, c.includeInFlight, "in flight messages not included by default")
+	assert.Equal(t, defaultVHost, c.VHost, "vhost defaulted")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{
+		"perNode":         "true",
+		"includeInFlight": "true",
+		"queue":           "jobs",
+		"vhost":           "production",
+		"group":           "workers",
+		"topic":           "events",
+		"key":             "jobs:pending",
+	})
+	assert.NoError(t, err, "good config")
+	assert.True(t, c.perNode)
+	assert.True(t, c.includeInFlight)
+	assert.Equal(t, "jobs", c.Queue)
+	assert.Equal(t, "production", c.VHost, "vhost not defaulted if provided")
+	assert.Equal(t, "workers", c.Group)
+	assert.Equal(t, "events", c.Topic)
+	assert.Equal(t, "jobs:pending", c.Key)
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{"perNode": "maybe"})
+	assert.Error(t, err, "bad perNode")
+
+	c = metricConfiguration{}
+	err = c.defaultAndValidate(map[string]string{"includeInFlight": "maybe"})
+	assert.Error(t, err, "bad includeInFlight")
+}
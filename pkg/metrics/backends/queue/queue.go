@@ -0,0 +1,104 @@
+package queue
+
+import (
+	"github.com/pkg/errors"
+
+	corelistersv1 "k8s.io/client-go/listers/core/v1"
+
+	"github.com/containership/cerebral/pkg/metrics"
+	"github.com/containership/cerebral/pkg/metrics/backends/httpconfig"
+	"github.com/containership/cerebral/pkg/nodeutil"
+	"github.com/containership/cluster-manager/pkg/log"
+)
+
+// A queueClient reports the backlog of a queue in a particular message broker
+type queueClient interface {
+	// Backlog returns the number of pending messages of the queue described
+	// by the metric configuration
+	Backlog(config metricConfiguration) (float64, error)
+}
+
+// Backend implements a metrics backend reporting the backlog of a queue. It
+// requires a node lister so that the backlog can be normalized by the number
+// of nodes. Nodes accessed via the lister must not be mutated.
+type Backend struct {
+	client queueClient
+
+	nodeLister corelistersv1.NodeLister
+}
+
+// NewClient returns a new client for the provider specified in the
+// MetricsBackend configuration, or an error
+func NewClient(configuration map[string]string, getSecret httpconfig.SecretGetter,
+	nodeLister corelistersv1.NodeLister) (metrics.Backend, error) {
+	if nodeLister == nil {
+		return nil, errors.New("node lister must be provided")
+	}
+
+	var client queueClient
+	var err error
+	switch provider := configuration["provider"]; provider {
+	case "sqs":
+		client, err = newSQSClient(configuration["region"])
+
+	case "rabbitmq":
+		client, err = newRabbitMQClient(configuration, getSecret)
+
+	case "kafka":
+		client, err = newKafkaClient(configuration, getSecret)
+
+	case "redis":
+		client, err = newRedisClient(configuration, getSecret)
+
+	case "":
+		return nil, errors.New("provider must be provided")
+
+	default:
+		return nil, errors.Errorf("unknown provider %q", provider)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return Backend{
+		client:     client,
+		nodeLister: nodeLister,
+	}, nil
+}
+
+// GetValue implements the metrics.Backend interface
+func (b Backend) GetValue(metric string, configuration map[string]string, nodeSelector map[string]string) (float64, error) {
+	if metric != MetricBacklog.String() {
+		return 0, errors.Errorf("unknown metric %q", metric)
+	}
+
+	config := metricConfiguration{}
+	if err := config.defaultAndValidate(configuration); err != nil {
+		return 0, errors.Wrap(err, "validating configuration")
+	}
+
+	backlog, err := b.client.Backlog(config)
+	if err != nil {
+		return 0, errors.Wrap(err, "getting backlog")
+	}
+
+	if !config.perNode {
+		return backlog, nil
+	}
+
+	selector := nodeutil.GetNodesLabelSelector(nodeSelector)
+	nodes, err := b.nodeLister.List(selector)
+	if err != nil {
+		return 0, errors.Wrap(err, "listing nodes")
+	}
+
+	// With no nodes there's nothing to divide the backlog between, so report
+	// the entire backlog to allow scaling up from zero
+	if len(nodes) == 0 {
+		log.Debugf("No nodes match node selector %v, reporting entire backlog", nodeSelector)
+		return backlog, nil
+	}
+
+	return backlog / float64(len(nodes)), nil
+}
@@ -0,0 +1,121 @@
+package queue
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/containership/cerebral/pkg/kubernetestest"
+)
+
+// fakeQueueClient returns the given backlog or error and records the
+// configuration it was last called with
+type fakeQueueClient struct {
+	backlog float64
+	err     error
+
+	config metricConfiguration
+}
+
+func (c *fakeQueueClient) Backlog(config metricConfiguration) (float64, error) {
+	c.config = config
+	return c.backlog, c.err
+}
+
+var workers = []corev1.Node{
+	{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "worker-0",
+			Labels: map[string]string{"role": "worker"},
+		},
+	},
+	{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "worker-1",
+			Labels: map[string]string{"role": "worker"},
+		},
+	},
+	{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "worker-2",
+			Labels: map[string]string{"role": "worker"},
+		},
+	},
+	{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "web-0",
+			Labels: map[string]string{"role": "web"},
+		},
+	},
+}
+
+func TestNewClient(t *testing.T) {
+	nodeLister := kubernetestest.BuildNodeLister(nil)
+
+	_, err := NewClient(map[string]string{"provider": "redis", "address": "redis:6379"}, nil, nil)
+	assert.Error(t, err, "error on nil NodeLister")
+
+	_, err = NewClient(map[string]string{}, nil, nodeLister)
+	assert.Error(t, err, "error on missing provider")
+
+	_, err = NewClient(map[string]string{"provider": "nats"}, nil, nodeLister)
+	assert.Error(t, err, "error on unknown provider")
+
+	client, err := NewClient(map[string]string{"provider": "redis", "address": "redis:6379"}, nil, nodeLister)
+	assert.NoError(t, err)
+	assert.IsType(t, redisClient{}, client.(Backend).client)
+
+	client, err = NewClient(map[string]string{"provider": "rabbitmq", "address": "http://rabbitmq:15672"}, nil, nodeLister)
+	assert.NoError(t, err)
+	assert.IsType(t, rabbitMQClient{}, client.(Backend).client)
+
+	client, err = NewClient(map[string]string{
+		"provider": "kafka",
+		"address":  "http://kafka-rest:8082",
+		"cluster":  "my-cluster",
+	}, nil, nodeLister)
+	assert.NoError(t, err)
+	assert.IsType(t, kafkaClient{}, client.(Backend).client)
+
+	client, err = NewClient(map[string]string{"provider": "sqs", "region": "us-east-1"}, nil, nodeLister)
+	assert.NoError(t, err)
+	assert.IsType(t, sqsClient{}, client.(Backend).client)
+
+	_, err = NewClient(map[string]string{"provider": "rabbitmq"}, nil, nodeLister)
+	assert.Error(t, err, "provider errors are returned")
+}
+
+func TestGetValue(t *testing.T) {
+	client := &fakeQueueClient{backlog: 90}
+	b := Backend{
+		client:     client,
+		nodeLister: kubernetestest.BuildNodeLister(workers),
+	}
+
+	val, err := b.GetValue("backlog", map[string]string{"queue": "jobs"}, map[string]string{"role": "worker"})
+	assert.NoError(t, err)
+	assert.Equal(t, 90.0, val, "total backlog by default")
+	assert.Equal(t, "jobs", client.config.Queue, "configuration passed to client")
+
+	val, err = b.GetValue("backlog", map[string]string{"perNode": "true"}, map[string]string{"role": "worker"})
+	assert.NoError(t, err)
+	assert.Equal(t, 30.0, val, "backlog divided between selected nodes")
+
+	val, err = b.GetValue("backlog", map[string]string{"perNode": "true"}, map[string]string{"role": "batch"})
+	assert.NoError(t, err)
+	assert.Equal(t, 90.0, val, "entire backlog reported if no nodes are selected")
+
+	_, err = b.GetValue("backlog", map[string]string{"perNode": "maybe"}, nil)
+	assert.Error(t, err, "invalid configuration")
+
+	_, err = b.GetValue("depth", nil, nil)
+	assert.Error(t, err, "unknown metric requested")
+
+	client.err = errors.New("some broker error")
+	_, err = b.GetValue("backlog", nil, nil)
+	assert.Error(t, err, "client errors are returned")
+}
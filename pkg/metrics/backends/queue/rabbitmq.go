@@ -0,0 +1,61 @@
+package queue
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/pkg/errors"
+
+	"github.com/containership/cerebral/pkg/metrics/backends/httpconfig"
+)
+
+// rabbitMQClient reports the number of messages in a RabbitMQ queue using the
+// management API
+type rabbitMQClient struct {
+	address    string
+	httpClient HTTPClient
+}
+
+// rabbitMQQueue is the subset of a queue returned by the management API used
+// by the client. Message counts are missing until statistics are collected.
+type rabbitMQQueue struct {
+	MessagesReady          *float64 `json:"messages_ready"`
+	MessagesUnacknowledged *float64 `json:"messages_unacknowledged"`
+}
+
+func newRabbitMQClient(configuration map[string]string, getSecret httpconfig.SecretGetter) (queueClient, error) {
+	address, httpClient, err := newHTTPClient(configuration, getSecret)
+	if err != nil {
+		return nil, errors.Wrap(err, "configuring RabbitMQ client")
+	}
+
+	return rabbitMQClient{
+		address:    address,
+		httpClient: httpClient,
+	}, nil
+}
+
+// Backlog implements queueClient
+func (c rabbitMQClient) Backlog(config metricConfiguration) (float64, error) {
+	if config.Queue == "" {
+		return 0, errors.New("queue must be provided")
+	}
+
+	u := fmt.Sprintf("%s/api/queues/%s/%s", c.address, url.PathEscape(config.VHost), url.PathEscape(config.Queue))
+
+	var q rabbitMQQueue
+	if err := getJSON(c.httpClient, u, &q); err != nil {
+		return 0, errors.Wrapf(err, "getting RabbitMQ queue %q in vhost %q", config.Queue, config.VHost)
+	}
+
+	if q.MessagesReady == nil || (config.includeInFlight && q.MessagesUnacknowledged == nil) {
+		return 0, errors.Errorf("message counts of RabbitMQ queue %q are not available yet", config.Queue)
+	}
+
+	backlog := *q.MessagesReady
+	if config.includeInFlight {
+		backlog += *q.MessagesUnacknowledged
+	}
+
+	return backlog, nil
+}
@@ -0,0 +1,80 @@
+package queue
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRabbitMQClient(t *testing.T) {
+	_, err := newRabbitMQClient(map[string]string{}, nil)
+	assert.Error(t, err, "error on empty address")
+
+	_, err = newRabbitMQClient(map[string]string{"address": "rabbitmq:15672"}, nil)
+	assert.Error(t, err, "error on address without scheme")
+
+	_, err = newRabbitMQClient(map[string]string{
+		"address":            "https://rabbitmq:15671/",
+		"username":           "cerebral",
+		"insecureSkipVerify": "true",
+	}, nil)
+	assert.NoError(t, err)
+
+	_, err = newRabbitMQClient(map[string]string{
+		"address":            "https://rabbitmq:15671/",
+		"insecureSkipVerify": "maybe",
+	}, nil)
+	assert.Error(t, err, "error on invalid HTTP configuration")
+}
+
+func TestRabbitMQBacklog(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, _ := r.BasicAuth()
+		assert.Equal(t, "cerebral", username)
+		assert.Equal(t, "password", password)
+
+		switch r.URL.EscapedPath() {
+		case "/api/queues/%2F/jobs":
+			w.Write([]byte(`{"name": "jobs", "messages": 15, "messages_ready": 12, "messages_unacknowledged": 3}`))
+		case "/api/queues/production/new":
+			w.Write([]byte(`{"name": "new"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": "Object Not Found", "reason": "Not Found"}`))
+		}
+	}))
+	defer server.Close()
+
+	getSecret := func(namespace, name string) (map[string][]byte, error) {
+		return map[string][]byte{"password": []byte("password")}, nil
+	}
+
+	client, err := newRabbitMQClient(map[string]string{
+		"address":        server.URL,
+		"username":       "cerebral",
+		"passwordSecret": "rabbitmq/cerebral/password",
+	}, getSecret)
+	assert.NoError(t, err)
+	c := client.(rabbitMQClient)
+
+	backlog, err := c.Backlog(metricConfiguration{Queue: "jobs", VHost: "/"})
+	assert.NoError(t, err)
+	assert.Equal(t, 12.0, backlog, "ready messages")
+
+	backlog, err = c.Backlog(metricConfiguration{Queue: "jobs", VHost: "/", includeInFlight: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 15.0, backlog, "unacknowledged messages included")
+
+	_, err = c.Backlog(metricConfiguration{Queue: "new", VHost: "production"})
+	assert.Error(t, err, "statistics not available yet")
+
+	_, err = c.Backlog(metricConfiguration{Queue: "missing", VHost: "/"})
+	if assert.Error(t, err, "unknown queue") {
+		assert.Contains(t, err.Error(), "Object Not Found", "error message is surfaced")
+	}
+
+	_, err = c.Backlog(metricConfiguration{VHost: "/"})
+	assert.Error(t, err, "queue required")
+}
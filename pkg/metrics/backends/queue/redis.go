@@ -0,0 +1,351 @@
+package queue
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/containership/cerebral/pkg/metrics/backends/httpconfig"
+	"github.com/containership/cluster-manager/pkg/log"
+)
+
+const (
+	redisTimeout = 10 * time.Second
+)
+
+// redisConn executes commands against a Redis server
+type redisConn interface {
+	// Do executes the command and returns the reply, which is a string,
+	// int64, []interface{}, or nil
+	Do(args ...string) (interface{}, error)
+	Close() error
+}
+
+// redisClient reports the length of a Redis list or stream, or the lag of a
+// Redis stream consumer group
+type redisClient struct {
+	dial func() (redisConn, error)
+}
+
+// redisError is an error reply from Redis
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+func newRedisClient(configuration map[string]string, getSecret httpconfig.SecretGetter) (queueClient, error) {
+	address := configuration["address"]
+	if address == "" {
+		return nil, errors.New("address must not be empty")
+	}
+
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return nil, errors.Wrapf(err, "address %q must be in the format host:port", address)
+	}
+
+	// Redis uses the same credentials and TLS configuration as HTTP based
+	// providers, but has no concept of bearer tokens or headers
+	c, err := httpconfig.FromConfiguration(configuration, getSecret)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing Redis configuration")
+	}
+
+	if c.BearerToken != "" || len(c.Headers) != 0 {
+		return nil, errors.New("bearer tokens and extra headers are not supported for Redis")
+	}
+
+	var useTLS bool
+	if v, ok := configuration["tls"]; ok {
+		if useTLS, err = strconv.ParseBool(v); err != nil {
+			return nil, errors.Wrapf(err, "parsing tls %q", v)
+		}
+	}
+
+	var tlsConfig *tls.Config
+	if useTLS {
+		if tlsConfig, err = c.TLSConfig(); err != nil {
+			return nil, errors.Wrap(err, "configuring TLS")
+		}
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+	}
+
+	var db int
+	if v, ok := configuration["db"]; ok {
+		if db, err = strconv.Atoi(v); err != nil || db < 0 {
+			return nil, errors.Errorf("invalid db %q", v)
+		}
+	}
+
+	return redisClient{
+		dial: func() (redisConn, error) {
+			return dialRedis(address, tlsConfig, c.Username, c.Password, db)
+		},
+	}, nil
+}
+
+// Backlog implements queueClient
+func (c redisClient) Backlog(config metricConfiguration) (float64, error) {
+	if config.Key == "" {
+		return 0, errors.New("key must be provided")
+	}
+
+	conn, err := c.dial()
+	if err != nil {
+		return 0, errors.Wrap(err, "connecting to Redis")
+	}
+	defer conn.Close()
+
+	reply, err := conn.Do("TYPE", config.Key)
+	if err != nil {
+		return 0, errors.Wrapf(err, "getting type of Redis key %q", config.Key)
+	}
+
+	switch reply {
+	case "none":
+		// Redis deletes empty lists and streams aren't created until
+		// the first entry is added, so a missing key has no backlog
+		return 0, nil
+
+	case "list":
+		if config.Group != "" {
+			return 0, errors.Errorf("group is not supported for Redis list %q", config.Key)
+		}
+
+		return redisInt(conn.Do("LLEN", config.Key))
+
+	case "stream":
+		if config.Group == "" {
+			return redisInt(conn.Do("XLEN", config.Key))
+		}
+
+		return redisStreamGroupBacklog(conn, config)
+
+	default:
+		return 0, errors.Errorf("Redis key %q has unsupported type %v", config.Key, reply)
+	}
+}
+
+// redisStreamGroupBacklog returns the number of entries not yet delivered to
+// the consumer group and, if in flight messages are included, the number of
+// entries delivered but not acknowledged
+func redisStreamGroupBacklog(conn redisConn, config metricConfiguration) (float64, error) {
+	reply, err := conn.Do("XINFO", "GROUPS", config.Key)
+	if err != nil {
+		return 0, errors.Wrapf(err, "getting groups of Redis stream %q", config.Key)
+	}
+
+	groups, ok := reply.([]interface{})
+	if !ok {
+		return 0, errors.Errorf("unexpected reply %v getting groups of Redis stream %q", reply, config.Key)
+	}
+
+	for _, g := range groups {
+		fields, ok := g.([]interface{})
+		if !ok || len(fields)%2 != 0 {
+			return 0, errors.Errorf("unexpected group %v of Redis stream %q", g, config.Key)
+		}
+
+		info := make(map[string]interface{}, len(fields)/2)
+		for i := 0; i < len(fields); i += 2 {
+			name, _ := fields[i].(string)
+			info[name] = fields[i+1]
+		}
+
+		if info["name"] != config.Group {
+			continue
+		}
+
+		// Lag was added in Redis 7.0 and is nil if it can't be determined
+		lag, ok := info["lag"].(int64)
+		if !ok {
+			return 0, errors.Errorf("lag of group %q of Redis stream %q is not available", config.Group, config.Key)
+		}
+
+		backlog := float64(lag)
+		if config.includeInFlight {
+			pending, ok := info["pending"].(int64)
+			if !ok {
+				return 0, errors.Errorf("pending count of group %q of Redis stream %q is not available", config.Group, config.Key)
+			}
+			backlog += float64(pending)
+		}
+
+		return backlog, nil
+	}
+
+	return 0, errors.Errorf("Redis stream %q has no group %q", config.Key, config.Group)
+}
+
+// redisInt converts an integer reply to a float64
+func redisInt(reply interface{}, err error) (float64, error) {
+	if err != nil {
+		return 0, err
+	}
+
+	n, ok := reply.(int64)
+	if !ok {
+		return 0, errors.Errorf("expected integer reply but got %v", reply)
+	}
+
+	return float64(n), nil
+}
+
+// respConn is a redisConn speaking the Redis serialization protocol (RESP)
+// over a network connection
+type respConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// dialRedis connects to the Redis server at the given address, authenticating
+// and selecting the given database if needed
+func dialRedis(address string, tlsConfig *tls.Config, username, password string, db int) (redisConn, error) {
+	dialer := &net.Dialer{Timeout: redisTimeout}
+
+	var conn net.Conn
+	var err error
+	if tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.SetDeadline(time.Now().Add(redisTimeout)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	c := newRESPConn(conn)
+
+	if password != "" {
+		args := []string{"AUTH", password}
+		if username != "" {
+			args = []string{"AUTH", username, password}
+		}
+
+		if _, err := c.Do(args...); err != nil {
+			c.Close()
+			return nil, errors.Wrap(err, "authenticating")
+		}
+	}
+
+	if db != 0 {
+		if _, err := c.Do("SELECT", strconv.Itoa(db)); err != nil {
+			c.Close()
+			return nil, errors.Wrapf(err, "selecting db %d", db)
+		}
+	}
+
+	return c, nil
+}
+
+func newRESPConn(conn net.Conn) *respConn {
+	return &respConn{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+	}
+}
+
+// Do implements redisConn
+func (c *respConn) Do(args ...string) (interface{}, error) {
+	log.Debugf("Performing Redis command %s", args[0])
+
+	if _, err := c.conn.Write(encodeRESPCommand(args)); err != nil {
+		return nil, err
+	}
+
+	return readRESPReply(c.r)
+}
+
+// Close implements redisConn
+func (c *respConn) Close() error {
+	return c.conn.Close()
+}
+
+// encodeRESPCommand encodes the command as an array of bulk strings
+func encodeRESPCommand(args []string) []byte {
+	b := []byte(fmt.Sprintf("*%d\r\n", len(args)))
+	for _, arg := range args {
+		b = append(b, fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)...)
+	}
+
+	return b
+}
+
+// readRESPReply reads a single RESP2 reply. Error replies are returned as a
+// redisError.
+func readRESPReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.Errorf("invalid RESP line %q", line)
+	}
+
+	kind, line := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return line, nil
+
+	case '-':
+		return nil, redisError(line)
+
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing bulk string length %q", line)
+		}
+
+		if n < 0 {
+			return nil, nil
+		}
+
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+
+		return string(b[:n]), nil
+
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing array length %q", line)
+		}
+
+		if n < 0 {
+			return nil, nil
+		}
+
+		elements := make([]interface{}, n)
+		for i := range elements {
+			// Errors may be nested in arrays, but aren't expected in any
+			// replies used here, so they're treated as errors for the
+			// entire reply
+			if elements[i], err = readRESPReply(r); err != nil {
+				return nil, err
+			}
+		}
+
+		return elements, nil
+	}
+
+	return nil, errors.Errorf("unknown RESP type %q", kind)
+}
@@ -0,0 +1,219 @@
+package queue
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRedisConn replies to commands using a map of command to reply
+type fakeRedisConn struct {
+	replies map[string]interface{}
+	closed  bool
+}
+
+func (c *fakeRedisConn) Do(args ...string) (interface{}, error) {
+	reply, ok := c.replies[strings.Join(args, " ")]
+	if !ok {
+		return nil, redisError("ERR unknown command")
+	}
+
+	if err, ok := reply.(error); ok {
+		return nil, err
+	}
+
+	return reply, nil
+}
+
+func (c *fakeRedisConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestNewRedisClient(t *testing.T) {
+	_, err := newRedisClient(map[string]string{}, nil)
+	assert.Error(t, err, "error on empty address")
+
+	_, err = newRedisClient(map[string]string{"address": "redis"}, nil)
+	assert.Error(t, err, "error on address without port")
+
+	_, err = newRedisClient(map[string]string{
+		"address":            "redis:6379",
+		"tls":                "true",
+		"insecureSkipVerify": "true",
+		"db":                 "2",
+	}, nil)
+	assert.NoError(t, err)
+
+	_, err = newRedisClient(map[string]string{"address": "redis:6379", "tls": "maybe"}, nil)
+	assert.Error(t, err, "error on invalid tls")
+
+	_, err = newRedisClient(map[string]string{"address": "redis:6379", "db": "-1"}, nil)
+	assert.Error(t, err, "error on invalid db")
+
+	_, err = newRedisClient(map[string]string{"address": "redis:6379", "header.X-Test": "value"}, nil)
+	assert.Error(t, err, "error on extra headers")
+}
+
+func TestRedisBacklog(t *testing.T) {
+	conn := &fakeRedisConn{
+		replies: map[string]interface{}{
+			"TYPE jobs":    "list",
+			"LLEN jobs":    int64(12),
+			"TYPE events":  "stream",
+			"XLEN events":  int64(40),
+			"TYPE missing": "none",
+			"TYPE config":  "hash",
+			"XINFO GROUPS events": []interface{}{
+				[]interface{}{"name", "workers", "consumers", int64(2), "pending", int64(3),
+					"last-delivered-id", "1-0", "entries-read", int64(30), "lag", int64(10)},
+				[]interface{}{"name", "legacy", "consumers", int64(1), "pending", int64(0),
+					"last-delivered-id", "0-0", "entries-read", nil, "lag", nil},
+			},
+		},
+	}
+	c := redisClient{
+		dial: func() (redisConn, error) {
+			return conn, nil
+		},
+	}
+
+	backlog, err := c.Backlog(metricConfiguration{Key: "jobs"})
+	assert.NoError(t, err)
+	assert.Equal(t, 12.0, backlog, "list length")
+	assert.True(t, conn.closed, "connection closed")
+
+	backlog, err = c.Backlog(metricConfiguration{Key: "events"})
+	assert.NoError(t, err)
+	assert.Equal(t, 40.0, backlog, "stream length")
+
+	backlog, err = c.Backlog(metricConfiguration{Key: "events", Group: "workers"})
+	assert.NoError(t, err)
+	assert.Equal(t, 10.0, backlog, "consumer group lag")
+
+	backlog, err = c.Backlog(metricConfiguration{Key: "events", Group: "workers", includeInFlight: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 13.0, backlog, "pending entries included")
+
+	backlog, err = c.Backlog(metricConfiguration{Key: "missing"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, backlog, "missing key has no backlog")
+
+	_, err = c.Backlog(metricConfiguration{Key: "events", Group: "legacy"})
+	assert.Error(t, err, "lag not available")
+
+	_, err = c.Backlog(metricConfiguration{Key: "events", Group: "missing"})
+	assert.Error(t, err, "unknown group")
+
+	_, err = c.Backlog(metricConfiguration{Key: "jobs", Group: "workers"})
+	assert.Error(t, err, "group not supported for lists")
+
+	_, err = c.Backlog(metricConfiguration{Key: "config"})
+	assert.Error(t, err, "unsupported type")
+
+	_, err = c.Backlog(metricConfiguration{Key: "unknown"})
+	assert.Error(t, err, "command error")
+
+	_, err = c.Backlog(metricConfiguration{})
+	assert.Error(t, err, "key required")
+
+	c.dial = func() (redisConn, error) {
+		return nil, errors.New("connection refused")
+	}
+	_, err = c.Backlog(metricConfiguration{Key: "jobs"})
+	assert.Error(t, err, "error when connecting fails")
+}
+
+func TestDialRedis(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer listener.Close()
+
+	commands := make(chan []interface{}, 4)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		replies := []string{"+OK\r\n", "+OK\r\n", "+list\r\n", ":7\r\n"}
+		for _, reply := range replies {
+			command, err := readRESPReply(r)
+			if err != nil {
+				return
+			}
+			commands <- command.([]interface{})
+			conn.Write([]byte(reply))
+		}
+	}()
+
+	client, err := newRedisClient(map[string]string{
+		"address":        listener.Addr().String(),
+		"username":       "cerebral",
+		"passwordSecret": "redis/cerebral/password",
+		"db":             "3",
+	}, func(namespace, name string) (map[string][]byte, error) {
+		return map[string][]byte{"password": []byte("password")}, nil
+	})
+	assert.NoError(t, err)
+
+	backlog, err := client.Backlog(metricConfiguration{Key: "jobs"})
+	assert.NoError(t, err)
+	assert.Equal(t, 7.0, backlog)
+
+	assert.Equal(t, []interface{}{"AUTH", "cerebral", "password"}, <-commands, "authenticated")
+	assert.Equal(t, []interface{}{"SELECT", "3"}, <-commands, "db selected")
+	assert.Equal(t, []interface{}{"TYPE", "jobs"}, <-commands)
+	assert.Equal(t, []interface{}{"LLEN", "jobs"}, <-commands)
+}
+
+func TestRESP(t *testing.T) {
+	assert.Equal(t, "*2\r\n$4\r\nLLEN\r\n$4\r\njobs\r\n", string(encodeRESPCommand([]string{"LLEN", "jobs"})))
+
+	reply := func(s string) (interface{}, error) {
+		return readRESPReply(bufio.NewReader(strings.NewReader(s)))
+	}
+
+	v, err := reply("+OK\r\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "OK", v, "simple string")
+
+	_, err = reply("-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+	assert.Equal(t, redisError("WRONGTYPE Operation against a key holding the wrong kind of value"), err, "error")
+
+	v, err = reply(":42\r\n")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), v, "integer")
+
+	v, err = reply("$5\r\nhello\r\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", v, "bulk string")
+
+	v, err = reply("$-1\r\n")
+	assert.NoError(t, err)
+	assert.Nil(t, v, "nil bulk string")
+
+	v, err = reply("*3\r\n$4\r\nname\r\n:1\r\n*-1\r\n")
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"name", int64(1), nil}, v, "array")
+
+	_, err = reply("$5\r\nhel")
+	assert.Error(t, err, "truncated bulk string")
+
+	_, err = reply("!3\r\n")
+	assert.Error(t, err, "unknown type")
+
+	_, err = reply("+OK\n")
+	assert.Error(t, err, "missing carriage return")
+
+	_, err = reply(":abc\r\n")
+	assert.Error(t, err, "invalid integer")
+}
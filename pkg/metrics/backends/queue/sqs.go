@@ -0,0 +1,110 @@
+package queue
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	awssqs "github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// sqsAPI is the subset of the SQS API used by the SQS client
+type sqsAPI interface {
+	GetQueueUrl(*awssqs.GetQueueUrlInput) (*awssqs.GetQueueUrlOutput, error)
+	GetQueueAttributes(*awssqs.GetQueueAttributesInput) (*awssqs.GetQueueAttributesOutput, error)
+}
+
+// sqsClient reports the approximate number of messages in an SQS queue
+type sqsClient struct {
+	api sqsAPI
+}
+
+// newSQSClient returns a new SQS client for the given region. If the region
+// is empty, it's pulled from the environment.
+func newSQSClient(region string) (queueClient, error) {
+	// Note that aws-sdk-go pulls AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+	// AWS_REGION directly from the environment
+	config := aws.NewConfig()
+	if region != "" {
+		config = config.WithRegion(region)
+	}
+
+	sess, err := session.NewSession(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating new AWS session")
+	}
+
+	return sqsClient{
+		api: awssqs.New(sess),
+	}, nil
+}
+
+// Backlog implements queueClient
+func (c sqsClient) Backlog(config metricConfiguration) (float64, error) {
+	if config.Queue == "" {
+		return 0, errors.New("queue must be provided")
+	}
+
+	queueURL, err := c.getQueueURL(config.Queue)
+	if err != nil {
+		return 0, err
+	}
+
+	attributes := []string{awssqs.QueueAttributeNameApproximateNumberOfMessages}
+	if config.includeInFlight {
+		attributes = append(attributes, awssqs.QueueAttributeNameApproximateNumberOfMessagesNotVisible)
+	}
+
+	output, err := c.api.GetQueueAttributes(&awssqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(queueURL),
+		AttributeNames: aws.StringSlice(attributes),
+	})
+	if err != nil {
+		return 0, errors.Wrapf(err, "getting attributes of SQS queue %q", queueURL)
+	}
+
+	if output == nil {
+		return 0, errors.New("AWS returned nil result for get queue attributes")
+	}
+
+	var backlog float64
+	for _, name := range attributes {
+		value, ok := output.Attributes[name]
+		if !ok {
+			return 0, errors.Errorf("SQS queue %q is missing attribute %s", queueURL, name)
+		}
+
+		n, err := strconv.ParseInt(aws.StringValue(value), 10, 64)
+		if err != nil {
+			return 0, errors.Wrapf(err, "parsing attribute %s of SQS queue %q", name, queueURL)
+		}
+
+		backlog += float64(n)
+	}
+
+	return backlog, nil
+}
+
+// getQueueURL returns the URL of the given queue, which may be either a
+// queue name or URL
+func (c sqsClient) getQueueURL(queue string) (string, error) {
+	if strings.HasPrefix(queue, "https://") || strings.HasPrefix(queue, "http://") {
+		return queue, nil
+	}
+
+	output, err := c.api.GetQueueUrl(&awssqs.GetQueueUrlInput{
+		QueueName: aws.String(queue),
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "getting URL of SQS queue %q", queue)
+	}
+
+	if output == nil || output.QueueUrl == nil {
+		return "", errors.New("AWS returned nil result for get queue URL")
+	}
+
+	return aws.StringValue(output.QueueUrl), nil
+}
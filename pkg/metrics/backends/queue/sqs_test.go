@@ -0,0 +1,80 @@
+package queue
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awssqs "github.com/aws/aws-sdk-go/service/sqs"
+)
+
+const jobsQueueURL = "https://sqs.us-east-1.amazonaws.com/123456789012/jobs"
+
+// fakeSQSAPI serves the attributes of a single queue named jobs
+type fakeSQSAPI struct {
+	attributes map[string]string
+	err        error
+
+	attributeNames []string
+}
+
+func (f *fakeSQSAPI) GetQueueUrl(input *awssqs.GetQueueUrlInput) (*awssqs.GetQueueUrlOutput, error) {
+	if aws.StringValue(input.QueueName) != "jobs" {
+		return nil, errors.New("AWS.SimpleQueueService.NonExistentQueue")
+	}
+
+	return &awssqs.GetQueueUrlOutput{QueueUrl: aws.String(jobsQueueURL)}, nil
+}
+
+func (f *fakeSQSAPI) GetQueueAttributes(input *awssqs.GetQueueAttributesInput) (*awssqs.GetQueueAttributesOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	if aws.StringValue(input.QueueUrl) != jobsQueueURL {
+		return nil, errors.New("AWS.SimpleQueueService.NonExistentQueue")
+	}
+
+	f.attributeNames = aws.StringValueSlice(input.AttributeNames)
+
+	return &awssqs.GetQueueAttributesOutput{Attributes: aws.StringMap(f.attributes)}, nil
+}
+
+func TestSQSBacklog(t *testing.T) {
+	api := &fakeSQSAPI{
+		attributes: map[string]string{
+			awssqs.QueueAttributeNameApproximateNumberOfMessages:           "12",
+			awssqs.QueueAttributeNameApproximateNumberOfMessagesNotVisible: "3",
+		},
+	}
+	c := sqsClient{api: api}
+
+	backlog, err := c.Backlog(metricConfiguration{Queue: "jobs"})
+	assert.NoError(t, err)
+	assert.Equal(t, 12.0, backlog, "visible messages")
+	assert.Equal(t, []string{awssqs.QueueAttributeNameApproximateNumberOfMessages}, api.attributeNames)
+
+	backlog, err = c.Backlog(metricConfiguration{Queue: jobsQueueURL, includeInFlight: true})
+	assert.NoError(t, err, "queue URL is ok")
+	assert.Equal(t, 15.0, backlog, "in flight messages included")
+
+	_, err = c.Backlog(metricConfiguration{})
+	assert.Error(t, err, "queue required")
+
+	_, err = c.Backlog(metricConfiguration{Queue: "missing"})
+	assert.Error(t, err, "unknown queue")
+
+	api.attributes = map[string]string{awssqs.QueueAttributeNameApproximateNumberOfMessages: "many"}
+	_, err = c.Backlog(metricConfiguration{Queue: "jobs"})
+	assert.Error(t, err, "invalid attribute")
+
+	api.attributes = map[string]string{}
+	_, err = c.Backlog(metricConfiguration{Queue: "jobs"})
+	assert.Error(t, err, "missing attribute")
+
+	api.err = errors.New("some AWS error")
+	_, err = c.Backlog(metricConfiguration{Queue: "jobs"})
+	assert.Error(t, err, "error when AWS errors")
+}
@@ -0,0 +1,168 @@
+package metrics
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+var nowFunc = time.Now
+
+// cachedBackend wraps a Backend, caching values for a TTL and deduplicating
+// identical queries that are in flight at the same time
+type cachedBackend struct {
+	backend Backend
+	ttl     time.Duration
+
+	sync.Mutex
+	values   map[string]cachedValue
+	inFlight map[string]*query
+}
+
+// cachedRangeBackend is a cachedBackend wrapping a RangeBackend. Range queries
+// are passed through to the underlying backend.
+type cachedRangeBackend struct {
+	*cachedBackend
+}
+
+type cachedValue struct {
+	value   float64
+	expires time.Time
+}
+
+// query is a GetValue call shared by all callers performing the same query
+// while it's in flight
+type query struct {
+	done  chan struct{}
+	value float64
+	err   error
+}
+
+// NewCachedBackend returns a Backend that caches successful values returned by
+// the given backend for the given TTL. Identical queries made while a query
+// is in flight wait for and share its result, even if the TTL is zero. If the
+// backend is a RangeBackend, the returned Backend is too.
+func NewCachedBackend(backend Backend, ttl time.Duration) Backend {
+	b := &cachedBackend{
+		backend:  backend,
+		ttl:      ttl,
+		values:   make(map[string]cachedValue),
+		inFlight: make(map[string]*query),
+	}
+
+	if _, ok := backend.(RangeBackend); ok {
+		return cachedRangeBackend{b}
+	}
+
+	return b
+}
+
+// GetValue implements the Backend interface
+func (b *cachedBackend) GetValue(metric string, configuration map[string]string, nodeSelector map[string]string) (float64, error) {
+	key, err := cacheKey(metric, b.keyConfiguration(metric, configuration), nodeSelector)
+	if err != nil {
+		// This can't happen for maps of strings, but don't let caching
+		// get in the way of querying
+		return b.backend.GetValue(metric, configuration, nodeSelector)
+	}
+
+	b.Lock()
+	if v, ok := b.values[key]; ok && nowFunc().Before(v.expires) {
+		b.Unlock()
+		return v.value, nil
+	}
+
+	if q, ok := b.inFlight[key]; ok {
+		b.Unlock()
+		<-q.done
+		return q.value, q.err
+	}
+
+	q := &query{done: make(chan struct{})}
+	b.inFlight[key] = q
+	b.Unlock()
+
+	q.value, q.err = b.backend.GetValue(metric, configuration, nodeSelector)
+
+	b.Lock()
+	delete(b.inFlight, key)
+	if q.err == nil && b.ttl > 0 {
+		b.pruneLocked()
+		b.values[key] = cachedValue{
+			value:   q.value,
+			expires: nowFunc().Add(b.ttl),
+		}
+	}
+	b.Unlock()
+
+	close(q.done)
+
+	return q.value, q.err
+}
+
+// keyConfiguration returns the configuration identifying the query. The
+// AutoscalingGroup being polled is omitted unless the backend's values may
+// depend on it, so that AutoscalingGroups making the same query share it.
+func (b *cachedBackend) keyConfiguration(metric string, configuration map[string]string) map[string]string {
+	if _, ok := configuration[AutoscalingGroupKey]; !ok {
+		return configuration
+	}
+
+	if scoped, ok := b.backend.(AutoscalingGroupScopedBackend); ok && scoped.UsesAutoscalingGroup(metric, configuration) {
+		return configuration
+	}
+
+	c := make(map[string]string, len(configuration)-1)
+	for k, v := range configuration {
+		if k != AutoscalingGroupKey {
+			c[k] = v
+		}
+	}
+
+	return c
+}
+
+// Unwrap returns the wrapped Backend
+func (b *cachedBackend) Unwrap() Backend {
+	return b.backend
+}
+
+// pruneLocked removes expired values. The lock must be held.
+func (b *cachedBackend) pruneLocked() {
+	now := nowFunc()
+	for key, v := range b.values {
+		if !now.Before(v.expires) {
+			delete(b.values, key)
+		}
+	}
+}
+
+// GetValueRange implements the RangeBackend interface
+func (b cachedRangeBackend) GetValueRange(metric string, configuration map[string]string, nodeSelector map[string]string,
+	start, end time.Time, step time.Duration) ([]Sample, error) {
+	return b.backend.(RangeBackend).GetValueRange(metric, configuration, nodeSelector, start, end, step)
+}
+
+// cacheKey returns a key uniquely identifying the query. Maps are encoded with
+// sorted keys, so equal maps always have the same key.
+func cacheKey(metric string, configuration map[string]string, nodeSelector map[string]string) (string, error) {
+	// Nil and empty maps are equivalent to backends
+	if len(configuration) == 0 {
+		configuration = nil
+	}
+	if len(nodeSelector) == 0 {
+		nodeSelector = nil
+	}
+
+	b, err := json.Marshal(struct {
+		Metric        string
+		Configuration map[string]string
+		NodeSelector  map[string]string
+	}{
+		Metric:        metric,
+		Configuration: configuration,
+		NodeSelector:  nodeSelector,
+	})
+
+	return string(b), err
+}
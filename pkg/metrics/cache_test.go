@@ -0,0 +1,205 @@
+package metrics
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingBackend returns the number of calls made to it so far, or an error
+// if err is set. If block is set, calls wait for it to be closed.
+type countingBackend struct {
+	sync.Mutex
+	calls int
+	err   error
+	block chan struct{}
+}
+
+func (b *countingBackend) GetValue(_ string, _ map[string]string, _ map[string]string) (float64, error) {
+	b.Lock()
+	b.calls++
+	calls := b.calls
+	b.Unlock()
+
+	if b.block != nil {
+		<-b.block
+	}
+
+	return float64(calls), b.err
+}
+
+// scopedBackend is a countingBackend whose values depend on the
+// AutoscalingGroup being polled
+type scopedBackend struct {
+	countingBackend
+}
+
+func (b *scopedBackend) UsesAutoscalingGroup(metric string, _ map[string]string) bool {
+	return metric == "scoped"
+}
+
+type countingRangeBackend struct {
+	countingBackend
+}
+
+func (b *countingRangeBackend) GetValueRange(_ string, _ map[string]string, _ map[string]string,
+	_, _ time.Time, _ time.Duration) ([]Sample, error) {
+	return []Sample{{Value: 42}}, nil
+}
+
+func TestNewCachedBackend(t *testing.T) {
+	b := NewCachedBackend(&countingBackend{}, time.Minute)
+	_, ok := b.(RangeBackend)
+	assert.False(t, ok, "non-range backend is not a range backend")
+
+	b = NewCachedBackend(&countingRangeBackend{}, time.Minute)
+	rb, ok := b.(RangeBackend)
+	if assert.True(t, ok, "range backend is still a range backend") {
+		samples, err := rb.GetValueRange("metric", nil, nil, time.Time{}, time.Time{}, time.Minute)
+		assert.NoError(t, err)
+		assert.Equal(t, []Sample{{Value: 42}}, samples, "range queries are passed through")
+	}
+}
+
+func TestCachedBackendTTL(t *testing.T) {
+	defer func() { nowFunc = time.Now }()
+	now := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	nowFunc = func() time.Time { return now }
+
+	backend := &countingBackend{}
+	b := NewCachedBackend(backend, time.Minute)
+
+	config := map[string]string{"a": "1", "b": "2"}
+	selector := map[string]string{"pool": "web"}
+
+	v, err := b.GetValue("metric", config, selector)
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, v)
+
+	v, err = b.GetValue("metric", map[string]string{"b": "2", "a": "1"}, selector)
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, v, "cached value is returned for equal query")
+
+	v, _ = b.GetValue("other", config, selector)
+	assert.Equal(t, 2.0, v, "different metric is not cached")
+
+	v, _ = b.GetValue("metric", map[string]string{"a": "1"}, selector)
+	assert.Equal(t, 3.0, v, "different configuration is not cached")
+
+	v, _ = b.GetValue("metric", config, map[string]string{"pool": "db"})
+	assert.Equal(t, 4.0, v, "different node selector is not cached")
+
+	v, _ = b.GetValue("metric", nil, nil)
+	assert.Equal(t, 5.0, v)
+	v, _ = b.GetValue("metric", map[string]string{}, map[string]string{})
+	assert.Equal(t, 5.0, v, "empty maps are equivalent to nil maps")
+
+	now = now.Add(59 * time.Second)
+	v, _ = b.GetValue("metric", config, selector)
+	assert.Equal(t, 1.0, v, "value is cached until TTL expires")
+
+	now = now.Add(time.Second)
+	v, _ = b.GetValue("metric", config, selector)
+	assert.Equal(t, 6.0, v, "value is queried after TTL expires")
+	assert.Len(t, b.(*cachedBackend).values, 1, "expired values are pruned")
+
+	backend.err = errors.New("backend error")
+	now = now.Add(time.Minute)
+	_, err = b.GetValue("metric", config, selector)
+	assert.Error(t, err)
+
+	backend.err = nil
+	v, err = b.GetValue("metric", config, selector)
+	assert.NoError(t, err)
+	assert.Equal(t, 8.0, v, "errors are not cached")
+}
+
+func TestCachedBackendNoTTL(t *testing.T) {
+	backend := &countingBackend{}
+	b := NewCachedBackend(backend, 0)
+
+	v, _ := b.GetValue("metric", nil, nil)
+	assert.Equal(t, 1.0, v)
+
+	v, _ = b.GetValue("metric", nil, nil)
+	assert.Equal(t, 2.0, v, "values are not cached without TTL")
+	assert.Empty(t, b.(*cachedBackend).values)
+}
+
+func TestCachedBackendAutoscalingGroups(t *testing.T) {
+	backend := &countingBackend{}
+	b := NewCachedBackend(backend, time.Minute)
+
+	config := map[string]string{"query": "up"}
+	v, err := b.GetValue("metric", WithAutoscalingGroup(config, "web"), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, v)
+
+	v, err = b.GetValue("metric", WithAutoscalingGroup(config, "db"), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, v, "AutoscalingGroups making the same query share it")
+	assert.Equal(t, 1, backend.calls)
+
+	v, _ = b.GetValue("metric", config, nil)
+	assert.Equal(t, 1.0, v, "query without AutoscalingGroup is the same query")
+
+	scoped := &scopedBackend{}
+	b = NewCachedBackend(scoped, time.Minute)
+
+	v, _ = b.GetValue("scoped", WithAutoscalingGroup(config, "web"), nil)
+	assert.Equal(t, 1.0, v)
+	v, _ = b.GetValue("scoped", WithAutoscalingGroup(config, "db"), nil)
+	assert.Equal(t, 2.0, v, "AutoscalingGroups are not shared if values depend on them")
+	v, _ = b.GetValue("scoped", WithAutoscalingGroup(config, "web"), nil)
+	assert.Equal(t, 1.0, v)
+
+	v, _ = b.GetValue("unscoped", WithAutoscalingGroup(config, "web"), nil)
+	assert.Equal(t, 3.0, v)
+	v, _ = b.GetValue("unscoped", WithAutoscalingGroup(config, "db"), nil)
+	assert.Equal(t, 3.0, v, "scoping is decided per metric")
+}
+
+func TestCachedBackendDeduplication(t *testing.T) {
+	defer func() { nowFunc = time.Now }()
+	now := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	nowFunc = func() time.Time { return now }
+
+	backend := &countingBackend{block: make(chan struct{})}
+	b := NewCachedBackend(backend, time.Minute).(*cachedBackend)
+
+	const callers = 5
+	var wg sync.WaitGroup
+	values := make([]float64, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values[i], _ = b.GetValue("metric", nil, nil)
+		}(i)
+	}
+
+	// Wait for the first query to start. Callers arriving before it completes
+	// wait for it, and those arriving after get the cached value.
+	for {
+		backend.Lock()
+		calls := backend.calls
+		backend.Unlock()
+
+		if calls > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	close(backend.block)
+	wg.Wait()
+
+	assert.Equal(t, 1, backend.calls, "identical queries are only performed once")
+	for _, v := range values {
+		assert.Equal(t, 1.0, v, "all callers get the shared value")
+	}
+	assert.Empty(t, b.inFlight, "in flight queries are cleaned up")
+}
@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// validReducers are the reducers that can be used by backends returning
+// results containing multiple series
+var validReducers = []string{
+	"sum",   // sum the values of all series
+	"avg",   // average the values of all series
+	"max",   // select the maximum value of all series
+	"min",   // select the minimum value of all series
+	"first", // select the value of the first series
+}
+
+// ValidateReducer returns an error if the reducer is not empty and not a
+// valid reducer
+func ValidateReducer(reducer string) error {
+	if reducer == "" {
+		return nil
+	}
+
+	for _, r := range validReducers {
+		if r == reducer {
+			return nil
+		}
+	}
+
+	return errors.Errorf("invalid reducer %s", reducer)
+}
+
+// Reduce reduces the values to a single value using the given reducer. If
+// no reducer is given, there must be exactly one value.
+func Reduce(values []float64, reducer string) (float64, error) {
+	if reducer == "" {
+		if len(values) != 1 {
+			return 0, errors.Errorf("expected a single value but there are %d", len(values))
+		}
+
+		return values[0], nil
+	}
+
+	if len(values) == 0 {
+		return 0, errors.New("no values to reduce")
+	}
+
+	result := values[0]
+	for _, v := range values[1:] {
+		switch reducer {
+		case "sum", "avg":
+			result += v
+		case "max":
+			result = math.Max(result, v)
+		case "min":
+			result = math.Min(result, v)
+		}
+	}
+
+	if reducer == "avg" {
+		result /= float64(len(values))
+	}
+
+	return result, nil
+}
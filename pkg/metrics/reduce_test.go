@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateReducer(t *testing.T) {
+	assert.NoError(t, ValidateReducer(""), "no reducer is ok")
+	assert.NoError(t, ValidateReducer("avg"), "valid reducer")
+	assert.Error(t, ValidateReducer("mean"), "invalid reducer")
+}
+
+func TestReduce(t *testing.T) {
+	values := []float64{2, 1, 6}
+
+	_, err := Reduce(values, "")
+	assert.Error(t, err, "multiple values require a reducer")
+
+	v, err := Reduce([]float64{2}, "")
+	assert.NoError(t, err, "single value does not require a reducer")
+	assert.Equal(t, 2.0, v)
+
+	_, err = Reduce(nil, "sum")
+	assert.Error(t, err, "no values to reduce")
+
+	v, _ = Reduce(values, "sum")
+	assert.Equal(t, 9.0, v, "sum")
+
+	v, _ = Reduce(values, "avg")
+	assert.Equal(t, 3.0, v, "avg")
+
+	v, _ = Reduce(values, "max")
+	assert.Equal(t, 6.0, v, "max")
+
+	v, _ = Reduce(values, "min")
+	assert.Equal(t, 1.0, v, "min")
+
+	v, _ = Reduce(values, "first")
+	assert.Equal(t, 2.0, v, "first")
+}
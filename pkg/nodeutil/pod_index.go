@@ -0,0 +1,52 @@
+package nodeutil
+
+import (
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// PodNodeNameIndex is the name of the pod informer index keyed by the name of
+// the node each pod is assigned to. Pods that haven't been scheduled are
+// indexed under the empty string.
+const PodNodeNameIndex = "nodeName"
+
+// PodNodeNameIndexers returns the indexers that must be added to a pod
+// informer before using PodsOnNode with its indexer
+func PodNodeNameIndexers() cache.Indexers {
+	return cache.Indexers{
+		PodNodeNameIndex: podNodeNameIndexFunc,
+	}
+}
+
+func podNodeNameIndexFunc(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return nil, errors.Errorf("expected pod but got %T", obj)
+	}
+
+	return []string{pod.Spec.NodeName}, nil
+}
+
+// PodsOnNode returns the pods assigned to the node with the given name, or the
+// pods that haven't been scheduled if the name is empty. Pods returned must
+// not be mutated.
+func PodsOnNode(indexer cache.Indexer, nodeName string) ([]*corev1.Pod, error) {
+	objs, err := indexer.ByIndex(PodNodeNameIndex, nodeName)
+	if err != nil {
+		return nil, err
+	}
+
+	pods := make([]*corev1.Pod, 0, len(objs))
+	for _, obj := range objs {
+		pod, ok := obj.(*corev1.Pod)
+		if !ok {
+			return nil, errors.Errorf("expected pod in index but got %T", obj)
+		}
+
+		pods = append(pods, pod)
+	}
+
+	return pods, nil
+}
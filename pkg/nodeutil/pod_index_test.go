@@ -0,0 +1,58 @@
+package nodeutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestPodsOnNode(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, PodNodeNameIndexers())
+
+	pods := []*corev1.Pod{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod-0", Namespace: "default"},
+			Spec:       corev1.PodSpec{NodeName: "node-0"},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "default"},
+			Spec:       corev1.PodSpec{NodeName: "node-0"},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod-2", Namespace: "default"},
+			Spec:       corev1.PodSpec{NodeName: "node-1"},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod-3", Namespace: "default"},
+		},
+	}
+	for _, pod := range pods {
+		assert.NoError(t, indexer.Add(pod))
+	}
+
+	result, err := PodsOnNode(indexer, "node-0")
+	assert.NoError(t, err)
+	assert.Len(t, result, 2, "pods on node are returned")
+
+	result, err = PodsOnNode(indexer, "node-1")
+	assert.NoError(t, err)
+	assert.Equal(t, []*corev1.Pod{pods[2]}, result)
+
+	result, err = PodsOnNode(indexer, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []*corev1.Pod{pods[3]}, result, "unscheduled pods are indexed under the empty string")
+
+	result, err = PodsOnNode(indexer, "node-2")
+	assert.NoError(t, err)
+	assert.Empty(t, result, "no pods on node")
+
+	_, err = PodsOnNode(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}), "node-0")
+	assert.Error(t, err, "error when index does not exist")
+
+	_, err = podNodeNameIndexFunc(&corev1.Node{})
+	assert.Error(t, err, "error on non-pod object")
+}
@@ -0,0 +1,178 @@
+package scheduling
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// NodesLikelyRemoved returns the count nodes whose removal is hardest to
+// absorb, i.e. those whose reschedulable pods request the most CPU and then
+// memory. Since engines choose which nodes to remove, assuming the worst case
+// keeps the simulation conservative.
+func NodesLikelyRemoved(nodes []*corev1.Node, pods []*corev1.Pod, count int) []*corev1.Node {
+	requested := make(map[string]map[corev1.ResourceName]int64, len(nodes))
+	for _, node := range nodes {
+		requested[node.ObjectMeta.Name] = make(map[corev1.ResourceName]int64)
+	}
+
+	for _, pod := range pods {
+		total, ok := requested[pod.Spec.NodeName]
+		if !ok || !needsRescheduling(pod) {
+			continue
+		}
+
+		allocate(PodRequests(pod), total)
+	}
+
+	sorted := make([]*corev1.Node, len(nodes))
+	copy(sorted, nodes)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		// Requests are allocated as negative values, so the busiest nodes
+		// have the smallest totals
+		a, b := requested[sorted[i].ObjectMeta.Name], requested[sorted[j].ObjectMeta.Name]
+		if a[corev1.ResourceCPU] != b[corev1.ResourceCPU] {
+			return a[corev1.ResourceCPU] < b[corev1.ResourceCPU]
+		}
+		if a[corev1.ResourceMemory] != b[corev1.ResourceMemory] {
+			return a[corev1.ResourceMemory] < b[corev1.ResourceMemory]
+		}
+		return sorted[i].ObjectMeta.Name < sorted[j].ObjectMeta.Name
+	})
+
+	if count > len(sorted) {
+		count = len(sorted)
+	}
+
+	return sorted[:count]
+}
+
+// SimulateNodeRemoval returns an error describing why the pods running on the
+// removed nodes could not be rescheduled onto the remaining nodes, or nil if
+// they could. Pods must fit within the free resources of a remaining node that
+// their node selector, required node affinity, and tolerations allow, and
+// evicting them must not exceed the disruptions allowed by any
+// PodDisruptionBudget.
+func SimulateNodeRemoval(removed, remaining []*corev1.Node, pods []*corev1.Pod,
+	pdbs []*policyv1beta1.PodDisruptionBudget) error {
+	removedNames := make(map[string]bool, len(removed))
+	for _, node := range removed {
+		removedNames[node.ObjectMeta.Name] = true
+	}
+
+	free := make(map[string]map[corev1.ResourceName]int64, len(remaining))
+	for _, node := range remaining {
+		capacity := make(map[corev1.ResourceName]int64)
+		for name, quantity := range node.Status.Allocatable {
+			capacity[name] = quantity.MilliValue()
+		}
+		free[node.ObjectMeta.Name] = capacity
+	}
+
+	var evicted []*corev1.Pod
+	for _, pod := range pods {
+		if isTerminated(pod) {
+			continue
+		}
+
+		if capacity, ok := free[pod.Spec.NodeName]; ok {
+			allocate(PodRequests(pod), capacity)
+			continue
+		}
+
+		if removedNames[pod.Spec.NodeName] && needsRescheduling(pod) {
+			evicted = append(evicted, pod)
+		}
+	}
+
+	if err := checkDisruptionBudgets(evicted, pdbs); err != nil {
+		return err
+	}
+
+	// Place the largest pods first so that they aren't crowded out by
+	// smaller ones
+	sort.SliceStable(evicted, func(i, j int) bool {
+		a, b := PodRequests(evicted[i]), PodRequests(evicted[j])
+		if a[corev1.ResourceCPU] != b[corev1.ResourceCPU] {
+			return a[corev1.ResourceCPU] > b[corev1.ResourceCPU]
+		}
+		return a[corev1.ResourceMemory] > b[corev1.ResourceMemory]
+	})
+
+	for _, pod := range evicted {
+		requests := PodRequests(pod)
+
+		placed := false
+		for _, node := range remaining {
+			if node.Spec.Unschedulable || !PodMatchesNode(pod, node) {
+				continue
+			}
+
+			capacity := free[node.ObjectMeta.Name]
+			if fits(requests, capacity) {
+				allocate(requests, capacity)
+				placed = true
+				break
+			}
+		}
+
+		if !placed {
+			return errors.Errorf("pod %s on node %s would not fit on any remaining node",
+				podName(pod), pod.Spec.NodeName)
+		}
+	}
+
+	return nil
+}
+
+// checkDisruptionBudgets returns an error if evicting the pods would disrupt
+// more pods than allowed by any of the budgets
+func checkDisruptionBudgets(evicted []*corev1.Pod, pdbs []*policyv1beta1.PodDisruptionBudget) error {
+	for _, pdb := range pdbs {
+		selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err != nil {
+			return errors.Wrapf(err, "parsing selector of PodDisruptionBudget %s/%s",
+				pdb.ObjectMeta.Namespace, pdb.ObjectMeta.Name)
+		}
+
+		if selector.Empty() {
+			// An empty selector matches no pods for PodDisruptionBudgets
+			continue
+		}
+
+		disrupted := 0
+		for _, pod := range evicted {
+			if pod.ObjectMeta.Namespace == pdb.ObjectMeta.Namespace &&
+				selector.Matches(labels.Set(pod.ObjectMeta.Labels)) {
+				disrupted++
+			}
+		}
+
+		if disrupted > int(pdb.Status.PodDisruptionsAllowed) {
+			return errors.Errorf("evicting %d pod(s) would violate PodDisruptionBudget %s/%s which allows %d disruption(s)",
+				disrupted, pdb.ObjectMeta.Namespace, pdb.ObjectMeta.Name, pdb.Status.PodDisruptionsAllowed)
+		}
+	}
+
+	return nil
+}
+
+// needsRescheduling returns true if the pod would have to be scheduled
+// elsewhere if its node were removed
+func needsRescheduling(pod *corev1.Pod) bool {
+	return !isTerminated(pod) && !IsDaemonSetPod(pod) && !IsMirrorPod(pod)
+}
+
+func isTerminated(pod *corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+}
+
+func podName(pod *corev1.Pod) string {
+	return fmt.Sprintf("%s/%s", pod.ObjectMeta.Namespace, pod.ObjectMeta.Name)
+}
@@ -0,0 +1,107 @@
+package scheduling
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func buildNode(name string) *corev1.Node {
+	node := templateNode.DeepCopy()
+	node.ObjectMeta.Name = name
+	return node
+}
+
+func buildRunningPod(name, nodeName, cpu string) *corev1.Pod {
+	pod := buildPod(cpu, "1Gi")
+	pod.ObjectMeta.Name = name
+	pod.ObjectMeta.Namespace = "default"
+	pod.ObjectMeta.Labels = map[string]string{"app": "web"}
+	pod.Spec.NodeName = nodeName
+	pod.Status = corev1.PodStatus{Phase: corev1.PodRunning}
+	return pod
+}
+
+func TestNodesLikelyRemoved(t *testing.T) {
+	nodes := []*corev1.Node{buildNode("node-0"), buildNode("node-1"), buildNode("node-2")}
+	pods := []*corev1.Pod{
+		buildRunningPod("small", "node-0", "500m"),
+		buildRunningPod("large", "node-1", "1500m"),
+	}
+
+	removed := NodesLikelyRemoved(nodes, pods, 1)
+	assert.Len(t, removed, 1)
+	assert.Equal(t, "node-1", removed[0].ObjectMeta.Name, "busiest node is removed first")
+
+	daemon := buildRunningPod("daemon", "node-2", "1900m")
+	daemon.ObjectMeta.OwnerReferences = []metav1.OwnerReference{
+		{Kind: "DaemonSet", Controller: &trueVar},
+	}
+	removed = NodesLikelyRemoved(nodes, append(pods, daemon), 2)
+	assert.Equal(t, "node-1", removed[0].ObjectMeta.Name, "DaemonSet pods are ignored")
+	assert.Equal(t, "node-0", removed[1].ObjectMeta.Name, "DaemonSet pods are ignored")
+
+	removed = NodesLikelyRemoved(nodes, pods, 5)
+	assert.Len(t, removed, 3, "count is capped at number of nodes")
+}
+
+func TestSimulateNodeRemoval(t *testing.T) {
+	node0, node1 := buildNode("node-0"), buildNode("node-1")
+	removed, remaining := []*corev1.Node{node0}, []*corev1.Node{node1}
+
+	pods := []*corev1.Pod{
+		buildRunningPod("a", "node-0", "1"),
+		buildRunningPod("b", "node-1", "500m"),
+	}
+	assert.NoError(t, SimulateNodeRemoval(removed, remaining, pods, nil), "pod fits on remaining node")
+
+	full := append(pods, buildRunningPod("c", "node-1", "1"))
+	assert.Error(t, SimulateNodeRemoval(removed, remaining, full, nil), "remaining node lacks CPU")
+
+	intolerant := buildRunningPod("a", "node-0", "1")
+	intolerant.Spec.Tolerations = nil
+	assert.Error(t, SimulateNodeRemoval(removed, remaining, []*corev1.Pod{intolerant}, nil),
+		"pod does not tolerate remaining node's taint")
+
+	selective := buildRunningPod("a", "node-0", "1")
+	selective.Spec.NodeSelector = map[string]string{"size": "8"}
+	assert.Error(t, SimulateNodeRemoval(removed, remaining, []*corev1.Pod{selective}, nil),
+		"pod node selector does not match remaining node")
+
+	cordoned := buildNode("node-1")
+	cordoned.Spec.Unschedulable = true
+	assert.Error(t, SimulateNodeRemoval(removed, []*corev1.Node{cordoned}, pods, nil),
+		"pods are not rescheduled onto cordoned nodes")
+
+	completed := buildRunningPod("done", "node-0", "4")
+	completed.Status.Phase = corev1.PodSucceeded
+	mirror := buildRunningPod("static", "node-0", "4")
+	mirror.ObjectMeta.Annotations = map[string]string{corev1.MirrorPodAnnotationKey: ""}
+	assert.NoError(t, SimulateNodeRemoval(removed, remaining, []*corev1.Pod{completed, mirror}, nil),
+		"completed and mirror pods are not rescheduled")
+
+	pdb := &policyv1beta1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "default",
+		},
+		Spec: policyv1beta1.PodDisruptionBudgetSpec{
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"app": "web"},
+			},
+		},
+	}
+	pdbs := []*policyv1beta1.PodDisruptionBudget{pdb}
+	assert.Error(t, SimulateNodeRemoval(removed, remaining, pods, pdbs), "no disruptions allowed")
+
+	pdb.Status.PodDisruptionsAllowed = 1
+	assert.NoError(t, SimulateNodeRemoval(removed, remaining, pods, pdbs), "one disruption allowed")
+
+	pdb.Status.PodDisruptionsAllowed = 0
+	pdb.ObjectMeta.Namespace = "other"
+	assert.NoError(t, SimulateNodeRemoval(removed, remaining, pods, pdbs), "budget in other namespace")
+}
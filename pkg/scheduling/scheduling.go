@@ -0,0 +1,309 @@
+// Package scheduling approximates the decisions of the Kubernetes scheduler
+// closely enough to reason about whether pods could run on a node group.
+package scheduling
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+// IsUnschedulable returns true if the pod is pending because the scheduler
+// could not find a node for it
+func IsUnschedulable(pod *corev1.Pod) bool {
+	if pod.Spec.NodeName != "" || pod.Status.Phase != corev1.PodPending {
+		return false
+	}
+
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodScheduled &&
+			cond.Status == corev1.ConditionFalse &&
+			cond.Reason == corev1.PodReasonUnschedulable {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PodMatchesNode returns true if the pod's node selector, required node
+// affinity, and tolerations allow it to be scheduled on the given node.
+// Resources are not considered.
+func PodMatchesNode(pod *corev1.Pod, node *corev1.Node) bool {
+	nodeLabels := labels.Set(node.ObjectMeta.Labels)
+
+	if len(pod.Spec.NodeSelector) > 0 &&
+		!labels.SelectorFromSet(pod.Spec.NodeSelector).Matches(nodeLabels) {
+		return false
+	}
+
+	if !requiredNodeAffinityMatches(pod.Spec.Affinity, nodeLabels) {
+		return false
+	}
+
+	return toleratesNodeTaints(pod.Spec.Tolerations, node.Spec.Taints)
+}
+
+// requiredNodeAffinityMatches returns true if any of the required node
+// selector terms match the labels, or if there are none
+func requiredNodeAffinityMatches(affinity *corev1.Affinity, nodeLabels labels.Set) bool {
+	if affinity == nil || affinity.NodeAffinity == nil ||
+		affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return true
+	}
+
+	terms := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	for _, term := range terms {
+		// Field selectors can only match a specific existing node, so a term
+		// using them can never be satisfied by a node we don't know about
+		if len(term.MatchFields) > 0 || len(term.MatchExpressions) == 0 {
+			continue
+		}
+
+		selector, err := nodeSelectorRequirementsAsSelector(term.MatchExpressions)
+		if err != nil {
+			continue
+		}
+
+		if selector.Matches(nodeLabels) {
+			return true
+		}
+	}
+
+	return false
+}
+
+var nodeSelectorOperators = map[corev1.NodeSelectorOperator]selection.Operator{
+	corev1.NodeSelectorOpIn:           selection.In,
+	corev1.NodeSelectorOpNotIn:        selection.NotIn,
+	corev1.NodeSelectorOpExists:       selection.Exists,
+	corev1.NodeSelectorOpDoesNotExist: selection.DoesNotExist,
+	corev1.NodeSelectorOpGt:           selection.GreaterThan,
+	corev1.NodeSelectorOpLt:           selection.LessThan,
+}
+
+func nodeSelectorRequirementsAsSelector(reqs []corev1.NodeSelectorRequirement) (labels.Selector, error) {
+	selector := labels.NewSelector()
+	for _, req := range reqs {
+		op, ok := nodeSelectorOperators[req.Operator]
+		if !ok {
+			return nil, errors.Errorf("invalid node selector operator %q", req.Operator)
+		}
+
+		r, err := labels.NewRequirement(req.Key, op, req.Values)
+		if err != nil {
+			return nil, err
+		}
+
+		selector = selector.Add(*r)
+	}
+
+	return selector, nil
+}
+
+// toleratesNodeTaints returns true if the tolerations tolerate every taint
+// that would prevent scheduling
+func toleratesNodeTaints(tolerations []corev1.Toleration, taints []corev1.Taint) bool {
+	for i := range taints {
+		taint := &taints[i]
+		if taint.Effect == corev1.TaintEffectPreferNoSchedule {
+			continue
+		}
+
+		tolerated := false
+		for j := range tolerations {
+			if tolerations[j].ToleratesTaint(taint) {
+				tolerated = true
+				break
+			}
+		}
+
+		if !tolerated {
+			return false
+		}
+	}
+
+	return true
+}
+
+// PodRequests returns the effective resources requested by the pod the same
+// way the scheduler computes them: for each resource, the larger of the sum of
+// the container requests and the largest init container request, since init
+// containers run one at a time before the containers start. One unit of the
+// pod resource is included for the pod itself.
+//
+// Pod overhead is not included since it isn't available in the version of the
+// Kubernetes API in use.
+func PodRequests(pod *corev1.Pod) map[corev1.ResourceName]int64 {
+	requests := make(map[corev1.ResourceName]int64)
+	for _, container := range pod.Spec.Containers {
+		for name, quantity := range container.Resources.Requests {
+			requests[name] += quantity.MilliValue()
+		}
+	}
+
+	for _, container := range pod.Spec.InitContainers {
+		for name, quantity := range container.Resources.Requests {
+			if val := quantity.MilliValue(); val > requests[name] {
+				requests[name] = val
+			}
+		}
+	}
+
+	requests[corev1.ResourcePods] = 1000
+
+	return requests
+}
+
+// PendingPodsForNode returns the unschedulable pods that could be scheduled
+// on a node like the given one if it had enough capacity
+func PendingPodsForNode(pods []*corev1.Pod, node *corev1.Node) []*corev1.Pod {
+	var result []*corev1.Pod
+	for _, pod := range pods {
+		if IsUnschedulable(pod) && PodMatchesNode(pod, node) {
+			result = append(result, pod)
+		}
+	}
+
+	return result
+}
+
+// TemplateNode returns the node that new nodes in a group are expected to
+// resemble. The first node by name is used so that the choice is stable. If
+// there are no nodes, a node with only the labels of the node selector is
+// returned.
+func TemplateNode(nodes []*corev1.Node, nodeSelector map[string]string) *corev1.Node {
+	if len(nodes) == 0 {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Labels: nodeSelector,
+			},
+		}
+	}
+
+	template := nodes[0]
+	for _, node := range nodes[1:] {
+		if node.ObjectMeta.Name < template.ObjectMeta.Name {
+			template = node
+		}
+	}
+
+	return template
+}
+
+// NodeCapacity returns the resources available to new pods on an empty node
+// like the given one. Requests of DaemonSet pods running on the node are
+// subtracted since they would run on any new node as well.
+func NodeCapacity(node *corev1.Node, podsOnNode []*corev1.Pod) map[corev1.ResourceName]int64 {
+	capacity := make(map[corev1.ResourceName]int64)
+	for name, quantity := range node.Status.Allocatable {
+		capacity[name] = quantity.MilliValue()
+	}
+
+	for _, pod := range podsOnNode {
+		if !IsDaemonSetPod(pod) {
+			continue
+		}
+
+		for name, val := range PodRequests(pod) {
+			capacity[name] -= val
+		}
+	}
+
+	return capacity
+}
+
+// IsMirrorPod returns true if the pod is the API representation of a static
+// pod, which is tied to its node and never rescheduled
+func IsMirrorPod(pod *corev1.Pod) bool {
+	_, ok := pod.ObjectMeta.Annotations[corev1.MirrorPodAnnotationKey]
+	return ok
+}
+
+// IsDaemonSetPod returns true if the pod is controlled by a DaemonSet
+func IsDaemonSetPod(pod *corev1.Pod) bool {
+	for _, ref := range pod.ObjectMeta.OwnerReferences {
+		if ref.Controller != nil && *ref.Controller && ref.Kind == "DaemonSet" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NodesNeeded returns the number of empty nodes with the given capacity that
+// are required to fit all of the pods, along with any pods that would not fit
+// on an empty node at all. Pods are packed first fit in decreasing order of
+// their CPU and then memory requests.
+func NodesNeeded(pods []*corev1.Pod, capacity map[corev1.ResourceName]int64) (int, []*corev1.Pod) {
+	type podRequests struct {
+		pod      *corev1.Pod
+		requests map[corev1.ResourceName]int64
+	}
+
+	sorted := make([]podRequests, len(pods))
+	for i, pod := range pods {
+		sorted[i] = podRequests{
+			pod:      pod,
+			requests: PodRequests(pod),
+		}
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i].requests, sorted[j].requests
+		if a[corev1.ResourceCPU] != b[corev1.ResourceCPU] {
+			return a[corev1.ResourceCPU] > b[corev1.ResourceCPU]
+		}
+		return a[corev1.ResourceMemory] > b[corev1.ResourceMemory]
+	})
+
+	var nodes []map[corev1.ResourceName]int64
+	var unfit []*corev1.Pod
+	for _, p := range sorted {
+		if !fits(p.requests, capacity) {
+			unfit = append(unfit, p.pod)
+			continue
+		}
+
+		placed := false
+		for _, free := range nodes {
+			if fits(p.requests, free) {
+				allocate(p.requests, free)
+				placed = true
+				break
+			}
+		}
+
+		if !placed {
+			free := make(map[corev1.ResourceName]int64, len(capacity))
+			for name, val := range capacity {
+				free[name] = val
+			}
+			allocate(p.requests, free)
+			nodes = append(nodes, free)
+		}
+	}
+
+	return len(nodes), unfit
+}
+
+func fits(requests, free map[corev1.ResourceName]int64) bool {
+	for name, val := range requests {
+		if val > 0 && free[name] < val {
+			return false
+		}
+	}
+
+	return true
+}
+
+func allocate(requests, free map[corev1.ResourceName]int64) {
+	for name, val := range requests {
+		free[name] -= val
+	}
+}
@@ -0,0 +1,256 @@
+package scheduling
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	trueVar = true
+
+	templateNode = &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node-0",
+			Labels: map[string]string{
+				"pool": "workers",
+				"size": "4",
+			},
+		},
+		Spec: corev1.NodeSpec{
+			Taints: []corev1.Taint{
+				{
+					Key:    "dedicated",
+					Value:  "workers",
+					Effect: corev1.TaintEffectNoSchedule,
+				},
+				{
+					Key:    "soft",
+					Effect: corev1.TaintEffectPreferNoSchedule,
+				},
+			},
+		},
+		Status: corev1.NodeStatus{
+			Allocatable: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("2"),
+				corev1.ResourceMemory: resource.MustParse("4Gi"),
+				corev1.ResourcePods:   resource.MustParse("10"),
+			},
+		},
+	}
+
+	workersToleration = corev1.Toleration{
+		Key:      "dedicated",
+		Operator: corev1.TolerationOpEqual,
+		Value:    "workers",
+		Effect:   corev1.TaintEffectNoSchedule,
+	}
+
+	unschedulableStatus = corev1.PodStatus{
+		Phase: corev1.PodPending,
+		Conditions: []corev1.PodCondition{
+			{
+				Type:   corev1.PodScheduled,
+				Status: corev1.ConditionFalse,
+				Reason: corev1.PodReasonUnschedulable,
+			},
+		},
+	}
+)
+
+func buildPod(cpu, memory string) *corev1.Pod {
+	return &corev1.Pod{
+		Spec: corev1.PodSpec{
+			Tolerations: []corev1.Toleration{workersToleration},
+			Containers: []corev1.Container{
+				{
+					Resources: corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{
+							corev1.ResourceCPU:    resource.MustParse(cpu),
+							corev1.ResourceMemory: resource.MustParse(memory),
+						},
+					},
+				},
+			},
+		},
+		Status: *unschedulableStatus.DeepCopy(),
+	}
+}
+
+func TestIsUnschedulable(t *testing.T) {
+	pod := buildPod("1", "1Gi")
+	assert.True(t, IsUnschedulable(pod), "unschedulable")
+
+	scheduled := pod.DeepCopy()
+	scheduled.Spec.NodeName = "node-0"
+	assert.False(t, IsUnschedulable(scheduled), "already bound to a node")
+
+	running := pod.DeepCopy()
+	running.Status.Phase = corev1.PodRunning
+	assert.False(t, IsUnschedulable(running), "not pending")
+
+	waiting := pod.DeepCopy()
+	waiting.Status.Conditions = nil
+	assert.False(t, IsUnschedulable(waiting), "not yet considered by scheduler")
+}
+
+func TestPodMatchesNode(t *testing.T) {
+	pod := buildPod("1", "1Gi")
+	assert.True(t, PodMatchesNode(pod, templateNode), "no constraints besides toleration")
+
+	untolerated := pod.DeepCopy()
+	untolerated.Spec.Tolerations = nil
+	assert.False(t, PodMatchesNode(untolerated, templateNode), "does not tolerate taint")
+
+	selected := pod.DeepCopy()
+	selected.Spec.NodeSelector = map[string]string{"pool": "workers"}
+	assert.True(t, PodMatchesNode(selected, templateNode), "node selector matches")
+
+	selected.Spec.NodeSelector = map[string]string{"pool": "other"}
+	assert.False(t, PodMatchesNode(selected, templateNode), "node selector does not match")
+
+	affinity := pod.DeepCopy()
+	affinity.Spec.Affinity = &corev1.Affinity{
+		NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{
+					{
+						MatchExpressions: []corev1.NodeSelectorRequirement{
+							{
+								Key:      "pool",
+								Operator: corev1.NodeSelectorOpIn,
+								Values:   []string{"other"},
+							},
+						},
+					},
+					{
+						MatchExpressions: []corev1.NodeSelectorRequirement{
+							{
+								Key:      "size",
+								Operator: corev1.NodeSelectorOpGt,
+								Values:   []string{"2"},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	assert.True(t, PodMatchesNode(affinity, templateNode), "any affinity term may match")
+
+	terms := affinity.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	terms[1].MatchExpressions[0].Values = []string{"8"}
+	assert.False(t, PodMatchesNode(affinity, templateNode), "no affinity term matches")
+
+	terms[1] = corev1.NodeSelectorTerm{
+		MatchFields: []corev1.NodeSelectorRequirement{
+			{
+				Key:      "metadata.name",
+				Operator: corev1.NodeSelectorOpIn,
+				Values:   []string{"node-0"},
+			},
+		},
+	}
+	assert.False(t, PodMatchesNode(affinity, templateNode), "field selectors never match")
+}
+
+func TestPodRequests(t *testing.T) {
+	pod := buildPod("500m", "1Gi")
+	pod.Spec.Containers = append(pod.Spec.Containers, pod.Spec.Containers[0])
+
+	requests := PodRequests(pod)
+	assert.Equal(t, int64(1000), requests[corev1.ResourceCPU], "containers are summed")
+	assert.Equal(t, int64(1000), requests[corev1.ResourcePods], "pod counts once")
+
+	pod.Spec.InitContainers = []corev1.Container{
+		{
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("1500m"),
+					corev1.ResourceMemory: resource.MustParse("1Gi"),
+				},
+			},
+		},
+	}
+
+	requests = PodRequests(pod)
+	memory := resource.MustParse("2Gi")
+	assert.Equal(t, int64(1500), requests[corev1.ResourceCPU], "larger init container request is used")
+	assert.Equal(t, memory.MilliValue(), requests[corev1.ResourceMemory],
+		"smaller init container request is ignored")
+}
+
+func TestIsMirrorPod(t *testing.T) {
+	pod := buildPod("1", "1Gi")
+	assert.False(t, IsMirrorPod(pod))
+
+	pod.ObjectMeta.Annotations = map[string]string{corev1.MirrorPodAnnotationKey: "hash"}
+	assert.True(t, IsMirrorPod(pod))
+}
+
+func TestPendingPodsForNode(t *testing.T) {
+	matching := buildPod("1", "1Gi")
+	other := buildPod("1", "1Gi")
+	other.Spec.NodeSelector = map[string]string{"pool": "other"}
+	running := buildPod("1", "1Gi")
+	running.Status.Phase = corev1.PodRunning
+
+	pending := PendingPodsForNode([]*corev1.Pod{matching, other, running}, templateNode)
+	assert.Equal(t, []*corev1.Pod{matching}, pending)
+}
+
+func TestTemplateNode(t *testing.T) {
+	template := TemplateNode(nil, map[string]string{"pool": "workers"})
+	assert.Equal(t, "workers", template.ObjectMeta.Labels["pool"], "labels from node selector")
+
+	other := templateNode.DeepCopy()
+	other.ObjectMeta.Name = "node-1"
+	template = TemplateNode([]*corev1.Node{other, templateNode}, nil)
+	assert.Equal(t, "node-0", template.ObjectMeta.Name, "first node by name")
+}
+
+func TestNodeCapacity(t *testing.T) {
+	ds := buildPod("500m", "1Gi")
+	ds.ObjectMeta.OwnerReferences = []metav1.OwnerReference{
+		{
+			Kind:       "DaemonSet",
+			Controller: &trueVar,
+		},
+	}
+	regular := buildPod("1", "1Gi")
+
+	capacity := NodeCapacity(templateNode, []*corev1.Pod{ds, regular})
+	assert.Equal(t, int64(1500), capacity[corev1.ResourceCPU], "DaemonSet requests are subtracted")
+	assert.Equal(t, int64(9000), capacity[corev1.ResourcePods], "DaemonSet pods are subtracted")
+}
+
+func TestNodesNeeded(t *testing.T) {
+	capacity := NodeCapacity(templateNode, nil)
+
+	n, unfit := NodesNeeded(nil, capacity)
+	assert.Equal(t, 0, n, "no pods")
+	assert.Empty(t, unfit)
+
+	pods := []*corev1.Pod{
+		buildPod("500m", "1Gi"),
+		buildPod("1500m", "1Gi"),
+		buildPod("500m", "1Gi"),
+		buildPod("1", "1Gi"),
+		buildPod("4", "1Gi"),
+	}
+
+	// 1500m + 500m, 1 + 500m
+	n, unfit = NodesNeeded(pods, capacity)
+	assert.Equal(t, 2, n, "pods are bin packed")
+	assert.Equal(t, []*corev1.Pod{pods[4]}, unfit, "pods too large for an empty node are skipped")
+
+	gpu := buildPod("100m", "1Gi")
+	gpu.Spec.Containers[0].Resources.Requests["nvidia.com/gpu"] = resource.MustParse("1")
+	n, unfit = NodesNeeded([]*corev1.Pod{gpu}, capacity)
+	assert.Equal(t, 0, n, "pod requesting a resource the node lacks")
+	assert.Len(t, unfit, 1)
+}